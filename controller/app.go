@@ -1,14 +1,19 @@
 package main
 
 import (
+	"crypto/sha256"
+	"encoding/hex"
 	"encoding/json"
 	"fmt"
 	"io"
 	"log"
 	"net/http"
+	"net/url"
 	"regexp"
+	"sort"
 	"strconv"
 	"strings"
+	"time"
 
 	"github.com/flynn/flynn/controller/name"
 	"github.com/flynn/flynn/controller/schema"
@@ -19,7 +24,6 @@ import (
 	"github.com/flynn/flynn/pkg/httphelper"
 	"github.com/flynn/flynn/pkg/postgres"
 	"github.com/flynn/flynn/pkg/random"
-	"github.com/flynn/flynn/pkg/sse"
 	routerc "github.com/flynn/flynn/router/client"
 	"github.com/flynn/flynn/router/types"
 	"github.com/flynn/que-go"
@@ -41,15 +45,176 @@ func NewAppRepo(db *postgres.DB, defaultDomain string, router routerc.Client) *A
 }
 
 func (r *AppRepo) Add(data interface{}) error {
-	app := data.(*ct.App)
+	return r.AddMultiple([]*ct.App{data.(*ct.App)})
+}
+
+// AddMultiple creates the given apps in a single transaction, so that either
+// all of them are created or, if any fails (for example because its name is
+// taken), none of them are.
+func (r *AppRepo) AddMultiple(apps []*ct.App) error {
+	tx, err := r.db.Begin()
+	if err != nil {
+		return err
+	}
+	for _, app := range apps {
+		if err := r.insert(tx, app); err != nil {
+			tx.Rollback()
+			return err
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+
+	for _, app := range apps {
+		r.addDefaultRoute(app)
+	}
+	return nil
+}
+
+// addDefaultRoute creates a route for the app's web process on the default
+// domain, unless it is a system app or there is no default domain.
+func (r *AppRepo) addDefaultRoute(app *ct.App) {
+	if app.System() || r.defaultDomain == "" {
+		return
+	}
+	route := (&router.HTTPRoute{
+		Domain:  fmt.Sprintf("%s.%s", app.Name, r.defaultDomain),
+		Service: app.Name + "-web",
+	}).ToRoute()
+	if err := createRoute(r.db, r.router, app.ID, route); err != nil {
+		log.Printf("Error creating default route for %s: %s", app.Name, err)
+	}
+}
+
+// Import creates the app in the given export along with its release, the
+// release's artifacts and formation, and its routes and their metadata,
+// giving the app and release new IDs but otherwise preserving their names
+// and config. Exported artifacts are reused if an artifact with the same
+// type and URI exists.
+//
+// The routes are created before the transaction (which also stores their
+// metadata) is committed and deleted if creating a later one or committing
+// fails, so if anything fails nothing is created.
+func (r *AppRepo) Import(export *ct.AppExport) error {
+	app := export.App
+	app.ID = ""
+	app.ReleaseID = ""
+	app.PendingReleaseID = ""
+
+	tx, err := r.db.Begin()
+	if err != nil {
+		return err
+	}
+	if err := r.insert(tx, app); err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	if release := export.Release; release != nil {
+		artifactIDs := make(map[string]string, len(export.Artifacts))
+		for _, artifact := range export.Artifacts {
+			id := artifact.ID
+			artifact.ID = ""
+			if err := insertArtifact(tx, artifact); err != nil {
+				tx.Rollback()
+				return err
+			}
+			artifactIDs[id] = artifact.ID
+		}
+		for i, id := range release.ArtifactIDs {
+			if newID, ok := artifactIDs[id]; ok {
+				release.ArtifactIDs[i] = newID
+			}
+		}
+
+		prepareRelease(release)
+		release.ID = random.UUID()
+		if err := insertRelease(tx, release, "release_insert", release.ID, release.Env, release.Processes, release.Meta); err != nil {
+			tx.Rollback()
+			return err
+		}
+		if err := setAppRelease(tx, app, release.ID); err != nil {
+			tx.Rollback()
+			return err
+		}
+
+		if f := export.Formation; f != nil {
+			f.AppID = app.ID
+			f.ReleaseID = release.ID
+			if err := insertFormation(tx, f, &ct.Scale{Processes: f.Processes, ReleaseID: f.ReleaseID}); err != nil {
+				tx.Rollback()
+				return err
+			}
+		}
+	}
+
+	for i, route := range export.Routes {
+		route.ID = ""
+		if err := createRoute(r.db, r.router, app.ID, route); err != nil {
+			tx.Rollback()
+			r.deleteRoutes(export.Routes[:i])
+			return routerError(err)
+		}
+		if len(route.Meta) > 0 {
+			if err := tx.Exec("route_meta_upsert", route.ID, app.ID, route.Meta); err != nil {
+				tx.Rollback()
+				r.deleteRoutes(export.Routes[:i+1])
+				return err
+			}
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		r.deleteRoutes(export.Routes)
+		return err
+	}
+	return nil
+}
+
+// Clone creates the given app along with a copy of the given release (if
+// set) with a new ID as its current release in a single transaction, so the
+// clone is not left without a release if copying the release fails.
+func (r *AppRepo) Clone(app *ct.App, release *ct.Release) error {
 	tx, err := r.db.Begin()
 	if err != nil {
 		return err
 	}
+	if err := r.insert(tx, app); err != nil {
+		tx.Rollback()
+		return err
+	}
+	if release != nil {
+		clone := *release
+		clone.ID = random.UUID()
+		clone.CreatedAt = nil
+		if err := insertRelease(tx, &clone, "release_insert", clone.ID, clone.Env, clone.Processes, clone.Meta); err != nil {
+			tx.Rollback()
+			return err
+		}
+		if err := setAppRelease(tx, app, clone.ID); err != nil {
+			tx.Rollback()
+			return err
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+	r.addDefaultRoute(app)
+	return nil
+}
+
+// deleteRoutes deletes routes created during a failed import, which the
+// router has no transactions for.
+func (r *AppRepo) deleteRoutes(routes []*router.Route) {
+	for _, route := range routes {
+		r.router.DeleteRoute(route.Type, route.ID)
+	}
+}
+
+func (r *AppRepo) insert(tx *postgres.DBTx, app *ct.App) error {
 	if app.Name == "" {
 		var nameID int64
 		if err := tx.QueryRow("app_next_name_id").Scan(&nameID); err != nil {
-			tx.Rollback()
 			return err
 		}
 		// Safe cast because name_ids is limited to 32 bit size in schema
@@ -67,8 +232,10 @@ func (r *AppRepo) Add(data interface{}) error {
 	if app.DeployTimeout == 0 {
 		app.DeployTimeout = ct.DefaultDeployTimeout
 	}
+	// apps are created active, and only paused by PauseApp
+	app.Paused = false
+	app.SchedulingState = ct.AppSchedulingStateActive
 	if err := tx.QueryRow("app_insert", app.ID, app.Name, app.Meta, app.Strategy, app.DeployTimeout).Scan(&app.CreatedAt, &app.UpdatedAt); err != nil {
-		tx.Rollback()
 		if postgres.IsUniquenessError(err, "apps_name_idx") {
 			return httphelper.ObjectExistsErr(fmt.Sprintf("application %q already exists", app.Name))
 		}
@@ -80,34 +247,18 @@ func (r *AppRepo) Add(data interface{}) error {
 		app.Meta = make(map[string]string)
 	}
 
-	if err := createEvent(tx.Exec, &ct.Event{
+	return createEvent(tx.Exec, &ct.Event{
 		AppID:      app.ID,
 		ObjectID:   app.ID,
 		ObjectType: ct.EventTypeApp,
-	}, app); err != nil {
-		tx.Rollback()
-		return err
-	}
-	if err := tx.Commit(); err != nil {
-		return err
-	}
-
-	if !app.System() && r.defaultDomain != "" {
-		route := (&router.HTTPRoute{
-			Domain:  fmt.Sprintf("%s.%s", app.Name, r.defaultDomain),
-			Service: app.Name + "-web",
-		}).ToRoute()
-		if err := createRoute(r.db, r.router, app.ID, route); err != nil {
-			log.Printf("Error creating default route for %s: %s", app.Name, err)
-		}
-	}
-	return nil
+	}, app)
 }
 
-func scanApp(s postgres.Scanner) (*ct.App, error) {
+func scanApp(s postgres.Scanner, extra ...interface{}) (*ct.App, error) {
 	app := &ct.App{}
-	var releaseID *string
-	err := s.Scan(&app.ID, &app.Name, &app.Meta, &app.Strategy, &releaseID, &app.DeployTimeout, &app.CreatedAt, &app.UpdatedAt)
+	var releaseID, pendingReleaseID *string
+	dest := []interface{}{&app.ID, &app.Name, &app.Meta, &app.Strategy, &releaseID, &pendingReleaseID, &app.DeployTimeout, &app.Paused, &app.CreatedAt, &app.UpdatedAt}
+	err := s.Scan(append(dest, extra...)...)
 	if err == pgx.ErrNoRows {
 		return nil, ErrNotFound
 	} else if err != nil {
@@ -116,6 +267,10 @@ func scanApp(s postgres.Scanner) (*ct.App, error) {
 	if releaseID != nil {
 		app.ReleaseID = *releaseID
 	}
+	if pendingReleaseID != nil {
+		app.PendingReleaseID = *pendingReleaseID
+	}
+	app.SchedulingState = appSchedulingState(app.Paused)
 	if app.Meta == nil {
 		// ensure `{}` rather than `null` when serializing to JSON
 		app.Meta = map[string]string{}
@@ -123,6 +278,13 @@ func scanApp(s postgres.Scanner) (*ct.App, error) {
 	return app, err
 }
 
+func appSchedulingState(paused bool) string {
+	if paused {
+		return ct.AppSchedulingStatePaused
+	}
+	return ct.AppSchedulingStateActive
+}
+
 var idPattern = regexp.MustCompile(`^[a-f0-9]{8}-?([a-f0-9]{4}-?){3}[a-f0-9]{12}$`)
 
 type rowQueryer interface {
@@ -147,7 +309,26 @@ func (r *AppRepo) Get(id string) (interface{}, error) {
 	return selectApp(r.db, id, false)
 }
 
-func (r *AppRepo) Update(id string, data map[string]interface{}) (interface{}, error) {
+// GetIncludingDeleted gets the app with the given ID even if it has been
+// deleted, setting app.Deleted and app.DeletedAt if so. It is used to show
+// the app referred to by historical events.
+func (r *AppRepo) GetIncludingDeleted(id string) (*ct.App, error) {
+	if !idPattern.MatchString(id) {
+		return nil, ErrNotFound
+	}
+	var deletedAt *time.Time
+	app, err := scanApp(r.db.QueryRow("app_select_including_deleted", id), &deletedAt)
+	if err != nil {
+		return nil, err
+	}
+	app.Deleted = deletedAt != nil
+	app.DeletedAt = deletedAt
+	return app, nil
+}
+
+// Update updates the given fields of the app, failing without making any
+// changes if the app has been modified since the given precondition.
+func (r *AppRepo) Update(id string, data map[string]interface{}, since *unmodifiedSince) (interface{}, error) {
 	tx, err := r.db.Begin()
 	if err != nil {
 		return nil, err
@@ -157,6 +338,10 @@ func (r *AppRepo) Update(id string, data map[string]interface{}) (interface{}, e
 		tx.Rollback()
 		return nil, err
 	}
+	if err := since.Check(app.UpdatedAt); err != nil {
+		tx.Rollback()
+		return nil, err
+	}
 
 	for k, v := range data {
 		switch k {
@@ -209,6 +394,13 @@ func (r *AppRepo) Update(id string, data map[string]interface{}) (interface{}, e
 		}
 	}
 
+	// reload the app so that its updated_at reflects the update
+	app, err = selectApp(tx, app.ID, false)
+	if err != nil {
+		tx.Rollback()
+		return nil, err
+	}
+
 	if err := createEvent(tx.Exec, &ct.Event{
 		AppID:      app.ID,
 		ObjectID:   app.ID,
@@ -238,11 +430,68 @@ func (r *AppRepo) List() (interface{}, error) {
 	return apps, rows.Err()
 }
 
+// ListQuery lists the apps with the comma separated IDs given in the ids
+// query parameter in the requested order, with a nil entry for any app which
+// does not exist, or lists all apps if ids is not set.
+func (r *AppRepo) ListQuery(query url.Values) (interface{}, error) {
+	if _, ok := query["ids"]; !ok {
+		return r.List()
+	}
+	ids := split(query.Get("ids"), ",")
+	apps, err := r.ListIDs(ids...)
+	if err != nil {
+		return nil, err
+	}
+	list := make([]*ct.App, len(ids))
+	for i, id := range ids {
+		list[i] = apps[id]
+	}
+	return list, nil
+}
+
+// ListIDs returns the apps with the given IDs, keyed by ID. IDs which are not
+// valid app IDs are ignored.
+func (r *AppRepo) ListIDs(ids ...string) (map[string]*ct.App, error) {
+	valid := make([]string, 0, len(ids))
+	for _, id := range ids {
+		if idPattern.MatchString(id) {
+			valid = append(valid, id)
+		}
+	}
+	apps := make(map[string]*ct.App, len(valid))
+	if len(valid) == 0 {
+		return apps, nil
+	}
+	rows, err := r.db.Query("app_list_ids", fmt.Sprintf("{%s}", strings.Join(valid, ",")))
+	if err != nil {
+		return nil, err
+	}
+	for rows.Next() {
+		app, err := scanApp(rows)
+		if err != nil {
+			rows.Close()
+			return nil, err
+		}
+		apps[app.ID] = app
+	}
+	return apps, rows.Err()
+}
+
 func (r *AppRepo) SetRelease(app *ct.App, releaseID string) error {
 	tx, err := r.db.Begin()
 	if err != nil {
 		return err
 	}
+	if err := setAppRelease(tx, app, releaseID); err != nil {
+		tx.Rollback()
+		return err
+	}
+	return tx.Commit()
+}
+
+// setAppRelease sets the app's current release as part of the given
+// transaction, which the caller must roll back on error.
+func setAppRelease(tx *postgres.DBTx, app *ct.App, releaseID string) error {
 	var release *ct.Release
 	var prevRelease *ct.Release
 	if app.ReleaseID != "" {
@@ -250,22 +499,85 @@ func (r *AppRepo) SetRelease(app *ct.App, releaseID string) error {
 		prevRelease, _ = scanRelease(row)
 	}
 	row := tx.QueryRow("release_select", releaseID)
-	if release, err = scanRelease(row); err != nil {
+	release, err := scanRelease(row)
+	if err != nil {
 		return err
 	}
 	app.ReleaseID = releaseID
 	if err := tx.Exec("app_update_release", app.ID, app.ReleaseID); err != nil {
-		tx.Rollback()
 		return err
 	}
-	if err := createEvent(tx.Exec, &ct.Event{
+	if app.PendingReleaseID == releaseID {
+		app.PendingReleaseID = ""
+	}
+	return createEvent(tx.Exec, &ct.Event{
 		AppID:      app.ID,
 		ObjectID:   release.ID,
 		ObjectType: ct.EventTypeAppRelease,
 	}, &ct.AppRelease{
 		PrevRelease: prevRelease,
 		Release:     release,
-	}); err != nil {
+	})
+}
+
+// SetPendingRelease stages the given release as the next release to deploy
+// to the app, clearing any staged release if releaseID is empty.
+func (r *AppRepo) SetPendingRelease(app *ct.App, releaseID string) error {
+	tx, err := r.db.Begin()
+	if err != nil {
+		return err
+	}
+	var pendingReleaseID *string
+	if releaseID != "" {
+		pendingReleaseID = &releaseID
+	}
+	if err := tx.Exec("app_update_pending_release", app.ID, pendingReleaseID); err != nil {
+		tx.Rollback()
+		return err
+	}
+	updated, err := selectApp(tx, app.ID, false)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+	*app = *updated
+	if err := createEvent(tx.Exec, &ct.Event{
+		AppID:      app.ID,
+		ObjectID:   app.ID,
+		ObjectType: ct.EventTypeApp,
+	}, app); err != nil {
+		tx.Rollback()
+		return err
+	}
+	return tx.Commit()
+}
+
+// SetPaused pauses or resumes the scheduling of the app's jobs, touching its
+// formations so the scheduler is notified of the change.
+func (r *AppRepo) SetPaused(app *ct.App, paused bool) error {
+	tx, err := r.db.Begin()
+	if err != nil {
+		return err
+	}
+	if err := tx.Exec("app_update_paused", app.ID, paused); err != nil {
+		tx.Rollback()
+		return err
+	}
+	if err := tx.Exec("formation_touch_by_app", app.ID); err != nil {
+		tx.Rollback()
+		return err
+	}
+	updated, err := selectApp(tx, app.ID, false)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+	*app = *updated
+	if err := createEvent(tx.Exec, &ct.Event{
+		AppID:      app.ID,
+		ObjectID:   app.ID,
+		ObjectType: ct.EventTypeApp,
+	}, app); err != nil {
 		tx.Rollback()
 		return err
 	}
@@ -296,7 +608,13 @@ func (c *controllerAPI) UpdateApp(ctx context.Context, rw http.ResponseWriter, r
 		return
 	}
 
-	app, err := c.appRepo.Update(params.ByName("apps_id"), data)
+	since, err := parseUnmodifiedSince(req)
+	if err != nil {
+		respondWithError(rw, err)
+		return
+	}
+
+	app, err := c.appRepo.Update(params.ByName("apps_id"), data, since)
 	if err != nil {
 		respondWithError(rw, err)
 		return
@@ -304,6 +622,336 @@ func (c *controllerAPI) UpdateApp(ctx context.Context, rw http.ResponseWriter, r
 	httphelper.JSON(rw, 200, app)
 }
 
+// PauseApp stops the scheduler from starting new jobs for the app, leaving
+// its running jobs and formations as they are.
+func (c *controllerAPI) PauseApp(ctx context.Context, w http.ResponseWriter, req *http.Request) {
+	c.setAppPaused(ctx, w, true)
+}
+
+// ResumeApp lets the scheduler start jobs for a paused app again, so the app
+// is scaled back up to its formations.
+func (c *controllerAPI) ResumeApp(ctx context.Context, w http.ResponseWriter, req *http.Request) {
+	c.setAppPaused(ctx, w, false)
+}
+
+func (c *controllerAPI) setAppPaused(ctx context.Context, w http.ResponseWriter, paused bool) {
+	app := c.getApp(ctx)
+	if err := c.appRepo.SetPaused(app, paused); err != nil {
+		respondWithError(w, err)
+		return
+	}
+	httphelper.JSON(w, 200, app)
+}
+
+// ExportApp responds with a snapshot of the app's config (its current
+// release, the release's artifacts and formation, and the app's routes and
+// their metadata) which can be imported into another cluster.
+func (c *controllerAPI) ExportApp(ctx context.Context, w http.ResponseWriter, req *http.Request) {
+	app := c.getApp(ctx)
+	export := &ct.AppExport{App: app}
+
+	if app.ReleaseID != "" {
+		data, err := c.releaseRepo.Get(app.ReleaseID)
+		if err != nil {
+			respondWithError(w, err)
+			return
+		}
+		export.Release = data.(*ct.Release)
+
+		artifacts, err := c.artifactRepo.ListIDs(export.Release.ArtifactIDs...)
+		if err != nil {
+			respondWithError(w, err)
+			return
+		}
+		for _, id := range export.Release.ArtifactIDs {
+			if artifact, ok := artifacts[id]; ok {
+				export.Artifacts = append(export.Artifacts, artifact)
+			}
+		}
+
+		formation, err := c.formationRepo.Get(app.ID, app.ReleaseID)
+		if err == nil {
+			export.Formation = formation
+		} else if err != ErrNotFound {
+			respondWithError(w, err)
+			return
+		}
+	}
+
+	var err error
+	if export.Routes, err = c.routerc.ListRoutes(routeParentRef(app.ID)); err != nil {
+		respondWithError(w, routerError(err))
+		return
+	}
+	if err := c.routeMetaRepo.AddToRoutes(app.ID, export.Routes); err != nil {
+		respondWithError(w, err)
+		return
+	}
+	httphelper.JSON(w, 200, export)
+}
+
+// ImportApp creates an app from a snapshot exported from another cluster by
+// ExportApp, responding with the created app.
+func (c *controllerAPI) ImportApp(ctx context.Context, w http.ResponseWriter, req *http.Request) {
+	var export ct.AppExport
+	if err := httphelper.DecodeJSON(req, &export); err != nil {
+		respondWithError(w, err)
+		return
+	}
+	if export.App == nil {
+		respondWithError(w, ct.ValidationError{Field: "app", Message: "must be set"})
+		return
+	}
+	if err := validate(c.appRepo, export.App); err != nil {
+		respondWithError(w, prefixValidationError(err, "app"))
+		return
+	}
+	if release := export.Release; release != nil {
+		if err := validateReleaseConfig(release).Err(); err != nil {
+			respondWithError(w, prefixValidationError(err, "release"))
+			return
+		}
+		for i, artifact := range export.Artifacts {
+			field := fmt.Sprintf("artifacts[%d]", i)
+			if artifact == nil {
+				respondWithError(w, ct.ValidationError{Field: field, Message: "must not be null"})
+				return
+			}
+			if artifact.Type == "" || artifact.URI == "" {
+				respondWithError(w, ct.ValidationError{Field: field, Message: "must have a type and URI"})
+				return
+			}
+		}
+		if export.Formation != nil {
+			if err := validateFormationProcesses(export.Formation, release); err != nil {
+				respondWithError(w, prefixValidationError(err, "formation"))
+				return
+			}
+		}
+	} else if export.Formation != nil {
+		respondWithError(w, ct.ValidationError{Field: "formation", Message: "requires a release"})
+		return
+	}
+	for i, route := range export.Routes {
+		if route == nil {
+			respondWithError(w, ct.ValidationError{Field: fmt.Sprintf("routes[%d]", i), Message: "must not be null"})
+			return
+		}
+	}
+	if len(export.Routes) > 0 {
+		existing, err := c.routerc.ListRoutes("")
+		if err != nil {
+			respondWithError(w, routerError(err))
+			return
+		}
+		for i, route := range export.Routes {
+			for _, r := range append(existing, export.Routes[:i]...) {
+				if routesConflict(route, r) {
+					respondWithError(w, httphelper.ObjectExistsErr(fmt.Sprintf("routes[%d] conflicts with an existing route", i)))
+					return
+				}
+			}
+		}
+	}
+
+	defer func() { c.routeCache.Remove(export.App.ID) }()
+	if err := c.appRepo.Import(&export); err != nil {
+		respondWithError(w, err)
+		return
+	}
+	httphelper.JSON(w, 200, export.App)
+}
+
+// CreateApps creates the apps in the request in a single transaction, so if
+// any of them cannot be created (for example because its name is taken) none
+// of them are.
+func (c *controllerAPI) CreateApps(ctx context.Context, w http.ResponseWriter, req *http.Request) {
+	var apps []*ct.App
+	if err := httphelper.DecodeJSON(req, &apps); err != nil {
+		respondWithError(w, err)
+		return
+	}
+	if len(apps) == 0 {
+		respondWithError(w, ct.ValidationError{Field: "apps", Message: "must not be empty"})
+		return
+	}
+	for i, app := range apps {
+		field := fmt.Sprintf("apps[%d]", i)
+		if app == nil {
+			respondWithError(w, ct.ValidationError{Field: field, Message: "must not be null"})
+			return
+		}
+		if err := validate(c.appRepo, app); err != nil {
+			respondWithError(w, prefixValidationError(err, field))
+			return
+		}
+	}
+
+	if err := c.appRepo.AddMultiple(apps); err != nil {
+		respondWithError(w, err)
+		return
+	}
+	httphelper.JSON(w, 200, apps)
+}
+
+// CloneApp creates a new app with the meta, strategy and deploy timeout of
+// the app in the request, releasing a copy of its current release (if any)
+// to the new app. Routes and resources are not copied.
+func (c *controllerAPI) CloneApp(ctx context.Context, w http.ResponseWriter, req *http.Request) {
+	src := c.getApp(ctx)
+
+	var data ct.AppClone
+	if err := httphelper.DecodeJSON(req, &data); err != nil {
+		respondWithError(w, err)
+		return
+	}
+
+	app := &ct.App{
+		Name:          data.Name,
+		Meta:          make(map[string]string, len(src.Meta)),
+		Strategy:      src.Strategy,
+		DeployTimeout: src.DeployTimeout,
+	}
+	for k, v := range src.Meta {
+		// a clone of a system app is not itself a system app
+		if k == "flynn-system-app" {
+			continue
+		}
+		app.Meta[k] = v
+	}
+	if err := schema.Validate(app); err != nil {
+		respondWithError(w, err)
+		return
+	}
+
+	var current *ct.Release
+	if src.ReleaseID != "" {
+		release, err := c.releaseRepo.Get(src.ReleaseID)
+		if err != nil {
+			respondWithError(w, err)
+			return
+		}
+		current = release.(*ct.Release)
+	}
+
+	// the clone is given a default route
+	defer func() { c.routeCache.Remove(app.ID) }()
+	if err := c.appRepo.Clone(app, current); err != nil {
+		respondWithError(w, err)
+		return
+	}
+	httphelper.JSON(w, 200, app)
+}
+
+// GetAppConfigChecksum responds with a checksum of the app's effective
+// config, which CI pipelines can compare to detect config drift.
+func (c *controllerAPI) GetAppConfigChecksum(ctx context.Context, w http.ResponseWriter, req *http.Request) {
+	app := c.getApp(ctx)
+	release, err := c.getCurrentRelease(ctx, app.ID)
+	if err == ErrNotFound {
+		release = &ct.Release{}
+	} else if err != nil {
+		respondWithError(w, err)
+		return
+	}
+	formation := &ct.Formation{}
+	if release.ID != "" {
+		formation, err = c.formationRepo.Get(app.ID, release.ID)
+		if err == ErrNotFound {
+			formation = &ct.Formation{}
+		} else if err != nil {
+			respondWithError(w, err)
+			return
+		}
+	}
+	checksum, err := appConfigChecksum(release, formation)
+	if err != nil {
+		respondWithError(w, err)
+		return
+	}
+	httphelper.JSON(w, 200, &ct.AppConfigChecksum{Checksum: checksum})
+}
+
+// appConfigChecksum returns a SHA-256 checksum of the release's env and
+// processes and the formation's process counts. The config is hashed as JSON,
+// which encodes maps with sorted keys so the checksum does not depend on map
+// ordering, and processes scaled to zero are treated as absent.
+func appConfigChecksum(release *ct.Release, formation *ct.Formation) (string, error) {
+	config := struct {
+		Env       map[string]string         `json:"env"`
+		Processes map[string]ct.ProcessType `json:"processes"`
+		Formation map[string]int            `json:"formation"`
+	}{
+		Env:       release.Env,
+		Processes: release.Processes,
+		Formation: make(map[string]int, len(formation.Processes)),
+	}
+	for typ, n := range formation.Processes {
+		if n > 0 {
+			config.Formation[typ] = n
+		}
+	}
+	data, err := json.Marshal(config)
+	if err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256(data)
+	return "sha256:" + hex.EncodeToString(sum[:]), nil
+}
+
+// GetAppEffectiveEnv responds with the env the app's processes run with,
+// which is the env of each attached resource (oldest first, so newer
+// resources take precedence) overlaid with the current release's env, which
+// takes precedence over all resources since it is what jobs are started with.
+func (c *controllerAPI) GetAppEffectiveEnv(ctx context.Context, w http.ResponseWriter, req *http.Request) {
+	app := c.getApp(ctx)
+	release, err := c.getCurrentRelease(ctx, app.ID)
+	if err == ErrNotFound {
+		release = &ct.Release{}
+	} else if err != nil {
+		respondWithError(w, err)
+		return
+	}
+	resources, err := c.resourceRepo.AppList(app.ID)
+	if err != nil {
+		respondWithError(w, err)
+		return
+	}
+	httphelper.JSON(w, 200, effectiveEnv(release, resources))
+}
+
+// effectiveEnv merges the env of the given resources and release, see
+// GetAppEffectiveEnv for the precedence rules.
+func effectiveEnv(release *ct.Release, resources []*ct.Resource) map[string]string {
+	sort.Sort(resourcesByCreatedAt(resources))
+	env := make(map[string]string)
+	for _, r := range resources {
+		for k, v := range r.Env {
+			env[k] = v
+		}
+	}
+	for k, v := range release.Env {
+		env[k] = v
+	}
+	return env
+}
+
+type resourcesByCreatedAt []*ct.Resource
+
+func (r resourcesByCreatedAt) Len() int      { return len(r) }
+func (r resourcesByCreatedAt) Swap(i, j int) { r[i], r[j] = r[j], r[i] }
+func (r resourcesByCreatedAt) Less(i, j int) bool {
+	if r[i].CreatedAt == nil || r[j].CreatedAt == nil || r[i].CreatedAt.Equal(*r[j].CreatedAt) {
+		return r[i].ID < r[j].ID
+	}
+	return r[i].CreatedAt.Before(*r[j].CreatedAt)
+}
+
+func (c *controllerAPI) GetAppMetaValue(ctx context.Context, w http.ResponseWriter, req *http.Request) {
+	params, _ := ctxhelper.ParamsFromContext(ctx)
+	httphelper.JSON(w, 200, ct.NewMetaValue(c.getApp(ctx).Meta, params.ByName("key")))
+}
+
 func (c *controllerAPI) DeleteApp(ctx context.Context, w http.ResponseWriter, req *http.Request) {
 	args, err := json.Marshal(c.getApp(ctx))
 	if err != nil {
@@ -388,7 +1036,7 @@ func (c *controllerAPI) AppLog(ctx context.Context, w http.ResponseWriter, req *
 
 	ch := make(chan *ct.SSELogChunk)
 	l, _ := ctxhelper.LoggerFromContext(ctx)
-	s := sse.NewStream(w, ch, l)
+	s := c.newSSEStream(w, ch, l)
 	defer s.Close()
 	s.Serve()
 
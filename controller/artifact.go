@@ -1,22 +1,34 @@
 package main
 
 import (
+	"encoding/json"
 	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
 	"strings"
+	"time"
 
 	ct "github.com/flynn/flynn/controller/types"
 	"github.com/flynn/flynn/host/types"
+	"github.com/flynn/flynn/pkg/ctxhelper"
+	"github.com/flynn/flynn/pkg/httphelper"
 	"github.com/flynn/flynn/pkg/postgres"
 	"github.com/flynn/flynn/pkg/random"
 	"github.com/jackc/pgx"
+	"golang.org/x/net/context"
 )
 
 type ArtifactRepo struct {
 	db *postgres.DB
+
+	// cache is an optional cache of artifacts which is nil unless
+	// enabled in the handler config
+	cache *objectCache
 }
 
 func NewArtifactRepo(db *postgres.DB) *ArtifactRepo {
-	return &ArtifactRepo{db}
+	return &ArtifactRepo{db: db}
 }
 
 func (r *ArtifactRepo) Add(data interface{}) error {
@@ -31,25 +43,29 @@ func (r *ArtifactRepo) Add(data interface{}) error {
 	if a.URI == "" {
 		return ct.ValidationError{Field: "uri", Message: "must not be empty"}
 	}
+	if a.Size != nil && *a.Size < 0 {
+		return ct.ValidationError{Field: "size", Message: "must not be negative"}
+	}
 	tx, err := r.db.Begin()
 	if err != nil {
 		return err
 	}
 
-	err = tx.QueryRow("artifact_insert", a.ID, string(a.Type), a.URI, a.Meta).Scan(&a.CreatedAt)
+	err = tx.QueryRow("artifact_insert", a.ID, string(a.Type), a.URI, a.Meta, a.Size).Scan(&a.CreatedAt)
 	if postgres.IsUniquenessError(err, "") {
 		tx.Rollback()
 		tx, err = r.db.Begin()
 		if err != nil {
 			return err
 		}
-		err = tx.QueryRow("artifact_select_by_type_and_uri", string(a.Type), a.URI).Scan(&a.ID, &a.Meta, &a.CreatedAt)
+		err = tx.QueryRow("artifact_select_by_type_and_uri", string(a.Type), a.URI).Scan(&a.ID, &a.Meta, &a.Size, &a.CreatedAt)
 		if err != nil {
 			tx.Rollback()
 			return err
 		}
 	}
 	if err == nil {
+		setArtifactSizeHuman(a)
 		if err := createEvent(tx.Exec, &ct.Event{
 			ObjectID:   a.ID,
 			ObjectType: ct.EventTypeArtifact,
@@ -64,24 +80,145 @@ func (r *ArtifactRepo) Add(data interface{}) error {
 	return tx.Commit()
 }
 
+// insertArtifact inserts the artifact as part of the given transaction,
+// which the caller must roll back on error, unless an artifact with the same
+// type and URI already exists, in which case the artifact is updated to be
+// the existing one.
+func insertArtifact(tx *postgres.DBTx, a *ct.Artifact) error {
+	err := tx.QueryRow("artifact_select_by_type_and_uri", string(a.Type), a.URI).Scan(&a.ID, &a.Meta, &a.Size, &a.CreatedAt)
+	if err == nil {
+		setArtifactSizeHuman(a)
+		return nil
+	} else if err != pgx.ErrNoRows {
+		return err
+	}
+	if a.ID == "" {
+		a.ID = random.UUID()
+	}
+	if err := tx.QueryRow("artifact_insert", a.ID, string(a.Type), a.URI, a.Meta, a.Size).Scan(&a.CreatedAt); err != nil {
+		return err
+	}
+	setArtifactSizeHuman(a)
+	return createEvent(tx.Exec, &ct.Event{
+		ObjectID:   a.ID,
+		ObjectType: ct.EventTypeArtifact,
+	}, a)
+}
+
 func scanArtifact(s postgres.Scanner) (*ct.Artifact, error) {
 	artifact := &ct.Artifact{}
 	var typ string
-	err := s.Scan(&artifact.ID, &typ, &artifact.URI, &artifact.Meta, &artifact.CreatedAt)
+	err := s.Scan(&artifact.ID, &typ, &artifact.URI, &artifact.Meta, &artifact.Size, &artifact.CreatedAt)
 	if err == pgx.ErrNoRows {
 		err = ErrNotFound
 	}
 	artifact.Type = host.ArtifactType(typ)
+	setArtifactSizeHuman(artifact)
 	return artifact, err
 }
 
+func setArtifactSizeHuman(a *ct.Artifact) {
+	a.SizeHuman = ""
+	if a.Size != nil {
+		a.SizeHuman = ct.ByteSize(*a.Size).String()
+	}
+}
+
 func (r *ArtifactRepo) Get(id string) (interface{}, error) {
+	if artifact, ok := r.cachedArtifact(id); ok {
+		return artifact, nil
+	}
 	row := r.db.QueryRow("artifact_select", id)
-	return scanArtifact(row)
+	artifact, err := scanArtifact(row)
+	if err == nil {
+		r.cacheArtifact(artifact)
+	}
+	return artifact, err
+}
+
+// artifactInUseError is returned when deleting an artifact which releases
+// still reference.
+type artifactInUseError struct {
+	ReleaseIDs []string `json:"releases"`
+}
+
+func (e artifactInUseError) Error() string {
+	return fmt.Sprintf("controller: artifact is referenced by %d release(s)", len(e.ReleaseIDs))
+}
+
+// Delete deletes the artifact unless any releases reference it, in which case
+// an artifactInUseError listing them is returned. The artifact is locked while
+// checking so that a release referencing it cannot be created concurrently.
+func (r *ArtifactRepo) Delete(id string) (*ct.Artifact, error) {
+	tx, err := r.db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	artifact, err := scanArtifact(tx.QueryRow("artifact_select_for_update", id))
+	if err != nil {
+		tx.Rollback()
+		return nil, err
+	}
+	var releaseIDs []string
+	if err := tx.QueryRow("artifact_release_ids", id).Scan(&releaseIDs); err != nil {
+		tx.Rollback()
+		return nil, err
+	}
+	if len(releaseIDs) > 0 {
+		tx.Rollback()
+		return nil, artifactInUseError{ReleaseIDs: releaseIDs}
+	}
+	if err := tx.Exec("artifact_delete", id); err != nil {
+		tx.Rollback()
+		return nil, err
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+	r.cache.Remove(id)
+	return artifact, nil
+}
+
+// cachedArtifact returns a deep copy of the given artifact from the cache so
+// that callers cannot modify the cached value.
+func (r *ArtifactRepo) cachedArtifact(id string) (*ct.Artifact, bool) {
+	v, ok := r.cache.Get(id)
+	if !ok {
+		return nil, false
+	}
+	return copyArtifact(v.(*ct.Artifact)), true
+}
+
+func (r *ArtifactRepo) cacheArtifact(artifact *ct.Artifact) {
+	if r.cache == nil {
+		return
+	}
+	r.cache.Add(artifact.ID, copyArtifact(artifact))
 }
 
 func (r *ArtifactRepo) List() (interface{}, error) {
-	rows, err := r.db.Query("artifact_list")
+	return r.list("artifact_list")
+}
+
+// ListQuery lists artifacts, filtering them by whether or not they are
+// referenced by any release if the in_use query parameter is set.
+func (r *ArtifactRepo) ListQuery(query url.Values) (interface{}, error) {
+	inUse := query.Get("in_use")
+	if inUse == "" {
+		return r.List()
+	}
+	used, err := strconv.ParseBool(inUse)
+	if err != nil {
+		return nil, ct.ValidationError{Field: "in_use", Message: "must be a boolean"}
+	}
+	if used {
+		return r.list("artifact_list_in_use")
+	}
+	return r.list("artifact_list_unused")
+}
+
+func (r *ArtifactRepo) list(query string) ([]*ct.Artifact, error) {
+	rows, err := r.db.Query(query)
 	if err != nil {
 		return nil, err
 	}
@@ -101,18 +238,166 @@ func (r *ArtifactRepo) ListIDs(ids ...string) (map[string]*ct.Artifact, error) {
 	if len(ids) == 0 {
 		return nil, nil
 	}
-	rows, err := r.db.Query("artifact_list_ids", fmt.Sprintf("{%s}", strings.Join(ids, ",")))
+	artifacts := make(map[string]*ct.Artifact, len(ids))
+	uncached := make([]string, 0, len(ids))
+	for _, id := range ids {
+		if artifact, ok := r.cachedArtifact(id); ok {
+			artifacts[id] = artifact
+		} else {
+			uncached = append(uncached, id)
+		}
+	}
+	if len(uncached) == 0 {
+		return artifacts, nil
+	}
+	rows, err := r.db.Query("artifact_list_ids", fmt.Sprintf("{%s}", strings.Join(uncached, ",")))
 	if err != nil {
 		return nil, err
 	}
-	artifacts := make(map[string]*ct.Artifact, len(ids))
 	for rows.Next() {
 		artifact, err := scanArtifact(rows)
 		if err != nil {
 			rows.Close()
 			return nil, err
 		}
+		r.cacheArtifact(artifact)
 		artifacts[artifact.ID] = artifact
 	}
 	return artifacts, rows.Err()
 }
+
+// DeleteArtifact deletes an artifact which no releases reference, responding
+// with a conflict error listing the referencing releases otherwise.
+//
+// Only the artifact's record is deleted, files in the blobstore are left in
+// place.
+func (c *controllerAPI) DeleteArtifact(ctx context.Context, w http.ResponseWriter, req *http.Request) {
+	params, _ := ctxhelper.ParamsFromContext(ctx)
+	artifact, err := c.artifactRepo.Delete(params.ByName("artifacts_id"))
+	if e, ok := err.(artifactInUseError); ok {
+		jsonErr := httphelper.JSONError{
+			Code:    httphelper.ConflictErrorCode,
+			Message: fmt.Sprintf("artifact is referenced by %d release(s)", len(e.ReleaseIDs)),
+		}
+		jsonErr.Detail, _ = json.Marshal(e)
+		respondWithError(w, jsonErr)
+		return
+	} else if err != nil {
+		respondWithError(w, err)
+		return
+	}
+	httphelper.JSON(w, 200, artifact)
+}
+
+// dockerRegistryClient is the HTTP client used to resolve Docker image tags
+// to digests
+var dockerRegistryClient = &http.Client{Timeout: 30 * time.Second}
+
+// CreateDockerArtifact creates a Docker artifact, first resolving the image
+// tag in the URI's id parameter to the digest the registry currently serves
+// for it so that the artifact always refers to the same image. The original
+// tag is recorded in the artifact's "docker.tag" meta.
+func (c *controllerAPI) CreateDockerArtifact(ctx context.Context, w http.ResponseWriter, req *http.Request) {
+	var artifact ct.Artifact
+	if err := httphelper.DecodeJSON(req, &artifact); err != nil {
+		respondWithError(w, err)
+		return
+	}
+	if artifact.Type == "" {
+		artifact.Type = host.ArtifactTypeDocker
+	} else if artifact.Type != host.ArtifactTypeDocker {
+		respondWithError(w, ct.ValidationError{Field: "type", Message: "must be docker"})
+		return
+	}
+	if err := c.callDependency(ctx, dependencyRegistry, func(ctx context.Context) error {
+		return pinDockerArtifact(ctx, &artifact)
+	}); err != nil {
+		respondWithError(w, err)
+		return
+	}
+	if err := c.artifactRepo.Add(&artifact); err != nil {
+		respondWithError(w, err)
+		return
+	}
+	httphelper.JSON(w, 200, &artifact)
+}
+
+// pinDockerArtifact replaces the image tag in the artifact's URI with the
+// digest it currently resolves to, leaving URIs which already reference a
+// digest untouched.
+func pinDockerArtifact(ctx context.Context, a *ct.Artifact) error {
+	u, err := url.Parse(a.URI)
+	if err != nil || u.Host == "" || (u.Scheme != "http" && u.Scheme != "https") {
+		return ct.ValidationError{Field: "uri", Message: "must be a http or https registry URL"}
+	}
+	q := u.Query()
+	name := q.Get("name")
+	if name == "" {
+		return ct.ValidationError{Field: "uri", Message: "must include an image name"}
+	}
+	tag := q.Get("id")
+	if tag == "" {
+		tag = "latest"
+	}
+	if strings.HasPrefix(tag, "sha256:") {
+		return nil
+	}
+	digest, err := resolveDockerDigest(ctx, u, name, tag)
+	if err != nil {
+		return err
+	}
+	q.Set("id", digest)
+	u.RawQuery = q.Encode()
+	a.URI = u.String()
+	if a.Meta == nil {
+		a.Meta = make(map[string]string, 1)
+	}
+	a.Meta["docker.tag"] = tag
+	return nil
+}
+
+// resolveDockerDigest asks the registry for the digest of the manifest the
+// given tag refers to.
+func resolveDockerDigest(ctx context.Context, registry *url.URL, name, tag string) (string, error) {
+	manifestURL := &url.URL{
+		Scheme: registry.Scheme,
+		User:   registry.User,
+		Host:   registry.Host,
+		Path:   fmt.Sprintf("/v2/%s/manifests/%s", name, tag),
+	}
+	req, err := http.NewRequest("HEAD", manifestURL.String(), nil)
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Accept", "application/vnd.docker.distribution.manifest.v2+json")
+	res, err := dockerRegistryClient.Do(req.WithContext(ctx))
+	if err != nil {
+		// avoid including any registry credentials in the error
+		if e, ok := err.(*url.Error); ok {
+			err = e.Err
+		}
+		return "", httphelper.JSONError{
+			Code:    httphelper.ServiceUnavailableErrorCode,
+			Message: fmt.Sprintf("registry %s is unreachable: %s", registry.Host, err),
+			Retry:   true,
+		}
+	}
+	res.Body.Close()
+	switch {
+	case res.StatusCode == http.StatusNotFound:
+		return "", ct.ValidationError{Field: "uri", Message: fmt.Sprintf("image %s:%s does not exist in the registry", name, tag)}
+	case res.StatusCode != http.StatusOK:
+		return "", httphelper.JSONError{
+			Code:    httphelper.ServiceUnavailableErrorCode,
+			Message: fmt.Sprintf("registry %s returned unexpected status %d", registry.Host, res.StatusCode),
+		}
+	}
+	digest := res.Header.Get("Docker-Content-Digest")
+	if digest == "" {
+		return "", httphelper.JSONError{
+			Code:    httphelper.ServiceUnavailableErrorCode,
+			Message: fmt.Sprintf("registry %s did not return a digest for %s:%s", registry.Host, name, tag),
+		}
+	}
+	return digest, nil
+}
@@ -28,13 +28,24 @@ func NewBackupRepo(db *postgres.DB) *BackupRepo {
 }
 
 func (r *BackupRepo) GetLatest() (*ct.ClusterBackup, error) {
+	return scanBackup(r.db.QueryRow("backup_select_latest"))
+}
+
+func (r *BackupRepo) Get(id string) (*ct.ClusterBackup, error) {
+	return scanBackup(r.db.QueryRow("backup_select", id))
+}
+
+func scanBackup(s postgres.Scanner) (*ct.ClusterBackup, error) {
 	b := &ct.ClusterBackup{}
-	if err := r.db.QueryRow("backup_select_latest").Scan(&b.ID, &b.Status, &b.SHA512, &b.Size, &b.Error, &b.CreatedAt, &b.UpdatedAt, &b.CompletedAt); err != nil {
+	if err := s.Scan(&b.ID, &b.Status, &b.SHA512, &b.Size, &b.Error, &b.CreatedAt, &b.UpdatedAt, &b.CompletedAt); err != nil {
 		if err == pgx.ErrNoRows {
 			err = ErrNotFound
 		}
 		return nil, err
 	}
+	if b.Size > 0 {
+		b.SizeHuman = ct.ByteSize(b.Size).String()
+	}
 	return b, nil
 }
 
@@ -76,6 +87,48 @@ func (r *BackupRepo) Update(b *ct.ClusterBackup) error {
 	return tx.Commit()
 }
 
+// Prune deletes completed backups which completed before the given time,
+// returning the number deleted and their total size. Backups which are
+// running or failed are never deleted. A cluster_backup_deletion event is
+// emitted for each deleted backup in the same transaction.
+func (r *BackupRepo) Prune(before time.Time) (int, int64, error) {
+	tx, err := r.db.Begin()
+	if err != nil {
+		return 0, 0, err
+	}
+	rows, err := tx.Query("backup_prune", before)
+	if err != nil {
+		tx.Rollback()
+		return 0, 0, err
+	}
+	var backups []*ct.ClusterBackup
+	for rows.Next() {
+		b, err := scanBackup(rows)
+		if err != nil {
+			rows.Close()
+			tx.Rollback()
+			return 0, 0, err
+		}
+		backups = append(backups, b)
+	}
+	if err := rows.Err(); err != nil {
+		tx.Rollback()
+		return 0, 0, err
+	}
+	var size int64
+	for _, b := range backups {
+		size += b.Size
+		if err := createEvent(tx.Exec, &ct.Event{
+			ObjectID:   b.ID,
+			ObjectType: ct.EventTypeClusterBackupDeletion,
+		}, b); err != nil {
+			tx.Rollback()
+			return 0, 0, err
+		}
+	}
+	return len(backups), size, tx.Commit()
+}
+
 func (c *controllerAPI) GetBackup(ctx context.Context, w http.ResponseWriter, req *http.Request) {
 	if !strings.Contains(req.Header.Get("Accept"), "json") {
 		c.createAndStreamBackup(ctx, w, req)
@@ -89,6 +142,52 @@ func (c *controllerAPI) GetBackup(ctx context.Context, w http.ResponseWriter, re
 	httphelper.JSON(w, 200, b)
 }
 
+// RetryBackup takes a new backup of the cluster in place of a failed one,
+// streaming it to the client in the same way as GetBackup. The ID of the new
+// backup is returned in the Flynn-Backup-Id header.
+func (c *controllerAPI) RetryBackup(ctx context.Context, w http.ResponseWriter, req *http.Request) {
+	params, _ := ctxhelper.ParamsFromContext(ctx)
+	b, err := c.backupRepo.Get(params.ByName("backup_id"))
+	if err != nil {
+		respondWithError(w, err)
+		return
+	}
+	if b.Status != ct.ClusterBackupStatusError {
+		respondWithError(w, ct.ValidationError{
+			Field:   "status",
+			Message: fmt.Sprintf("only failed backups can be retried, backup is %s", b.Status),
+		})
+		return
+	}
+	c.createAndStreamBackup(ctx, w, req)
+}
+
+// PruneBackups deletes the records of completed backups which completed
+// before the given time. Backups are streamed to the client rather than
+// stored by the cluster, so the freed bytes are the total size of the deleted
+// backups as recorded when they were taken.
+func (c *controllerAPI) PruneBackups(ctx context.Context, w http.ResponseWriter, req *http.Request) {
+	var data ct.PruneBackups
+	if err := httphelper.DecodeJSON(req, &data); err != nil {
+		respondWithError(w, err)
+		return
+	}
+	if data.Before == nil {
+		respondWithError(w, ct.ValidationError{Field: "before", Message: "must be set"})
+		return
+	}
+	deleted, size, err := c.backupRepo.Prune(*data.Before)
+	if err != nil {
+		respondWithError(w, err)
+		return
+	}
+	res := &ct.PruneBackupsResult{Deleted: deleted, FreedBytes: size}
+	if size > 0 {
+		res.FreedHuman = ct.ByteSize(size).String()
+	}
+	httphelper.JSON(w, 200, res)
+}
+
 type sizeWriter struct {
 	size int
 	w    io.Writer
@@ -143,6 +242,7 @@ func (c *controllerAPI) createAndStreamBackup(ctx context.Context, w http.Respon
 		handleError(err)
 		return
 	}
+	w.Header().Set("Flynn-Backup-Id", b.ID)
 
 	h := sha512.New()
 	hw := io.MultiWriter(h, w)
@@ -164,6 +264,7 @@ func (c *controllerAPI) createAndStreamBackup(ctx context.Context, w http.Respon
 	b.Status = ct.ClusterBackupStatusComplete
 	b.SHA512 = hex.EncodeToString(h.Sum(nil))
 	b.Size = int64(sw.Size())
+	b.SizeHuman = ct.ByteSize(b.Size).String()
 	now := time.Now()
 	b.CompletedAt = &now
 	if err := c.backupRepo.Update(b); err != nil {
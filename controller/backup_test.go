@@ -1,10 +1,14 @@
 package main
 
 import (
+	"io/ioutil"
+	"net/http"
 	"time"
 
 	"github.com/flynn/flynn/controller/client"
 	ct "github.com/flynn/flynn/controller/types"
+	hh "github.com/flynn/flynn/pkg/httphelper"
+	"github.com/flynn/flynn/pkg/random"
 	. "github.com/flynn/go-check"
 )
 
@@ -30,6 +34,7 @@ func (s *S) TestGetBackup(c *C) {
 	c.Assert(rb.Status, Equals, b.Status)
 	c.Assert(rb.SHA512, Equals, b.SHA512)
 	c.Assert(rb.Size, Equals, b.Size)
+	c.Assert(rb.SizeHuman, Equals, "123 B")
 	c.Assert(rb.CompletedAt, Not(IsNil))
 	c.Assert(rb.CreatedAt, Not(IsNil))
 	c.Assert(rb.UpdatedAt, Not(IsNil))
@@ -37,3 +42,94 @@ func (s *S) TestGetBackup(c *C) {
 	c.Assert(rb.CreatedAt.Truncate(time.Millisecond).Equal(b.CreatedAt.Truncate(time.Millisecond)), Equals, true)
 	c.Assert(rb.UpdatedAt.Truncate(time.Millisecond).Equal(b.UpdatedAt.Truncate(time.Millisecond)), Equals, true)
 }
+
+func (s *S) TestRetryBackup(c *C) {
+	insertBackup := func(status string) *ct.ClusterBackup {
+		now := time.Now()
+		b := &ct.ClusterBackup{Status: status, CompletedAt: &now}
+		err := s.hc.db.QueryRow("backup_insert", b.Status, b.SHA512, b.Size, b.Error, b.CompletedAt).Scan(&b.ID, &b.CreatedAt, &b.UpdatedAt)
+		c.Assert(err, IsNil)
+		return b
+	}
+
+	// retrying a completed backup should fail
+	complete := insertBackup(ct.ClusterBackupStatusComplete)
+	_, _, err := s.c.RetryBackup(complete.ID)
+	c.Assert(err, NotNil)
+	c.Assert(hh.IsValidationError(err), Equals, true)
+
+	// retrying an unknown backup should fail
+	_, _, err = s.c.RetryBackup(random.UUID())
+	c.Assert(err, Equals, controller.ErrNotFound)
+
+	// retrying a failed backup should start a new backup. The backup itself
+	// fails without a cluster to back up, which the client reports as an
+	// error, so make the request directly to check the new backup's ID
+	failed := insertBackup(ct.ClusterBackupStatusError)
+	req, err := http.NewRequest("POST", s.srv.URL+"/backups/"+failed.ID+"/retry", nil)
+	c.Assert(err, IsNil)
+	req.SetBasicAuth("", authKey)
+	res, err := http.DefaultClient.Do(req)
+	c.Assert(err, IsNil)
+	_, err = ioutil.ReadAll(res.Body)
+	res.Body.Close()
+	c.Assert(err, IsNil)
+	id := res.Header.Get("Flynn-Backup-Id")
+	c.Assert(id, Not(Equals), "")
+	c.Assert(id, Not(Equals), failed.ID)
+
+	// the new backup should be the latest, and no longer running
+	latest, err := s.c.GetBackupMeta()
+	c.Assert(err, IsNil)
+	c.Assert(latest.ID, Equals, id)
+	c.Assert(latest.Status, Not(Equals), ct.ClusterBackupStatusRunning)
+}
+
+func (s *S) TestPruneBackups(c *C) {
+	insertBackup := func(status string, size int64, completedAt *time.Time) *ct.ClusterBackup {
+		b := &ct.ClusterBackup{Status: status, Size: size, CompletedAt: completedAt}
+		err := s.hc.db.QueryRow("backup_insert", b.Status, b.SHA512, b.Size, b.Error, b.CompletedAt).Scan(&b.ID, &b.CreatedAt, &b.UpdatedAt)
+		c.Assert(err, IsNil)
+		return b
+	}
+	now := time.Now()
+	old := now.Add(-48 * time.Hour)
+	cutoff := now.Add(-24 * time.Hour)
+
+	old1 := insertBackup(ct.ClusterBackupStatusComplete, 1024, &old)
+	old2 := insertBackup(ct.ClusterBackupStatusComplete, 2048, &old)
+	oldFailed := insertBackup(ct.ClusterBackupStatusError, 10, &old)
+	running := insertBackup(ct.ClusterBackupStatusRunning, 0, nil)
+	recent := insertBackup(ct.ClusterBackupStatusComplete, 4096, &now)
+
+	res, err := s.c.PruneBackups(cutoff)
+	c.Assert(err, IsNil)
+	c.Assert(res.Deleted, Equals, 2)
+	c.Assert(res.FreedBytes, Equals, int64(3072))
+	c.Assert(res.FreedHuman, Equals, "3.0 KiB")
+
+	// only old completed backups should have been removed
+	repo := NewBackupRepo(s.hc.db)
+	for _, b := range []*ct.ClusterBackup{old1, old2} {
+		_, err := repo.Get(b.ID)
+		c.Assert(err, Equals, ErrNotFound)
+	}
+	for _, b := range []*ct.ClusterBackup{oldFailed, running, recent} {
+		_, err := repo.Get(b.ID)
+		c.Assert(err, IsNil)
+	}
+
+	// a deletion event should have been emitted for each pruned backup
+	for _, b := range []*ct.ClusterBackup{old1, old2} {
+		var count int
+		err := s.hc.db.QueryRow("SELECT count(*) FROM events WHERE object_type = $1 AND object_id = $2", string(ct.EventTypeClusterBackupDeletion), b.ID).Scan(&count)
+		c.Assert(err, IsNil)
+		c.Assert(count, Equals, 1)
+	}
+
+	// pruning again should be a no-op
+	res, err = s.c.PruneBackups(cutoff)
+	c.Assert(err, IsNil)
+	c.Assert(res.Deleted, Equals, 0)
+	c.Assert(res.FreedBytes, Equals, int64(0))
+}
@@ -0,0 +1,256 @@
+package main
+
+import (
+	"container/list"
+	"sync"
+	"time"
+
+	ct "github.com/flynn/flynn/controller/types"
+	"github.com/flynn/flynn/host/resource"
+)
+
+// objectCache is a bounded, thread-safe LRU cache with a per-entry TTL which
+// is used to avoid repeatedly loading objects which don't change once they
+// have been created (i.e. releases and artifacts).
+//
+// A nil *objectCache is valid and caches nothing, which is how caching is
+// disabled.
+type objectCache struct {
+	size int
+	ttl  time.Duration
+
+	mtx   sync.Mutex
+	items map[string]*list.Element
+	order *list.List
+
+	// now is used to determine entry expiry and is overridden in tests
+	now func() time.Time
+}
+
+type objectCacheEntry struct {
+	key     string
+	value   interface{}
+	expires time.Time
+}
+
+func newObjectCache(size int, ttl time.Duration) *objectCache {
+	if size <= 0 {
+		return nil
+	}
+	return &objectCache{
+		size:  size,
+		ttl:   ttl,
+		items: make(map[string]*list.Element, size),
+		order: list.New(),
+		now:   time.Now,
+	}
+}
+
+// Get returns the value cached for the given key, if any.
+func (c *objectCache) Get(key string) (interface{}, bool) {
+	if c == nil {
+		return nil, false
+	}
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+	elem, ok := c.items[key]
+	if !ok {
+		return nil, false
+	}
+	entry := elem.Value.(*objectCacheEntry)
+	if c.ttl > 0 && !c.now().Before(entry.expires) {
+		c.removeElement(elem)
+		return nil, false
+	}
+	c.order.MoveToFront(elem)
+	return entry.value, true
+}
+
+// Add caches the given value, evicting the least recently used entry if the
+// cache is full.
+func (c *objectCache) Add(key string, value interface{}) {
+	if c == nil {
+		return
+	}
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+	expires := c.now().Add(c.ttl)
+	if elem, ok := c.items[key]; ok {
+		entry := elem.Value.(*objectCacheEntry)
+		entry.value = value
+		entry.expires = expires
+		c.order.MoveToFront(elem)
+		return
+	}
+	c.items[key] = c.order.PushFront(&objectCacheEntry{key: key, value: value, expires: expires})
+	for c.order.Len() > c.size {
+		c.removeElement(c.order.Back())
+	}
+}
+
+// Remove removes the given keys from the cache, and should be called
+// whenever a cached object is deleted.
+func (c *objectCache) Remove(keys ...string) {
+	if c == nil {
+		return
+	}
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+	for _, key := range keys {
+		if elem, ok := c.items[key]; ok {
+			c.removeElement(elem)
+		}
+	}
+}
+
+func (c *objectCache) removeElement(elem *list.Element) {
+	c.order.Remove(elem)
+	delete(c.items, elem.Value.(*objectCacheEntry).key)
+}
+
+// currentReleaseCache caches the current releases of apps for the duration of
+// a single request, so a handler which needs an app's current release in
+// several places (e.g. to build a new release and then to deploy it) only
+// looks it up once. Concurrent lookups of the same app share a single load.
+type currentReleaseCache struct {
+	load func(appID string) (*ct.Release, error)
+
+	mtx     sync.Mutex
+	entries map[string]*currentReleaseEntry
+}
+
+type currentReleaseEntry struct {
+	once    sync.Once
+	release *ct.Release
+	err     error
+}
+
+func newCurrentReleaseCache(load func(appID string) (*ct.Release, error)) *currentReleaseCache {
+	return &currentReleaseCache{
+		load:    load,
+		entries: make(map[string]*currentReleaseEntry),
+	}
+}
+
+// Get returns a deep copy of the app's current release, loading it if it has
+// not already been loaded. Errors (including ErrNotFound if the app has no
+// release) are cached along with releases.
+func (c *currentReleaseCache) Get(appID string) (*ct.Release, error) {
+	c.mtx.Lock()
+	entry, ok := c.entries[appID]
+	if !ok {
+		entry = &currentReleaseEntry{}
+		c.entries[appID] = entry
+	}
+	c.mtx.Unlock()
+
+	entry.once.Do(func() {
+		entry.release, entry.err = c.load(appID)
+	})
+	if entry.err != nil {
+		return nil, entry.err
+	}
+	return copyRelease(entry.release), nil
+}
+
+// Remove forgets the app's current release, and should be called whenever
+// the app's release is changed.
+func (c *currentReleaseCache) Remove(appID string) {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+	delete(c.entries, appID)
+}
+
+// copyRelease returns a deep copy of the given release so that neither the
+// caller nor the cache can modify a value the other holds.
+func copyRelease(r *ct.Release) *ct.Release {
+	release := *r
+	release.ArtifactIDs = copyStrings(r.ArtifactIDs)
+	release.Env = copyStringMap(r.Env)
+	release.Meta = copyStringMap(r.Meta)
+	release.CreatedAt = copyTime(r.CreatedAt)
+	release.DeletedAt = copyTime(r.DeletedAt)
+	if r.Processes != nil {
+		release.Processes = make(map[string]ct.ProcessType, len(r.Processes))
+		for name, proc := range r.Processes {
+			release.Processes[name] = copyProcessType(proc)
+		}
+	}
+	return &release
+}
+
+func copyProcessType(p ct.ProcessType) ct.ProcessType {
+	proc := p
+	proc.Args = copyStrings(p.Args)
+	proc.Env = copyStringMap(p.Env)
+	proc.DeprecatedCmd = copyStrings(p.DeprecatedCmd)
+	proc.DeprecatedEntrypoint = copyStrings(p.DeprecatedEntrypoint)
+	if p.Ports != nil {
+		proc.Ports = make([]ct.Port, len(p.Ports))
+		for i, port := range p.Ports {
+			if port.Service != nil {
+				service := *port.Service
+				if service.Check != nil {
+					check := *service.Check
+					service.Check = &check
+				}
+				port.Service = &service
+			}
+			proc.Ports[i] = port
+		}
+	}
+	if p.Resources != nil {
+		proc.Resources = make(resource.Resources, len(p.Resources))
+		for typ, spec := range p.Resources {
+			proc.Resources[typ] = resource.Spec{
+				Request: copyInt64(spec.Request),
+				Limit:   copyInt64(spec.Limit),
+			}
+		}
+	}
+	return proc
+}
+
+// copyArtifact returns a deep copy of the given artifact so that neither the
+// caller nor the cache can modify a value the other holds.
+func copyArtifact(a *ct.Artifact) *ct.Artifact {
+	artifact := *a
+	artifact.Meta = copyStringMap(a.Meta)
+	artifact.CreatedAt = copyTime(a.CreatedAt)
+	artifact.Size = copyInt64(a.Size)
+	return &artifact
+}
+
+func copyStrings(s []string) []string {
+	if s == nil {
+		return nil
+	}
+	return append(make([]string, 0, len(s)), s...)
+}
+
+func copyStringMap(m map[string]string) map[string]string {
+	if m == nil {
+		return nil
+	}
+	res := make(map[string]string, len(m))
+	for k, v := range m {
+		res[k] = v
+	}
+	return res
+}
+
+func copyTime(t *time.Time) *time.Time {
+	if t == nil {
+		return nil
+	}
+	res := *t
+	return &res
+}
+
+func copyInt64(i *int64) *int64 {
+	if i == nil {
+		return nil
+	}
+	res := *i
+	return &res
+}
@@ -0,0 +1,155 @@
+package main
+
+import (
+	"sync"
+	"time"
+
+	ct "github.com/flynn/flynn/controller/types"
+	"github.com/flynn/flynn/host/types"
+	. "github.com/flynn/go-check"
+	"github.com/flynn/que-go"
+)
+
+func (s *S) TestObjectCache(c *C) {
+	// a nil cache caches nothing
+	var nilCache *objectCache
+	nilCache.Add("a", 1)
+	_, ok := nilCache.Get("a")
+	c.Assert(ok, Equals, false)
+	c.Assert(newObjectCache(0, time.Minute), IsNil)
+
+	now := time.Now()
+	cache := newObjectCache(2, time.Minute)
+	cache.now = func() time.Time { return now }
+
+	// the least recently used entry is evicted when the cache is full
+	cache.Add("a", 1)
+	cache.Add("b", 2)
+	v, ok := cache.Get("a")
+	c.Assert(ok, Equals, true)
+	c.Assert(v, Equals, 1)
+	cache.Add("c", 3)
+	_, ok = cache.Get("b")
+	c.Assert(ok, Equals, false)
+	_, ok = cache.Get("a")
+	c.Assert(ok, Equals, true)
+
+	// removed entries are no longer returned
+	cache.Remove("a")
+	_, ok = cache.Get("a")
+	c.Assert(ok, Equals, false)
+
+	// entries expire after the TTL
+	now = now.Add(time.Minute)
+	_, ok = cache.Get("c")
+	c.Assert(ok, Equals, false)
+}
+
+func (s *S) TestReleaseCache(c *C) {
+	app := s.createTestApp(c, &ct.App{Name: "release-cache"})
+	fileArtifact := s.createTestArtifact(c, &ct.Artifact{Type: host.ArtifactTypeFile})
+	release := s.createTestRelease(c, &ct.Release{
+		ArtifactIDs: []string{s.createTestArtifact(c, &ct.Artifact{}).ID, fileArtifact.ID},
+		Meta:        map[string]string{"foo": "bar"},
+	})
+
+	artifactRepo := NewArtifactRepo(s.hc.db)
+	artifactRepo.cache = newObjectCache(10, time.Minute)
+	releaseRepo := NewReleaseRepo(s.hc.db, artifactRepo, que.NewClient(s.hc.db.ConnPool))
+	releaseRepo.cache = newObjectCache(10, time.Minute)
+
+	// load the release and artifact into the cache
+	_, err := releaseRepo.Get(release.ID)
+	c.Assert(err, IsNil)
+	_, err = artifactRepo.ListIDs(fileArtifact.ID)
+	c.Assert(err, IsNil)
+
+	// modify the rows directly so we can tell if the cache was hit
+	c.Assert(s.hc.db.Exec("UPDATE releases SET meta = $2 WHERE release_id = $1", release.ID, map[string]string{"foo": "baz"}), IsNil)
+	c.Assert(s.hc.db.Exec("UPDATE artifacts SET meta = $2 WHERE artifact_id = $1", fileArtifact.ID, map[string]string{"foo": "baz"}), IsNil)
+
+	data, err := releaseRepo.Get(release.ID)
+	c.Assert(err, IsNil)
+	c.Assert(data.(*ct.Release).Meta["foo"], Equals, "bar")
+
+	// modifying a returned release does not modify the cached release
+	data.(*ct.Release).Meta["foo"] = "modified"
+	data, err = releaseRepo.Get(release.ID)
+	c.Assert(err, IsNil)
+	c.Assert(data.(*ct.Release).Meta["foo"], Equals, "bar")
+
+	data, err = artifactRepo.Get(fileArtifact.ID)
+	c.Assert(err, IsNil)
+	c.Assert(data.(*ct.Artifact).Meta, IsNil)
+
+	// deleting the release should invalidate both the release and its
+	// file artifact
+	c.Assert(releaseRepo.Delete(app, release), IsNil)
+	_, err = releaseRepo.Get(release.ID)
+	c.Assert(err, Equals, ErrNotFound)
+	_, err = artifactRepo.Get(fileArtifact.ID)
+	c.Assert(err, Equals, ErrNotFound)
+}
+
+func (s *S) TestCurrentReleaseCache(c *C) {
+	var mtx sync.Mutex
+	loads := make(map[string]int)
+	cache := newCurrentReleaseCache(func(appID string) (*ct.Release, error) {
+		mtx.Lock()
+		loads[appID]++
+		mtx.Unlock()
+		if appID == "no-release" {
+			return nil, ErrNotFound
+		}
+		// give concurrent lookups a chance to overlap
+		time.Sleep(10 * time.Millisecond)
+		return &ct.Release{
+			ID:          "release-" + appID,
+			ArtifactIDs: []string{"artifact"},
+			Env:         map[string]string{"FOO": "bar"},
+			Processes:   map[string]ct.ProcessType{"web": {Args: []string{"start"}}},
+		}, nil
+	})
+
+	// concurrent lookups of the same app only load the release once
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			release, err := cache.Get("app")
+			c.Check(err, IsNil)
+			c.Check(release.ID, Equals, "release-app")
+		}()
+	}
+	wg.Wait()
+	c.Assert(loads["app"], Equals, 1)
+
+	// returned releases are deep copies
+	release, err := cache.Get("app")
+	c.Assert(err, IsNil)
+	release.ID = "modified"
+	release.ArtifactIDs[0] = "modified"
+	release.Env["FOO"] = "modified"
+	release.Processes["web"].Args[0] = "modified"
+	release, err = cache.Get("app")
+	c.Assert(err, IsNil)
+	c.Assert(release.ID, Equals, "release-app")
+	c.Assert(release.ArtifactIDs, DeepEquals, []string{"artifact"})
+	c.Assert(release.Env, DeepEquals, map[string]string{"FOO": "bar"})
+	c.Assert(release.Processes["web"].Args, DeepEquals, []string{"start"})
+	c.Assert(loads["app"], Equals, 1)
+
+	// ErrNotFound is cached
+	for i := 0; i < 2; i++ {
+		_, err := cache.Get("no-release")
+		c.Assert(err, Equals, ErrNotFound)
+	}
+	c.Assert(loads["no-release"], Equals, 1)
+
+	// removed releases are loaded again
+	cache.Remove("app")
+	_, err = cache.Get("app")
+	c.Assert(err, IsNil)
+	c.Assert(loads["app"], Equals, 2)
+}
@@ -0,0 +1,141 @@
+package main
+
+import (
+	"crypto/tls"
+	"net/http"
+	"strings"
+
+	ct "github.com/flynn/flynn/controller/types"
+	"github.com/flynn/flynn/pkg/httphelper"
+	routerc "github.com/flynn/flynn/router/client"
+	"github.com/flynn/flynn/router/types"
+	"golang.org/x/net/context"
+)
+
+// UpsertCertificate creates a TLS certificate in the router independently of
+// any route so it can later be attached to routes by ID, or if an ID is given
+// replaces that certificate's cert and key.
+//
+// The router stores certificates by the hash of their cert, so replacing a
+// certificate creates a new one, moves the old certificate's routes to it and
+// deletes the old one, meaning the responded certificate's ID differs from
+// the given ID unless the cert is unchanged.
+func (c *controllerAPI) UpsertCertificate(ctx context.Context, w http.ResponseWriter, req *http.Request) {
+	var data router.Certificate
+	if err := httphelper.DecodeJSON(req, &data); err != nil {
+		respondWithError(w, err)
+		return
+	}
+	cert := &router.Certificate{
+		Cert: strings.TrimSpace(data.Cert),
+		Key:  strings.TrimSpace(data.Key),
+	}
+	if err := validateCertificate(cert); err != nil {
+		respondWithError(w, err)
+		return
+	}
+
+	if data.ID != "" {
+		if _, err := c.routerc.GetCert(data.ID); err != nil {
+			respondWithError(w, routerError(err))
+			return
+		}
+		oldRoutes, err := c.routerc.ListCertRoutes(data.ID)
+		if err != nil {
+			respondWithError(w, routerError(err))
+			return
+		}
+		for _, route := range oldRoutes {
+			cert.Routes = append(cert.Routes, route.ID)
+		}
+		defer c.forgetRoutes(oldRoutes)
+	}
+
+	if err := c.routerc.CreateCert(cert); err != nil {
+		respondWithError(w, routerError(err))
+		return
+	}
+	if data.ID != "" && cert.ID != data.ID {
+		if err := c.routerc.DeleteCert(data.ID); err != nil {
+			respondWithError(w, routerError(err))
+			return
+		}
+	}
+	httphelper.JSON(w, 200, cert)
+}
+
+// validateCertificate checks that the certificate has a valid cert and a key
+// which matches it.
+func validateCertificate(cert *router.Certificate) error {
+	if cert.Cert == "" {
+		return ct.ValidationError{Field: "cert", Message: "must be set"}
+	}
+	if cert.Key == "" {
+		return ct.ValidationError{Field: "key", Message: "must be set"}
+	}
+	if _, err := parseLeafCertificate(cert.Cert); err != nil {
+		return ct.ValidationError{Field: "cert", Message: "is not a valid PEM encoded certificate"}
+	}
+	if _, err := tls.X509KeyPair([]byte(cert.Cert), []byte(cert.Key)); err != nil {
+		return ct.ValidationError{Field: "key", Message: "does not match the certificate"}
+	}
+	return nil
+}
+
+// RotateRouteCertificate replaces the certificate of the route in the request
+// with the given cert and key, responding with the new certificate.
+//
+// The new certificate is created already attached to the route so the router
+// switches the route over in a single step rather than detaching the old
+// certificate first, and the old certificate is then deleted unless other
+// routes still use it.
+func (c *controllerAPI) RotateRouteCertificate(ctx context.Context, w http.ResponseWriter, req *http.Request) {
+	var data router.Certificate
+	if err := httphelper.DecodeJSON(req, &data); err != nil {
+		respondWithError(w, err)
+		return
+	}
+	cert := &router.Certificate{
+		Cert: strings.TrimSpace(data.Cert),
+		Key:  strings.TrimSpace(data.Key),
+	}
+	if err := validateCertificate(cert); err != nil {
+		respondWithError(w, err)
+		return
+	}
+
+	route, err := c.getRoute(ctx)
+	if err != nil {
+		respondWithError(w, err)
+		return
+	}
+	if route.Type != "http" {
+		respondWithError(w, ct.ValidationError{Field: "route", Message: "must be an HTTP route to have a certificate"})
+		return
+	}
+	var oldID string
+	if route.Certificate != nil {
+		oldID = route.Certificate.ID
+	}
+	cert.Routes = []string{route.ID}
+	defer c.routeCache.Remove(c.getApp(ctx).ID)
+
+	if err := c.routerc.CreateCert(cert); err != nil {
+		respondWithError(w, routerError(err))
+		return
+	}
+	if oldID != "" && oldID != cert.ID {
+		routes, err := c.routerc.ListCertRoutes(oldID)
+		if err != nil {
+			respondWithError(w, routerError(err))
+			return
+		}
+		if len(routes) == 0 {
+			if err := c.routerc.DeleteCert(oldID); err != nil && err != routerc.ErrNotFound {
+				respondWithError(w, routerError(err))
+				return
+			}
+		}
+	}
+	httphelper.JSON(w, 200, cert)
+}
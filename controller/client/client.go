@@ -3,6 +3,7 @@ package controller
 
 import (
 	"crypto/tls"
+	"encoding/json"
 	"errors"
 	"io"
 	"net/http"
@@ -20,72 +21,151 @@ import (
 
 type Client interface {
 	GetCACert() ([]byte, error)
+	GetServerInfo() (*ct.ServerInfo, error)
 	StreamFormations(since *time.Time, output chan<- *ct.ExpandedFormation) (stream.Stream, error)
 	PutDomain(dm *ct.DomainMigration) error
 	CreateArtifact(artifact *ct.Artifact) error
+	CreateDockerArtifact(artifact *ct.Artifact) error
 	CreateRelease(release *ct.Release) error
 	CreateApp(app *ct.App) error
+	CreateApps(apps []*ct.App) error
 	UpdateApp(app *ct.App) error
+	UpdateAppIfUnmodifiedSince(app *ct.App, since time.Time) error
 	UpdateAppMeta(app *ct.App) error
 	DeleteApp(appID string) (*ct.AppDeletion, error)
+	CloneApp(appID, name string) (*ct.App, error)
+	ExportApp(appID string) (*ct.AppExport, error)
+	ImportApp(export *ct.AppExport) (*ct.App, error)
 	CreateProvider(provider *ct.Provider) error
 	GetProvider(providerID string) (*ct.Provider, error)
+	UpdateProvider(provider *ct.Provider) error
 	ProvisionResource(req *ct.ResourceReq) (*ct.Resource, error)
+	CreateProviderResource(req *ct.ProviderResourceReq) (*ct.ProviderResource, error)
+	CreateAppResource(appID string, req *ct.AppResourceReq) (*ct.Resource, error)
 	GetResource(providerID, resourceID string) (*ct.Resource, error)
 	ResourceListAll() ([]*ct.Resource, error)
+	ResourceListByApp(appID string) ([]*ct.Resource, error)
+	StreamResourceStatus(resourceID string, output chan *ct.ResourceStatus) (stream.Stream, error)
 	ResourceList(providerID string) ([]*ct.Resource, error)
 	AddResourceApp(providerID, resourceID, appID string) (*ct.Resource, error)
+	AddResourceApps(providerID, resourceID string, appIDs []string) (*ct.Resource, error)
 	DeleteResourceApp(providerID, resourceID, appID string) (*ct.Resource, error)
 	AppResourceList(appID string) ([]*ct.Resource, error)
 	PutResource(resource *ct.Resource) error
 	DeleteResource(providerID, resourceID string) (*ct.Resource, error)
 	PutFormation(formation *ct.Formation) error
+	PutFormationIfUnmodifiedSince(formation *ct.Formation, since time.Time) error
+	PutFormations(formations []*ct.Formation) error
 	PutJob(job *ct.Job) error
 	DeleteJob(appID, jobID string) error
+	RestartJob(appID, jobID string) (*ct.Job, error)
+	StopReleaseJobs(appID, releaseID string) ([]*ct.Job, error)
 	SetAppRelease(appID, releaseID string) error
+	SetCurrentRelease(appID, releaseID string) (*ct.App, error)
+	SetPendingRelease(appID, releaseID string) (*ct.App, error)
+	GetPendingRelease(appID string) (*ct.Release, error)
+	ClearPendingRelease(appID string) (*ct.App, error)
 	GetAppRelease(appID string) (*ct.Release, error)
+	GetPreviousRelease(appID, releaseID string) (*ct.Release, error)
+	GetAppConfigChecksum(appID string) (string, error)
+	GetAppEffectiveEnv(appID string) (map[string]string, error)
+	PromoteRelease(appID string, req *ct.PromoteRelease) (*ct.PromotedRelease, error)
+	SetAppEnv(appID string, req *ct.AppEnvUpdate) (*ct.PromotedRelease, error)
+	GetAppMetaValue(appID, key string) (*string, error)
+	GetReleaseMetaValue(releaseID, key string) (*string, error)
+	GetReleaseDefaultProcesses(releaseID string) (map[string]int, error)
+	GetReleaseSize(releaseID string) (*int64, error)
+	GetReleaseInUse(releaseID string) (bool, error)
+	ImportRelease(release *ct.Release, artifacts []*ct.Artifact) error
+	ReviseRelease(releaseID string, artifactIDs []string) (*ct.Release, error)
 	RouteList(appID string) ([]*router.Route, error)
+	GetAppRouteCount(appID string) (*int, error)
+	GetAppLeaderJob(appID string) (*ct.Job, error)
 	GetRoute(appID string, routeID string) (*router.Route, error)
 	CreateRoute(appID string, route *router.Route) error
+	CreateRoutes(appID string, routes []*router.Route) ([]*router.Route, error)
+	UpdateAppRoutes(appID string, flags *ct.AppRouteFlags) ([]*router.Route, error)
+	UpsertCertificate(cert *router.Certificate) error
 	UpdateRoute(appID string, routeID string, route *router.Route) error
 	DeleteRoute(appID string, routeID string) error
+	ChangeRouteDomain(appID string, routeID string, domain string) (*router.Route, error)
+	RotateRouteCertificate(appID string, routeID string, cert *router.Certificate) (*router.Certificate, error)
+	GetDomainApp(domain string) (*ct.App, error)
+	GetAppCertificateStatus(appID string) (*ct.CertificateStatus, error)
+	GetRouteURL(appID string, routeID string) (string, error)
+	GetRouteMeta(appID string, routeID string) (map[string]string, error)
+	UpdateRouteMeta(appID string, routeID string, meta map[string]string) error
 	GetFormation(appID, releaseID string) (*ct.Formation, error)
 	GetExpandedFormation(appID, releaseID string) (*ct.ExpandedFormation, error)
+	GetCurrentExpandedFormation(appID string) (*ct.ExpandedFormation, error)
+	GetDegradedProcesses(appID string) ([]*ct.DegradedProcess, error)
 	FormationList(appID string) ([]*ct.Formation, error)
+	CordonApp(appID string) ([]*ct.Formation, error)
+	UncordonApp(appID string) ([]*ct.Formation, error)
+	PauseApp(appID string) (*ct.App, error)
+	ResumeApp(appID string) (*ct.App, error)
 	FormationListActive() ([]*ct.ExpandedFormation, error)
+	StreamAppFormations(appID string, output chan<- *ct.ExpandedFormation) (stream.Stream, error)
 	DeleteFormation(appID, releaseID string) error
 	GetRelease(releaseID string) (*ct.Release, error)
 	GetArtifact(artifactID string) (*ct.Artifact, error)
+	DeleteArtifact(artifactID string) (*ct.Artifact, error)
 	GetApp(appID string) (*ct.App, error)
 	GetAppLog(appID string, options *ct.LogOpts) (io.ReadCloser, error)
 	StreamAppLog(appID string, options *ct.LogOpts, output chan<- *ct.SSELogChunk) (stream.Stream, error)
 	GetDeployment(deploymentID string) (*ct.Deployment, error)
 	CreateDeployment(appID, releaseID string) (*ct.Deployment, error)
+	CreateDeploymentWithStrategy(appID, releaseID, strategy string) (*ct.Deployment, error)
+	RestartApp(appID string) (*ct.Deployment, error)
 	DeploymentList(appID string) ([]*ct.Deployment, error)
+	ReleaseDeploymentList(releaseID string) ([]*ct.Deployment, error)
+	GetAppDeployTimes(appID string) (*ct.AppDeployTimes, error)
 	StreamDeployment(d *ct.Deployment, output chan *ct.DeploymentEvent) (stream.Stream, error)
+	GetDeploymentProgress(deploymentID string) (*ct.DeploymentProgress, error)
+	GetDeploymentTimeline(deploymentID string) ([]*ct.Event, error)
+	StreamDeploymentProgress(deploymentID string, output chan *ct.DeploymentProgress) (stream.Stream, error)
+	StreamAppLifecycle(output chan *ct.AppLifecycleEvent) (stream.Stream, error)
 	DeployAppRelease(appID, releaseID string, stopWait <-chan struct{}) error
 	StreamJobEvents(appID string, output chan *ct.Job) (stream.Stream, error)
 	WatchJobEvents(appID, releaseID string) (ct.JobWatcher, error)
 	StreamEvents(opts ct.StreamEventsOptions, output chan *ct.Event) (stream.Stream, error)
+	StreamAppActivity(appID string, objectTypes []ct.EventType, output chan *ct.Event) (stream.Stream, error)
 	ListEvents(opts ct.ListEventsOptions) ([]*ct.Event, error)
 	GetEvent(id int64) (*ct.Event, error)
+	GetLatestEventID() (int64, error)
+	GetEventApp(id int64) (*ct.App, error)
+	GetEventAppAtEventTime(id int64) (*ct.App, error)
+	GetEventData(id int64) (json.RawMessage, error)
+	ListAppEvents(appIDs []string, count int) ([]*ct.AppEvents, error)
+	ListRecentAppEvents(appID string, count int) ([]*ct.Event, error)
 	ExpectedScalingEvents(actual, expected map[string]int, releaseProcesses map[string]ct.ProcessType, clusterSize int) ct.JobEvents
 	RunJobAttached(appID string, job *ct.NewJob) (httpclient.ReadWriteCloser, error)
 	RunJobDetached(appID string, req *ct.NewJob) (*ct.Job, error)
+	ScheduleJob(appID string, req *ct.ScheduledJob) (*ct.ScheduledJob, error)
+	PruneJobs(req *ct.PruneJobs) (*ct.PruneJobsResult, error)
 	GetJob(appID, jobID string) (*ct.Job, error)
+	GetJobNetwork(appID, jobID string) (*ct.JobNetwork, error)
 	JobList(appID string) ([]*ct.Job, error)
+	JobListByRelease(appID string) ([]*ct.ReleaseJobs, error)
+	JobListPage(appID string, opts ct.ListJobsOptions) ([]*ct.Job, error)
 	JobListActive() ([]*ct.Job, error)
 	AppList() ([]*ct.App, error)
+	AppListIDs(ids []string) ([]*ct.App, error)
 	KeyList() ([]*ct.Key, error)
 	ArtifactList() ([]*ct.Artifact, error)
+	ArtifactListInUse(inUse bool) ([]*ct.Artifact, error)
 	ReleaseList() ([]*ct.Release, error)
 	AppReleaseList(appID string) ([]*ct.Release, error)
+	DeletedReleaseList(appID string) ([]*ct.Release, error)
 	CreateKey(pubKey string) (*ct.Key, error)
 	GetKey(keyID string) (*ct.Key, error)
 	DeleteKey(id string) error
 	ProviderList() ([]*ct.Provider, error)
 	Backup() (io.ReadCloser, error)
 	GetBackupMeta() (*ct.ClusterBackup, error)
+	GetClusterStats() (*ct.ClusterStats, error)
+	RetryBackup(backupID string) (string, io.ReadCloser, error)
+	PruneBackups(before time.Time) (*ct.PruneBackupsResult, error)
 	DeleteRelease(appID, releaseID string) (*ct.ReleaseDeletion, error)
 	ScheduleAppGarbageCollection(appID string) error
 }
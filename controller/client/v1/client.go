@@ -115,6 +115,12 @@ func (c *Client) GetCACert() ([]byte, error) {
 	return cert.Bytes(), nil
 }
 
+// GetServerInfo returns the controller's version and enabled features.
+func (c *Client) GetServerInfo() (*ct.ServerInfo, error) {
+	info := &ct.ServerInfo{}
+	return info, c.Get("/server-info", info)
+}
+
 // StreamFormations yields a series of ExpandedFormation into the provided channel.
 // If since is not nil, only retrieves formation updates since the specified time.
 func (c *Client) StreamFormations(since *time.Time, output chan<- *ct.ExpandedFormation) (stream.Stream, error) {
@@ -142,6 +148,12 @@ func (c *Client) CreateArtifact(artifact *ct.Artifact) error {
 	return c.Post("/artifacts", artifact, artifact)
 }
 
+// CreateDockerArtifact creates a new Docker artifact, pinning the image tag
+// in the artifact's URI to the digest it currently refers to in the registry.
+func (c *Client) CreateDockerArtifact(artifact *ct.Artifact) error {
+	return c.Post("/artifacts/docker", artifact, artifact)
+}
+
 // CreateRelease creates a new release.
 func (c *Client) CreateRelease(release *ct.Release) error {
 	return c.Post("/releases", release, release)
@@ -152,6 +164,22 @@ func (c *Client) CreateApp(app *ct.App) error {
 	return c.Post("/apps", app, app)
 }
 
+// CreateApps creates the given apps in a single transaction, so if any of them
+// cannot be created none of them are. The apps are updated with the created
+// apps' IDs, names and defaults.
+func (c *Client) CreateApps(apps []*ct.App) error {
+	var created []*ct.App
+	if err := c.Post("/create-apps", apps, &created); err != nil {
+		return err
+	}
+	for i, app := range created {
+		if i < len(apps) {
+			*apps[i] = *app
+		}
+	}
+	return nil
+}
+
 // UpdateApp updates the meta and strategy using app.ID.
 func (c *Client) UpdateApp(app *ct.App) error {
 	if app.ID == "" {
@@ -160,6 +188,26 @@ func (c *Client) UpdateApp(app *ct.App) error {
 	return c.Post(fmt.Sprintf("/apps/%s", app.ID), app, app)
 }
 
+// UpdateAppIfUnmodifiedSince updates the app like UpdateApp, but fails with
+// a precondition failed error if the app was modified after since.
+func (c *Client) UpdateAppIfUnmodifiedSince(app *ct.App, since time.Time) error {
+	if app.ID == "" {
+		return errors.New("controller: missing id")
+	}
+	return c.sendIfUnmodifiedSince("POST", fmt.Sprintf("/apps/%s", app.ID), since, app, app)
+}
+
+// sendIfUnmodifiedSince sends a request with an If-Unmodified-Since header set
+// to the given time with sub-second precision.
+func (c *Client) sendIfUnmodifiedSince(method, path string, since time.Time, in, out interface{}) error {
+	header := http.Header{
+		"Accept":              []string{"application/json"},
+		"If-Unmodified-Since": []string{since.UTC().Format(time.RFC3339Nano)},
+	}
+	_, err := c.RawReq(method, path, header, in, out)
+	return err
+}
+
 // UpdateAppMeta updates the meta using app.ID, allowing empty meta to be set explicitly.
 func (c *Client) UpdateAppMeta(app *ct.App) error {
 	if app.ID == "" {
@@ -168,6 +216,29 @@ func (c *Client) UpdateAppMeta(app *ct.App) error {
 	return c.Post(fmt.Sprintf("/apps/%s/meta", app.ID), app, app)
 }
 
+// CloneApp creates a new app with the given name (or a generated name if
+// empty) which has the same meta, strategy, deploy timeout and current
+// release contents as the specified app.
+func (c *Client) CloneApp(appID, name string) (*ct.App, error) {
+	app := &ct.App{}
+	return app, c.Post(fmt.Sprintf("/apps/%s/clone", appID), &ct.AppClone{Name: name}, app)
+}
+
+// ExportApp returns a snapshot of an app's config (its current release, the
+// release's artifacts and formation, and the app's routes) which can be
+// imported into another cluster.
+func (c *Client) ExportApp(appID string) (*ct.AppExport, error) {
+	export := &ct.AppExport{}
+	return export, c.Get(fmt.Sprintf("/apps/%s/export", appID), export)
+}
+
+// ImportApp creates an app from a snapshot returned by ExportApp (usually on
+// another cluster), giving the app and its release new IDs.
+func (c *Client) ImportApp(export *ct.AppExport) (*ct.App, error) {
+	app := &ct.App{}
+	return app, c.Post("/import-app", export, app)
+}
+
 // DeleteApp deletes an app.
 func (c *Client) DeleteApp(appID string) (*ct.AppDeletion, error) {
 	events := make(chan *ct.Event)
@@ -213,6 +284,12 @@ func (c *Client) GetProvider(providerID string) (*ct.Provider, error) {
 	return provider, c.Get(fmt.Sprintf("/providers/%s", providerID), provider)
 }
 
+// UpdateProvider updates the name and URL of the provider identified by
+// provider.ID.
+func (c *Client) UpdateProvider(provider *ct.Provider) error {
+	return c.Post(fmt.Sprintf("/providers/%s", provider.ID), provider, provider)
+}
+
 // ProvisionResource uses a provider to provision a new resource for the
 // application. Returns details about the resource.
 func (c *Client) ProvisionResource(req *ct.ResourceReq) (*ct.Resource, error) {
@@ -224,6 +301,22 @@ func (c *Client) ProvisionResource(req *ct.ResourceReq) (*ct.Resource, error) {
 	return res, err
 }
 
+// CreateProviderResource provisions a new resource from the provider with
+// the given URL, first registering the provider with the given name if there
+// is no provider with that URL. Returns the provider and the resource.
+func (c *Client) CreateProviderResource(req *ct.ProviderResourceReq) (*ct.ProviderResource, error) {
+	res := &ct.ProviderResource{}
+	return res, c.Post("/provider-resources", req, res)
+}
+
+// CreateAppResource uses a provider to provision a new resource and attaches
+// it to the given app, deploying a new release of the app which includes the
+// resource's env.
+func (c *Client) CreateAppResource(appID string, req *ct.AppResourceReq) (*ct.Resource, error) {
+	res := &ct.Resource{}
+	return res, c.Post(fmt.Sprintf("/apps/%s/resources", appID), req, res)
+}
+
 // GetResource returns the resource identified by resourceID under providerID.
 func (c *Client) GetResource(providerID, resourceID string) (*ct.Resource, error) {
 	res := &ct.Resource{}
@@ -237,6 +330,19 @@ func (c *Client) ResourceListAll() ([]*ct.Resource, error) {
 	return resources, c.Get("/resources", &resources)
 }
 
+// ResourceListByApp returns all resources which are attached to the given
+// app.
+func (c *Client) ResourceListByApp(appID string) ([]*ct.Resource, error) {
+	var resources []*ct.Resource
+	return resources, c.Get(fmt.Sprintf("/resources?app_id=%s", appID), &resources)
+}
+
+// StreamResourceStatus streams the provisioning status of the given resource
+// until it is either ready, failed or deleted.
+func (c *Client) StreamResourceStatus(resourceID string, output chan *ct.ResourceStatus) (stream.Stream, error) {
+	return c.Stream("GET", fmt.Sprintf("/resources/%s/status", resourceID), nil, output)
+}
+
 // ResourceList returns all resources under providerID.
 func (c *Client) ResourceList(providerID string) ([]*ct.Resource, error) {
 	var resources []*ct.Resource
@@ -249,6 +355,14 @@ func (c *Client) AddResourceApp(providerID, resourceID, appID string) (*ct.Resou
 	return resource, c.Put(fmt.Sprintf("/providers/%s/resources/%s/apps/%s", providerID, resourceID, appID), nil, &resource)
 }
 
+// AddResourceApps attaches the resource identified by resourceID to all of
+// the given apps, injecting the resource's env into each app's release, and
+// returns the resource. If any app cannot be attached, none are.
+func (c *Client) AddResourceApps(providerID, resourceID string, appIDs []string) (*ct.Resource, error) {
+	var resource *ct.Resource
+	return resource, c.Post(fmt.Sprintf("/providers/%s/resources/%s/apps", providerID, resourceID), &ct.ResourceApps{Apps: appIDs}, &resource)
+}
+
 // DeleteResourceApp removes appID from the resource identified by resourceID and returns the resource
 func (c *Client) DeleteResourceApp(providerID, resourceID, appID string) (*ct.Resource, error) {
 	var resource *ct.Resource
@@ -284,6 +398,22 @@ func (c *Client) PutFormation(formation *ct.Formation) error {
 	return c.Put(fmt.Sprintf("/apps/%s/formations/%s", formation.AppID, formation.ReleaseID), formation, formation)
 }
 
+// PutFormationIfUnmodifiedSince updates the formation like PutFormation, but
+// fails with a precondition failed error if the formation was modified after
+// since.
+func (c *Client) PutFormationIfUnmodifiedSince(formation *ct.Formation, since time.Time) error {
+	if formation.AppID == "" || formation.ReleaseID == "" {
+		return errors.New("controller: missing app id and/or release id")
+	}
+	return c.sendIfUnmodifiedSince("PUT", fmt.Sprintf("/apps/%s/formations/%s", formation.AppID, formation.ReleaseID), since, formation, formation)
+}
+
+// PutFormations updates the given formations atomically, either all of them
+// are updated or none of them are.
+func (c *Client) PutFormations(formations []*ct.Formation) error {
+	return c.Put("/formations", formations, &formations)
+}
+
 // PutJob updates an existing job.
 func (c *Client) PutJob(job *ct.Job) error {
 	if job.UUID == "" || job.AppID == "" {
@@ -297,23 +427,206 @@ func (c *Client) DeleteJob(appID, jobID string) error {
 	return c.Delete(fmt.Sprintf("/apps/%s/jobs/%s", appID, jobID), nil)
 }
 
+// RestartJob stops the given up job so that the scheduler replaces it with a
+// new job of the same type, returning the new job.
+func (c *Client) RestartJob(appID, jobID string) (*ct.Job, error) {
+	job := &ct.Job{}
+	return job, c.Post(fmt.Sprintf("/apps/%s/jobs/%s/restart", appID, jobID), nil, job)
+}
+
+// StopReleaseJobs stops all of the app's up jobs which are running the given
+// release, which must be scaled to zero, returning the jobs which were
+// stopped.
+func (c *Client) StopReleaseJobs(appID, releaseID string) ([]*ct.Job, error) {
+	var jobs []*ct.Job
+	return jobs, c.Post(fmt.Sprintf("/apps/%s/releases/%s/stop-jobs", appID, releaseID), nil, &jobs)
+}
+
 // SetAppRelease sets the specified release as the current release for an app.
 func (c *Client) SetAppRelease(appID, releaseID string) error {
 	return c.Put(fmt.Sprintf("/apps/%s/release", appID), &ct.Release{ID: releaseID}, nil)
 }
 
+// SetCurrentRelease immediately switches an app to the specified release
+// without a deployment, moving the current formation's process counts to the
+// new release, and returns the updated app.
+func (c *Client) SetCurrentRelease(appID, releaseID string) (*ct.App, error) {
+	app := &ct.App{}
+	return app, c.Post(fmt.Sprintf("/apps/%s/current-release", appID), &ct.Release{ID: releaseID}, app)
+}
+
+// SetPendingRelease stages a release as the next release to deploy to an
+// app without deploying it, and returns the updated app. The release must
+// belong to the app.
+func (c *Client) SetPendingRelease(appID, releaseID string) (*ct.App, error) {
+	app := &ct.App{}
+	return app, c.Put(fmt.Sprintf("/apps/%s/pending-release", appID), &ct.Release{ID: releaseID}, app)
+}
+
+// GetPendingRelease returns the release staged as the next release to deploy
+// to an app, or nil if there is none.
+func (c *Client) GetPendingRelease(appID string) (*ct.Release, error) {
+	var release *ct.Release
+	return release, c.Get(fmt.Sprintf("/apps/%s/pending-release", appID), &release)
+}
+
+// ClearPendingRelease clears the release staged as the next release to deploy
+// to an app, and returns the updated app.
+func (c *Client) ClearPendingRelease(appID string) (*ct.App, error) {
+	app := &ct.App{}
+	return app, c.Delete(fmt.Sprintf("/apps/%s/pending-release", appID), app)
+}
+
 // GetAppRelease returns the current release of an app.
 func (c *Client) GetAppRelease(appID string) (*ct.Release, error) {
 	release := &ct.Release{}
 	return release, c.Get(fmt.Sprintf("/apps/%s/release", appID), release)
 }
 
+// GetPreviousRelease returns the release which was current for an app before
+// the given release became current, which is nil if the given release was the
+// app's first.
+func (c *Client) GetPreviousRelease(appID, releaseID string) (*ct.Release, error) {
+	var release *ct.Release
+	if err := c.Get(fmt.Sprintf("/apps/%s/releases/%s/previous", appID, releaseID), &release); err != nil {
+		return nil, err
+	}
+	return release, nil
+}
+
+// GetAppConfigChecksum returns a checksum of the app's effective config (its
+// current release's env and processes and the current formation), which
+// changes whenever the config does.
+func (c *Client) GetAppConfigChecksum(appID string) (string, error) {
+	res := &ct.AppConfigChecksum{}
+	if err := c.Get(fmt.Sprintf("/apps/%s/config-checksum", appID), res); err != nil {
+		return "", err
+	}
+	return res.Checksum, nil
+}
+
+// GetAppEffectiveEnv returns the env the app's processes run with, which is
+// the env of its attached resources overlaid with its current release's env.
+func (c *Client) GetAppEffectiveEnv(appID string) (map[string]string, error) {
+	var env map[string]string
+	return env, c.Get(fmt.Sprintf("/apps/%s/effective-env", appID), &env)
+}
+
+// PromoteRelease creates a release for the specified app from another app's
+// release, optionally deploying it.
+func (c *Client) PromoteRelease(appID string, req *ct.PromoteRelease) (*ct.PromotedRelease, error) {
+	res := &ct.PromotedRelease{}
+	return res, c.Post(fmt.Sprintf("/apps/%s/promote", appID), req, res)
+}
+
+// SetAppEnv creates a release for the specified app from its current release
+// with the given env vars set and unset, optionally deploying it. If the env
+// is unchanged, the current release is returned without being deployed.
+func (c *Client) SetAppEnv(appID string, req *ct.AppEnvUpdate) (*ct.PromotedRelease, error) {
+	res := &ct.PromotedRelease{}
+	return res, c.Post(fmt.Sprintf("/apps/%s/env", appID), req, res)
+}
+
+// GetAppMetaValue returns the value of the given app meta key, or nil if the
+// key is not set.
+func (c *Client) GetAppMetaValue(appID, key string) (*string, error) {
+	value := &ct.MetaValue{}
+	if err := c.Get(fmt.Sprintf("/apps/%s/meta/%s", appID, (&url.URL{Path: key}).EscapedPath()), value); err != nil {
+		return nil, err
+	}
+	return value.Value, nil
+}
+
+// GetReleaseMetaValue returns the value of the given release meta key, or nil
+// if the key is not set.
+func (c *Client) GetReleaseMetaValue(releaseID, key string) (*string, error) {
+	value := &ct.MetaValue{}
+	if err := c.Get(fmt.Sprintf("/releases/%s/meta/%s", releaseID, (&url.URL{Path: key}).EscapedPath()), value); err != nil {
+		return nil, err
+	}
+	return value.Value, nil
+}
+
+// GetReleaseDefaultProcesses returns the recommended initial process counts
+// for the given release.
+func (c *Client) GetReleaseDefaultProcesses(releaseID string) (map[string]int, error) {
+	var procs map[string]int
+	return procs, c.Get(fmt.Sprintf("/releases/%s/default-processes", releaseID), &procs)
+}
+
+// GetReleaseSize returns the total size of the release's artifacts in bytes,
+// or nil if the size of any of the artifacts is not known.
+func (c *Client) GetReleaseSize(releaseID string) (*int64, error) {
+	size := &ct.ReleaseSize{}
+	if err := c.Get(fmt.Sprintf("/releases/%s/size", releaseID), size); err != nil {
+		return nil, err
+	}
+	return size.TotalSize, nil
+}
+
+// GetReleaseInUse returns whether the release is the current release of any
+// app.
+func (c *Client) GetReleaseInUse(releaseID string) (bool, error) {
+	res := &ct.ReleaseInUse{}
+	if err := c.Get(fmt.Sprintf("/releases/%s/in-use", releaseID), res); err != nil {
+		return false, err
+	}
+	return res.InUse, nil
+}
+
+// ReviseRelease creates a copy of the given release which uses the given
+// artifacts.
+func (c *Client) ReviseRelease(releaseID string, artifactIDs []string) (*ct.Release, error) {
+	release := &ct.Release{}
+	return release, c.Post("/releases/revise", &ct.ReleaseRevision{ReleaseID: releaseID, ArtifactIDs: artifactIDs}, release)
+}
+
+// ImportRelease creates a release exported from another cluster, preserving
+// its ID, along with the given artifacts which have not yet been imported.
+func (c *Client) ImportRelease(release *ct.Release, artifacts []*ct.Artifact) error {
+	return c.Post("/releases/import", &ct.ReleaseImport{Release: release, Artifacts: artifacts}, release)
+}
+
+// GetAppLeaderJob returns the job which is currently the leader of the
+// service the app's leader routes send traffic to, or nil if there is none.
+func (c *Client) GetAppLeaderJob(appID string) (*ct.Job, error) {
+	var job *ct.Job
+	return job, c.Get(fmt.Sprintf("/apps/%s/leader-job", appID), &job)
+}
+
+// GetDomainApp returns the app which has a HTTP route for the given domain,
+// or nil if the domain is not routed to an app.
+func (c *Client) GetDomainApp(domain string) (*ct.App, error) {
+	var app *ct.App
+	return app, c.Get(fmt.Sprintf("/domains/%s/app", domain), &app)
+}
+
+// GetAppCertificateStatus returns a summary of the expiry of the TLS
+// certificates used by the app's routes, or nil if it has no TLS routes.
+func (c *Client) GetAppCertificateStatus(appID string) (*ct.CertificateStatus, error) {
+	var status *ct.CertificateStatus
+	if err := c.Get(fmt.Sprintf("/apps/%s/certificate-status", appID), &status); err != nil {
+		return nil, err
+	}
+	return status, nil
+}
+
 // RouteList returns all routes for an app.
 func (c *Client) RouteList(appID string) ([]*router.Route, error) {
 	var routes []*router.Route
 	return routes, c.Get(fmt.Sprintf("/apps/%s/routes", appID), &routes)
 }
 
+// GetAppRouteCount returns the number of routes an app has, which is the last
+// known count if the router is unavailable, or nil if that is not known.
+func (c *Client) GetAppRouteCount(appID string) (*int, error) {
+	res := &ct.RouteCount{}
+	if err := c.Get(fmt.Sprintf("/apps/%s/route-count", appID), res); err != nil {
+		return nil, err
+	}
+	return res.Count, nil
+}
+
 // GetRoute returns details for the routeID under the specified app.
 func (c *Client) GetRoute(appID string, routeID string) (*router.Route, error) {
 	route := &router.Route{}
@@ -325,6 +638,31 @@ func (c *Client) CreateRoute(appID string, route *router.Route) error {
 	return c.Post(fmt.Sprintf("/apps/%s/routes", appID), route, route)
 }
 
+// CreateRoutes creates several routes for the specified app at once, creating
+// none of them if any fail.
+func (c *Client) CreateRoutes(appID string, routes []*router.Route) ([]*router.Route, error) {
+	var res []*router.Route
+	if err := c.Post(fmt.Sprintf("/apps/%s/routes/batch", appID), routes, &res); err != nil {
+		return nil, err
+	}
+	return res, nil
+}
+
+// UpdateAppRoutes sets the given flags on all of the app's routes to which
+// they apply, returning the routes which were changed.
+func (c *Client) UpdateAppRoutes(appID string, flags *ct.AppRouteFlags) ([]*router.Route, error) {
+	var routes []*router.Route
+	return routes, c.Put(fmt.Sprintf("/apps/%s/routes", appID), flags, &routes)
+}
+
+// UpsertCertificate creates a TLS certificate which can be attached to routes
+// by ID, or if cert.ID is set replaces that certificate, moving its routes to
+// the replacement. The router identifies certificates by their content, so
+// cert.ID is set to the ID of the resulting certificate.
+func (c *Client) UpsertCertificate(cert *router.Certificate) error {
+	return c.Post("/certificates", cert, cert)
+}
+
 // UpdateRoute updates details for the routeID under the specified app.
 func (c *Client) UpdateRoute(appID string, routeID string, route *router.Route) error {
 	return c.Put(fmt.Sprintf("/apps/%s/routes/%s", appID, routeID), route, route)
@@ -335,6 +673,44 @@ func (c *Client) DeleteRoute(appID string, routeID string) error {
 	return c.Delete(fmt.Sprintf("/apps/%s/routes/%s", appID, routeID), nil)
 }
 
+// ChangeRouteDomain moves an HTTP route under the specified app to a new
+// domain.
+func (c *Client) ChangeRouteDomain(appID string, routeID string, domain string) (*router.Route, error) {
+	route := &router.Route{}
+	return route, c.Put(fmt.Sprintf("/apps/%s/routes/%s/domain", appID, routeID), &ct.RouteDomain{Domain: domain}, route)
+}
+
+// RotateRouteCertificate replaces the certificate of an HTTP route under the
+// specified app with the given cert and key, returning the new certificate.
+func (c *Client) RotateRouteCertificate(appID string, routeID string, cert *router.Certificate) (*router.Certificate, error) {
+	res := &router.Certificate{}
+	return res, c.Put(fmt.Sprintf("/apps/%s/routes/%s/certificate", appID, routeID), cert, res)
+}
+
+// GetRouteURL returns the external URL of a route under the specified app,
+// which is empty if the route is missing the fields needed to build it.
+func (c *Client) GetRouteURL(appID string, routeID string) (string, error) {
+	res := &ct.RouteURL{}
+	if err := c.Get(fmt.Sprintf("/apps/%s/routes/%s/url", appID, routeID), res); err != nil {
+		return "", err
+	}
+	if res.URL == nil {
+		return "", nil
+	}
+	return *res.URL, nil
+}
+
+// GetRouteMeta returns the metadata set for a route under the specified app.
+func (c *Client) GetRouteMeta(appID string, routeID string) (map[string]string, error) {
+	var meta map[string]string
+	return meta, c.Get(fmt.Sprintf("/apps/%s/routes/%s/meta", appID, routeID), &meta)
+}
+
+// UpdateRouteMeta replaces the metadata of a route under the specified app.
+func (c *Client) UpdateRouteMeta(appID string, routeID string, meta map[string]string) error {
+	return c.Put(fmt.Sprintf("/apps/%s/routes/%s/meta", appID, routeID), meta, &meta)
+}
+
 // GetFormation returns details for the specified formation under app and
 // release.
 func (c *Client) GetFormation(appID, releaseID string) (*ct.Formation, error) {
@@ -349,12 +725,53 @@ func (c *Client) GetExpandedFormation(appID, releaseID string) (*ct.ExpandedForm
 	return formation, c.Get(fmt.Sprintf("/apps/%s/formations/%s?expand=true", appID, releaseID), formation)
 }
 
+// GetCurrentExpandedFormation returns the expanded formation of the app's
+// current release.
+func (c *Client) GetCurrentExpandedFormation(appID string) (*ct.ExpandedFormation, error) {
+	formation := &ct.ExpandedFormation{}
+	return formation, c.Get(fmt.Sprintf("/apps/%s/current-formation", appID), formation)
+}
+
+// GetDegradedProcesses returns the process types of the app's current
+// release which have fewer up jobs than its formation wants.
+func (c *Client) GetDegradedProcesses(appID string) ([]*ct.DegradedProcess, error) {
+	var processes []*ct.DegradedProcess
+	return processes, c.Get(fmt.Sprintf("/apps/%s/degraded-processes", appID), &processes)
+}
+
 // FormationList returns a list of all formations under appID.
 func (c *Client) FormationList(appID string) ([]*ct.Formation, error) {
 	var formations []*ct.Formation
 	return formations, c.Get(fmt.Sprintf("/apps/%s/formations", appID), &formations)
 }
 
+// CordonApp sets the reserved cordon tag on all of an app's formations, so
+// its jobs are kept off hosts with the tag, returning the updated formations.
+func (c *Client) CordonApp(appID string) ([]*ct.Formation, error) {
+	var formations []*ct.Formation
+	return formations, c.Post(fmt.Sprintf("/apps/%s/cordon", appID), nil, &formations)
+}
+
+// UncordonApp removes the reserved cordon tag from all of an app's
+// formations, returning the updated formations.
+func (c *Client) UncordonApp(appID string) ([]*ct.Formation, error) {
+	var formations []*ct.Formation
+	return formations, c.Post(fmt.Sprintf("/apps/%s/uncordon", appID), nil, &formations)
+}
+
+// PauseApp stops the scheduler from starting new jobs for an app, leaving its
+// running jobs and formations as they are.
+func (c *Client) PauseApp(appID string) (*ct.App, error) {
+	app := &ct.App{}
+	return app, c.Post(fmt.Sprintf("/apps/%s/pause", appID), nil, app)
+}
+
+// ResumeApp lets the scheduler start jobs for a paused app again.
+func (c *Client) ResumeApp(appID string) (*ct.App, error) {
+	app := &ct.App{}
+	return app, c.Post(fmt.Sprintf("/apps/%s/resume", appID), nil, app)
+}
+
 // FormationListActive returns a list of all active formations (i.e. formations
 // whose process count is greater than zero).
 func (c *Client) FormationListActive() ([]*ct.ExpandedFormation, error) {
@@ -362,6 +779,12 @@ func (c *Client) FormationListActive() ([]*ct.ExpandedFormation, error) {
 	return formations, c.Get("/formations?active=true", &formations)
 }
 
+// StreamAppFormations yields formation updates for the specified app into the
+// provided channel as they happen.
+func (c *Client) StreamAppFormations(appID string, output chan<- *ct.ExpandedFormation) (stream.Stream, error) {
+	return c.Stream("GET", fmt.Sprintf("/apps/%s/formations", appID), nil, output)
+}
+
 // DeleteFormation deletes the formation matching appID and releaseID.
 func (c *Client) DeleteFormation(appID, releaseID string) error {
 	return c.Delete(fmt.Sprintf("/apps/%s/formations/%s", appID, releaseID), nil)
@@ -379,6 +802,13 @@ func (c *Client) GetArtifact(artifactID string) (*ct.Artifact, error) {
 	return artifact, c.Get(fmt.Sprintf("/artifacts/%s", artifactID), artifact)
 }
 
+// DeleteArtifact deletes an artifact which no releases reference, returning
+// the deleted artifact.
+func (c *Client) DeleteArtifact(artifactID string) (*ct.Artifact, error) {
+	artifact := &ct.Artifact{}
+	return artifact, c.Send("DELETE", fmt.Sprintf("/artifacts/%s", artifactID), nil, artifact)
+}
+
 // GetApp returns details for the specified app.
 func (c *Client) GetApp(appID string) (*ct.App, error) {
 	app := &ct.App{}
@@ -450,8 +880,22 @@ func (c *Client) GetDeployment(deploymentID string) (*ct.Deployment, error) {
 }
 
 func (c *Client) CreateDeployment(appID, releaseID string) (*ct.Deployment, error) {
+	return c.CreateDeploymentWithStrategy(appID, releaseID, "")
+}
+
+// CreateDeploymentWithStrategy creates a deployment of a release using the
+// given strategy rather than the app's strategy, which is used if strategy
+// is empty.
+func (c *Client) CreateDeploymentWithStrategy(appID, releaseID, strategy string) (*ct.Deployment, error) {
 	deployment := &ct.Deployment{}
-	return deployment, c.Post(fmt.Sprintf("/apps/%s/deploy", appID), &ct.Release{ID: releaseID}, deployment)
+	return deployment, c.Post(fmt.Sprintf("/apps/%s/deploy", appID), &ct.CreateDeployment{ReleaseID: releaseID, Strategy: strategy}, deployment)
+}
+
+// RestartApp restarts all of the app's processes by deploying a copy of its
+// current release.
+func (c *Client) RestartApp(appID string) (*ct.Deployment, error) {
+	deployment := &ct.Deployment{}
+	return deployment, c.Post(fmt.Sprintf("/apps/%s/restart", appID), nil, deployment)
 }
 
 // DeploymentList returns a list of all deployments.
@@ -460,6 +904,20 @@ func (c *Client) DeploymentList(appID string) ([]*ct.Deployment, error) {
 	return deployments, c.Get(fmt.Sprintf("/apps/%s/deployments", appID), &deployments)
 }
 
+// ReleaseDeploymentList returns the deployments which deployed the given
+// release, most recent first.
+func (c *Client) ReleaseDeploymentList(releaseID string) ([]*ct.Deployment, error) {
+	var deployments []*ct.Deployment
+	return deployments, c.Get(fmt.Sprintf("/releases/%s/deployments", releaseID), &deployments)
+}
+
+// GetAppDeployTimes returns the times of the app's first and most recent
+// deployments.
+func (c *Client) GetAppDeployTimes(appID string) (*ct.AppDeployTimes, error) {
+	times := &ct.AppDeployTimes{}
+	return times, c.Get(fmt.Sprintf("/apps/%s/deploy-times", appID), times)
+}
+
 func convertEvents(appEvents chan *ct.Event, outputCh interface{}) {
 	outValue := reflect.ValueOf(outputCh)
 	msgType := outValue.Type().Elem().Elem()
@@ -488,6 +946,33 @@ func (c *Client) StreamDeployment(d *ct.Deployment, output chan *ct.DeploymentEv
 	}, appEvents)
 }
 
+// GetDeploymentProgress returns a snapshot of the number of jobs of the
+// deployment's old and new releases which are up, and how complete the
+// deployment is.
+func (c *Client) GetDeploymentProgress(deploymentID string) (*ct.DeploymentProgress, error) {
+	progress := &ct.DeploymentProgress{}
+	return progress, c.Get(fmt.Sprintf("/deployments/%s/progress", deploymentID), progress)
+}
+
+// GetDeploymentTimeline returns the deployment's events and the job events
+// of its new release emitted while it was running, oldest first.
+func (c *Client) GetDeploymentTimeline(deploymentID string) ([]*ct.Event, error) {
+	var events []*ct.Event
+	return events, c.Get(fmt.Sprintf("/deployments/%s/timeline", deploymentID), &events)
+}
+
+// StreamDeploymentProgress streams snapshots of the number of jobs of the
+// deployment's old and new releases which are up, until the deployment
+// either completes or fails.
+func (c *Client) StreamDeploymentProgress(deploymentID string, output chan *ct.DeploymentProgress) (stream.Stream, error) {
+	return c.Stream("GET", fmt.Sprintf("/deployments/%s/progress", deploymentID), nil, output)
+}
+
+// StreamAppLifecycle streams an event each time an app is created or deleted.
+func (c *Client) StreamAppLifecycle(output chan *ct.AppLifecycleEvent) (stream.Stream, error) {
+	return c.Stream("GET", "/app-lifecycle", nil, output)
+}
+
 func (c *Client) DeployAppRelease(appID, releaseID string, stopWait <-chan struct{}) error {
 	d, err := c.CreateDeployment(appID, releaseID)
 	if err != nil {
@@ -568,10 +1053,29 @@ func (c *Client) StreamEvents(opts ct.StreamEventsOptions, output chan *ct.Event
 	if opts.Count > 0 {
 		q.Set("count", strconv.Itoa(opts.Count))
 	}
+	if opts.LastID > 0 {
+		q.Set("last_id", strconv.FormatInt(opts.LastID, 10))
+	}
 	path.RawQuery = q.Encode()
 	return c.ResumingStream("GET", path.String(), output)
 }
 
+// StreamAppActivity streams the app's events of the given object types, or
+// of its jobs, deployments and scale changes if none are given.
+func (c *Client) StreamAppActivity(appID string, objectTypes []ct.EventType, output chan *ct.Event) (stream.Stream, error) {
+	path, _ := url.Parse(fmt.Sprintf("/apps/%s/activity", appID))
+	if len(objectTypes) > 0 {
+		types := make([]string, len(objectTypes))
+		for i, t := range objectTypes {
+			types[i] = string(t)
+		}
+		q := path.Query()
+		q.Set("object_types", strings.Join(types, ","))
+		path.RawQuery = q.Encode()
+	}
+	return c.ResumingStream("GET", path.String(), output)
+}
+
 func (c *Client) ListEvents(opts ct.ListEventsOptions) ([]*ct.Event, error) {
 	var events []*ct.Event
 	path, err := url.Parse("/events")
@@ -601,6 +1105,9 @@ func (c *Client) ListEvents(opts ct.ListEventsOptions) ([]*ct.Event, error) {
 	if opts.Count > 0 {
 		q.Set("count", strconv.Itoa(opts.Count))
 	}
+	if opts.ErrorsOnly {
+		q.Set("errors_only", "true")
+	}
 	path.RawQuery = q.Encode()
 	h := make(http.Header)
 	h.Set("Accept", "application/json")
@@ -617,6 +1124,66 @@ func (c *Client) GetEvent(id int64) (*ct.Event, error) {
 	return event, c.Get(fmt.Sprintf("/events/%d", id), &event)
 }
 
+// GetLatestEventID returns the ID of the most recent event, which is zero if
+// there are no events.
+func (c *Client) GetLatestEventID() (int64, error) {
+	res := &ct.LatestEventID{}
+	if err := c.Get("/latest-event-id", res); err != nil {
+		return 0, err
+	}
+	return res.ID, nil
+}
+
+// GetEventApp returns the app the given event refers to, even if the app has
+// since been deleted.
+func (c *Client) GetEventApp(id int64) (*ct.App, error) {
+	app := &ct.App{}
+	return app, c.Get(fmt.Sprintf("/events/%d/app", id), app)
+}
+
+// GetEventAppAtEventTime returns the app the given event refers to as it was
+// when the event was emitted, or the current app if its state at the time was
+// not recorded.
+func (c *Client) GetEventAppAtEventTime(id int64) (*ct.App, error) {
+	app := &ct.App{}
+	return app, c.Get(fmt.Sprintf("/events/%d/app?at_event_time=true", id), app)
+}
+
+// GetEventData returns the raw data of the given event.
+func (c *Client) GetEventData(id int64) (json.RawMessage, error) {
+	var data json.RawMessage
+	if err := c.Get(fmt.Sprintf("/events/%d/data", id), &data); err != nil {
+		return nil, err
+	}
+	return data, nil
+}
+
+// ListAppEvents returns the most recent events for each of the given apps,
+// grouped by app. If count is greater than zero, at most count events are
+// returned for each app.
+func (c *Client) ListAppEvents(appIDs []string, count int) ([]*ct.AppEvents, error) {
+	q := make(url.Values)
+	q.Set("app_ids", strings.Join(appIDs, ","))
+	if count > 0 {
+		q.Set("count", strconv.Itoa(count))
+	}
+	var list []*ct.AppEvents
+	return list, c.Get("/app-events?"+q.Encode(), &list)
+}
+
+// ListRecentAppEvents returns the most recent events of all types for an app,
+// most recent first. At most count events are returned if count is greater
+// than zero, otherwise the server's default of 10, with the server capping the
+// count at 100.
+func (c *Client) ListRecentAppEvents(appID string, count int) ([]*ct.Event, error) {
+	path := fmt.Sprintf("/apps/%s/recent-events", appID)
+	if count > 0 {
+		path += "?count=" + strconv.Itoa(count)
+	}
+	var list []*ct.Event
+	return list, c.Get(path, &list)
+}
+
 func (c *Client) ExpectedScalingEvents(actual, expected map[string]int, releaseProcesses map[string]ct.ProcessType, clusterSize int) ct.JobEvents {
 	events := make(ct.JobEvents, len(expected))
 	for typ, count := range expected {
@@ -652,18 +1219,64 @@ func (c *Client) RunJobDetached(appID string, req *ct.NewJob) (*ct.Job, error) {
 	return job, c.Post(fmt.Sprintf("/apps/%s/jobs", appID), req, job)
 }
 
+// ScheduleJob schedules a one-off job under the specified app to be run at
+// req.RunAt by the controller worker.
+func (c *Client) ScheduleJob(appID string, req *ct.ScheduledJob) (*ct.ScheduledJob, error) {
+	job := &ct.ScheduledJob{}
+	return job, c.Post(fmt.Sprintf("/apps/%s/scheduled-jobs", appID), req, job)
+}
+
+// PruneJobs deletes a batch of the records of terminated jobs which were
+// last updated before req.Before, returning the number of records deleted
+// and whether more remain, in which case it should be called again.
+func (c *Client) PruneJobs(req *ct.PruneJobs) (*ct.PruneJobsResult, error) {
+	res := &ct.PruneJobsResult{}
+	return res, c.Post("/prune-jobs", req, res)
+}
+
 // GetJob returns a Job for the given app and job ID
 func (c *Client) GetJob(appID, jobID string) (*ct.Job, error) {
 	job := &ct.Job{}
 	return job, c.Get(fmt.Sprintf("/apps/%s/jobs/%s", appID, jobID), job)
 }
 
+// GetJobNetwork returns the IP and ports of a running job under the specified
+// app, which is nil if the job's host cannot provide them.
+func (c *Client) GetJobNetwork(appID, jobID string) (*ct.JobNetwork, error) {
+	var network *ct.JobNetwork
+	if err := c.Get(fmt.Sprintf("/apps/%s/jobs/%s/network", appID, jobID), &network); err != nil {
+		return nil, err
+	}
+	return network, nil
+}
+
 // JobList returns a list of all jobs.
 func (c *Client) JobList(appID string) ([]*ct.Job, error) {
 	var jobs []*ct.Job
 	return jobs, c.Get(fmt.Sprintf("/apps/%s/jobs", appID), &jobs)
 }
 
+// JobListByRelease returns the app's jobs grouped by release, with the
+// release of the most recently created job first.
+func (c *Client) JobListByRelease(appID string) ([]*ct.ReleaseJobs, error) {
+	var groups []*ct.ReleaseJobs
+	return groups, c.Get(fmt.Sprintf("/apps/%s/jobs-by-release", appID), &groups)
+}
+
+// JobListPage returns a page of jobs for the specified app, starting after
+// the job with ID opts.BeforeID if set.
+func (c *Client) JobListPage(appID string, opts ct.ListJobsOptions) ([]*ct.Job, error) {
+	q := make(url.Values)
+	if opts.BeforeID != "" {
+		q.Set("before_id", opts.BeforeID)
+	}
+	if opts.Count > 0 {
+		q.Set("count", strconv.Itoa(opts.Count))
+	}
+	var jobs []*ct.Job
+	return jobs, c.Get(fmt.Sprintf("/apps/%s/jobs?%s", appID, q.Encode()), &jobs)
+}
+
 // JobListActive returns a list of all active jobs.
 func (c *Client) JobListActive() ([]*ct.Job, error) {
 	var jobs []*ct.Job
@@ -676,6 +1289,13 @@ func (c *Client) AppList() ([]*ct.App, error) {
 	return apps, c.Get("/apps", &apps)
 }
 
+// AppListIDs returns the apps with the given IDs in the same order, with a
+// nil entry for any app which does not exist.
+func (c *Client) AppListIDs(ids []string) ([]*ct.App, error) {
+	var apps []*ct.App
+	return apps, c.Get(fmt.Sprintf("/apps?ids=%s", url.QueryEscape(strings.Join(ids, ","))), &apps)
+}
+
 // KeyList returns a list of all ssh public keys added.
 func (c *Client) KeyList() ([]*ct.Key, error) {
 	var keys []*ct.Key
@@ -688,6 +1308,14 @@ func (c *Client) ArtifactList() ([]*ct.Artifact, error) {
 	return artifacts, c.Get("/artifacts", &artifacts)
 }
 
+// ArtifactListInUse returns a list of artifacts which are either referenced
+// by a release (if inUse is true) or not referenced by any release (if inUse
+// is false).
+func (c *Client) ArtifactListInUse(inUse bool) ([]*ct.Artifact, error) {
+	var artifacts []*ct.Artifact
+	return artifacts, c.Get(fmt.Sprintf("/artifacts?in_use=%t", inUse), &artifacts)
+}
+
 // ReleaseList returns a list of all releases
 func (c *Client) ReleaseList() ([]*ct.Release, error) {
 	var releases []*ct.Release
@@ -700,6 +1328,17 @@ func (c *Client) AppReleaseList(appID string) ([]*ct.Release, error) {
 	return releases, c.Get(fmt.Sprintf("/apps/%s/releases", appID), &releases)
 }
 
+// DeletedReleaseList returns releases which have been deleted, most recently
+// deleted first, limited to those deleted from the given app if appID is set.
+func (c *Client) DeletedReleaseList(appID string) ([]*ct.Release, error) {
+	path := "/deleted-releases"
+	if appID != "" {
+		path += "?app_id=" + appID
+	}
+	var releases []*ct.Release
+	return releases, c.Get(path, &releases)
+}
+
 // CreateKey uploads pubKey as the ssh public key.
 func (c *Client) CreateKey(pubKey string) (*ct.Key, error) {
 	key := &ct.Key{}
@@ -729,6 +1368,29 @@ func (c *Client) Backup() (io.ReadCloser, error) {
 	return res.Body, err
 }
 
+// RetryBackup takes a new backup of the cluster in place of the given failed
+// backup, returning the ID of the new backup along with the backup stream.
+func (c *Client) RetryBackup(backupID string) (string, io.ReadCloser, error) {
+	res, err := c.RawReq("POST", fmt.Sprintf("/backups/%s/retry", backupID), nil, nil, nil)
+	if err != nil {
+		return "", nil, err
+	}
+	return res.Header.Get("Flynn-Backup-Id"), res.Body, nil
+}
+
+// PruneBackups deletes the records of completed backups which completed
+// before the given time, returning the number deleted and their total size.
+func (c *Client) PruneBackups(before time.Time) (*ct.PruneBackupsResult, error) {
+	res := &ct.PruneBackupsResult{}
+	return res, c.Post("/prune-backups", &ct.PruneBackups{Before: &before}, res)
+}
+
+// GetClusterStats returns cluster wide totals.
+func (c *Client) GetClusterStats() (*ct.ClusterStats, error) {
+	stats := &ct.ClusterStats{}
+	return stats, c.Get("/cluster-stats", stats)
+}
+
 // GetBackupMeta returns metadata for latest backup
 func (c *Client) GetBackupMeta() (*ct.ClusterBackup, error) {
 	b := &ct.ClusterBackup{}
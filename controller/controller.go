@@ -4,14 +4,17 @@ import (
 	"crypto/md5"
 	"crypto/subtle"
 	"encoding/hex"
+	"encoding/json"
 	"errors"
 	"fmt"
 	"io"
 	"log"
 	"net/http"
 	"os"
+	"strconv"
 	"strings"
 	"sync"
+	"time"
 
 	"github.com/flynn/flynn/controller/name"
 	"github.com/flynn/flynn/controller/schema"
@@ -21,10 +24,13 @@ import (
 	logaggc "github.com/flynn/flynn/logaggregator/client"
 	"github.com/flynn/flynn/pkg/cluster"
 	"github.com/flynn/flynn/pkg/ctxhelper"
+	"github.com/flynn/flynn/pkg/dialer"
 	"github.com/flynn/flynn/pkg/httphelper"
 	"github.com/flynn/flynn/pkg/postgres"
 	"github.com/flynn/flynn/pkg/shutdown"
+	"github.com/flynn/flynn/pkg/sse"
 	"github.com/flynn/flynn/pkg/status"
+	"github.com/flynn/flynn/pkg/version"
 	routerc "github.com/flynn/flynn/router/client"
 	"github.com/flynn/flynn/router/types"
 	"github.com/flynn/que-go"
@@ -40,6 +46,13 @@ var ErrShutdown = errors.New("controller: shutting down")
 
 var schemaRoot = "/etc/flynn-controller/jsonschema"
 
+const defaultObjectCacheTTL = 10 * time.Minute
+
+const (
+	routeCacheSize = 1000
+	routeCacheTTL  = time.Hour
+)
+
 func main() {
 	defer shutdown.Exit()
 
@@ -57,6 +70,72 @@ func main() {
 		name.SetSeed(s)
 	}
 
+	// caching of releases and artifacts is disabled unless a cache size
+	// is set
+	var cacheSize int
+	if size := os.Getenv("OBJECT_CACHE_SIZE"); size != "" {
+		var err error
+		cacheSize, err = strconv.Atoi(size)
+		if err != nil {
+			log.Fatalln("error parsing OBJECT_CACHE_SIZE:", err)
+		}
+	}
+	cacheTTL := defaultObjectCacheTTL
+	if ttl := os.Getenv("OBJECT_CACHE_TTL"); ttl != "" {
+		var err error
+		cacheTTL, err = time.ParseDuration(ttl)
+		if err != nil {
+			log.Fatalln("error parsing OBJECT_CACHE_TTL:", err)
+		}
+	}
+
+	sseKeepAlive := sse.DefaultKeepAliveInterval
+	if interval := os.Getenv("SSE_KEEPALIVE_INTERVAL"); interval != "" {
+		var err error
+		sseKeepAlive, err = time.ParseDuration(interval)
+		if err != nil {
+			log.Fatalln("error parsing SSE_KEEPALIVE_INTERVAL:", err)
+		}
+	}
+
+	timeouts := make(map[dependency]time.Duration, len(defaultDependencyTimeouts))
+	for dep, timeout := range defaultDependencyTimeouts {
+		timeouts[dep] = timeout
+	}
+	for dep, env := range map[dependency]string{
+		dependencyDB:       "DB_TIMEOUT",
+		dependencyRouter:   "ROUTER_TIMEOUT",
+		dependencyHost:     "HOST_TIMEOUT",
+		dependencyProvider: "PROVIDER_TIMEOUT",
+		dependencyRegistry: "REGISTRY_TIMEOUT",
+	} {
+		if timeout := os.Getenv(env); timeout != "" {
+			var err error
+			timeouts[dep], err = time.ParseDuration(timeout)
+			if err != nil {
+				log.Fatalf("error parsing %s: %s", env, err)
+			}
+		}
+	}
+
+	var expensiveRequestLimit int
+	if limit := os.Getenv("EXPENSIVE_REQUEST_LIMIT"); limit != "" {
+		var err error
+		expensiveRequestLimit, err = strconv.Atoi(limit)
+		if err != nil {
+			log.Fatalln("error parsing EXPENSIVE_REQUEST_LIMIT:", err)
+		}
+	}
+
+	var deployLimit int
+	if limit := os.Getenv("DEPLOY_LIMIT"); limit != "" {
+		var err error
+		deployLimit, err = strconv.Atoi(limit)
+		if err != nil {
+			log.Fatalln("error parsing DEPLOY_LIMIT:", err)
+		}
+	}
+
 	db := postgres.Wait(nil, nil)
 
 	if err := migrateDB(db); err != nil {
@@ -65,7 +144,7 @@ func main() {
 
 	// Reconnect, preparing statements now that schema is migrated
 	db.Close()
-	db = postgres.Wait(nil, schema.PrepareStatements)
+	db = postgres.Wait(nil, limitStatements(timeouts[dependencyDB], schema.PrepareStatements))
 
 	shutdown.BeforeExit(func() { db.Close() })
 
@@ -74,6 +153,14 @@ func main() {
 		shutdown.Fatal(err)
 	}
 	rc := routerc.New()
+	// the router event stream is long lived, so API calls use a separate
+	// client which gives up after the router timeout
+	apiRC := routerc.NewWithHTTP(&http.Client{Timeout: timeouts[dependencyRouter]})
+
+	cc := cluster.NewClientWithHTTP(nil, &http.Client{
+		Transport: &http.Transport{Dial: dialer.Retry.Dial},
+		Timeout:   timeouts[dependencyHost],
+	})
 
 	doneCh := make(chan struct{})
 	shutdown.BeforeExit(func() { close(doneCh) })
@@ -102,12 +189,20 @@ func main() {
 	})
 
 	handler := appHandler(handlerConfig{
-		db:     db,
-		cc:     utils.ClusterClientWrapper(cluster.NewClient()),
-		lc:     lc,
-		rc:     rc,
-		keys:   strings.Split(os.Getenv("AUTH_KEY"), ","),
-		caCert: []byte(os.Getenv("CA_CERT")),
+		db:           db,
+		cc:           utils.ClusterClientWrapper(cc),
+		lc:           lc,
+		rc:           apiRC,
+		sl:           discoverdServiceLeaders{},
+		keys:         strings.Split(os.Getenv("AUTH_KEY"), ","),
+		caCert:       []byte(os.Getenv("CA_CERT")),
+		cacheSize:    cacheSize,
+		cacheTTL:     cacheTTL,
+		sseKeepAlive: sseKeepAlive,
+		timeouts:     timeouts,
+
+		expensiveRequestLimit: expensiveRequestLimit,
+		deployLimit:           deployLimit,
 	})
 	shutdown.Fatal(http.ListenAndServe(addr, handler))
 }
@@ -173,13 +268,69 @@ type logClient interface {
 	GetLog(channelID string, options *logaggc.LogOpts) (io.ReadCloser, error)
 }
 
+// serviceLeaders looks up the current leader of a discoverd service.
+type serviceLeaders interface {
+	Leader(service string) (*discoverd.Instance, error)
+}
+
+type discoverdServiceLeaders struct{}
+
+func (discoverdServiceLeaders) Leader(service string) (*discoverd.Instance, error) {
+	return discoverd.NewService(service).Leader()
+}
+
 type handlerConfig struct {
 	db     *postgres.DB
 	cc     utils.ClusterClient
 	lc     logClient
 	rc     routerc.Client
+	sl     serviceLeaders
 	keys   []string
 	caCert []byte
+
+	// cacheSize is the maximum number of releases and artifacts to
+	// cache, with caching disabled if it is zero
+	cacheSize int
+	cacheTTL  time.Duration
+
+	// sseKeepAlive is how long event streams can be idle before a
+	// keepalive is sent
+	sseKeepAlive time.Duration
+
+	// timeouts limits how long calls to each kind of dependency can
+	// take, with no limit for dependencies which are not set
+	timeouts map[dependency]time.Duration
+
+	// expensiveRequestLimit is the maximum number of provisions which
+	// can be in progress at once, with no limit if it is zero
+	expensiveRequestLimit int
+
+	// deployLimit is the maximum number of unfinished deployments at
+	// which new deploys are rejected, with no limit if it is zero
+	deployLimit int
+
+	// currentReleaseLoaded is called with the app ID whenever an app's
+	// current release is loaded rather than served from a request's
+	// current release cache, and is used by tests to count lookups
+	currentReleaseLoaded func(appID string)
+}
+
+// capabilities returns the optional features enabled by the config.
+func (c handlerConfig) capabilities() []string {
+	capabilities := []string{}
+	if c.cacheSize > 0 {
+		capabilities = append(capabilities, ct.CapabilityObjectCache)
+	}
+	if c.sseKeepAlive > 0 {
+		capabilities = append(capabilities, ct.CapabilityEventKeepAlive)
+	}
+	for _, timeout := range c.timeouts {
+		if timeout > 0 {
+			capabilities = append(capabilities, ct.CapabilityDependencyTimeouts)
+			break
+		}
+	}
+	return capabilities
 }
 
 // NOTE: this is temporary until httphelper supports custom errors
@@ -187,15 +338,73 @@ func respondWithError(w http.ResponseWriter, err error) {
 	switch v := err.(type) {
 	case ct.ValidationError:
 		httphelper.ValidationError(w, v.Field, v.Message)
+	case ct.ValidationErrors:
+		// include every error in the detail so clients can display
+		// them all, with the message summarising them
+		msgs := make([]string, len(v))
+		for i, e := range v {
+			msgs[i] = strings.TrimSpace(e.Field + " " + e.Message)
+		}
+		jsonErr := httphelper.JSONError{
+			Code:    httphelper.ValidationErrorCode,
+			Message: strings.Join(msgs, "; "),
+		}
+		jsonErr.Detail, _ = json.Marshal(map[string]ct.ValidationErrors{"errors": v})
+		httphelper.Error(w, jsonErr)
 	default:
 		if err == ErrNotFound {
 			w.WriteHeader(404)
 			return
 		}
+		if postgres.IsPostgresCode(err, postgres.QueryCanceled) {
+			err = dependencyTimeoutError(dependencyDB, true)
+		}
 		httphelper.Error(w, err)
 	}
 }
 
+// unmodifiedSince is a precondition parsed from a request's
+// If-Unmodified-Since header which an update is conditional on.
+type unmodifiedSince struct {
+	time time.Time
+
+	// precision is the precision of the header value, with HTTP dates
+	// only having second precision
+	precision time.Duration
+}
+
+// parseUnmodifiedSince parses the request's If-Unmodified-Since header, which
+// may be either a HTTP date or a RFC3339 timestamp for sub-second precision,
+// returning nil if the header is not set.
+func parseUnmodifiedSince(req *http.Request) (*unmodifiedSince, error) {
+	header := req.Header.Get("If-Unmodified-Since")
+	if header == "" {
+		return nil, nil
+	}
+	if t, err := time.Parse(time.RFC3339Nano, header); err == nil {
+		return &unmodifiedSince{time: t}, nil
+	}
+	if t, err := http.ParseTime(header); err == nil {
+		return &unmodifiedSince{time: t, precision: time.Second}, nil
+	}
+	return nil, ct.ValidationError{Field: "If-Unmodified-Since", Message: "must be a HTTP date or RFC3339 timestamp"}
+}
+
+// Check returns a precondition failed error if the object was updated after
+// the precondition's time. A nil precondition always passes.
+func (u *unmodifiedSince) Check(updatedAt *time.Time) error {
+	if u == nil || updatedAt == nil {
+		return nil
+	}
+	if updatedAt.Truncate(u.precision).After(u.time) {
+		return httphelper.JSONError{
+			Code:    httphelper.PreconditionFailedErrorCode,
+			Message: fmt.Sprintf("object was modified at %s", updatedAt.UTC().Format(time.RFC3339Nano)),
+		}
+	}
+	return nil
+}
+
 func appHandler(c handlerConfig) http.Handler {
 	err := schema.Load(schemaRoot)
 	if err != nil {
@@ -208,13 +417,17 @@ func appHandler(c handlerConfig) http.Handler {
 	resourceRepo := NewResourceRepo(c.db)
 	appRepo := NewAppRepo(c.db, os.Getenv("DEFAULT_ROUTE_DOMAIN"), c.rc)
 	artifactRepo := NewArtifactRepo(c.db)
+	artifactRepo.cache = newObjectCache(c.cacheSize, c.cacheTTL)
 	releaseRepo := NewReleaseRepo(c.db, artifactRepo, q)
+	releaseRepo.cache = newObjectCache(c.cacheSize, c.cacheTTL)
 	jobRepo := NewJobRepo(c.db)
 	formationRepo := NewFormationRepo(c.db, appRepo, releaseRepo, artifactRepo)
 	releaseRepo.formations = formationRepo
 	deploymentRepo := NewDeploymentRepo(c.db)
 	eventRepo := NewEventRepo(c.db)
 	backupRepo := NewBackupRepo(c.db)
+	routeMetaRepo := NewRouteMetaRepo(c.db)
+	statsRepo := NewStatsRepo(c.db)
 
 	api := controllerAPI{
 		domainMigrationRepo: domainMigrationRepo,
@@ -228,13 +441,20 @@ func appHandler(c handlerConfig) http.Handler {
 		deploymentRepo:      deploymentRepo,
 		eventRepo:           eventRepo,
 		backupRepo:          backupRepo,
+		routeMetaRepo:       routeMetaRepo,
+		statsRepo:           statsRepo,
+		routeCache:          newObjectCache(routeCacheSize, routeCacheTTL),
 		clusterClient:       c.cc,
 		logaggc:             c.lc,
 		routerc:             c.rc,
+		serviceLeaders:      c.sl,
 		que:                 q,
 		caCert:              c.caCert,
 		config:              c,
 	}
+	if c.expensiveRequestLimit > 0 {
+		api.expensiveRequests = make(chan struct{}, c.expensiveRequestLimit)
+	}
 
 	shutdown.BeforeExit(api.Shutdown)
 
@@ -244,6 +464,8 @@ func appHandler(c handlerConfig) http.Handler {
 	crud(httpRouter, "releases", ct.Release{}, releaseRepo)
 	crud(httpRouter, "providers", ct.Provider{}, providerRepo)
 	crud(httpRouter, "artifacts", ct.Artifact{}, artifactRepo)
+	httpRouter.POST("/artifacts/docker", httphelper.WrapHandler(api.CreateDockerArtifact))
+	httpRouter.DELETE("/artifacts/:artifacts_id", httphelper.WrapHandler(api.DeleteArtifact))
 
 	httpRouter.Handler("GET", status.Path, status.Handler(func() status.Status {
 		if err := c.db.Exec("ping"); err != nil {
@@ -253,58 +475,126 @@ func appHandler(c handlerConfig) http.Handler {
 	}))
 
 	httpRouter.GET("/ca-cert", httphelper.WrapHandler(api.GetCACert))
+	httpRouter.GET("/server-info", httphelper.WrapHandler(api.GetServerInfo))
 
+	httpRouter.GET("/cluster-stats", httphelper.WrapHandler(api.GetClusterStats))
 	httpRouter.GET("/backup", httphelper.WrapHandler(api.GetBackup))
+	httpRouter.POST("/backups/:backup_id/retry", httphelper.WrapHandler(api.RetryBackup))
+	httpRouter.POST("/prune-backups", httphelper.WrapHandler(api.PruneBackups))
 
 	httpRouter.PUT("/domain", httphelper.WrapHandler(api.MigrateDomain))
 
+	httpRouter.POST("/create-apps", httphelper.WrapHandler(api.CreateApps))
+	httpRouter.POST("/import-app", httphelper.WrapHandler(api.limitExpensive(api.ImportApp)))
 	httpRouter.POST("/apps/:apps_id", httphelper.WrapHandler(api.UpdateApp))
 	httpRouter.GET("/apps/:apps_id/log", httphelper.WrapHandler(api.appLookup(api.AppLog)))
+	httpRouter.GET("/apps/:apps_id/activity", httphelper.WrapHandler(api.appLookup(api.StreamAppActivity)))
+	httpRouter.GET("/apps/:apps_id/recent-events", httphelper.WrapHandler(api.appLookup(api.ListRecentAppEvents)))
 	httpRouter.DELETE("/apps/:apps_id", httphelper.WrapHandler(api.appLookup(api.DeleteApp)))
 	httpRouter.DELETE("/apps/:apps_id/releases/:releases_id", httphelper.WrapHandler(api.appLookup(api.DeleteRelease)))
+	httpRouter.GET("/apps/:apps_id/export", httphelper.WrapHandler(api.appLookup(api.ExportApp)))
+	httpRouter.POST("/apps/:apps_id/clone", httphelper.WrapHandler(api.limitExpensive(api.appLookup(api.CloneApp))))
 	httpRouter.POST("/apps/:apps_id/gc", httphelper.WrapHandler(api.appLookup(api.ScheduleAppGarbageCollection)))
 
 	httpRouter.PUT("/apps/:apps_id/formations/:releases_id", httphelper.WrapHandler(api.appLookup(api.PutFormation)))
 	httpRouter.GET("/apps/:apps_id/formations/:releases_id", httphelper.WrapHandler(api.appLookup(api.GetFormation)))
 	httpRouter.DELETE("/apps/:apps_id/formations/:releases_id", httphelper.WrapHandler(api.appLookup(api.DeleteFormation)))
 	httpRouter.GET("/apps/:apps_id/formations", httphelper.WrapHandler(api.appLookup(api.ListFormations)))
+	httpRouter.GET("/apps/:apps_id/config-checksum", httphelper.WrapHandler(api.appLookup(api.GetAppConfigChecksum)))
+	httpRouter.GET("/apps/:apps_id/effective-env", httphelper.WrapHandler(api.appLookup(api.GetAppEffectiveEnv)))
+	httpRouter.GET("/apps/:apps_id/current-formation", httphelper.WrapHandler(api.appLookup(api.GetCurrentFormation)))
+	httpRouter.GET("/apps/:apps_id/degraded-processes", httphelper.WrapHandler(api.appLookup(api.GetDegradedProcesses)))
+	httpRouter.POST("/apps/:apps_id/cordon", httphelper.WrapHandler(api.appLookup(api.CordonApp)))
+	httpRouter.POST("/apps/:apps_id/uncordon", httphelper.WrapHandler(api.appLookup(api.UncordonApp)))
+	httpRouter.POST("/apps/:apps_id/pause", httphelper.WrapHandler(api.appLookup(api.PauseApp)))
+	httpRouter.POST("/apps/:apps_id/resume", httphelper.WrapHandler(api.appLookup(api.ResumeApp)))
 	httpRouter.GET("/formations", httphelper.WrapHandler(api.GetFormations))
+	httpRouter.PUT("/formations", httphelper.WrapHandler(api.PutFormations))
 
 	httpRouter.POST("/apps/:apps_id/jobs", httphelper.WrapHandler(api.appLookup(api.RunJob)))
 	httpRouter.GET("/apps/:apps_id/jobs/:jobs_id", httphelper.WrapHandler(api.appLookup(api.GetJob)))
+	httpRouter.GET("/apps/:apps_id/jobs/:jobs_id/network", httphelper.WrapHandler(api.appLookup(api.GetJobNetwork)))
 	httpRouter.PUT("/apps/:apps_id/jobs/:jobs_id", httphelper.WrapHandler(api.appLookup(api.PutJob)))
 	httpRouter.GET("/apps/:apps_id/jobs", httphelper.WrapHandler(api.appLookup(api.ListJobs)))
+	httpRouter.GET("/apps/:apps_id/jobs-by-release", httphelper.WrapHandler(api.appLookup(api.ListJobsByRelease)))
+	httpRouter.POST("/apps/:apps_id/scheduled-jobs", httphelper.WrapHandler(api.appLookup(api.ScheduleJob)))
 	httpRouter.DELETE("/apps/:apps_id/jobs/:jobs_id", httphelper.WrapHandler(api.appLookup(api.KillJob)))
+	httpRouter.POST("/apps/:apps_id/jobs/:jobs_id/restart", httphelper.WrapHandler(api.appLookup(api.RestartJob)))
+	httpRouter.POST("/apps/:apps_id/releases/:releases_id/stop-jobs", httphelper.WrapHandler(api.appLookup(api.StopReleaseJobs)))
+	httpRouter.GET("/apps/:apps_id/releases/:releases_id/previous", httphelper.WrapHandler(api.appLookup(api.GetPreviousRelease)))
 	httpRouter.GET("/active-jobs", httphelper.WrapHandler(api.ListActiveJobs))
+	httpRouter.POST("/prune-jobs", httphelper.WrapHandler(api.PruneJobs))
 
-	httpRouter.POST("/apps/:apps_id/deploy", httphelper.WrapHandler(api.appLookup(api.CreateDeployment)))
+	httpRouter.POST("/apps/:apps_id/deploy", httphelper.WrapHandler(api.limitDeploys(api.appLookup(api.CreateDeployment))))
+	httpRouter.POST("/apps/:apps_id/restart", httphelper.WrapHandler(api.limitDeploys(api.appLookup(api.RestartApp))))
 	httpRouter.GET("/apps/:apps_id/deployments", httphelper.WrapHandler(api.appLookup(api.ListDeployments)))
+	httpRouter.GET("/apps/:apps_id/deploy-times", httphelper.WrapHandler(api.appLookup(api.GetAppDeployTimes)))
 	httpRouter.GET("/deployments/:deployment_id", httphelper.WrapHandler(api.GetDeployment))
+	httpRouter.GET("/deployments/:deployment_id/progress", httphelper.WrapHandler(api.GetDeploymentProgress))
+	httpRouter.GET("/deployments/:deployment_id/timeline", httphelper.WrapHandler(api.GetDeploymentTimeline))
 
 	httpRouter.PUT("/apps/:apps_id/release", httphelper.WrapHandler(api.appLookup(api.SetAppRelease)))
+	httpRouter.POST("/apps/:apps_id/current-release", httphelper.WrapHandler(api.limitDeploys(api.appLookup(api.SetCurrentRelease))))
 	httpRouter.GET("/apps/:apps_id/release", httphelper.WrapHandler(api.appLookup(api.GetAppRelease)))
+	httpRouter.PUT("/apps/:apps_id/pending-release", httphelper.WrapHandler(api.appLookup(api.SetPendingRelease)))
+	httpRouter.GET("/apps/:apps_id/pending-release", httphelper.WrapHandler(api.appLookup(api.GetPendingRelease)))
+	httpRouter.DELETE("/apps/:apps_id/pending-release", httphelper.WrapHandler(api.appLookup(api.ClearPendingRelease)))
 	httpRouter.GET("/apps/:apps_id/releases", httphelper.WrapHandler(api.appLookup(api.GetAppReleases)))
+	httpRouter.POST("/apps/:apps_id/promote", httphelper.WrapHandler(api.limitDeploys(api.appLookup(api.PromoteRelease))))
+	httpRouter.POST("/apps/:apps_id/env", httphelper.WrapHandler(api.limitDeploys(api.appLookup(api.SetAppEnv))))
 
 	httpRouter.GET("/resources", httphelper.WrapHandler(api.GetResources))
-	httpRouter.POST("/providers/:providers_id/resources", httphelper.WrapHandler(api.ProvisionResource))
+	httpRouter.GET("/resources/:resources_id/status", httphelper.WrapHandler(api.StreamResourceStatus))
+	httpRouter.POST("/providers/:providers_id", httphelper.WrapHandler(api.UpdateProvider))
+	httpRouter.POST("/providers/:providers_id/resources", httphelper.WrapHandler(api.limitExpensive(api.ProvisionResource)))
+	httpRouter.POST("/provider-resources", httphelper.WrapHandler(api.limitExpensive(api.CreateProviderResource)))
 	httpRouter.GET("/providers/:providers_id/resources", httphelper.WrapHandler(api.GetProviderResources))
 	httpRouter.GET("/providers/:providers_id/resources/:resources_id", httphelper.WrapHandler(api.GetResource))
 	httpRouter.PUT("/providers/:providers_id/resources/:resources_id", httphelper.WrapHandler(api.PutResource))
 	httpRouter.DELETE("/providers/:providers_id/resources/:resources_id", httphelper.WrapHandler(api.DeleteResource))
 	httpRouter.PUT("/providers/:providers_id/resources/:resources_id/apps/:app_id", httphelper.WrapHandler(api.AddResourceApp))
+	httpRouter.POST("/providers/:providers_id/resources/:resources_id/apps", httphelper.WrapHandler(api.limitDeploys(api.AddResourceApps)))
 	httpRouter.DELETE("/providers/:providers_id/resources/:resources_id/apps/:app_id", httphelper.WrapHandler(api.DeleteResourceApp))
 	httpRouter.GET("/apps/:apps_id/resources", httphelper.WrapHandler(api.appLookup(api.GetAppResources)))
+	httpRouter.POST("/apps/:apps_id/resources", httphelper.WrapHandler(api.limitExpensive(api.limitDeploys(api.appLookup(api.CreateAppResource)))))
 
 	httpRouter.POST("/apps/:apps_id/routes", httphelper.WrapHandler(api.appLookup(api.CreateRoute)))
+	httpRouter.POST("/apps/:apps_id/routes/batch", httphelper.WrapHandler(api.appLookup(api.CreateRoutes)))
 	httpRouter.GET("/apps/:apps_id/routes", httphelper.WrapHandler(api.appLookup(api.GetRouteList)))
+	httpRouter.PUT("/apps/:apps_id/routes", httphelper.WrapHandler(api.appLookup(api.UpdateAppRoutes)))
 	httpRouter.GET("/apps/:apps_id/routes/:routes_type/:routes_id", httphelper.WrapHandler(api.appLookup(api.GetRoute)))
 	httpRouter.PUT("/apps/:apps_id/routes/:routes_type/:routes_id", httphelper.WrapHandler(api.appLookup(api.UpdateRoute)))
 	httpRouter.DELETE("/apps/:apps_id/routes/:routes_type/:routes_id", httphelper.WrapHandler(api.appLookup(api.DeleteRoute)))
+	httpRouter.PUT("/apps/:apps_id/routes/:routes_type/:routes_id/domain", httphelper.WrapHandler(api.appLookup(api.ChangeRouteDomain)))
+	httpRouter.PUT("/apps/:apps_id/routes/:routes_type/:routes_id/certificate", httphelper.WrapHandler(api.appLookup(api.RotateRouteCertificate)))
+	httpRouter.GET("/apps/:apps_id/routes/:routes_type/:routes_id/url", httphelper.WrapHandler(api.appLookup(api.GetRouteURL)))
+	httpRouter.GET("/apps/:apps_id/routes/:routes_type/:routes_id/meta", httphelper.WrapHandler(api.appLookup(api.GetRouteMeta)))
+	httpRouter.PUT("/apps/:apps_id/routes/:routes_type/:routes_id/meta", httphelper.WrapHandler(api.appLookup(api.UpdateRouteMeta)))
+
+	httpRouter.GET("/apps/:apps_id/certificate-status", httphelper.WrapHandler(api.appLookup(api.GetAppCertificateStatus)))
+	httpRouter.POST("/certificates", httphelper.WrapHandler(api.UpsertCertificate))
+	httpRouter.GET("/apps/:apps_id/route-count", httphelper.WrapHandler(api.appLookup(api.GetAppRouteCount)))
+	httpRouter.GET("/apps/:apps_id/leader-job", httphelper.WrapHandler(api.appLookup(api.GetAppLeaderJob)))
+	httpRouter.GET("/domains/:domain/app", httphelper.WrapHandler(api.GetDomainApp))
 
 	httpRouter.POST("/apps/:apps_id/meta", httphelper.WrapHandler(api.appLookup(api.UpdateApp)))
+	httpRouter.GET("/apps/:apps_id/meta/:key", httphelper.WrapHandler(api.appLookup(api.GetAppMetaValue)))
+	httpRouter.GET("/releases/:releases_id/meta/:key", httphelper.WrapHandler(api.GetReleaseMetaValue))
+	httpRouter.GET("/releases/:releases_id/default-processes", httphelper.WrapHandler(api.GetReleaseDefaultProcesses))
+	httpRouter.GET("/releases/:releases_id/size", httphelper.WrapHandler(api.GetReleaseSize))
+	httpRouter.GET("/releases/:releases_id/in-use", httphelper.WrapHandler(api.GetReleaseInUse))
+	httpRouter.GET("/releases/:releases_id/deployments", httphelper.WrapHandler(api.GetReleaseDeployments))
+	httpRouter.POST("/releases/import", httphelper.WrapHandler(api.ImportRelease))
+	httpRouter.POST("/releases/revise", httphelper.WrapHandler(api.ReviseRelease))
+	httpRouter.GET("/deleted-releases", httphelper.WrapHandler(api.GetDeletedReleases))
 
 	httpRouter.GET("/events", httphelper.WrapHandler(api.Events))
 	httpRouter.GET("/events/:id", httphelper.WrapHandler(api.GetEvent))
+	httpRouter.GET("/events/:id/app", httphelper.WrapHandler(api.GetEventApp))
+	httpRouter.GET("/events/:id/data", httphelper.WrapHandler(api.GetEventData))
+	httpRouter.GET("/latest-event-id", httphelper.WrapHandler(api.GetLatestEventID))
+	httpRouter.GET("/app-events", httphelper.WrapHandler(api.ListAppEvents))
+	httpRouter.GET("/app-lifecycle", httphelper.WrapHandler(api.StreamAppLifecycle))
 
 	return httphelper.ContextInjector("controller",
 		httphelper.NewRequestLogger(muxHandler(httpRouter, c.keys)))
@@ -340,7 +630,9 @@ func muxHandler(main http.Handler, authKeys []string) http.Handler {
 			w.WriteHeader(401)
 			return
 		}
-		main.ServeHTTP(w, r)
+		fw := formatResponse(w, r)
+		defer fw.Close()
+		main.ServeHTTP(fw, r)
 	}))
 }
 
@@ -356,12 +648,25 @@ type controllerAPI struct {
 	deploymentRepo      *DeploymentRepo
 	eventRepo           *EventRepo
 	backupRepo          *BackupRepo
-	clusterClient       utils.ClusterClient
-	logaggc             logClient
-	routerc             routerc.Client
-	que                 *que.Client
-	caCert              []byte
-	config              handlerConfig
+	routeMetaRepo       *RouteMetaRepo
+	statsRepo           *StatsRepo
+
+	// routeCache caches the last known routes for each app so they can
+	// still be listed if the router is unavailable, and an app's entry is
+	// removed whenever the controller changes the app's routes
+	routeCache    *objectCache
+	clusterClient utils.ClusterClient
+	logaggc       logClient
+	routerc       routerc.Client
+	// serviceLeaders looks up the leaders of the services which leader
+	// routes send traffic to
+	serviceLeaders serviceLeaders
+	que            *que.Client
+	caCert         []byte
+	config         handlerConfig
+	// expensiveRequests holds a value for each expensive request in
+	// progress, limiting how many can run at once
+	expensiveRequests chan struct{}
 
 	eventListener    *EventListener
 	eventListenerMtx sync.Mutex
@@ -371,6 +676,14 @@ func (c *controllerAPI) getApp(ctx context.Context) *ct.App {
 	return ctx.Value("app").(*ct.App)
 }
 
+// newSSEStream returns an event stream which sends keepalives at the
+// configured interval.
+func (c *controllerAPI) newSSEStream(w http.ResponseWriter, ch interface{}, l log15.Logger) *sse.Stream {
+	s := sse.NewStream(w, ch, l)
+	s.KeepAliveInterval = c.config.sseKeepAlive
+	return s
+}
+
 func (c *controllerAPI) getRelease(ctx context.Context) (*ct.Release, error) {
 	params, _ := ctxhelper.ParamsFromContext(ctx)
 	data, err := c.releaseRepo.Get(params.ByName("releases_id"))
@@ -380,6 +693,21 @@ func (c *controllerAPI) getRelease(ctx context.Context) (*ct.Release, error) {
 	return data.(*ct.Release), nil
 }
 
+// isAppRelease returns whether the release belongs to the app, meaning the
+// app either has a formation for it or is currently using it.
+func (c *controllerAPI) isAppRelease(appID, releaseID string) (bool, error) {
+	appIDs, err := c.releaseRepo.AppIDs(releaseID)
+	if err != nil {
+		return false, err
+	}
+	for _, id := range appIDs {
+		if id == appID {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
 func (c *controllerAPI) getProvider(ctx context.Context) (*ct.Provider, error) {
 	params, _ := ctxhelper.ParamsFromContext(ctx)
 	data, err := c.providerRepo.Get(params.ByName("providers_id"))
@@ -389,16 +717,71 @@ func (c *controllerAPI) getProvider(ctx context.Context) (*ct.Provider, error) {
 	return data.(*ct.Provider), nil
 }
 
+// resolveApp looks up an app using a string which is either the app's ID or
+// its name, returning an ObjectNotFound error which states whether the
+// string was treated as an ID or a name if the app does not exist.
+func (c *controllerAPI) resolveApp(idOrName string) (*ct.App, error) {
+	idOrName = strings.TrimSpace(idOrName)
+	if idOrName == "" {
+		return nil, ct.ValidationError{Field: "app", Message: "must not be blank"}
+	}
+	isID := idPattern.MatchString(strings.ToLower(idOrName))
+	if isID {
+		idOrName = strings.ToLower(idOrName)
+	}
+	data, err := c.appRepo.Get(idOrName)
+	if err == ErrNotFound {
+		msg := fmt.Sprintf("app with name %q not found", idOrName)
+		if isID {
+			msg = fmt.Sprintf("app with ID %q not found", idOrName)
+		}
+		return nil, httphelper.JSONError{Code: httphelper.ObjectNotFoundErrorCode, Message: msg}
+	} else if err != nil {
+		return nil, err
+	}
+	return data.(*ct.App), nil
+}
+
 func (c *controllerAPI) appLookup(handler httphelper.HandlerFunc) httphelper.HandlerFunc {
 	return func(ctx context.Context, w http.ResponseWriter, req *http.Request) {
 		params, _ := ctxhelper.ParamsFromContext(ctx)
-		data, err := c.appRepo.Get(params.ByName("apps_id"))
+		app, err := c.resolveApp(params.ByName("apps_id"))
 		if err != nil {
 			respondWithError(w, err)
 			return
 		}
-		ctx = context.WithValue(ctx, "app", data.(*ct.App))
-		handler(ctx, w, req)
+		ctx = context.WithValue(ctx, "app", app)
+		handler(c.withCurrentReleaseCache(ctx), w, req)
+	}
+}
+
+// withCurrentReleaseCache returns a context which caches the current
+// releases of apps looked up with getCurrentRelease.
+func (c *controllerAPI) withCurrentReleaseCache(ctx context.Context) context.Context {
+	load := c.appRepo.GetRelease
+	if loaded := c.config.currentReleaseLoaded; loaded != nil {
+		load = func(appID string) (*ct.Release, error) {
+			loaded(appID)
+			return c.appRepo.GetRelease(appID)
+		}
+	}
+	return context.WithValue(ctx, "current_release_cache", newCurrentReleaseCache(load))
+}
+
+// getCurrentRelease returns the app's current release, using the request's
+// current release cache if it has one.
+func (c *controllerAPI) getCurrentRelease(ctx context.Context, appID string) (*ct.Release, error) {
+	if cache, ok := ctx.Value("current_release_cache").(*currentReleaseCache); ok {
+		return cache.Get(appID)
+	}
+	return c.appRepo.GetRelease(appID)
+}
+
+// forgetCurrentRelease removes the app's current release from the request's
+// current release cache, and should be called after changing the release.
+func (c *controllerAPI) forgetCurrentRelease(ctx context.Context, appID string) {
+	if cache, ok := ctx.Value("current_release_cache").(*currentReleaseCache); ok {
+		cache.Remove(appID)
 	}
 }
 
@@ -409,13 +792,21 @@ func routeParentRef(appID string) string {
 func (c *controllerAPI) getRoute(ctx context.Context) (*router.Route, error) {
 	params, _ := ctxhelper.ParamsFromContext(ctx)
 	route, err := c.routerc.GetRoute(params.ByName("routes_type"), params.ByName("routes_id"))
-	if err == routerc.ErrNotFound || err == nil && route.ParentRef != routeParentRef(c.getApp(ctx).ID) {
-		err = ErrNotFound
+	if err != nil {
+		return nil, routerError(err)
 	}
+	if route.ParentRef != routeParentRef(c.getApp(ctx).ID) {
+		return nil, ErrNotFound
+	}
+	meta, err := c.routeMetaRepo.Get(route.ID)
 	if err != nil {
 		return nil, err
 	}
-	return route, err
+	route.Meta = nil
+	if len(meta) > 0 {
+		route.Meta = meta
+	}
+	return route, nil
 }
 
 func createEvent(dbExec func(string, ...interface{}) error, e *ct.Event, data interface{}) error {
@@ -452,6 +843,15 @@ func (c *controllerAPI) GetCACert(_ context.Context, w http.ResponseWriter, _ *h
 	w.Write(c.caCert)
 }
 
+// GetServerInfo responds with the controller's version and the optional
+// features enabled in its configuration.
+func (c *controllerAPI) GetServerInfo(_ context.Context, w http.ResponseWriter, _ *http.Request) {
+	httphelper.JSON(w, 200, &ct.ServerInfo{
+		Version:      version.String(),
+		Capabilities: c.config.capabilities(),
+	})
+}
+
 func (c *controllerAPI) Shutdown() {
 	c.formationRepo.stopListener <- struct{}{}
 
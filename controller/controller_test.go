@@ -1,24 +1,32 @@
 package main
 
 import (
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
 	"fmt"
+	"io/ioutil"
 	"net/http"
 	"net/http/httptest"
+	"net/url"
 	"os"
 	"path/filepath"
 	"strings"
 	"testing"
+	"time"
 
 	"github.com/flynn/flynn/controller/client"
 	"github.com/flynn/flynn/controller/schema"
 	tu "github.com/flynn/flynn/controller/testutils"
 	ct "github.com/flynn/flynn/controller/types"
+	"github.com/flynn/flynn/host/resource"
 	"github.com/flynn/flynn/host/types"
 	"github.com/flynn/flynn/pkg/certgen"
 	hh "github.com/flynn/flynn/pkg/httphelper"
 	"github.com/flynn/flynn/pkg/postgres"
 	"github.com/flynn/flynn/pkg/random"
 	"github.com/flynn/flynn/pkg/testutils/postgres"
+	"github.com/flynn/flynn/router/types"
 	. "github.com/flynn/go-check"
 	"github.com/jackc/pgx"
 )
@@ -93,6 +101,7 @@ func (s *S) SetUpSuite(c *C) {
 		cc:     s.cc,
 		lc:     s.flac,
 		rc:     newFakeRouter(),
+		sl:     newFakeServiceLeaders(),
 		keys:   []string{authKey},
 		caCert: s.caCert,
 	}
@@ -107,6 +116,29 @@ func (s *S) SetUpTest(c *C) {
 	s.cc.SetHosts(make(map[string]*tu.FakeHostClient))
 }
 
+func (s *S) TestServerInfo(c *C) {
+	// the test handler enables none of the optional features
+	info, err := s.c.GetServerInfo()
+	c.Assert(err, IsNil)
+	c.Assert(info.Version, Equals, "dev")
+	c.Assert(info.Capabilities, DeepEquals, []string{})
+
+	config := handlerConfig{
+		cacheSize:    10,
+		sseKeepAlive: time.Second,
+		timeouts:     map[dependency]time.Duration{dependencyRouter: time.Second},
+	}
+	c.Assert(config.capabilities(), DeepEquals, []string{
+		ct.CapabilityObjectCache,
+		ct.CapabilityEventKeepAlive,
+		ct.CapabilityDependencyTimeouts,
+	})
+
+	// zero timeouts do not enable dependency timeouts
+	config = handlerConfig{cacheSize: 10, timeouts: map[dependency]time.Duration{dependencyRouter: 0}}
+	c.Assert(config.capabilities(), DeepEquals, []string{ct.CapabilityObjectCache})
+}
+
 func (s *S) TestBadAuth(c *C) {
 	res, err := http.Get(s.srv.URL + "/apps")
 	c.Assert(err, IsNil)
@@ -150,6 +182,52 @@ func (s *S) TestCreateApp(c *C) {
 	}
 }
 
+func (s *S) TestCreateApps(c *C) {
+	apps := []*ct.App{
+		{Name: "create-apps-web"},
+		{Name: "create-apps-worker", Meta: map[string]string{"foo": "bar"}},
+		{},
+	}
+	c.Assert(s.c.CreateApps(apps), IsNil)
+	for _, app := range apps {
+		c.Assert(app.ID, Not(Equals), "")
+		c.Assert(app.Name, Not(Equals), "")
+		gotApp, err := s.c.GetApp(app.ID)
+		c.Assert(err, IsNil)
+		c.Assert(gotApp, DeepEquals, app)
+	}
+	c.Assert(apps[1].Meta["foo"], Equals, "bar")
+
+	// a name conflict should create none of the apps
+	err := s.c.CreateApps([]*ct.App{
+		{Name: "create-apps-db"},
+		{Name: "create-apps-web"},
+	})
+	c.Assert(hh.IsObjectExistsError(err), Equals, true)
+	c.Assert(err, ErrorMatches, `.*"create-apps-web" already exists.*`)
+	_, err = s.c.GetApp("create-apps-db")
+	c.Assert(err, Equals, controller.ErrNotFound)
+
+	// so should a conflict within the batch
+	err = s.c.CreateApps([]*ct.App{
+		{Name: "create-apps-cache"},
+		{Name: "create-apps-cache"},
+	})
+	c.Assert(hh.IsObjectExistsError(err), Equals, true)
+	_, err = s.c.GetApp("create-apps-cache")
+	c.Assert(err, Equals, controller.ErrNotFound)
+
+	// and an invalid app
+	err = s.c.CreateApps([]*ct.App{
+		{Name: "create-apps-valid"},
+		{Name: "create-apps-invalid", Strategy: "foo"},
+	})
+	c.Assert(hh.IsValidationError(err), Equals, true)
+	c.Assert(err, ErrorMatches, `.*apps\[1\]\.strategy.*`)
+	_, err = s.c.GetApp("create-apps-valid")
+	c.Assert(err, Equals, controller.ErrNotFound)
+}
+
 func (s *S) TestSystemApp(c *C) {
 	app := s.createTestApp(c, &ct.App{Meta: map[string]string{"flynn-system-app": "true"}})
 	c.Assert(app.System(), Equals, true)
@@ -207,6 +285,247 @@ func (s *S) TestUpdateApp(c *C) {
 	c.Assert(app.DeployTimeout, Equals, timeout)
 }
 
+func (s *S) TestCloneApp(c *C) {
+	app := s.createTestApp(c, &ct.App{
+		Name:          "clone-app-source",
+		Meta:          map[string]string{"foo": "bar"},
+		Strategy:      "one-by-one",
+		DeployTimeout: 150,
+	})
+	release := s.createTestRelease(c, &ct.Release{
+		Env:       map[string]string{"FOO": "bar"},
+		Meta:      map[string]string{"git": "true"},
+		Processes: map[string]ct.ProcessType{"web": {Args: []string{"start", "web"}}},
+	})
+	s.setAppRelease(c, app.ID, release.ID)
+
+	clone, err := s.c.CloneApp(app.Name, "clone-app")
+	c.Assert(err, IsNil)
+	c.Assert(clone.ID, Not(Equals), "")
+	c.Assert(clone.ID, Not(Equals), app.ID)
+	c.Assert(clone.Name, Equals, "clone-app")
+	c.Assert(clone.Meta, DeepEquals, app.Meta)
+	c.Assert(clone.Strategy, Equals, app.Strategy)
+	c.Assert(clone.DeployTimeout, Equals, app.DeployTimeout)
+
+	// the clone has a new release with the same contents
+	cloneRelease, err := s.c.GetAppRelease(clone.ID)
+	c.Assert(err, IsNil)
+	c.Assert(cloneRelease.ID, Not(Equals), release.ID)
+	c.Assert(cloneRelease.ArtifactIDs, DeepEquals, release.ArtifactIDs)
+	c.Assert(cloneRelease.Env, DeepEquals, release.Env)
+	c.Assert(cloneRelease.Meta, DeepEquals, release.Meta)
+	c.Assert(cloneRelease.Processes, DeepEquals, release.Processes)
+
+	// the source app is unchanged
+	gotRelease, err := s.c.GetAppRelease(app.ID)
+	c.Assert(err, IsNil)
+	c.Assert(gotRelease.ID, Equals, release.ID)
+
+	// an app without a release can be cloned
+	empty := s.createTestApp(c, &ct.App{Name: "clone-app-empty"})
+	clone, err = s.c.CloneApp(empty.ID, "clone-app-empty-clone")
+	c.Assert(err, IsNil)
+	_, err = s.c.GetAppRelease(clone.ID)
+	c.Assert(err, Equals, controller.ErrNotFound)
+
+	// the new name must not be in use
+	_, err = s.c.CloneApp(app.ID, "clone-app")
+	c.Assert(hh.IsObjectExistsError(err), Equals, true)
+}
+
+func (s *S) TestExportApp(c *C) {
+	app := s.createTestApp(c, &ct.App{Name: "export-app", Meta: map[string]string{"foo": "bar"}})
+	artifact := s.createTestArtifact(c, &ct.Artifact{})
+	release := s.createTestRelease(c, &ct.Release{
+		ArtifactIDs: []string{artifact.ID},
+		Env:         map[string]string{"FOO": "bar"},
+		Meta:        map[string]string{"git": "true"},
+		Processes:   map[string]ct.ProcessType{"web": {Args: []string{"start", "web"}}},
+	})
+	s.setAppRelease(c, app.ID, release.ID)
+	s.createTestFormation(c, &ct.Formation{AppID: app.ID, ReleaseID: release.ID, Processes: map[string]int{"web": 2}})
+	route := s.createTestRoute(c, app.ID, (&router.HTTPRoute{Service: "export-app-web", Domain: "export-app.example.com"}).ToRoute())
+
+	export, err := s.c.ExportApp(app.Name)
+	c.Assert(err, IsNil)
+	c.Assert(export.App.ID, Equals, app.ID)
+	c.Assert(export.App.Meta, DeepEquals, app.Meta)
+	c.Assert(export.Release, NotNil)
+	c.Assert(export.Release.ID, Equals, release.ID)
+	c.Assert(export.Release.Env, DeepEquals, release.Env)
+	c.Assert(export.Release.Meta, DeepEquals, release.Meta)
+	c.Assert(export.Release.Processes, DeepEquals, release.Processes)
+	c.Assert(export.Artifacts, HasLen, 1)
+	c.Assert(export.Artifacts[0].ID, Equals, artifact.ID)
+	c.Assert(export.Artifacts[0].URI, Equals, artifact.URI)
+	c.Assert(export.Formation, NotNil)
+	c.Assert(export.Formation.Processes, DeepEquals, map[string]int{"web": 2})
+	c.Assert(export.Routes, HasLen, 1)
+	c.Assert(export.Routes[0].ID, Equals, route.ID)
+	c.Assert(export.Routes[0].Domain, Equals, route.Domain)
+
+	// an app without a release only has the app and its routes
+	empty := s.createTestApp(c, &ct.App{Name: "export-app-empty"})
+	export, err = s.c.ExportApp(empty.ID)
+	c.Assert(err, IsNil)
+	c.Assert(export.App.ID, Equals, empty.ID)
+	c.Assert(export.Release, IsNil)
+	c.Assert(export.Artifacts, HasLen, 0)
+	c.Assert(export.Formation, IsNil)
+	c.Assert(export.Routes, HasLen, 0)
+}
+
+func (s *S) TestImportApp(c *C) {
+	app := s.createTestApp(c, &ct.App{Name: "import-app-source", Meta: map[string]string{"foo": "bar"}, Strategy: "one-by-one"})
+	artifact := s.createTestArtifact(c, &ct.Artifact{})
+	release := s.createTestRelease(c, &ct.Release{
+		ArtifactIDs: []string{artifact.ID},
+		Env:         map[string]string{"FOO": "bar"},
+		Processes:   map[string]ct.ProcessType{"web": {Args: []string{"start", "web"}}},
+	})
+	s.setAppRelease(c, app.ID, release.ID)
+	s.createTestFormation(c, &ct.Formation{AppID: app.ID, ReleaseID: release.ID, Processes: map[string]int{"web": 2}})
+	route := s.createTestRoute(c, app.ID, (&router.HTTPRoute{Service: "import-app-web", Domain: "import-app-source.example.com"}).ToRoute())
+	c.Assert(s.c.UpdateRouteMeta(app.ID, route.ID, map[string]string{"owner": "web-team"}), IsNil)
+
+	// import the export as a fresh app, renaming it and its route so it
+	// doesn't conflict with the source app
+	export, err := s.c.ExportApp(app.ID)
+	c.Assert(err, IsNil)
+	export.App.Name = "import-app"
+	export.Routes[0].Domain = "import-app.example.com"
+	imported, err := s.c.ImportApp(export)
+	c.Assert(err, IsNil)
+	c.Assert(imported.ID, Not(Equals), app.ID)
+	c.Assert(imported.Name, Equals, "import-app")
+	c.Assert(imported.Meta, DeepEquals, app.Meta)
+	c.Assert(imported.Strategy, Equals, app.Strategy)
+
+	importedRelease, err := s.c.GetAppRelease(imported.ID)
+	c.Assert(err, IsNil)
+	c.Assert(importedRelease.ID, Not(Equals), release.ID)
+	c.Assert(importedRelease.ArtifactIDs, DeepEquals, []string{artifact.ID})
+	c.Assert(importedRelease.Env, DeepEquals, release.Env)
+	c.Assert(importedRelease.Processes, DeepEquals, release.Processes)
+
+	formation, err := s.c.GetFormation(imported.ID, importedRelease.ID)
+	c.Assert(err, IsNil)
+	c.Assert(formation.Processes, DeepEquals, map[string]int{"web": 2})
+
+	routes, err := s.c.RouteList(imported.ID)
+	c.Assert(err, IsNil)
+	c.Assert(routes, HasLen, 1)
+	c.Assert(routes[0].Domain, Equals, "import-app.example.com")
+	c.Assert(routes[0].Service, Equals, "import-app-web")
+	c.Assert(routes[0].Meta, DeepEquals, map[string]string{"owner": "web-team"})
+
+	// the re-exported app matches the original export
+	reexport, err := s.c.ExportApp(imported.ID)
+	c.Assert(err, IsNil)
+	c.Assert(reexport.Release.Env, DeepEquals, export.Release.Env)
+	c.Assert(reexport.Formation.Processes, DeepEquals, export.Formation.Processes)
+	c.Assert(reexport.Artifacts[0].URI, Equals, artifact.URI)
+
+	// a failure part way through the import should create nothing
+	export, err = s.c.ExportApp(app.ID)
+	c.Assert(err, IsNil)
+	export.App.Name = "import-app-failed"
+	export.Routes[0].Domain = "import-app-failed.example.com"
+	export.Release.ArtifactIDs = append(export.Release.ArtifactIDs, random.UUID())
+	_, err = s.c.ImportApp(export)
+	c.Assert(err, NotNil)
+	_, err = s.c.GetApp("import-app-failed")
+	c.Assert(err, Equals, controller.ErrNotFound)
+
+	// as should a name conflict
+	export, err = s.c.ExportApp(app.ID)
+	c.Assert(err, IsNil)
+	export.Routes[0].Domain = "import-app-conflict.example.com"
+	_, err = s.c.ImportApp(export)
+	c.Assert(hh.IsObjectExistsError(err), Equals, true)
+	routes, err = s.hc.rc.ListRoutes("")
+	c.Assert(err, IsNil)
+	for _, route := range routes {
+		c.Assert(route.Domain, Not(Equals), "import-app-conflict.example.com")
+	}
+
+	// and a route conflict
+	export, err = s.c.ExportApp(app.ID)
+	c.Assert(err, IsNil)
+	export.App.Name = "import-app-route-conflict"
+	_, err = s.c.ImportApp(export)
+	c.Assert(hh.IsObjectExistsError(err), Equals, true)
+	_, err = s.c.GetApp("import-app-route-conflict")
+	c.Assert(err, Equals, controller.ErrNotFound)
+}
+
+func (s *S) TestAppConfigChecksum(c *C) {
+	newApp := func(name string, env map[string]string, processes map[string]int) *ct.App {
+		app := s.createTestApp(c, &ct.App{Name: name})
+		release := s.createTestRelease(c, &ct.Release{
+			Env:       env,
+			Processes: map[string]ct.ProcessType{"web": {Args: []string{"start", "web"}}, "worker": {Args: []string{"start", "worker"}}},
+		})
+		s.createTestFormation(c, &ct.Formation{AppID: app.ID, ReleaseID: release.ID, Processes: processes})
+		s.setAppRelease(c, app.ID, release.ID)
+		return app
+	}
+	checksum := func(app *ct.App) string {
+		sum, err := s.c.GetAppConfigChecksum(app.ID)
+		c.Assert(err, IsNil)
+		c.Assert(sum, Not(Equals), "")
+		return sum
+	}
+
+	// apps with identical configs have identical checksums
+	app1 := newApp("config-checksum-1", map[string]string{"A": "1", "B": "2", "C": "3"}, map[string]int{"web": 2, "worker": 1})
+	app2 := newApp("config-checksum-2", map[string]string{"C": "3", "B": "2", "A": "1"}, map[string]int{"worker": 1, "web": 2})
+	c.Assert(checksum(app1), Equals, checksum(app2))
+	c.Assert(checksum(app1), Equals, checksum(app1))
+
+	// processes scaled to zero are treated as absent
+	app3 := newApp("config-checksum-3", map[string]string{"A": "1", "B": "2", "C": "3"}, map[string]int{"web": 2, "worker": 1, "clock": 0})
+	c.Assert(checksum(app3), Equals, checksum(app1))
+
+	// changing the env changes the checksum
+	app4 := newApp("config-checksum-4", map[string]string{"A": "1", "B": "2", "C": "4"}, map[string]int{"web": 2, "worker": 1})
+	c.Assert(checksum(app4), Not(Equals), checksum(app1))
+
+	// changing the formation changes the checksum
+	before := checksum(app2)
+	release, err := s.c.GetAppRelease(app2.ID)
+	c.Assert(err, IsNil)
+	c.Assert(s.c.PutFormation(&ct.Formation{AppID: app2.ID, ReleaseID: release.ID, Processes: map[string]int{"web": 3, "worker": 1}}), IsNil)
+	c.Assert(checksum(app2), Not(Equals), before)
+
+	// an app without a release has a checksum
+	checksum(s.createTestApp(c, &ct.App{Name: "config-checksum-empty"}))
+}
+
+func (s *S) TestUpdateAppIfUnmodifiedSince(c *C) {
+	app := s.createTestApp(c, &ct.App{Name: "update-app-if-unmodified-since"})
+	c.Assert(app.UpdatedAt, NotNil)
+	updatedAt := *app.UpdatedAt
+
+	// an update conditional on the current updated_at should succeed
+	update := &ct.App{ID: app.ID, Meta: map[string]string{"foo": "bar"}}
+	c.Assert(s.c.UpdateAppIfUnmodifiedSince(update, updatedAt), IsNil)
+	c.Assert(update.Meta, DeepEquals, map[string]string{"foo": "bar"})
+	c.Assert(update.UpdatedAt, NotNil)
+	c.Assert(update.UpdatedAt.After(updatedAt), Equals, true)
+
+	// an update conditional on the stale updated_at should fail
+	stale := &ct.App{ID: app.ID, Meta: map[string]string{"foo": "baz"}}
+	err := s.c.UpdateAppIfUnmodifiedSince(stale, updatedAt)
+	c.Assert(err, NotNil)
+	c.Assert(hh.IsPreconditionFailedError(err), Equals, true)
+
+	gotApp, err := s.c.GetApp(app.ID)
+	c.Assert(err, IsNil)
+	c.Assert(gotApp.Meta, DeepEquals, map[string]string{"foo": "bar"})
+}
+
 func (s *S) TestUpdateAppMeta(c *C) {
 	meta := map[string]string{"foo": "bar"}
 	app := s.createTestApp(c, &ct.App{Name: "update-app-meta", Meta: meta})
@@ -229,6 +548,30 @@ func (s *S) TestUpdateAppMeta(c *C) {
 	c.Assert(app.Meta, DeepEquals, meta)
 }
 
+func (s *S) TestMetaValue(c *C) {
+	app := s.createTestApp(c, &ct.App{Name: "meta-value", Meta: map[string]string{"foo": "bar"}})
+	release := s.createTestRelease(c, &ct.Release{Meta: map[string]string{"git": "true"}})
+
+	value, err := s.c.GetAppMetaValue(app.ID, "foo")
+	c.Assert(err, IsNil)
+	c.Assert(value, NotNil)
+	c.Assert(*value, Equals, "bar")
+	value, err = s.c.GetAppMetaValue(app.ID, "missing")
+	c.Assert(err, IsNil)
+	c.Assert(value, IsNil)
+
+	value, err = s.c.GetReleaseMetaValue(release.ID, "git")
+	c.Assert(err, IsNil)
+	c.Assert(value, NotNil)
+	c.Assert(*value, Equals, "true")
+	value, err = s.c.GetReleaseMetaValue(release.ID, "missing")
+	c.Assert(err, IsNil)
+	c.Assert(value, IsNil)
+
+	_, err = s.c.GetReleaseMetaValue(random.UUID(), "git")
+	c.Assert(err, Equals, controller.ErrNotFound)
+}
+
 func (s *S) createTestArtifact(c *C, in *ct.Artifact) *ct.Artifact {
 	if in.Type == "" {
 		in.Type = host.ArtifactTypeDocker
@@ -290,6 +633,655 @@ func (s *S) TestCreateRelease(c *C) {
 	}
 }
 
+func (s *S) TestCreateReleaseProcessTypes(c *C) {
+	memory := int64(256 * 1024 * 1024)
+	cpu := int64(512)
+	proc := ct.ProcessType{
+		Args: []string{"/bin/web", "--port", "8080"},
+		Env:  map[string]string{"PROC": "web"},
+		Ports: []ct.Port{
+			{Port: 8080, Proto: "tcp"},
+			{Port: 53, Proto: "udp"},
+		},
+		Service: "web-service",
+		Resources: resource.Resources{
+			resource.TypeMemory: {Request: &memory, Limit: &memory},
+			resource.TypeCPU:    {Limit: &cpu},
+		},
+	}
+	release := s.createTestRelease(c, &ct.Release{
+		Processes: map[string]ct.ProcessType{"web": proc},
+	})
+
+	gotRelease, err := s.c.GetRelease(release.ID)
+	c.Assert(err, IsNil)
+	gotProc, ok := gotRelease.Processes["web"]
+	c.Assert(ok, Equals, true)
+	c.Assert(gotProc.Args, DeepEquals, proc.Args)
+	c.Assert(gotProc.Env, DeepEquals, proc.Env)
+	c.Assert(gotProc.Ports, DeepEquals, proc.Ports)
+	c.Assert(gotProc.Service, Equals, proc.Service)
+	c.Assert(*gotProc.Resources[resource.TypeMemory].Request, Equals, memory)
+	c.Assert(*gotProc.Resources[resource.TypeMemory].Limit, Equals, memory)
+	c.Assert(*gotProc.Resources[resource.TypeCPU].Limit, Equals, cpu)
+
+	for _, t := range []struct {
+		proc    ct.ProcessType
+		message string
+	}{
+		{
+			proc:    ct.ProcessType{Ports: []ct.Port{{Port: 8080, Proto: "http"}}},
+			message: `processes.web.ports[0].proto must be either "tcp" or "udp"`,
+		},
+		{
+			proc:    ct.ProcessType{Ports: []ct.Port{{Port: 70000, Proto: "tcp"}}},
+			message: "processes.web.ports[0].port must be between 0 and 65535",
+		},
+		{
+			proc: ct.ProcessType{Resources: resource.Resources{
+				resource.TypeMemory: {Request: &memory, Limit: &cpu},
+			}},
+			message: "processes.web.resources.memory.request must not be greater than the limit",
+		},
+	} {
+		err := s.c.CreateRelease(&ct.Release{
+			ArtifactIDs: release.ArtifactIDs,
+			Processes:   map[string]ct.ProcessType{"web": t.proc},
+		})
+		c.Assert(hh.IsValidationError(err), Equals, true)
+		c.Assert(err.(hh.JSONError).Message, Equals, t.message)
+	}
+}
+
+func (s *S) TestCreateReleaseValidationErrors(c *C) {
+	err := s.c.CreateRelease(&ct.Release{
+		ArtifactIDs: []string{random.UUID()},
+		Processes: map[string]ct.ProcessType{
+			"web": {Ports: []ct.Port{{Port: 8080, Proto: "http"}}},
+		},
+	})
+	c.Assert(hh.IsValidationError(err), Equals, true)
+	c.Assert(err.(hh.JSONError).Message, Equals, `artifacts[0] does not exist; processes.web.ports[0].proto must be either "tcp" or "udp"`)
+
+	var detail struct {
+		Errors []ct.ValidationError `json:"errors"`
+	}
+	c.Assert(json.Unmarshal(err.(hh.JSONError).Detail, &detail), IsNil)
+	c.Assert(detail.Errors, DeepEquals, []ct.ValidationError{
+		{Field: "artifacts[0]", Message: "does not exist"},
+		{Field: "processes.web.ports[0].proto", Message: `must be either "tcp" or "udp"`},
+	})
+}
+
+func (s *S) TestPromoteRelease(c *C) {
+	source := s.createTestRelease(c, &ct.Release{
+		Env:       map[string]string{"A": "1", "B": "2"},
+		Meta:      map[string]string{"git": "true"},
+		Processes: map[string]ct.ProcessType{"web": {Args: []string{"start", "web"}}},
+	})
+
+	// promoting without overrides or deploying should copy the release's
+	// artifacts and processes but not its env
+	app := s.createTestApp(c, &ct.App{Name: "promote-release"})
+	res, err := s.c.PromoteRelease(app.ID, &ct.PromoteRelease{ReleaseID: source.ID})
+	c.Assert(err, IsNil)
+	c.Assert(res.Release.ID, Not(Equals), source.ID)
+	c.Assert(res.Release.ArtifactIDs, DeepEquals, source.ArtifactIDs)
+	c.Assert(res.Release.Processes, DeepEquals, source.Processes)
+	c.Assert(res.Release.Meta, DeepEquals, source.Meta)
+	c.Assert(res.Release.Env, HasLen, 0)
+	c.Assert(res.Deployment, IsNil)
+	_, err = s.c.GetAppRelease(app.ID)
+	c.Assert(err, Equals, controller.ErrNotFound)
+
+	// env overrides should be merged into the app's current env, and
+	// deploying should set the app release
+	app = s.createTestApp(c, &ct.App{Name: "promote-release-deploy"})
+	current := s.createTestRelease(c, &ct.Release{Env: map[string]string{"B": "x", "D": "5"}})
+	c.Assert(s.c.SetAppRelease(app.ID, current.ID), IsNil)
+	res, err = s.c.PromoteRelease(app.ID, &ct.PromoteRelease{
+		ReleaseID: source.ID,
+		Env:       map[string]string{"B": "3", "C": "4"},
+		Deploy:    true,
+	})
+	c.Assert(err, IsNil)
+	c.Assert(res.Release.Env, DeepEquals, map[string]string{"B": "3", "C": "4", "D": "5"})
+	c.Assert(res.Deployment, NotNil)
+	c.Assert(res.Deployment.NewReleaseID, Equals, res.Release.ID)
+	appRelease, err := s.c.GetAppRelease(app.ID)
+	c.Assert(err, IsNil)
+	c.Assert(appRelease.ID, Equals, res.Release.ID)
+
+	// the source release should be unchanged
+	gotSource, err := s.c.GetRelease(source.ID)
+	c.Assert(err, IsNil)
+	c.Assert(gotSource.Env, DeepEquals, source.Env)
+
+	// the release should be deleted if deploying it fails
+	app = s.createTestApp(c, &ct.App{Name: "promote-release-deploy-fail"})
+	c.Assert(s.c.PutFormation(&ct.Formation{AppID: app.ID, ReleaseID: current.ID, Processes: map[string]int{"web": 1}}), IsNil)
+	c.Assert(s.c.SetAppRelease(app.ID, current.ID), IsNil)
+	_, err = s.c.CreateDeployment(app.ID, s.createTestRelease(c, &ct.Release{}).ID)
+	c.Assert(err, IsNil)
+	releases, err := s.c.ReleaseList()
+	c.Assert(err, IsNil)
+	_, err = s.c.PromoteRelease(app.ID, &ct.PromoteRelease{ReleaseID: source.ID, Deploy: true})
+	c.Assert(hh.IsValidationError(err), Equals, true)
+	afterReleases, err := s.c.ReleaseList()
+	c.Assert(err, IsNil)
+	c.Assert(afterReleases, HasLen, len(releases))
+}
+
+func (s *S) TestSetAppEnv(c *C) {
+	app := s.createTestApp(c, &ct.App{Name: "set-app-env"})
+	release := s.createTestRelease(c, &ct.Release{
+		Env:       map[string]string{"A": "1", "B": "2"},
+		Processes: map[string]ct.ProcessType{"web": {Args: []string{"start", "web"}}},
+	})
+	s.setAppRelease(c, app.ID, release.ID)
+
+	// setting and unsetting vars should create a copy of the current
+	// release with the new env without deploying it
+	res, err := s.c.SetAppEnv(app.ID, &ct.AppEnvUpdate{
+		Set:   map[string]string{"B": "3", "C": "4"},
+		Unset: []string{"A", "D"},
+	})
+	c.Assert(err, IsNil)
+	c.Assert(res.Release.ID, Not(Equals), release.ID)
+	c.Assert(res.Release.Env, DeepEquals, map[string]string{"B": "3", "C": "4"})
+	c.Assert(res.Release.Processes, DeepEquals, release.Processes)
+	c.Assert(res.Release.ArtifactIDs, DeepEquals, release.ArtifactIDs)
+	c.Assert(res.Deployment, IsNil)
+	appRelease, err := s.c.GetAppRelease(app.ID)
+	c.Assert(err, IsNil)
+	c.Assert(appRelease.ID, Equals, release.ID)
+	c.Assert(appRelease.Env, DeepEquals, release.Env)
+
+	// deploying should set the app release as the app has no processes
+	res, err = s.c.SetAppEnv(app.ID, &ct.AppEnvUpdate{Unset: []string{"B"}, Deploy: true})
+	c.Assert(err, IsNil)
+	c.Assert(res.Release.Env, DeepEquals, map[string]string{"A": "1"})
+	c.Assert(res.Deployment, NotNil)
+	c.Assert(res.Deployment.NewReleaseID, Equals, res.Release.ID)
+	appRelease, err = s.c.GetAppRelease(app.ID)
+	c.Assert(err, IsNil)
+	c.Assert(appRelease.ID, Equals, res.Release.ID)
+
+	// unchanged env should neither create nor deploy a release
+	current := res.Release
+	res, err = s.c.SetAppEnv(app.ID, &ct.AppEnvUpdate{
+		Set:    map[string]string{"A": "1"},
+		Unset:  []string{"B"},
+		Deploy: true,
+	})
+	c.Assert(err, IsNil)
+	c.Assert(res.Release.ID, Equals, current.ID)
+	c.Assert(res.Deployment, IsNil)
+	deployments, err := s.c.DeploymentList(app.ID)
+	c.Assert(err, IsNil)
+	c.Assert(deployments, HasLen, 1)
+
+	// a release which cannot be deployed (here because a deploy is in
+	// progress) should not be left behind
+	c.Assert(s.c.PutFormation(&ct.Formation{
+		AppID:     app.ID,
+		ReleaseID: current.ID,
+		Processes: map[string]int{"web": 1},
+	}), IsNil)
+	defer s.c.DeleteFormation(app.ID, current.ID)
+	_, err = s.c.RestartApp(app.ID)
+	c.Assert(err, IsNil)
+	var releaseCount int64
+	c.Assert(s.hc.db.QueryRow("SELECT COUNT(*) FROM releases WHERE deleted_at IS NULL").Scan(&releaseCount), IsNil)
+	_, err = s.c.SetAppEnv(app.ID, &ct.AppEnvUpdate{Set: map[string]string{"D": "5"}, Deploy: true})
+	c.Assert(hh.IsValidationError(err), Equals, true)
+	var newReleaseCount int64
+	c.Assert(s.hc.db.QueryRow("SELECT COUNT(*) FROM releases WHERE deleted_at IS NULL").Scan(&newReleaseCount), IsNil)
+	c.Assert(newReleaseCount, Equals, releaseCount)
+
+	// an app without a release gets a release with just the env
+	empty := s.createTestApp(c, &ct.App{Name: "set-app-env-empty"})
+	res, err = s.c.SetAppEnv(empty.ID, &ct.AppEnvUpdate{Set: map[string]string{"A": "1"}})
+	c.Assert(err, IsNil)
+	c.Assert(res.Release.ID, Not(Equals), "")
+	c.Assert(res.Release.Env, DeepEquals, map[string]string{"A": "1"})
+
+	// invalid updates should be rejected
+	for _, update := range []*ct.AppEnvUpdate{
+		{},
+		{Deploy: true},
+		{Set: map[string]string{"": "1"}},
+		{Set: map[string]string{"A": "2"}, Unset: []string{"A"}},
+	} {
+		_, err = s.c.SetAppEnv(app.ID, update)
+		c.Assert(hh.IsValidationError(err), Equals, true)
+	}
+}
+
+func (s *S) TestCreateDockerArtifact(c *C) {
+	digest := "sha256:c3ab8ff13720e8ad9047dd39466b3c8974e592c2fa383d4a3960714caef0c4f2"
+	registry := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if req.Method != "HEAD" || req.URL.Path != "/v2/test/image/manifests/v1" {
+			w.WriteHeader(404)
+			return
+		}
+		w.Header().Set("Docker-Content-Digest", digest)
+		w.WriteHeader(200)
+	}))
+	defer registry.Close()
+
+	// the tag should be resolved to a digest
+	artifact := &ct.Artifact{URI: registry.URL + "?name=test/image&id=v1"}
+	c.Assert(s.c.CreateDockerArtifact(artifact), IsNil)
+	c.Assert(artifact.Type, Equals, host.ArtifactTypeDocker)
+	c.Assert(artifact.URI, Equals, registry.URL+"?id="+url.QueryEscape(digest)+"&name=test%2Fimage")
+	c.Assert(artifact.Meta["docker.tag"], Equals, "v1")
+	gotArtifact, err := s.c.GetArtifact(artifact.ID)
+	c.Assert(err, IsNil)
+	c.Assert(gotArtifact.URI, Equals, artifact.URI)
+
+	// a URI which already references a digest should not be changed
+	pinned := &ct.Artifact{URI: registry.URL + "?name=test/other&id=" + digest}
+	uri := pinned.URI
+	c.Assert(s.c.CreateDockerArtifact(pinned), IsNil)
+	c.Assert(pinned.URI, Equals, uri)
+
+	// an unknown tag should be a validation error
+	err = s.c.CreateDockerArtifact(&ct.Artifact{URI: registry.URL + "?name=test/image&id=unknown"})
+	c.Assert(hh.IsValidationError(err), Equals, true)
+
+	// an unreachable registry should be reported as unavailable
+	unreachable := httptest.NewServer(http.NotFoundHandler())
+	unreachable.Close()
+	err = s.c.CreateDockerArtifact(&ct.Artifact{URI: unreachable.URL + "?name=test/image&id=v1"})
+	c.Assert(err, NotNil)
+	e, ok := err.(hh.JSONError)
+	c.Assert(ok, Equals, true)
+	c.Assert(e.Code, Equals, hh.ServiceUnavailableErrorCode)
+}
+
+func (s *S) TestClusterStats(c *C) {
+	before, err := s.c.GetClusterStats()
+	c.Assert(err, IsNil)
+
+	// seed two apps, one of which is deployed with a running job, and a
+	// provider
+	app := s.createTestApp(c, &ct.App{Name: "cluster-stats"})
+	s.createTestApp(c, &ct.App{Name: "cluster-stats-other"})
+	release := s.createTestRelease(c, &ct.Release{})
+	_, err = s.c.CreateDeployment(app.ID, release.ID)
+	c.Assert(err, IsNil)
+	s.createTestJob(c, &ct.Job{UUID: random.UUID(), AppID: app.ID, ReleaseID: release.ID, Type: "web", State: ct.JobStateUp})
+	s.createTestJob(c, &ct.Job{UUID: random.UUID(), AppID: app.ID, ReleaseID: release.ID, Type: "web", State: ct.JobStateDown})
+	s.createTestProvider(c, &ct.Provider{URL: "https://cluster-stats.example.com", Name: "cluster-stats"})
+
+	after, err := s.c.GetClusterStats()
+	c.Assert(err, IsNil)
+	c.Assert(after.AppCount-before.AppCount, Equals, int64(2))
+	c.Assert(after.RunningJobCount-before.RunningJobCount, Equals, int64(1))
+	c.Assert(after.DeploymentCount24h-before.DeploymentCount24h, Equals, int64(1))
+	c.Assert(after.ProviderCount-before.ProviderCount, Equals, int64(1))
+}
+
+func (s *S) TestReleaseSize(c *C) {
+	size := func(n int64) *int64 { return &n }
+	image := s.createTestArtifact(c, &ct.Artifact{Type: host.ArtifactTypeDocker, URI: "http://example.com/release-size-image", Size: size(1000)})
+	slug := s.createTestArtifact(c, &ct.Artifact{Type: host.ArtifactTypeFile, URI: "http://example.com/release-size-slug.tgz", Size: size(234)})
+	unknown := s.createTestArtifact(c, &ct.Artifact{Type: host.ArtifactTypeFile, URI: "http://example.com/release-size-unknown.tgz"})
+
+	gotArtifact, err := s.c.GetArtifact(slug.ID)
+	c.Assert(err, IsNil)
+	c.Assert(gotArtifact.Size, NotNil)
+	c.Assert(*gotArtifact.Size, Equals, int64(234))
+
+	// the total size is the sum of the artifact sizes
+	release := s.createTestRelease(c, &ct.Release{ArtifactIDs: []string{image.ID, slug.ID}})
+	total, err := s.c.GetReleaseSize(release.ID)
+	c.Assert(err, IsNil)
+	c.Assert(total, NotNil)
+	c.Assert(*total, Equals, int64(1234))
+
+	// the total size is unknown if any artifact size is unknown
+	release = s.createTestRelease(c, &ct.Release{ArtifactIDs: []string{image.ID, slug.ID, unknown.ID}})
+	total, err = s.c.GetReleaseSize(release.ID)
+	c.Assert(err, IsNil)
+	c.Assert(total, IsNil)
+}
+
+func (s *S) TestReviseRelease(c *C) {
+	base := s.createTestRelease(c, &ct.Release{
+		Env:       map[string]string{"FOO": "bar"},
+		Meta:      map[string]string{"git": "true"},
+		Processes: map[string]ct.ProcessType{"web": {Args: []string{"start", "web"}}},
+	})
+	image := s.createTestArtifact(c, &ct.Artifact{Type: host.ArtifactTypeDocker, URI: "http://example.com/revise-release-image"})
+	slug := s.createTestArtifact(c, &ct.Artifact{Type: host.ArtifactTypeFile, URI: "http://example.com/revise-release-slug.tgz"})
+
+	revised, err := s.c.ReviseRelease(base.ID, []string{image.ID, slug.ID})
+	c.Assert(err, IsNil)
+	c.Assert(revised.ID, Not(Equals), base.ID)
+	c.Assert(revised.ArtifactIDs, DeepEquals, []string{image.ID, slug.ID})
+
+	// the new release only differs from the base in its artifacts
+	gotBase, err := s.c.GetRelease(base.ID)
+	c.Assert(err, IsNil)
+	gotRevised, err := s.c.GetRelease(revised.ID)
+	c.Assert(err, IsNil)
+	c.Assert(gotRevised.ArtifactIDs, DeepEquals, []string{image.ID, slug.ID})
+	c.Assert(gotRevised.LegacyArtifactID, Equals, image.ID)
+	gotRevised.ID = gotBase.ID
+	gotRevised.CreatedAt = gotBase.CreatedAt
+	gotRevised.ArtifactIDs = gotBase.ArtifactIDs
+	gotRevised.LegacyArtifactID = gotBase.LegacyArtifactID
+	c.Assert(gotRevised, DeepEquals, gotBase)
+
+	// the artifacts must be set and exist
+	_, err = s.c.ReviseRelease(base.ID, nil)
+	c.Assert(hh.IsValidationError(err), Equals, true)
+	_, err = s.c.ReviseRelease(base.ID, []string{random.UUID()})
+	c.Assert(hh.IsValidationError(err), Equals, true)
+
+	// the base release must exist
+	_, err = s.c.ReviseRelease(random.UUID(), []string{image.ID})
+	c.Assert(hh.IsValidationError(err), Equals, true)
+}
+
+func (s *S) TestReleaseInUse(c *C) {
+	app := s.createTestApp(c, &ct.App{Name: "release-in-use"})
+	release := s.createTestRelease(c, &ct.Release{})
+	s.createTestFormation(c, &ct.Formation{AppID: app.ID, ReleaseID: release.ID})
+
+	// a release which is not an app's current release is not in use,
+	// even if it has a formation
+	inUse, err := s.c.GetReleaseInUse(release.ID)
+	c.Assert(err, IsNil)
+	c.Assert(inUse, Equals, false)
+
+	s.setAppRelease(c, app.ID, release.ID)
+	inUse, err = s.c.GetReleaseInUse(release.ID)
+	c.Assert(err, IsNil)
+	c.Assert(inUse, Equals, true)
+
+	// the previous release is no longer in use once replaced
+	next := s.createTestRelease(c, &ct.Release{})
+	s.setAppRelease(c, app.ID, next.ID)
+	inUse, err = s.c.GetReleaseInUse(release.ID)
+	c.Assert(err, IsNil)
+	c.Assert(inUse, Equals, false)
+
+	_, err = s.c.GetReleaseInUse(random.UUID())
+	c.Assert(err, Equals, controller.ErrNotFound)
+}
+
+func (s *S) TestByteSize(c *C) {
+	for _, t := range []struct {
+		size     int64
+		expected string
+	}{
+		{0, "0 B"},
+		{1023, "1023 B"},
+		{1024, "1.0 KiB"},
+		{1536, "1.5 KiB"},
+		{1024*1024 - 1, "1.0 MiB"},
+		{1024 * 1024, "1.0 MiB"},
+		{1024*1024*1024 - 1, "1.0 GiB"},
+		{1288490189, "1.2 GiB"},
+		{1024 * 1024 * 1024 * 1024, "1.0 TiB"},
+	} {
+		c.Assert(ct.ByteSize(t.size).String(), Equals, t.expected, Commentf("size = %d", t.size))
+	}
+
+	size := int64(1536)
+	artifact := s.createTestArtifact(c, &ct.Artifact{Size: &size})
+	gotArtifact, err := s.c.GetArtifact(artifact.ID)
+	c.Assert(err, IsNil)
+	c.Assert(gotArtifact.SizeHuman, Equals, "1.5 KiB")
+}
+
+func (s *S) TestImportRelease(c *C) {
+	existing := s.createTestArtifact(c, &ct.Artifact{})
+	inline := &ct.Artifact{
+		ID:   random.UUID(),
+		Type: host.ArtifactTypeFile,
+		URI:  "http://example.com/import-release-slug.tgz",
+	}
+	createdAt := time.Date(2016, 1, 2, 3, 4, 5, 0, time.UTC)
+	release := &ct.Release{
+		ID:          random.UUID(),
+		ArtifactIDs: []string{existing.ID, inline.ID},
+		Env:         map[string]string{"FOO": "bar"},
+		Meta:        map[string]string{"git": "true"},
+		Processes: map[string]ct.ProcessType{
+			"web": {Args: []string{"start", "web"}},
+		},
+		CreatedAt: &createdAt,
+	}
+	id := release.ID
+
+	// a release referencing an artifact which is neither imported nor
+	// included should be rejected
+	err := s.c.ImportRelease(release, nil)
+	c.Assert(err, NotNil)
+	c.Assert(hh.IsValidationError(err), Equals, true)
+
+	c.Assert(s.c.ImportRelease(release, []*ct.Artifact{inline}), IsNil)
+	c.Assert(release.ID, Equals, id)
+
+	gotArtifact, err := s.c.GetArtifact(inline.ID)
+	c.Assert(err, IsNil)
+	c.Assert(gotArtifact.URI, Equals, inline.URI)
+
+	gotRelease, err := s.c.GetRelease(id)
+	c.Assert(err, IsNil)
+	c.Assert(gotRelease.ID, Equals, id)
+	c.Assert(gotRelease.ArtifactIDs, DeepEquals, []string{existing.ID, inline.ID})
+	c.Assert(gotRelease.Env, DeepEquals, release.Env)
+	c.Assert(gotRelease.Meta, DeepEquals, release.Meta)
+	c.Assert(gotRelease.Processes["web"].Args, DeepEquals, []string{"start", "web"})
+	c.Assert(gotRelease.CreatedAt.Equal(createdAt), Equals, true)
+
+	// importing the same release again should fail without importing
+	// the included artifacts
+	other := &ct.Artifact{
+		ID:   random.UUID(),
+		Type: host.ArtifactTypeFile,
+		URI:  "http://example.com/import-release-other-slug.tgz",
+	}
+	err = s.c.ImportRelease(&ct.Release{ID: id, ArtifactIDs: []string{existing.ID, other.ID}}, []*ct.Artifact{other})
+	c.Assert(err, NotNil)
+	c.Assert(hh.IsObjectExistsError(err), Equals, true)
+	_, err = s.c.GetArtifact(other.ID)
+	c.Assert(err, Equals, controller.ErrNotFound)
+
+	// invalid artifacts should be rejected
+	err = s.c.ImportRelease(&ct.Release{ID: random.UUID()}, []*ct.Artifact{{Type: host.ArtifactTypeFile}})
+	c.Assert(hh.IsValidationError(err), Equals, true)
+}
+
+func (s *S) TestPendingRelease(c *C) {
+	app := s.createTestApp(c, &ct.App{Name: "pending-release"})
+	current := s.createTestRelease(c, &ct.Release{})
+	s.createTestFormation(c, &ct.Formation{AppID: app.ID, ReleaseID: current.ID})
+	s.setAppRelease(c, app.ID, current.ID)
+
+	// an app has no pending release by default
+	pending, err := s.c.GetPendingRelease(app.ID)
+	c.Assert(err, IsNil)
+	c.Assert(pending, IsNil)
+
+	// setting a pending release should not deploy it
+	release := s.createTestRelease(c, &ct.Release{})
+	s.createTestFormation(c, &ct.Formation{AppID: app.ID, ReleaseID: release.ID})
+	gotApp, err := s.c.SetPendingRelease(app.ID, release.ID)
+	c.Assert(err, IsNil)
+	c.Assert(gotApp.PendingReleaseID, Equals, release.ID)
+	c.Assert(gotApp.ReleaseID, Equals, current.ID)
+
+	gotApp, err = s.c.GetApp(app.Name)
+	c.Assert(err, IsNil)
+	c.Assert(gotApp.PendingReleaseID, Equals, release.ID)
+	c.Assert(gotApp.ReleaseID, Equals, current.ID)
+	pending, err = s.c.GetPendingRelease(app.ID)
+	c.Assert(err, IsNil)
+	c.Assert(pending, NotNil)
+	c.Assert(pending.ID, Equals, release.ID)
+
+	// setting an unknown release should fail
+	_, err = s.c.SetPendingRelease(app.ID, random.UUID())
+	c.Assert(hh.IsValidationError(err), Equals, true)
+
+	// as should setting a release which does not belong to the app
+	other := s.createTestApp(c, &ct.App{Name: "pending-release-other"})
+	foreign := s.createTestRelease(c, &ct.Release{})
+	s.setAppRelease(c, other.ID, foreign.ID)
+	_, err = s.c.SetPendingRelease(app.ID, foreign.ID)
+	c.Assert(hh.IsValidationError(err), Equals, true)
+	gotApp, err = s.c.GetApp(app.ID)
+	c.Assert(err, IsNil)
+	c.Assert(gotApp.PendingReleaseID, Equals, release.ID)
+
+	// the pending release should be cleared once it is released
+	s.setAppRelease(c, app.ID, release.ID)
+	gotApp, err = s.c.GetApp(app.ID)
+	c.Assert(err, IsNil)
+	c.Assert(gotApp.PendingReleaseID, Equals, "")
+
+	// the pending release can be cleared explicitly
+	_, err = s.c.SetPendingRelease(app.ID, current.ID)
+	c.Assert(err, IsNil)
+	gotApp, err = s.c.ClearPendingRelease(app.ID)
+	c.Assert(err, IsNil)
+	c.Assert(gotApp.PendingReleaseID, Equals, "")
+	pending, err = s.c.GetPendingRelease(app.ID)
+	c.Assert(err, IsNil)
+	c.Assert(pending, IsNil)
+}
+
+func (s *S) TestPauseApp(c *C) {
+	app := s.createTestApp(c, &ct.App{Name: "pause-app"})
+	release := s.createTestRelease(c, &ct.Release{
+		Processes: map[string]ct.ProcessType{"web": {}},
+	})
+	s.setAppRelease(c, app.ID, release.ID)
+	formation := s.createTestFormation(c, &ct.Formation{AppID: app.ID, ReleaseID: release.ID, Processes: map[string]int{"web": 2}})
+
+	// apps are not paused by default
+	c.Assert(app.Paused, Equals, false)
+
+	gotApp, err := s.c.PauseApp(app.ID)
+	c.Assert(err, IsNil)
+	c.Assert(gotApp.Paused, Equals, true)
+	gotApp, err = s.c.GetApp(app.Name)
+	c.Assert(err, IsNil)
+	c.Assert(gotApp.Paused, Equals, true)
+
+	// the formation is kept, but touched so the scheduler sees the change
+	expanded, err := s.c.GetExpandedFormation(app.ID, release.ID)
+	c.Assert(err, IsNil)
+	c.Assert(expanded.App.Paused, Equals, true)
+	c.Assert(expanded.Processes, DeepEquals, formation.Processes)
+	c.Assert(expanded.UpdatedAt.After(*formation.UpdatedAt), Equals, true)
+
+	gotApp, err = s.c.ResumeApp(app.ID)
+	c.Assert(err, IsNil)
+	c.Assert(gotApp.Paused, Equals, false)
+	gotApp, err = s.c.GetApp(app.ID)
+	c.Assert(err, IsNil)
+	c.Assert(gotApp.Paused, Equals, false)
+	expanded, err = s.c.GetExpandedFormation(app.ID, release.ID)
+	c.Assert(err, IsNil)
+	c.Assert(expanded.App.Paused, Equals, false)
+}
+
+func (s *S) TestAppSchedulingState(c *C) {
+	app := s.createTestApp(c, &ct.App{Name: "app-scheduling-state"})
+	c.Assert(app.Paused, Equals, false)
+	c.Assert(app.SchedulingState, Equals, ct.AppSchedulingStateActive)
+
+	assertState := func(paused bool, state string) {
+		gotApp, err := s.c.GetApp(app.ID)
+		c.Assert(err, IsNil)
+		c.Assert(gotApp.Paused, Equals, paused)
+		c.Assert(gotApp.SchedulingState, Equals, state)
+
+		apps, err := s.c.AppList()
+		c.Assert(err, IsNil)
+		var found bool
+		for _, a := range apps {
+			if a.ID == app.ID {
+				found = true
+				c.Assert(a.Paused, Equals, paused)
+				c.Assert(a.SchedulingState, Equals, state)
+			}
+		}
+		c.Assert(found, Equals, true)
+	}
+	assertState(false, ct.AppSchedulingStateActive)
+
+	// the state is read from the stored paused flag
+	c.Assert(s.hc.db.Exec("UPDATE apps SET paused = true WHERE app_id = $1", app.ID), IsNil)
+	assertState(true, ct.AppSchedulingStatePaused)
+
+	gotApp, err := s.c.ResumeApp(app.ID)
+	c.Assert(err, IsNil)
+	c.Assert(gotApp.SchedulingState, Equals, ct.AppSchedulingStateActive)
+	assertState(false, ct.AppSchedulingStateActive)
+
+	gotApp, err = s.c.PauseApp(app.ID)
+	c.Assert(err, IsNil)
+	c.Assert(gotApp.SchedulingState, Equals, ct.AppSchedulingStatePaused)
+	assertState(true, ct.AppSchedulingStatePaused)
+}
+
+func (s *S) TestSetCurrentRelease(c *C) {
+	procs := map[string]ct.ProcessType{"web": {Args: []string{"start", "web"}}}
+	app := s.createTestApp(c, &ct.App{Name: "set-current-release"})
+	oldRelease := s.createTestRelease(c, &ct.Release{Processes: procs})
+	s.createTestFormation(c, &ct.Formation{AppID: app.ID, ReleaseID: oldRelease.ID, Processes: map[string]int{"web": 2}})
+	c.Assert(s.c.SetAppRelease(app.ID, oldRelease.ID), IsNil)
+
+	// switching release should move the formation to the new release
+	newRelease := s.createTestRelease(c, &ct.Release{Processes: procs})
+	gotApp, err := s.c.SetCurrentRelease(app.ID, newRelease.ID)
+	c.Assert(err, IsNil)
+	c.Assert(gotApp.ID, Equals, app.ID)
+	c.Assert(gotApp.ReleaseID, Equals, newRelease.ID)
+	appRelease, err := s.c.GetAppRelease(app.ID)
+	c.Assert(err, IsNil)
+	c.Assert(appRelease.ID, Equals, newRelease.ID)
+	formation, err := s.c.GetFormation(app.ID, newRelease.ID)
+	c.Assert(err, IsNil)
+	c.Assert(formation.Processes, DeepEquals, map[string]int{"web": 2})
+	formation, err = s.c.GetFormation(app.ID, oldRelease.ID)
+	c.Assert(err, IsNil)
+	c.Assert(formation.Processes, DeepEquals, map[string]int{"web": 0})
+
+	// a release used by another app should be rejected
+	other := s.createTestApp(c, &ct.App{Name: "set-current-release-other"})
+	otherRelease := s.createTestRelease(c, &ct.Release{Processes: procs})
+	s.createTestFormation(c, &ct.Formation{AppID: other.ID, ReleaseID: otherRelease.ID})
+	_, err = s.c.SetCurrentRelease(app.ID, otherRelease.ID)
+	c.Assert(err, NotNil)
+	c.Assert(hh.IsValidationError(err), Equals, true)
+	appRelease, err = s.c.GetAppRelease(app.ID)
+	c.Assert(err, IsNil)
+	c.Assert(appRelease.ID, Equals, newRelease.ID)
+}
+
+func (s *S) TestReleaseDefaultProcesses(c *C) {
+	release := s.createTestRelease(c, &ct.Release{
+		Processes: map[string]ct.ProcessType{"web": {}, "worker": {}, "clock": {}},
+	})
+	procs, err := s.c.GetReleaseDefaultProcesses(release.ID)
+	c.Assert(err, IsNil)
+	c.Assert(procs, DeepEquals, map[string]int{"web": 1, "worker": 0, "clock": 0})
+
+	release = s.createTestRelease(c, &ct.Release{})
+	procs, err = s.c.GetReleaseDefaultProcesses(release.ID)
+	c.Assert(err, IsNil)
+	c.Assert(procs, DeepEquals, map[string]int{})
+}
+
 func (s *S) TestCreateFormation(c *C) {
 	for i, useName := range []bool{false, true} {
 		release := s.createTestRelease(c, &ct.Release{
@@ -373,6 +1365,25 @@ func (s *S) TestAppList(c *C) {
 	c.Assert(list[0].ID, Not(Equals), "")
 }
 
+func (s *S) TestAppListIDs(c *C) {
+	app1 := s.createTestApp(c, &ct.App{Name: "list-ids-test1"})
+	app2 := s.createTestApp(c, &ct.App{Name: "list-ids-test2"})
+
+	// apps should be returned in the requested order, with nil entries
+	// for missing apps
+	missing := random.UUID()
+	list, err := s.c.AppListIDs([]string{app2.ID, missing, app1.ID, "not-an-id"})
+	c.Assert(err, IsNil)
+	c.Assert(list, HasLen, 4)
+	c.Assert(list[0], NotNil)
+	c.Assert(list[0].ID, Equals, app2.ID)
+	c.Assert(list[0].Name, Equals, app2.Name)
+	c.Assert(list[1], IsNil)
+	c.Assert(list[2], NotNil)
+	c.Assert(list[2].ID, Equals, app1.ID)
+	c.Assert(list[3], IsNil)
+}
+
 func (s *S) TestReleaseList(c *C) {
 	s.createTestRelease(c, &ct.Release{})
 
@@ -479,6 +1490,60 @@ func (s *S) TestAppReleaseList(c *C) {
 	c.Assert(list[1], DeepEquals, releases[0])
 }
 
+func (s *S) TestDeletedReleaseList(c *C) {
+	app := s.createTestApp(c, &ct.App{Name: "deleted-release-list"})
+
+	// create 3 releases with formations
+	releases := make([]*ct.Release, 3)
+	for i := 0; i < 3; i++ {
+		releases[i] = s.createTestRelease(c, &ct.Release{})
+		s.createTestFormation(c, &ct.Formation{ReleaseID: releases[i].ID, AppID: app.ID})
+	}
+
+	// delete the first two releases
+	for _, release := range releases[:2] {
+		_, err := s.c.DeleteRelease(app.ID, release.ID)
+		c.Assert(err, IsNil)
+	}
+
+	// delete a release of a different app
+	other := s.createTestApp(c, &ct.App{})
+	otherRelease := s.createTestRelease(c, &ct.Release{})
+	s.createTestFormation(c, &ct.Formation{ReleaseID: otherRelease.ID, AppID: other.ID})
+	_, err := s.c.DeleteRelease(other.ID, otherRelease.ID)
+	c.Assert(err, IsNil)
+
+	// check only the app's deleted releases are returned, most recently
+	// deleted first
+	list, err := s.c.DeletedReleaseList(app.ID)
+	c.Assert(err, IsNil)
+	c.Assert(list, HasLen, 2)
+	for i, release := range []*ct.Release{releases[1], releases[0]} {
+		c.Assert(list[i].ID, Equals, release.ID)
+		c.Assert(list[i].ArtifactIDs, DeepEquals, release.ArtifactIDs)
+		c.Assert(list[i].DeletedAt, NotNil)
+	}
+	c.Assert(list[0].DeletedAt.Before(*list[1].DeletedAt), Equals, false)
+
+	// check listing all deleted releases includes the other app's release
+	// but not the live release
+	list, err = s.c.DeletedReleaseList("")
+	c.Assert(err, IsNil)
+	ids := make(map[string]struct{}, len(list))
+	for _, release := range list {
+		ids[release.ID] = struct{}{}
+	}
+	for _, release := range []*ct.Release{releases[0], releases[1], otherRelease} {
+		_, ok := ids[release.ID]
+		c.Assert(ok, Equals, true)
+	}
+	_, ok := ids[releases[2].ID]
+	c.Assert(ok, Equals, false)
+
+	_, err = s.c.DeletedReleaseList("invalid")
+	c.Assert(hh.IsValidationError(err), Equals, true)
+}
+
 func (s *S) TestArtifactList(c *C) {
 	s.createTestArtifact(c, &ct.Artifact{})
 
@@ -489,6 +1554,67 @@ func (s *S) TestArtifactList(c *C) {
 	c.Assert(list[0].ID, Not(Equals), "")
 }
 
+func (s *S) TestArtifactListInUse(c *C) {
+	unused := s.createTestArtifact(c, &ct.Artifact{})
+	used := s.createTestArtifact(c, &ct.Artifact{})
+	s.createTestRelease(c, &ct.Release{ArtifactIDs: []string{used.ID}})
+
+	contains := func(list []*ct.Artifact, id string) bool {
+		for _, a := range list {
+			if a.ID == id {
+				return true
+			}
+		}
+		return false
+	}
+
+	list, err := s.c.ArtifactListInUse(true)
+	c.Assert(err, IsNil)
+	c.Assert(contains(list, used.ID), Equals, true)
+	c.Assert(contains(list, unused.ID), Equals, false)
+
+	list, err = s.c.ArtifactListInUse(false)
+	c.Assert(err, IsNil)
+	c.Assert(contains(list, used.ID), Equals, false)
+	c.Assert(contains(list, unused.ID), Equals, true)
+
+	// the unfiltered list includes both
+	list, err = s.c.ArtifactList()
+	c.Assert(err, IsNil)
+	c.Assert(contains(list, used.ID), Equals, true)
+	c.Assert(contains(list, unused.ID), Equals, true)
+}
+
+func (s *S) TestDeleteArtifact(c *C) {
+	// an unused artifact should be deleted
+	unused := s.createTestArtifact(c, &ct.Artifact{})
+	deleted, err := s.c.DeleteArtifact(unused.ID)
+	c.Assert(err, IsNil)
+	c.Assert(deleted.ID, Equals, unused.ID)
+	c.Assert(deleted.URI, Equals, unused.URI)
+	_, err = s.c.GetArtifact(unused.ID)
+	c.Assert(err, Equals, controller.ErrNotFound)
+	_, err = s.c.DeleteArtifact(unused.ID)
+	c.Assert(err, Equals, controller.ErrNotFound)
+
+	// an artifact referenced by releases should not be deleted
+	used := s.createTestArtifact(c, &ct.Artifact{})
+	release1 := s.createTestRelease(c, &ct.Release{ArtifactIDs: []string{used.ID}})
+	release2 := s.createTestRelease(c, &ct.Release{ArtifactIDs: []string{used.ID}})
+	_, err = s.c.DeleteArtifact(used.ID)
+	c.Assert(err, NotNil)
+	jsonErr, ok := err.(hh.JSONError)
+	c.Assert(ok, Equals, true)
+	c.Assert(jsonErr.Code, Equals, hh.ConflictErrorCode)
+	var detail struct {
+		Releases []string `json:"releases"`
+	}
+	c.Assert(json.Unmarshal(jsonErr.Detail, &detail), IsNil)
+	c.Assert(detail.Releases, DeepEquals, []string{release2.ID, release1.ID})
+	_, err = s.c.GetArtifact(used.ID)
+	c.Assert(err, IsNil)
+}
+
 func (s *S) TestFormationList(c *C) {
 	release := s.createTestRelease(c, &ct.Release{})
 	app := s.createTestApp(c, &ct.App{Name: "formation-list"})
@@ -532,6 +1658,45 @@ func (s *S) TestSetAppRelease(c *C) {
 	c.Assert(formations, HasLen, 0)
 }
 
+func (s *S) TestGetPreviousRelease(c *C) {
+	app := s.createTestApp(c, &ct.App{Name: "previous-release"})
+	releases := make([]*ct.Release, 3)
+	for i := range releases {
+		releases[i] = s.createTestRelease(c, &ct.Release{})
+		s.setAppRelease(c, app.ID, releases[i].ID)
+	}
+
+	// walking back from the current release should visit each release in
+	// turn, ending with null for the first
+	release := releases[2]
+	for i := 1; i >= 0; i-- {
+		prev, err := s.c.GetPreviousRelease(app.ID, release.ID)
+		c.Assert(err, IsNil)
+		c.Assert(prev, NotNil)
+		c.Assert(prev.ID, Equals, releases[i].ID)
+		release = prev
+	}
+	prev, err := s.c.GetPreviousRelease(app.ID, release.ID)
+	c.Assert(err, IsNil)
+	c.Assert(prev, IsNil)
+
+	// rolling back to a release should make its previous release the one it
+	// replaced
+	s.setAppRelease(c, app.ID, releases[0].ID)
+	prev, err = s.c.GetPreviousRelease(app.ID, releases[0].ID)
+	c.Assert(err, IsNil)
+	c.Assert(prev, NotNil)
+	c.Assert(prev.ID, Equals, releases[2].ID)
+
+	// a release which has never been current for the app is not found
+	other := s.createTestRelease(c, &ct.Release{})
+	_, err = s.c.GetPreviousRelease(app.ID, other.ID)
+	c.Assert(err, Equals, controller.ErrNotFound)
+	otherApp := s.createTestApp(c, &ct.App{Name: "previous-release-other"})
+	_, err = s.c.GetPreviousRelease(otherApp.ID, releases[1].ID)
+	c.Assert(err, Equals, controller.ErrNotFound)
+}
+
 func (s *S) createTestProvider(c *C, provider *ct.Provider) *ct.Provider {
 	c.Assert(s.c.CreateProvider(provider), IsNil)
 	return provider
@@ -555,6 +1720,44 @@ func (s *S) TestCreateProvider(c *C) {
 	c.Assert(err, Equals, controller.ErrNotFound)
 }
 
+func (s *S) TestUpdateProvider(c *C) {
+	provider := s.createTestProvider(c, &ct.Provider{URL: "https://update-provider.example.com", Name: "update-provider"})
+	resource := &ct.Resource{ID: random.UUID(), ProviderID: provider.ID, ExternalID: "/things/update-provider"}
+	c.Assert(s.c.PutResource(resource), IsNil)
+
+	// updating just the URL should keep the name
+	c.Assert(s.c.UpdateProvider(&ct.Provider{ID: provider.ID, URL: "https://moved.example.com"}), IsNil)
+	gotProvider, err := s.c.GetProvider(provider.ID)
+	c.Assert(err, IsNil)
+	c.Assert(gotProvider.URL, Equals, "https://moved.example.com")
+	c.Assert(gotProvider.Name, Equals, "update-provider")
+
+	// both the name and URL can be changed
+	c.Assert(s.c.UpdateProvider(&ct.Provider{ID: provider.ID, Name: "update-provider-renamed", URL: "http://moved.example.com:8080/things"}), IsNil)
+	gotProvider, err = s.c.GetProvider("update-provider-renamed")
+	c.Assert(err, IsNil)
+	c.Assert(gotProvider.ID, Equals, provider.ID)
+	c.Assert(gotProvider.URL, Equals, "http://moved.example.com:8080/things")
+
+	// existing resources should still belong to the provider
+	resources, err := s.c.ResourceList(provider.ID)
+	c.Assert(err, IsNil)
+	c.Assert(resources, HasLen, 1)
+	c.Assert(resources[0].ID, Equals, resource.ID)
+}
+
+func (s *S) TestUpdateProviderInvalidURL(c *C) {
+	provider := s.createTestProvider(c, &ct.Provider{URL: "https://update-provider-invalid.example.com", Name: "update-provider-invalid"})
+	for _, u := range []string{"", "not a url", "ftp://example.com", "http://"} {
+		err := s.c.UpdateProvider(&ct.Provider{ID: provider.ID, URL: u})
+		c.Assert(err, NotNil)
+		c.Assert(hh.IsValidationError(err), Equals, true)
+	}
+	gotProvider, err := s.c.GetProvider(provider.ID)
+	c.Assert(err, IsNil)
+	c.Assert(gotProvider.URL, Equals, provider.URL)
+}
+
 func (s *S) TestProviderList(c *C) {
 	s.createTestProvider(c, &ct.Provider{URL: "https://example.org", Name: "list-test"})
 
@@ -587,3 +1790,93 @@ func (s *S) TestGetCACertWithoutAuth(c *C) {
 	c.Assert(err, IsNil)
 	c.Assert(cert, DeepEquals, s.caCert)
 }
+
+func (s *S) TestResolveApp(c *C) {
+	app := s.createTestApp(c, &ct.App{Name: "resolve-app"})
+	api := &controllerAPI{appRepo: NewAppRepo(s.hc.db, "", s.hc.rc)}
+
+	// lookup by ID, including an upper case ID with surrounding whitespace
+	for _, id := range []string{app.ID, " " + strings.ToUpper(app.ID) + " "} {
+		gotApp, err := api.resolveApp(id)
+		c.Assert(err, IsNil)
+		c.Assert(gotApp.ID, Equals, app.ID)
+	}
+
+	// lookup by name
+	gotApp, err := api.resolveApp(app.Name)
+	c.Assert(err, IsNil)
+	c.Assert(gotApp.ID, Equals, app.ID)
+
+	// a missing ID and a missing name have distinct errors
+	missingID := random.UUID()
+	_, err = api.resolveApp(missingID)
+	c.Assert(err, DeepEquals, hh.JSONError{
+		Code:    hh.ObjectNotFoundErrorCode,
+		Message: fmt.Sprintf("app with ID %q not found", missingID),
+	})
+	_, err = api.resolveApp("resolve-app-missing")
+	c.Assert(err, DeepEquals, hh.JSONError{
+		Code:    hh.ObjectNotFoundErrorCode,
+		Message: `app with name "resolve-app-missing" not found`,
+	})
+
+	// a blank string is invalid
+	_, err = api.resolveApp(" ")
+	c.Assert(err, FitsTypeOf, ct.ValidationError{})
+}
+
+func (s *S) TestGzipResponse(c *C) {
+	s.createTestApp(c, &ct.App{Name: "gzip-response"})
+
+	req, err := http.NewRequest("GET", s.srv.URL+"/apps/gzip-response", nil)
+	c.Assert(err, IsNil)
+	req.SetBasicAuth("", authKey)
+	req.Header.Set("Accept-Encoding", "gzip")
+	res, err := http.DefaultTransport.RoundTrip(req)
+	c.Assert(err, IsNil)
+	defer res.Body.Close()
+	c.Assert(res.StatusCode, Equals, 200)
+	c.Assert(res.Header.Get("Content-Encoding"), Equals, "gzip")
+
+	gz, err := gzip.NewReader(res.Body)
+	c.Assert(err, IsNil)
+	var app ct.App
+	c.Assert(json.NewDecoder(gz).Decode(&app), IsNil)
+	c.Assert(app.Name, Equals, "gzip-response")
+
+	// responses are not compressed unless requested
+	req.Header.Del("Accept-Encoding")
+	res, err = http.DefaultTransport.RoundTrip(req)
+	c.Assert(err, IsNil)
+	defer res.Body.Close()
+	c.Assert(res.Header.Get("Content-Encoding"), Equals, "")
+	c.Assert(json.NewDecoder(res.Body).Decode(&app), IsNil)
+	c.Assert(app.Name, Equals, "gzip-response")
+}
+
+func (s *S) TestPrettyResponse(c *C) {
+	s.createTestApp(c, &ct.App{Name: "pretty-response"})
+
+	get := func(query string) []byte {
+		req, err := http.NewRequest("GET", s.srv.URL+"/apps/pretty-response"+query, nil)
+		c.Assert(err, IsNil)
+		req.SetBasicAuth("", authKey)
+		res, err := http.DefaultClient.Do(req)
+		c.Assert(err, IsNil)
+		defer res.Body.Close()
+		c.Assert(res.StatusCode, Equals, 200)
+		data, err := ioutil.ReadAll(res.Body)
+		c.Assert(err, IsNil)
+		return data
+	}
+
+	compact := get("")
+	c.Assert(bytes.Contains(compact, []byte("\n  ")), Equals, false)
+
+	pretty := get("?pretty=true")
+	c.Assert(bytes.Contains(pretty, []byte("\n  \"name\": \"pretty-response\"")), Equals, true)
+
+	var app ct.App
+	c.Assert(json.Unmarshal(pretty, &app), IsNil)
+	c.Assert(app.Name, Equals, "pretty-response")
+}
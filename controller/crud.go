@@ -2,9 +2,11 @@ package main
 
 import (
 	"net/http"
+	"net/url"
 	"reflect"
 
 	"github.com/flynn/flynn/controller/schema"
+	ct "github.com/flynn/flynn/controller/types"
 	"github.com/flynn/flynn/pkg/ctxhelper"
 	"github.com/flynn/flynn/pkg/httphelper"
 	"github.com/julienschmidt/httprouter"
@@ -21,6 +23,19 @@ type Remover interface {
 	Remove(string) error
 }
 
+// Validator is implemented by repositories which validate things beyond their
+// schema, so that both schema and repository validation errors can be
+// reported together.
+type Validator interface {
+	Validate(thing interface{}) error
+}
+
+// QueryLister is implemented by repositories which support filtering the
+// list of things by query parameters.
+type QueryLister interface {
+	ListQuery(query url.Values) (interface{}, error)
+}
+
 func crud(r *httprouter.Router, resource string, example interface{}, repo Repository) {
 	resourceType := reflect.TypeOf(example)
 	prefix := "/" + resource
@@ -32,7 +47,7 @@ func crud(r *httprouter.Router, resource string, example interface{}, repo Repos
 			return
 		}
 
-		if err := schema.Validate(thing); err != nil {
+		if err := validate(repo, thing); err != nil {
 			respondWithError(rw, err)
 			return
 		}
@@ -59,8 +74,14 @@ func crud(r *httprouter.Router, resource string, example interface{}, repo Repos
 		httphelper.JSON(rw, 200, thing)
 	}))
 
-	r.GET(prefix, httphelper.WrapHandler(func(ctx context.Context, rw http.ResponseWriter, _ *http.Request) {
-		list, err := repo.List()
+	r.GET(prefix, httphelper.WrapHandler(func(ctx context.Context, rw http.ResponseWriter, req *http.Request) {
+		var list interface{}
+		var err error
+		if lister, ok := repo.(QueryLister); ok {
+			list, err = lister.ListQuery(req.URL.Query())
+		} else {
+			list, err = repo.List()
+		}
 		if err != nil {
 			respondWithError(rw, err)
 			return
@@ -84,3 +105,31 @@ func crud(r *httprouter.Router, resource string, example interface{}, repo Repos
 		}))
 	}
 }
+
+// validate validates thing against its schema and, if the repository
+// implements Validator, the repository's own checks, returning all of the
+// validation errors found.
+func validate(repo Repository, thing interface{}) error {
+	var errs ct.ValidationErrors
+	appendErr := func(err error) error {
+		switch v := err.(type) {
+		case nil:
+		case ct.ValidationError:
+			errs = append(errs, v)
+		case ct.ValidationErrors:
+			errs = append(errs, v...)
+		default:
+			return err
+		}
+		return nil
+	}
+	if err := appendErr(schema.Validate(thing)); err != nil {
+		return err
+	}
+	if v, ok := repo.(Validator); ok {
+		if err := appendErr(v.Validate(thing)); err != nil {
+			return err
+		}
+	}
+	return errs.Err()
+}
@@ -4,6 +4,7 @@ import (
 	"encoding/json"
 	"fmt"
 	"net/http"
+	"strings"
 	"time"
 
 	"github.com/flynn/flynn/controller/schema"
@@ -88,13 +89,39 @@ func (r *DeploymentRepo) Add(data interface{}) (*ct.Deployment, error) {
 	return d, err
 }
 
+// InProgress returns whether the app has a deployment which has not yet
+// finished, in which case no other deployment can be created for it.
+func (r *DeploymentRepo) InProgress(appID string) (bool, error) {
+	var inProgress bool
+	err := r.db.QueryRow("deployment_select_in_progress", appID).Scan(&inProgress)
+	return inProgress, err
+}
+
+// CountInProgress returns the number of deployments across all apps which
+// have not yet finished.
+func (r *DeploymentRepo) CountInProgress() (int, error) {
+	var count int64
+	err := r.db.QueryRow("deployment_count_in_progress").Scan(&count)
+	return int(count), err
+}
+
 func (r *DeploymentRepo) Get(id string) (*ct.Deployment, error) {
 	row := r.db.QueryRow("deployment_select", id)
 	return scanDeployment(row)
 }
 
 func (r *DeploymentRepo) List(appID string) ([]*ct.Deployment, error) {
-	rows, err := r.db.Query("deployment_list", appID)
+	return r.list("deployment_list", appID)
+}
+
+// ReleaseList returns the deployments which deployed the given release,
+// most recent first.
+func (r *DeploymentRepo) ReleaseList(releaseID string) ([]*ct.Deployment, error) {
+	return r.list("deployment_list_by_release", releaseID)
+}
+
+func (r *DeploymentRepo) list(query string, args ...interface{}) ([]*ct.Deployment, error) {
+	rows, err := r.db.Query(query, args...)
 	if err != nil {
 		return nil, err
 	}
@@ -110,6 +137,13 @@ func (r *DeploymentRepo) List(appID string) ([]*ct.Deployment, error) {
 	return deployments, rows.Err()
 }
 
+// DeployTimes returns the creation times of the first and most recent
+// deployments of the given app.
+func (r *DeploymentRepo) DeployTimes(appID string) (*ct.AppDeployTimes, error) {
+	times := &ct.AppDeployTimes{}
+	return times, r.db.QueryRow("deployment_times_by_app", appID).Scan(&times.FirstDeployedAt, &times.LastDeployedAt)
+}
+
 func scanDeployment(s postgres.Scanner) (*ct.Deployment, error) {
 	d := &ct.Deployment{}
 	var oldReleaseID *string
@@ -137,65 +171,366 @@ func (c *controllerAPI) GetDeployment(ctx context.Context, w http.ResponseWriter
 	httphelper.JSON(w, 200, deployment)
 }
 
+// GetDeploymentProgress responds with a snapshot of the deployment's
+// progress, or streams snapshots if the client accepts an event stream.
+func (c *controllerAPI) GetDeploymentProgress(ctx context.Context, w http.ResponseWriter, req *http.Request) {
+	params, _ := ctxhelper.ParamsFromContext(ctx)
+	d, err := c.deploymentRepo.Get(params.ByName("deployment_id"))
+	if err != nil {
+		respondWithError(w, err)
+		return
+	}
+
+	if strings.Contains(req.Header.Get("Accept"), "text/event-stream") {
+		c.streamDeploymentProgress(ctx, w, d)
+		return
+	}
+
+	jobs, err := c.jobRepo.List(d.AppID)
+	if err != nil {
+		respondWithError(w, err)
+		return
+	}
+	httphelper.JSON(w, 200, newDeploymentProgress(d, jobs).Snapshot())
+}
+
+// GetDeploymentTimeline responds with the deployment's events merged with the
+// job events of its new release which were emitted while it was running,
+// oldest first, so the whole story of the deployment can be shown at once.
+func (c *controllerAPI) GetDeploymentTimeline(ctx context.Context, w http.ResponseWriter, req *http.Request) {
+	params, _ := ctxhelper.ParamsFromContext(ctx)
+	d, err := c.deploymentRepo.Get(params.ByName("deployment_id"))
+	if err != nil {
+		respondWithError(w, err)
+		return
+	}
+	deploymentEvents, err := c.eventRepo.ListDeploymentEvents(d.ID)
+	if err != nil {
+		respondWithError(w, err)
+		return
+	}
+	jobEvents, err := c.eventRepo.ListReleaseJobEvents(d.AppID, d.NewReleaseID, *d.CreatedAt, d.FinishedAt)
+	if err != nil {
+		respondWithError(w, err)
+		return
+	}
+	httphelper.JSON(w, 200, mergeEvents(deploymentEvents, jobEvents))
+}
+
+// mergeEvents merges two lists of events which are each sorted oldest first
+// into a single list sorted by creation time, using the event ID to order
+// events created at the same time.
+func mergeEvents(a, b []*ct.Event) []*ct.Event {
+	events := make([]*ct.Event, 0, len(a)+len(b))
+	for len(a) > 0 && len(b) > 0 {
+		if eventBefore(b[0], a[0]) {
+			events = append(events, b[0])
+			b = b[1:]
+		} else {
+			events = append(events, a[0])
+			a = a[1:]
+		}
+	}
+	events = append(events, a...)
+	return append(events, b...)
+}
+
+func eventBefore(a, b *ct.Event) bool {
+	if a.CreatedAt == nil || b.CreatedAt == nil || a.CreatedAt.Equal(*b.CreatedAt) {
+		return a.ID < b.ID
+	}
+	return a.CreatedAt.Before(*b.CreatedAt)
+}
+
+// streamDeploymentProgress streams a DeploymentProgress snapshot each time the
+// number of up jobs of either of the deployment's releases or the status of
+// the deployment changes, closing the stream once the deployment has either
+// completed or failed.
+func (c *controllerAPI) streamDeploymentProgress(ctx context.Context, w http.ResponseWriter, d *ct.Deployment) {
+	l, _ := ctxhelper.LoggerFromContext(ctx)
+	log := l.New("fn", "streamDeploymentProgress")
+
+	if err := c.maybeStartEventListener(); err != nil {
+		log.Error("error starting event listener", "err", err)
+		respondWithError(w, err)
+		return
+	}
+	// subscribe before loading the current jobs so no job events are
+	// missed
+	sub, err := c.eventListener.Subscribe(d.AppID, []string{string(ct.EventTypeJob), string(ct.EventTypeDeployment)}, "")
+	if err != nil {
+		respondWithError(w, err)
+		return
+	}
+	defer sub.Close()
+
+	jobs, err := c.jobRepo.List(d.AppID)
+	if err != nil {
+		respondWithError(w, err)
+		return
+	}
+
+	progress := newDeploymentProgress(d, jobs)
+	ch := make(chan *ct.DeploymentProgress)
+	stream := c.newSSEStream(w, ch, log)
+	stream.Serve()
+	defer stream.Close()
+
+	send := func() bool {
+		snapshot := progress.Snapshot()
+		select {
+		case ch <- snapshot:
+		case <-stream.Done:
+			return false
+		}
+		return !progress.Finished()
+	}
+	if !send() {
+		return
+	}
+
+	for {
+		select {
+		case <-stream.Done:
+			return
+		case event, ok := <-sub.Events:
+			if !ok {
+				stream.Error(sub.Err)
+				return
+			}
+			changed, err := progress.Update(event)
+			if err != nil {
+				log.Error("error decoding event", "event.id", event.ID, "err", err)
+				continue
+			}
+			if changed && !send() {
+				return
+			}
+		}
+	}
+}
+
+// deploymentProgress tracks the state of the jobs of a deployment's releases.
+type deploymentProgress struct {
+	deployment *ct.Deployment
+	target     int
+	status     string
+	jobs       map[string]*ct.Job
+}
+
+func newDeploymentProgress(d *ct.Deployment, jobs []*ct.Job) *deploymentProgress {
+	p := &deploymentProgress{
+		deployment: d,
+		status:     d.Status,
+		jobs:       make(map[string]*ct.Job, len(jobs)),
+	}
+	for _, n := range d.Processes {
+		p.target += n
+	}
+	for _, job := range jobs {
+		if p.relevant(job) {
+			p.jobs[job.UUID] = job
+		}
+	}
+	return p
+}
+
+func (p *deploymentProgress) relevant(job *ct.Job) bool {
+	return job.ReleaseID == p.deployment.NewReleaseID || job.ReleaseID == p.deployment.OldReleaseID
+}
+
+// Update applies the given job or deployment event, returning whether the
+// snapshot has changed as a result.
+func (p *deploymentProgress) Update(event *ct.Event) (bool, error) {
+	before := *p.Snapshot()
+	switch event.ObjectType {
+	case ct.EventTypeJob:
+		var job ct.Job
+		if err := json.Unmarshal(event.Data, &job); err != nil {
+			return false, err
+		}
+		if !p.relevant(&job) {
+			return false, nil
+		}
+		p.jobs[job.UUID] = &job
+	case ct.EventTypeDeployment:
+		if event.ObjectID != p.deployment.ID {
+			return false, nil
+		}
+		var e ct.DeploymentEvent
+		if err := json.Unmarshal(event.Data, &e); err != nil {
+			return false, err
+		}
+		if e.Status != "" {
+			p.status = e.Status
+		}
+	}
+	return *p.Snapshot() != before, nil
+}
+
+func (p *deploymentProgress) Snapshot() *ct.DeploymentProgress {
+	snapshot := &ct.DeploymentProgress{Target: p.target, Status: p.status}
+	newUp := make(map[string]int, len(p.deployment.Processes))
+	for _, job := range p.jobs {
+		if job.State != ct.JobStateUp {
+			continue
+		}
+		if job.ReleaseID == p.deployment.NewReleaseID {
+			snapshot.NewUp++
+			newUp[job.Type]++
+		} else {
+			snapshot.OldUp++
+		}
+	}
+	snapshot.Percent = p.percent(newUp)
+	return snapshot
+}
+
+// percent returns how complete the deployment is from 0 to 100 based on the
+// number of up jobs of the new release of each process type, not counting
+// jobs beyond the target for a type so that one type being over scaled does
+// not make up for another which is behind.
+func (p *deploymentProgress) percent(newUp map[string]int) int {
+	if p.status == "complete" {
+		return 100
+	}
+	if p.target == 0 {
+		return 0
+	}
+	var up int
+	for typ, n := range p.deployment.Processes {
+		if newUp[typ] < n {
+			up += newUp[typ]
+		} else {
+			up += n
+		}
+	}
+	return up * 100 / p.target
+}
+
+// Finished returns whether the deployment has either completed or failed.
+func (p *deploymentProgress) Finished() bool {
+	return p.status == "complete" || p.status == "failed"
+}
+
 func (c *controllerAPI) CreateDeployment(ctx context.Context, w http.ResponseWriter, req *http.Request) {
-	var rid releaseID
-	if err := httphelper.DecodeJSON(req, &rid); err != nil {
+	var data ct.CreateDeployment
+	if err := httphelper.DecodeJSON(req, &data); err != nil {
 		respondWithError(w, err)
 		return
 	}
 
-	rel, err := c.releaseRepo.Get(rid.ID)
+	rel, err := c.releaseRepo.Get(data.ReleaseID)
 	if err != nil {
 		if err == ErrNotFound {
 			err = ct.ValidationError{
-				Message: fmt.Sprintf("could not find release with ID %s", rid.ID),
+				Message: fmt.Sprintf("could not find release with ID %s", data.ReleaseID),
 			}
 		}
 		respondWithError(w, err)
 		return
 	}
 	release := rel.(*ct.Release)
+
+	d, err := c.createDeployment(ctx, c.getApp(ctx), release, data.Strategy)
+	if err != nil {
+		respondWithError(w, err)
+		return
+	}
+
+	httphelper.JSON(w, 200, d)
+}
+
+// RestartApp restarts all of the app's processes by deploying a copy of its
+// current release using the app's deploy strategy. The deployer tracks jobs
+// by release, so the release is copied rather than redeployed as is, which
+// would stop the jobs it had just started.
+func (c *controllerAPI) RestartApp(ctx context.Context, w http.ResponseWriter, req *http.Request) {
 	app := c.getApp(ctx)
+	current, err := c.getCurrentRelease(ctx, app.ID)
+	if err == ErrNotFound {
+		respondWithError(w, ct.ValidationError{Message: "app has no release to restart"})
+		return
+	} else if err != nil {
+		respondWithError(w, err)
+		return
+	}
+	formation, err := c.formationRepo.Get(app.ID, current.ID)
+	if err != nil && err != ErrNotFound {
+		respondWithError(w, err)
+		return
+	}
+	procCount := 0
+	if formation != nil {
+		for _, n := range formation.Processes {
+			procCount += n
+		}
+	}
+	if procCount == 0 {
+		respondWithError(w, ct.ValidationError{Message: "app has no running processes to restart"})
+		return
+	}
+
+	release := *current
+	release.ID = ""
+	release.CreatedAt = nil
+	if err := c.releaseRepo.Add(&release); err != nil {
+		respondWithError(w, err)
+		return
+	}
+	d, err := c.createDeployment(ctx, app, &release, "")
+	if err != nil {
+		c.deleteUnusedRelease(app, &release)
+		respondWithError(w, err)
+		return
+	}
+	httphelper.JSON(w, 200, d)
+}
 
+// createDeployment creates a deployment of the given release for the given
+// app, setting the app's release immediately if the app has no running
+// processes. The deployment uses the given strategy, or the app's strategy
+// if it is empty.
+func (c *controllerAPI) createDeployment(ctx context.Context, app *ct.App, release *ct.Release, strategy string) (*ct.Deployment, error) {
 	// TODO: wrap all of this in a transaction
-	oldRelease, err := c.appRepo.GetRelease(app.ID)
+	oldRelease, err := c.getCurrentRelease(ctx, app.ID)
 	if err == ErrNotFound {
 		oldRelease = &ct.Release{}
 	} else if err != nil {
-		respondWithError(w, err)
-		return
+		return nil, err
 	}
 	oldFormation, err := c.formationRepo.Get(app.ID, oldRelease.ID)
 	if err == ErrNotFound {
 		oldFormation = &ct.Formation{}
 	} else if err != nil {
-		respondWithError(w, err)
-		return
+		return nil, err
 	}
 	procCount := 0
 	for _, i := range oldFormation.Processes {
 		procCount += i
 	}
+	if strategy == "" {
+		strategy = app.Strategy
+	}
 
 	deployment := &ct.Deployment{
 		AppID:         app.ID,
 		NewReleaseID:  release.ID,
-		Strategy:      app.Strategy,
+		Strategy:      strategy,
 		OldReleaseID:  oldRelease.ID,
 		Processes:     oldFormation.Processes,
 		DeployTimeout: app.DeployTimeout,
 	}
 
 	if err := schema.Validate(deployment); err != nil {
-		respondWithError(w, err)
-		return
+		return nil, err
 	}
 	if procCount == 0 {
 		// immediately set app release
 		if err := c.appRepo.SetRelease(app, release.ID); err != nil {
-			respondWithError(w, err)
-			return
+			return nil, err
 		}
+		c.forgetCurrentRelease(ctx, app.ID)
 		now := time.Now()
 		deployment.FinishedAt = &now
 	}
@@ -203,14 +538,11 @@ func (c *controllerAPI) CreateDeployment(ctx context.Context, w http.ResponseWri
 	d, err := c.deploymentRepo.Add(deployment)
 	if err != nil {
 		if postgres.IsUniquenessError(err, "isolate_deploys") {
-			httphelper.ValidationError(w, "", "Cannot create deploy, there is already one in progress for this app.")
-			return
+			return nil, ct.ValidationError{Message: "Cannot create deploy, there is already one in progress for this app."}
 		}
-		respondWithError(w, err)
-		return
+		return nil, err
 	}
-
-	httphelper.JSON(w, 200, d)
+	return d, nil
 }
 
 func (c *controllerAPI) ListDeployments(ctx context.Context, w http.ResponseWriter, req *http.Request) {
@@ -223,6 +555,32 @@ func (c *controllerAPI) ListDeployments(ctx context.Context, w http.ResponseWrit
 	httphelper.JSON(w, 200, list)
 }
 
+// GetReleaseDeployments responds with the deployments which deployed the
+// release, most recent first.
+func (c *controllerAPI) GetReleaseDeployments(ctx context.Context, w http.ResponseWriter, req *http.Request) {
+	release, err := c.getRelease(ctx)
+	if err != nil {
+		respondWithError(w, err)
+		return
+	}
+	list, err := c.deploymentRepo.ReleaseList(release.ID)
+	if err != nil {
+		respondWithError(w, err)
+		return
+	}
+	httphelper.JSON(w, 200, list)
+}
+
+func (c *controllerAPI) GetAppDeployTimes(ctx context.Context, w http.ResponseWriter, req *http.Request) {
+	app := c.getApp(ctx)
+	times, err := c.deploymentRepo.DeployTimes(app.ID)
+	if err != nil {
+		respondWithError(w, err)
+		return
+	}
+	httphelper.JSON(w, 200, times)
+}
+
 func createDeploymentEvent(dbExec func(string, ...interface{}) error, d *ct.Deployment, status string) error {
 	e := ct.DeploymentEvent{
 		AppID:        d.AppID,
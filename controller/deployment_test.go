@@ -1,11 +1,16 @@
 package main
 
 import (
+	"encoding/json"
+	"net/http/httptest"
 	"reflect"
+	"sync"
 	"time"
 
+	"github.com/flynn/flynn/controller/client"
 	ct "github.com/flynn/flynn/controller/types"
 	hh "github.com/flynn/flynn/pkg/httphelper"
+	"github.com/flynn/flynn/pkg/random"
 	. "github.com/flynn/go-check"
 )
 
@@ -45,6 +50,180 @@ func (s *S) TestCreateDeployment(c *C) {
 	c.Assert(err.(hh.JSONError).Message, Equals, "Cannot create deploy, there is already one in progress for this app.")
 }
 
+func (s *S) TestCreateDeploymentWithStrategy(c *C) {
+	app := s.createTestApp(c, &ct.App{Name: "create-deployment-strategy", Strategy: "one-by-one"})
+	release := s.createTestRelease(c, &ct.Release{
+		Processes: map[string]ct.ProcessType{"web": {}},
+	})
+	s.setAppRelease(c, app.ID, release.ID)
+	c.Assert(s.c.PutFormation(&ct.Formation{
+		AppID:     app.ID,
+		ReleaseID: release.ID,
+		Processes: map[string]int{"web": 1},
+	}), IsNil)
+	defer s.c.DeleteFormation(app.ID, release.ID)
+
+	// an invalid strategy should be rejected
+	newRelease := s.createTestRelease(c, &ct.Release{})
+	_, err := s.c.CreateDeploymentWithStrategy(app.ID, newRelease.ID, "foo")
+	c.Assert(hh.IsValidationError(err), Equals, true)
+
+	// the given strategy should override the app's strategy
+	d, err := s.c.CreateDeploymentWithStrategy(app.ID, newRelease.ID, "all-at-once")
+	c.Assert(err, IsNil)
+	c.Assert(d.Strategy, Equals, "all-at-once")
+	gotDeployment, err := s.c.GetDeployment(d.ID)
+	c.Assert(err, IsNil)
+	c.Assert(gotDeployment.Strategy, Equals, "all-at-once")
+
+	// without changing the app's strategy
+	gotApp, err := s.c.GetApp(app.ID)
+	c.Assert(err, IsNil)
+	c.Assert(gotApp.Strategy, Equals, "one-by-one")
+}
+
+func (s *S) TestRestartApp(c *C) {
+	app := s.createTestApp(c, &ct.App{Name: "restart-app", Strategy: "one-by-one"})
+
+	// an app without a release cannot be restarted
+	_, err := s.c.RestartApp(app.ID)
+	c.Assert(hh.IsValidationError(err), Equals, true)
+
+	release := s.createTestRelease(c, &ct.Release{
+		Env:       map[string]string{"FOO": "bar"},
+		Processes: map[string]ct.ProcessType{"web": {Args: []string{"start", "web"}}},
+	})
+	s.setAppRelease(c, app.ID, release.ID)
+
+	// nor can an app without running processes
+	_, err = s.c.RestartApp(app.ID)
+	c.Assert(hh.IsValidationError(err), Equals, true)
+
+	c.Assert(s.c.PutFormation(&ct.Formation{
+		AppID:     app.ID,
+		ReleaseID: release.ID,
+		Processes: map[string]int{"web": 2},
+	}), IsNil)
+	defer s.c.DeleteFormation(app.ID, release.ID)
+
+	d, err := s.c.RestartApp(app.ID)
+	c.Assert(err, IsNil)
+	c.Assert(d.ID, Not(Equals), "")
+	c.Assert(d.AppID, Equals, app.ID)
+	c.Assert(d.OldReleaseID, Equals, release.ID)
+	c.Assert(d.Strategy, Equals, "one-by-one")
+	c.Assert(d.Processes, DeepEquals, map[string]int{"web": 2})
+	c.Assert(d.FinishedAt, IsNil)
+
+	// the deployed release should have the same config as the old one
+	newRelease, err := s.c.GetRelease(d.NewReleaseID)
+	c.Assert(err, IsNil)
+	c.Assert(newRelease.ID, Not(Equals), release.ID)
+	c.Assert(newRelease.Env, DeepEquals, release.Env)
+	c.Assert(newRelease.Processes, DeepEquals, release.Processes)
+	c.Assert(newRelease.ArtifactIDs, DeepEquals, release.ArtifactIDs)
+
+	// restarting again while the restart is in progress should fail
+	// without leaving the copied release behind
+	var releaseCount int64
+	c.Assert(s.hc.db.QueryRow("SELECT COUNT(*) FROM releases WHERE deleted_at IS NULL").Scan(&releaseCount), IsNil)
+	_, err = s.c.RestartApp(app.ID)
+	c.Assert(hh.IsValidationError(err), Equals, true)
+	c.Assert(err.(hh.JSONError).Message, Equals, "Cannot create deploy, there is already one in progress for this app.")
+	var newReleaseCount int64
+	c.Assert(s.hc.db.QueryRow("SELECT COUNT(*) FROM releases WHERE deleted_at IS NULL").Scan(&newReleaseCount), IsNil)
+	c.Assert(newReleaseCount, Equals, releaseCount)
+}
+
+func (s *S) TestRestartAppLoadsCurrentReleaseOnce(c *C) {
+	// use a handler which counts current release lookups for each app
+	var mtx sync.Mutex
+	loads := make(map[string]int)
+	hc := s.hc
+	hc.currentReleaseLoaded = func(appID string) {
+		mtx.Lock()
+		defer mtx.Unlock()
+		loads[appID]++
+	}
+	srv := httptest.NewServer(appHandler(hc))
+	defer srv.Close()
+	client, err := controller.NewClient(srv.URL, authKey)
+	c.Assert(err, IsNil)
+
+	app := s.createTestApp(c, &ct.App{Name: "restart-app-release-lookups"})
+	release := s.createTestRelease(c, &ct.Release{
+		Processes: map[string]ct.ProcessType{"web": {Args: []string{"start", "web"}}},
+	})
+	s.setAppRelease(c, app.ID, release.ID)
+	c.Assert(s.c.PutFormation(&ct.Formation{
+		AppID:     app.ID,
+		ReleaseID: release.ID,
+		Processes: map[string]int{"web": 1},
+	}), IsNil)
+
+	// restarting looks up the current release both to copy it and to
+	// create the deployment, but should only load it once
+	_, err = client.RestartApp(app.ID)
+	c.Assert(err, IsNil)
+	mtx.Lock()
+	defer mtx.Unlock()
+	c.Assert(loads[app.ID], Equals, 1)
+}
+
+func (s *S) TestDeployLimit(c *C) {
+	// use a handler which allows one more unfinished deployment than
+	// other tests have left behind
+	var count int64
+	c.Assert(s.hc.db.QueryRow("deployment_count_in_progress").Scan(&count), IsNil)
+	hc := s.hc
+	hc.deployLimit = int(count) + 1
+	srv := httptest.NewServer(appHandler(hc))
+	defer srv.Close()
+	client, err := controller.NewClient(srv.URL, authKey)
+	c.Assert(err, IsNil)
+
+	createApp := func(name string) *ct.App {
+		app := s.createTestApp(c, &ct.App{Name: name})
+		release := s.createTestRelease(c, &ct.Release{
+			Processes: map[string]ct.ProcessType{"web": {Args: []string{"start", "web"}}},
+		})
+		s.setAppRelease(c, app.ID, release.ID)
+		c.Assert(s.c.PutFormation(&ct.Formation{
+			AppID:     app.ID,
+			ReleaseID: release.ID,
+			Processes: map[string]int{"web": 1},
+		}), IsNil)
+		return app
+	}
+	app1 := createApp("deploy-limit-1")
+	app2 := createApp("deploy-limit-2")
+
+	// the first deploy takes the last slot
+	d, err := client.RestartApp(app1.ID)
+	c.Assert(err, IsNil)
+	c.Assert(d.FinishedAt, IsNil)
+
+	// so further deploys of any app are rejected
+	_, err = client.RestartApp(app2.ID)
+	c.Assert(err, NotNil)
+	e, ok := err.(hh.JSONError)
+	c.Assert(ok, Equals, true)
+	c.Assert(e.Code, Equals, hh.ServiceUnavailableErrorCode)
+	c.Assert(e.Message, Equals, errServerBusy.Message)
+	_, err = client.CreateDeployment(app2.ID, s.createTestRelease(c, &ct.Release{}).ID)
+	c.Assert(err, NotNil)
+	c.Assert(err.(hh.JSONError).Message, Equals, errServerBusy.Message)
+
+	// but other requests are still served
+	_, err = client.GetApp(app2.ID)
+	c.Assert(err, IsNil)
+
+	// once the deployment finishes, deploys succeed again
+	c.Assert(s.hc.db.Exec("deployment_update_finished_at_now", d.ID), IsNil)
+	_, err = client.RestartApp(app2.ID)
+	c.Assert(err, IsNil)
+}
+
 func (s *S) TestStreamDeployment(c *C) {
 	app := s.createTestApp(c, &ct.App{Name: "stream-deployment"})
 	release := s.createTestRelease(c, &ct.Release{
@@ -90,6 +269,190 @@ func (s *S) TestStreamDeployment(c *C) {
 	}
 }
 
+func (s *S) TestDeploymentTimeline(c *C) {
+	app := s.createTestApp(c, &ct.App{Name: "deployment-timeline"})
+	release := s.createTestRelease(c, &ct.Release{
+		Processes: map[string]ct.ProcessType{"web": {}},
+	})
+	c.Assert(s.c.PutFormation(&ct.Formation{
+		AppID:     app.ID,
+		ReleaseID: release.ID,
+		Processes: map[string]int{"web": 1},
+	}), IsNil)
+	defer s.c.DeleteFormation(app.ID, release.ID)
+	c.Assert(s.c.SetAppRelease(app.ID, release.ID), IsNil)
+	newRelease := s.createTestRelease(c, &ct.Release{
+		Processes: map[string]ct.ProcessType{"web": {}},
+	})
+
+	d, err := s.c.CreateDeployment(app.ID, newRelease.ID)
+	c.Assert(err, IsNil)
+
+	createDeploymentEvent := func(status string, jobState ct.JobState) {
+		e := ct.DeploymentEvent{DeploymentID: d.ID, ReleaseID: newRelease.ID, Status: status, JobType: "web", JobState: jobState}
+		c.Assert(s.hc.db.Exec("event_insert", app.ID, d.ID, string(ct.EventTypeDeployment), e), IsNil)
+	}
+	createJobEvent := func(appID, releaseID string, state ct.JobState) {
+		job := &ct.Job{UUID: random.UUID(), AppID: appID, ReleaseID: releaseID, Type: "web", State: state}
+		c.Assert(s.hc.db.Exec("event_insert", appID, job.UUID, string(ct.EventTypeJob), job), IsNil)
+	}
+
+	// interleave deployment and job events, including job events for
+	// the old release and other apps which should not be included
+	other := s.createTestApp(c, &ct.App{Name: "deployment-timeline-other"})
+	createDeploymentEvent("running", ct.JobStateStarting)
+	createJobEvent(app.ID, newRelease.ID, ct.JobStateStarting)
+	createJobEvent(app.ID, release.ID, ct.JobStateStopping)
+	createJobEvent(other.ID, newRelease.ID, ct.JobStateUp)
+	createJobEvent(app.ID, newRelease.ID, ct.JobStateUp)
+	createDeploymentEvent("running", ct.JobStateUp)
+	createDeploymentEvent("complete", "")
+
+	events, err := s.c.GetDeploymentTimeline(d.ID)
+	c.Assert(err, IsNil)
+	type summary struct {
+		Type  ct.EventType
+		State string
+	}
+	actual := make([]summary, len(events))
+	for i, e := range events {
+		if i > 0 {
+			c.Assert(e.CreatedAt.Before(*events[i-1].CreatedAt), Equals, false)
+		}
+		var data struct {
+			Status   string      `json:"status"`
+			JobState ct.JobState `json:"job_state"`
+			State    ct.JobState `json:"state"`
+		}
+		c.Assert(json.Unmarshal(e.Data, &data), IsNil)
+		switch e.ObjectType {
+		case ct.EventTypeDeployment:
+			c.Assert(e.ObjectID, Equals, d.ID)
+			actual[i] = summary{e.ObjectType, data.Status + "/" + string(data.JobState)}
+		case ct.EventTypeJob:
+			c.Assert(e.AppID, Equals, app.ID)
+			actual[i] = summary{e.ObjectType, string(data.State)}
+		}
+	}
+	c.Assert(actual, DeepEquals, []summary{
+		{ct.EventTypeDeployment, "pending/"},
+		{ct.EventTypeDeployment, "running/starting"},
+		{ct.EventTypeJob, "starting"},
+		{ct.EventTypeJob, "up"},
+		{ct.EventTypeDeployment, "running/up"},
+		{ct.EventTypeDeployment, "complete/"},
+	})
+
+	_, err = s.c.GetDeploymentTimeline(random.UUID())
+	c.Assert(err, Equals, controller.ErrNotFound)
+}
+
+func (s *S) TestStreamDeploymentProgress(c *C) {
+	app := s.createTestApp(c, &ct.App{Name: "stream-deployment-progress"})
+	release := s.createTestRelease(c, &ct.Release{
+		Processes: map[string]ct.ProcessType{"web": {}},
+	})
+	c.Assert(s.c.PutFormation(&ct.Formation{
+		AppID:     app.ID,
+		ReleaseID: release.ID,
+		Processes: map[string]int{"web": 2},
+	}), IsNil)
+	defer s.c.DeleteFormation(app.ID, release.ID)
+	c.Assert(s.c.SetAppRelease(app.ID, release.ID), IsNil)
+	oldJobs := make([]*ct.Job, 2)
+	for i := range oldJobs {
+		oldJobs[i] = s.createTestJob(c, &ct.Job{UUID: random.UUID(), AppID: app.ID, ReleaseID: release.ID, Type: "web", State: ct.JobStateUp})
+	}
+
+	newRelease := s.createTestRelease(c, &ct.Release{
+		Processes: map[string]ct.ProcessType{"web": {}},
+	})
+	d, err := s.c.CreateDeployment(app.ID, newRelease.ID)
+	c.Assert(err, IsNil)
+
+	progress := make(chan *ct.DeploymentProgress)
+	stream, err := s.c.StreamDeploymentProgress(d.ID, progress)
+	c.Assert(err, IsNil)
+	defer stream.Close()
+
+	assertProgress := func(expected ct.DeploymentProgress) {
+		select {
+		case p, ok := <-progress:
+			if !ok {
+				c.Fatalf("unexpected close of progress stream: %s", stream.Err())
+			}
+			c.Assert(*p, DeepEquals, expected)
+		case <-time.After(5 * time.Second):
+			c.Fatal("timed out waiting for deployment progress")
+		}
+	}
+	assertProgress(ct.DeploymentProgress{OldUp: 2, NewUp: 0, Target: 2, Status: "pending"})
+
+	// starting a job for the new release should increase new_up
+	s.createTestJob(c, &ct.Job{UUID: random.UUID(), AppID: app.ID, ReleaseID: newRelease.ID, Type: "web", State: ct.JobStateUp})
+	assertProgress(ct.DeploymentProgress{OldUp: 2, NewUp: 1, Target: 2, Status: "pending", Percent: 50})
+
+	// stopping a job for the old release should decrease old_up
+	oldJobs[0].State = ct.JobStateDown
+	s.createTestJob(c, oldJobs[0])
+	assertProgress(ct.DeploymentProgress{OldUp: 1, NewUp: 1, Target: 2, Status: "pending", Percent: 50})
+
+	// completing the deployment should emit a final snapshot and close
+	// the stream
+	c.Assert(createDeploymentEvent(s.hc.db.Exec, d, "complete"), IsNil)
+	assertProgress(ct.DeploymentProgress{OldUp: 1, NewUp: 1, Target: 2, Status: "complete", Percent: 100})
+	select {
+	case _, ok := <-progress:
+		c.Assert(ok, Equals, false)
+	case <-time.After(5 * time.Second):
+		c.Fatal("timed out waiting for progress stream to close")
+	}
+}
+
+func (s *S) TestDeploymentProgressPercent(c *C) {
+	app := s.createTestApp(c, &ct.App{Name: "deployment-progress-percent"})
+	procs := map[string]ct.ProcessType{"web": {}, "worker": {}}
+	release := s.createTestRelease(c, &ct.Release{Processes: procs})
+	c.Assert(s.c.PutFormation(&ct.Formation{
+		AppID:     app.ID,
+		ReleaseID: release.ID,
+		Processes: map[string]int{"web": 2, "worker": 2},
+	}), IsNil)
+	defer s.c.DeleteFormation(app.ID, release.ID)
+	c.Assert(s.c.SetAppRelease(app.ID, release.ID), IsNil)
+
+	newRelease := s.createTestRelease(c, &ct.Release{Processes: procs})
+	d, err := s.c.CreateDeployment(app.ID, newRelease.ID)
+	c.Assert(err, IsNil)
+
+	assertPercent := func(expected int) {
+		progress, err := s.c.GetDeploymentProgress(d.ID)
+		c.Assert(err, IsNil)
+		c.Assert(progress.Percent, Equals, expected)
+	}
+
+	// a just started deploy is 0% complete
+	assertPercent(0)
+
+	// extra web jobs should not make up for the missing worker jobs
+	for i := 0; i < 3; i++ {
+		s.createTestJob(c, &ct.Job{UUID: random.UUID(), AppID: app.ID, ReleaseID: newRelease.ID, Type: "web", State: ct.JobStateUp})
+	}
+	assertPercent(50)
+
+	for i := 0; i < 2; i++ {
+		s.createTestJob(c, &ct.Job{UUID: random.UUID(), AppID: app.ID, ReleaseID: newRelease.ID, Type: "worker", State: ct.JobStateUp})
+	}
+	assertPercent(100)
+
+	// a completed deploy is 100% complete
+	c.Assert(createDeploymentEvent(s.hc.db.Exec, d, "complete"), IsNil)
+	progress, err := s.c.GetDeploymentProgress(d.ID)
+	c.Assert(err, IsNil)
+	c.Assert(progress.Status, Equals, "complete")
+	c.Assert(progress.Percent, Equals, 100)
+}
+
 func (s *S) TestGetDeployment(c *C) {
 	app := s.createTestApp(c, &ct.App{Name: "get-deployment"})
 	release := s.createTestRelease(c, &ct.Release{
@@ -154,3 +517,64 @@ func (s *S) TestDeploymentList(c *C) {
 	c.Assert(deployments[1].ID, Equals, initial.ID)
 	c.Assert(deployments[0].ID, Equals, second.ID)
 }
+
+func (s *S) TestReleaseDeploymentList(c *C) {
+	app1 := s.createTestApp(c, &ct.App{Name: "release-deployment-list1"})
+	app2 := s.createTestApp(c, &ct.App{Name: "release-deployment-list2"})
+	release := s.createTestRelease(c, &ct.Release{})
+	other := s.createTestRelease(c, &ct.Release{})
+
+	// a release which has not been deployed has no deployments
+	deployments, err := s.c.ReleaseDeploymentList(release.ID)
+	c.Assert(err, IsNil)
+	c.Assert(deployments, HasLen, 0)
+
+	// the apps have no processes running so each deployment completes
+	// immediately
+	first, err := s.c.CreateDeployment(app1.ID, release.ID)
+	c.Assert(err, IsNil)
+	_, err = s.c.CreateDeployment(app1.ID, other.ID)
+	c.Assert(err, IsNil)
+	second, err := s.c.CreateDeployment(app2.ID, release.ID)
+	c.Assert(err, IsNil)
+
+	deployments, err = s.c.ReleaseDeploymentList(release.ID)
+	c.Assert(err, IsNil)
+	c.Assert(deployments, HasLen, 2)
+	c.Assert(deployments[0].ID, Equals, second.ID)
+	c.Assert(deployments[0].AppID, Equals, app2.ID)
+	c.Assert(deployments[1].ID, Equals, first.ID)
+	c.Assert(deployments[1].AppID, Equals, app1.ID)
+	for _, d := range deployments {
+		c.Assert(d.NewReleaseID, Equals, release.ID)
+		c.Assert(d.Status, Equals, "complete")
+	}
+}
+
+func (s *S) TestAppDeployTimes(c *C) {
+	app := s.createTestApp(c, &ct.App{Name: "app-deploy-times"})
+
+	// an app which has never been deployed has no deploy times
+	times, err := s.c.GetAppDeployTimes(app.ID)
+	c.Assert(err, IsNil)
+	c.Assert(times.FirstDeployedAt, IsNil)
+	c.Assert(times.LastDeployedAt, IsNil)
+
+	// the app has no processes running so each deployment completes
+	// immediately, allowing them to be created one after another
+	deployments := make([]*ct.Deployment, 3)
+	for i := range deployments {
+		release := s.createTestRelease(c, &ct.Release{})
+		d, err := s.c.CreateDeployment(app.ID, release.ID)
+		c.Assert(err, IsNil)
+		deployments[i] = d
+	}
+
+	times, err = s.c.GetAppDeployTimes(app.ID)
+	c.Assert(err, IsNil)
+	c.Assert(times.FirstDeployedAt, NotNil)
+	c.Assert(times.LastDeployedAt, NotNil)
+	c.Assert(times.FirstDeployedAt.Equal(*deployments[0].CreatedAt), Equals, true)
+	c.Assert(times.LastDeployedAt.Equal(*deployments[2].CreatedAt), Equals, true)
+	c.Assert(times.LastDeployedAt.After(*times.FirstDeployedAt), Equals, true)
+}
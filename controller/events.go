@@ -8,12 +8,12 @@ import (
 	"strconv"
 	"strings"
 	"sync"
+	"time"
 
 	ct "github.com/flynn/flynn/controller/types"
 	"github.com/flynn/flynn/pkg/ctxhelper"
 	"github.com/flynn/flynn/pkg/httphelper"
 	"github.com/flynn/flynn/pkg/postgres"
-	"github.com/flynn/flynn/pkg/sse"
 	"github.com/jackc/pgx"
 	"golang.org/x/net/context"
 )
@@ -26,7 +26,11 @@ func NewEventRepo(db *postgres.DB) *EventRepo {
 	return &EventRepo{db: db}
 }
 
-func (r *EventRepo) ListEvents(appID string, objectTypes []string, objectID string, beforeID *int64, sinceID *int64, count int) ([]*ct.Event, error) {
+// ListEvents lists events matching the given filters, most recent first. If
+// errorsOnly is set, only events whose data has a non-empty top-level error
+// field (e.g. failed deployments, app and release deletions and backups) are
+// returned.
+func (r *EventRepo) ListEvents(appID string, objectTypes []string, objectID string, beforeID *int64, sinceID *int64, count int, errorsOnly bool) ([]*ct.Event, error) {
 	query := "SELECT event_id, app_id, object_id, object_type, data, created_at FROM events"
 	var conditions []string
 	var n int
@@ -64,6 +68,9 @@ func (r *EventRepo) ListEvents(appID string, objectTypes []string, objectID stri
 		conditions = append(conditions, fmt.Sprintf("object_id = $%d", n))
 		args = append(args, objectID)
 	}
+	if errorsOnly {
+		conditions = append(conditions, "COALESCE(data->>'error', '') <> ''")
+	}
 	if len(conditions) > 0 {
 		query += " WHERE " + strings.Join(conditions, " AND ")
 	}
@@ -94,6 +101,89 @@ func (r *EventRepo) GetEvent(id int64) (*ct.Event, error) {
 	return scanEvent(row)
 }
 
+// ListAppEvents returns the most recent events for each of the given apps,
+// keyed by app ID. If count is greater than zero, at most count events are
+// returned for each app.
+func (r *EventRepo) ListAppEvents(appIDs []string, count int) (map[string][]*ct.Event, error) {
+	rows, err := r.db.Query("event_list_by_apps", fmt.Sprintf("{%s}", strings.Join(appIDs, ",")), count)
+	if err != nil {
+		return nil, err
+	}
+	events := make(map[string][]*ct.Event, len(appIDs))
+	for rows.Next() {
+		event, err := scanEvent(rows)
+		if err != nil {
+			rows.Close()
+			return nil, err
+		}
+		events[event.AppID] = append(events[event.AppID], event)
+	}
+	return events, rows.Err()
+}
+
+// ListDeploymentEvents returns the events of the given deployment, oldest
+// first.
+func (r *EventRepo) ListDeploymentEvents(deploymentID string) ([]*ct.Event, error) {
+	return r.list("event_list_by_deployment", deploymentID)
+}
+
+// ListReleaseJobEvents returns the app's job events for jobs of the given
+// release which were emitted between since and until (or since the given
+// time if until is nil), oldest first.
+func (r *EventRepo) ListReleaseJobEvents(appID, releaseID string, since time.Time, until *time.Time) ([]*ct.Event, error) {
+	return r.list("event_list_job_by_release", appID, releaseID, since, until)
+}
+
+func (r *EventRepo) list(query string, args ...interface{}) ([]*ct.Event, error) {
+	rows, err := r.db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	var events []*ct.Event
+	for rows.Next() {
+		event, err := scanEvent(rows)
+		if err != nil {
+			rows.Close()
+			return nil, err
+		}
+		events = append(events, event)
+	}
+	return events, rows.Err()
+}
+
+// IsAppCreation returns whether the given app event was emitted when the app
+// was created rather than when it was later updated.
+func (r *EventRepo) IsAppCreation(event *ct.Event) (bool, error) {
+	var updated bool
+	err := r.db.QueryRow("event_app_updated", event.ObjectID, event.ID).Scan(&updated)
+	return !updated, err
+}
+
+// GetAppAsOf returns the state of the app as of the given event, which is
+// the data of the latest app event for the app up to and including it, or
+// ErrNotFound if no such event was recorded.
+func (r *EventRepo) GetAppAsOf(appID string, eventID int64) (*ct.App, error) {
+	event, err := scanEvent(r.db.QueryRow("event_select_app_as_of", appID, eventID))
+	if err != nil {
+		return nil, err
+	}
+	app := &ct.App{}
+	if err := json.Unmarshal(event.Data, app); err != nil {
+		return nil, err
+	}
+	return app, nil
+}
+
+// GetLatestID returns the ID of the most recent event, or zero if there are
+// no events.
+func (r *EventRepo) GetLatestID() (int64, error) {
+	var id int64
+	if err := r.db.QueryRow("event_select_latest_id").Scan(&id); err != nil {
+		return 0, err
+	}
+	return id, nil
+}
+
 func scanEvent(s postgres.Scanner) (*ct.Event, error) {
 	var event ct.Event
 	var typ string
@@ -127,6 +217,18 @@ func (c *controllerAPI) maybeStartEventListener() error {
 	return c.eventListener.Listen()
 }
 
+// GetLatestEventID responds with the ID of the most recent event so clients
+// polling for events can tell whether they are caught up, which is zero if
+// there are no events.
+func (c *controllerAPI) GetLatestEventID(ctx context.Context, w http.ResponseWriter, req *http.Request) {
+	id, err := c.eventRepo.GetLatestID()
+	if err != nil {
+		respondWithError(w, err)
+		return
+	}
+	httphelper.JSON(w, 200, &ct.LatestEventID{ID: id})
+}
+
 func (c *controllerAPI) GetEvent(ctx context.Context, w http.ResponseWriter, req *http.Request) {
 	params, _ := ctxhelper.ParamsFromContext(ctx)
 	id, err := strconv.ParseInt(params.ByName("id"), 10, 64)
@@ -142,17 +244,139 @@ func (c *controllerAPI) GetEvent(ctx context.Context, w http.ResponseWriter, req
 	httphelper.JSON(w, 200, event)
 }
 
+// GetEventData responds with the event's data exactly as it is stored, so
+// clients can consume it without having to know the type of every event.
+func (c *controllerAPI) GetEventData(ctx context.Context, w http.ResponseWriter, req *http.Request) {
+	params, _ := ctxhelper.ParamsFromContext(ctx)
+	id, err := strconv.ParseInt(params.ByName("id"), 10, 64)
+	if err != nil {
+		respondWithError(w, err)
+		return
+	}
+	event, err := c.eventRepo.GetEvent(id)
+	if err != nil {
+		respondWithError(w, err)
+		return
+	}
+	data := []byte(event.Data)
+	if len(data) == 0 {
+		data = []byte("null")
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(200)
+	w.Write(data)
+}
+
+// GetEventApp gets the app the given event refers to, including apps which
+// have since been deleted.
+//
+// If the at_event_time parameter is true, the app is responded with as it
+// was when the event was emitted, taken from the app's latest app event up to
+// that point. Apps which have no such event recorded (for example apps
+// created before app events were introduced) fall back to the current app.
+func (c *controllerAPI) GetEventApp(ctx context.Context, w http.ResponseWriter, req *http.Request) {
+	params, _ := ctxhelper.ParamsFromContext(ctx)
+	id, err := strconv.ParseInt(params.ByName("id"), 10, 64)
+	if err != nil {
+		respondWithError(w, err)
+		return
+	}
+	event, err := c.eventRepo.GetEvent(id)
+	if err != nil {
+		respondWithError(w, err)
+		return
+	}
+	if event.AppID == "" {
+		respondWithError(w, ErrNotFound)
+		return
+	}
+	if req.FormValue("at_event_time") == "true" {
+		app, err := c.eventRepo.GetAppAsOf(event.AppID, event.ID)
+		if err == nil {
+			httphelper.JSON(w, 200, app)
+			return
+		} else if err != ErrNotFound {
+			respondWithError(w, err)
+			return
+		}
+	}
+	app, err := c.appRepo.GetIncludingDeleted(event.AppID)
+	if err != nil {
+		respondWithError(w, err)
+		return
+	}
+	httphelper.JSON(w, 200, app)
+}
+
+// StreamAppLifecycle streams an event each time an app is created or deleted.
+func (c *controllerAPI) StreamAppLifecycle(ctx context.Context, w http.ResponseWriter, req *http.Request) {
+	l, _ := ctxhelper.LoggerFromContext(ctx)
+	log := l.New("fn", "StreamAppLifecycle")
+
+	if err := c.maybeStartEventListener(); err != nil {
+		log.Error("error starting event listener", "err", err)
+		respondWithError(w, err)
+		return
+	}
+	sub, err := c.eventListener.Subscribe("", []string{string(ct.EventTypeApp), string(ct.EventTypeAppDeletion)}, "")
+	if err != nil {
+		respondWithError(w, err)
+		return
+	}
+	defer sub.Close()
+
+	ch := make(chan *ct.AppLifecycleEvent)
+	stream := c.newSSEStream(w, ch, log)
+	stream.Serve()
+	defer stream.Close()
+
+	for {
+		select {
+		case <-stream.Done:
+			return
+		case event, ok := <-sub.Events:
+			if !ok {
+				stream.Error(sub.Err)
+				return
+			}
+			typ := ct.AppLifecycleDeleted
+			if event.ObjectType == ct.EventTypeApp {
+				created, err := c.eventRepo.IsAppCreation(event)
+				if err != nil {
+					stream.Error(err)
+					return
+				}
+				if !created {
+					continue
+				}
+				typ = ct.AppLifecycleCreated
+			}
+			// the app may have been deleted since the event was emitted
+			app, err := c.appRepo.GetIncludingDeleted(event.AppID)
+			if err != nil {
+				log.Error("error getting app", "event.id", event.ID, "app.id", event.AppID, "err", err)
+				continue
+			}
+			select {
+			case ch <- &ct.AppLifecycleEvent{EventType: typ, App: app}:
+			case <-stream.Done:
+				return
+			}
+		}
+	}
+}
+
 func (c *controllerAPI) Events(ctx context.Context, w http.ResponseWriter, req *http.Request) {
 	l, _ := ctxhelper.LoggerFromContext(ctx)
 	log := l.New("fn", "Events")
 	var app *ct.App
 	if appID := req.FormValue("app_id"); appID != "" {
-		data, err := c.appRepo.Get(appID)
+		var err error
+		app, err = c.resolveApp(appID)
 		if err != nil {
 			respondWithError(w, err)
 			return
 		}
-		app = data.(*ct.App)
 	}
 
 	if req.Header.Get("Accept") == "application/json" {
@@ -167,12 +391,128 @@ func (c *controllerAPI) Events(ctx context.Context, w http.ResponseWriter, req *
 		log.Error("error starting event listener", "err", err)
 		respondWithError(w, err)
 	}
-	if err := streamEvents(ctx, w, req, c.eventListener, app, c.eventRepo); err != nil {
+	if err := c.streamEvents(ctx, w, req, app); err != nil {
+		log.Error("error streaming events", "err", err)
+		respondWithError(w, err)
+	}
+}
+
+// defaultAppActivityTypes are the types of events streamed by
+// StreamAppActivity if none are requested.
+var defaultAppActivityTypes = []string{
+	string(ct.EventTypeJob),
+	string(ct.EventTypeDeployment),
+	string(ct.EventTypeScale),
+}
+
+// StreamAppActivity streams the app's events of the object types given by the
+// object_types query parameter (or its job, deployment and scale events if
+// none are given) over a single stream, supporting the same parameters as
+// streaming from /events.
+func (c *controllerAPI) StreamAppActivity(ctx context.Context, w http.ResponseWriter, req *http.Request) {
+	l, _ := ctxhelper.LoggerFromContext(ctx)
+	log := l.New("fn", "StreamAppActivity")
+	if err := req.ParseForm(); err != nil {
+		respondWithError(w, err)
+		return
+	}
+	if req.Form.Get("object_types") == "" {
+		req.Form.Set("object_types", strings.Join(defaultAppActivityTypes, ","))
+	}
+	if err := c.maybeStartEventListener(); err != nil {
+		log.Error("error starting event listener", "err", err)
+		respondWithError(w, err)
+		return
+	}
+	if err := c.streamEvents(ctx, w, req, c.getApp(ctx)); err != nil {
 		log.Error("error streaming events", "err", err)
 		respondWithError(w, err)
 	}
 }
 
+func (c *controllerAPI) ListAppEvents(ctx context.Context, w http.ResponseWriter, req *http.Request) {
+	appIDs := strings.Split(req.FormValue("app_ids"), ",")
+	if len(appIDs) == 1 && appIDs[0] == "" {
+		respondWithError(w, ct.ValidationError{Field: "app_ids", Message: "must not be empty"})
+		return
+	}
+
+	var count int
+	if req.FormValue("count") != "" {
+		var err error
+		count, err = strconv.Atoi(req.FormValue("count"))
+		if err != nil {
+			respondWithError(w, ct.ValidationError{Field: "count", Message: "is invalid"})
+			return
+		}
+	}
+
+	apps := make([]*ct.App, len(appIDs))
+	ids := make([]string, len(appIDs))
+	for i, id := range appIDs {
+		app, err := c.resolveApp(id)
+		if err != nil {
+			respondWithError(w, err)
+			return
+		}
+		apps[i] = app
+		ids[i] = app.ID
+	}
+
+	events, err := c.eventRepo.ListAppEvents(ids, count)
+	if err != nil {
+		respondWithError(w, err)
+		return
+	}
+
+	list := make([]*ct.AppEvents, len(apps))
+	for i, app := range apps {
+		appEvents := events[app.ID]
+		if appEvents == nil {
+			appEvents = []*ct.Event{}
+		}
+		list[i] = &ct.AppEvents{App: app, Events: appEvents}
+	}
+	httphelper.JSON(w, 200, list)
+}
+
+const (
+	// defaultRecentEventCount is the number of events listed by
+	// ListRecentAppEvents if no count is given
+	defaultRecentEventCount = 10
+
+	// maxRecentEventCount caps the number of events listed by
+	// ListRecentAppEvents
+	maxRecentEventCount = 100
+)
+
+// ListRecentAppEvents responds with the app's most recent events of all types,
+// most recent first, limited to the number given by the count query parameter
+// (10 by default, and at most 100).
+func (c *controllerAPI) ListRecentAppEvents(ctx context.Context, w http.ResponseWriter, req *http.Request) {
+	count := defaultRecentEventCount
+	if req.FormValue("count") != "" {
+		var err error
+		count, err = strconv.Atoi(req.FormValue("count"))
+		if err != nil || count < 1 {
+			respondWithError(w, ct.ValidationError{Field: "count", Message: "must be a positive integer"})
+			return
+		}
+	}
+	if count > maxRecentEventCount {
+		count = maxRecentEventCount
+	}
+	events, err := c.eventRepo.ListEvents(c.getApp(ctx).ID, nil, "", nil, nil, count, false)
+	if err != nil {
+		respondWithError(w, err)
+		return
+	}
+	if events == nil {
+		events = []*ct.Event{}
+	}
+	httphelper.JSON(w, 200, events)
+}
+
 func listEvents(ctx context.Context, w http.ResponseWriter, req *http.Request, app *ct.App, repo *EventRepo) (err error) {
 	var appID string
 	if app != nil {
@@ -211,7 +551,15 @@ func listEvents(ctx context.Context, w http.ResponseWriter, req *http.Request, a
 	}
 	objectID := req.FormValue("object_id")
 
-	list, err := repo.ListEvents(appID, objectTypes, objectID, beforeID, sinceID, count)
+	var errorsOnly bool
+	if req.FormValue("errors_only") != "" {
+		errorsOnly, err = strconv.ParseBool(req.FormValue("errors_only"))
+		if err != nil {
+			return ct.ValidationError{Field: "errors_only", Message: "is invalid"}
+		}
+	}
+
+	list, err := repo.ListEvents(appID, objectTypes, objectID, beforeID, sinceID, count, errorsOnly)
 	if err != nil {
 		return err
 	}
@@ -219,7 +567,7 @@ func listEvents(ctx context.Context, w http.ResponseWriter, req *http.Request, a
 	return nil
 }
 
-func streamEvents(ctx context.Context, w http.ResponseWriter, req *http.Request, eventListener *EventListener, app *ct.App, repo *EventRepo) (err error) {
+func (c *controllerAPI) streamEvents(ctx context.Context, w http.ResponseWriter, req *http.Request, app *ct.App) (err error) {
 	var appID string
 	if app != nil {
 		appID = app.ID
@@ -232,6 +580,19 @@ func streamEvents(ctx context.Context, w http.ResponseWriter, req *http.Request,
 			return ct.ValidationError{Field: "Last-Event-Id", Message: "is invalid"}
 		}
 	}
+	// last_id lets clients which cannot set headers (or which are
+	// reconnecting with a new stream) resume after a given event, with
+	// the Last-Event-Id header taking precedence once a resuming stream
+	// has seen later events
+	if req.FormValue("last_id") != "" {
+		id, err := strconv.ParseInt(req.FormValue("last_id"), 10, 64)
+		if err != nil {
+			return ct.ValidationError{Field: "last_id", Message: "is invalid"}
+		}
+		if id > lastID {
+			lastID = id
+		}
+	}
 
 	var count int
 	if req.FormValue("count") != "" {
@@ -251,7 +612,7 @@ func streamEvents(ctx context.Context, w http.ResponseWriter, req *http.Request,
 	l, _ := ctxhelper.LoggerFromContext(ctx)
 	log := l.New("fn", "streamEvents", "object_types", objectTypes, "object_id", objectID)
 	ch := make(chan *ct.Event)
-	s := sse.NewStream(w, ch, log)
+	s := c.newSSEStream(w, ch, log)
 	s.Serve()
 	defer func() {
 		if err == nil {
@@ -261,15 +622,23 @@ func streamEvents(ctx context.Context, w http.ResponseWriter, req *http.Request,
 		}
 	}()
 
-	sub, err := eventListener.Subscribe(appID, objectTypes, objectID)
+	sub, err := c.eventListener.Subscribe(appID, objectTypes, objectID)
 	if err != nil {
 		return err
 	}
 	defer sub.Close()
 
-	var currID int64
+	// the subscription is created before the stored events are listed so
+	// that no events are missed in between, so skip any live events which
+	// have already been sent from the list
+	currID := lastID
 	if past == "true" || lastID > 0 {
-		list, err := repo.ListEvents(appID, objectTypes, objectID, nil, &lastID, count)
+		// when resuming, send every missed event rather than just the
+		// most recent count events so there is no gap
+		if lastID > 0 {
+			count = 0
+		}
+		list, err := c.eventRepo.ListEvents(appID, objectTypes, objectID, nil, &lastID, count, false)
 		if err != nil {
 			return err
 		}
@@ -2,9 +2,11 @@ package main
 
 import (
 	"encoding/json"
+	"strconv"
 	"strings"
 	"time"
 
+	"github.com/flynn/flynn/controller/client"
 	ct "github.com/flynn/flynn/controller/types"
 	"github.com/flynn/flynn/pkg/random"
 	. "github.com/flynn/go-check"
@@ -477,3 +479,456 @@ func (s *S) TestGetEvent(c *C) {
 	c.Assert(err, IsNil)
 	c.Assert(event, DeepEquals, events[0])
 }
+
+func (s *S) TestGetLatestEventID(c *C) {
+	s.createTestApp(c, &ct.App{Name: "latest-event-id"})
+	events, err := s.c.ListEvents(ct.ListEventsOptions{Count: 1})
+	c.Assert(err, IsNil)
+	c.Assert(events, HasLen, 1)
+	id, err := s.c.GetLatestEventID()
+	c.Assert(err, IsNil)
+	c.Assert(id, Equals, events[0].ID)
+
+	// creating another event should advance the latest ID to it
+	release := s.createTestRelease(c, &ct.Release{})
+	latestID, err := s.c.GetLatestEventID()
+	c.Assert(err, IsNil)
+	c.Assert(latestID > id, Equals, true)
+	events, err = s.c.ListEvents(ct.ListEventsOptions{Count: 1})
+	c.Assert(err, IsNil)
+	c.Assert(events, HasLen, 1)
+	c.Assert(events[0].ID, Equals, latestID)
+	c.Assert(events[0].ObjectID, Equals, release.ID)
+
+	// and there should be no events since it
+	events, err = s.c.ListEvents(ct.ListEventsOptions{SinceID: &latestID})
+	c.Assert(err, IsNil)
+	c.Assert(events, HasLen, 0)
+}
+
+func (s *S) TestGetEventAppDeleted(c *C) {
+	app := s.createTestApp(c, &ct.App{Name: "event-app-deleted"})
+
+	// simulate the app deletion worker deleting the app and recording an
+	// app deletion event
+	c.Assert(s.hc.db.Exec("app_delete", app.ID), IsNil)
+	c.Assert(s.hc.db.Exec("event_insert", app.ID, app.ID, string(ct.EventTypeAppDeletion), ct.AppDeletionEvent{
+		AppDeletion: &ct.AppDeletion{AppID: app.ID},
+	}), IsNil)
+	_, err := s.c.GetApp(app.ID)
+	c.Assert(err, Equals, controller.ErrNotFound)
+
+	events, err := s.c.ListEvents(ct.ListEventsOptions{
+		AppID:       app.ID,
+		ObjectTypes: []ct.EventType{ct.EventTypeAppDeletion},
+	})
+	c.Assert(err, IsNil)
+	c.Assert(events, HasLen, 1)
+
+	eventApp, err := s.c.GetEventApp(events[0].ID)
+	c.Assert(err, IsNil)
+	c.Assert(eventApp.ID, Equals, app.ID)
+	c.Assert(eventApp.Name, Equals, app.Name)
+	c.Assert(eventApp.Deleted, Equals, true)
+	c.Assert(eventApp.DeletedAt, NotNil)
+	c.Assert(eventApp.DeletedAt.After(*eventApp.CreatedAt), Equals, true)
+}
+
+func (s *S) TestGetEventAppLive(c *C) {
+	app := s.createTestApp(c, &ct.App{Name: "event-app-live"})
+
+	events, err := s.c.ListEvents(ct.ListEventsOptions{
+		AppID:       app.ID,
+		ObjectTypes: []ct.EventType{ct.EventTypeApp},
+	})
+	c.Assert(err, IsNil)
+	c.Assert(events, HasLen, 1)
+
+	eventApp, err := s.c.GetEventApp(events[0].ID)
+	c.Assert(err, IsNil)
+	c.Assert(eventApp.ID, Equals, app.ID)
+	c.Assert(eventApp.Deleted, Equals, false)
+	c.Assert(eventApp.DeletedAt, IsNil)
+}
+
+func (s *S) TestGetEventAppAtEventTime(c *C) {
+	app := s.createTestApp(c, &ct.App{Name: "event-app-at-event-time"})
+	release := s.createTestRelease(c, &ct.Release{})
+	job := s.createTestJob(c, &ct.Job{UUID: random.UUID(), AppID: app.ID, ReleaseID: release.ID, Type: "web", State: ct.JobStateUp})
+
+	// simulate renaming the app, recording an app event like other app
+	// updates do
+	c.Assert(s.hc.db.Exec("UPDATE apps SET name = $2 WHERE app_id = $1", app.ID, "event-app-renamed"), IsNil)
+	renamed, err := s.c.GetApp(app.ID)
+	c.Assert(err, IsNil)
+	c.Assert(s.hc.db.Exec("event_insert", app.ID, app.ID, string(ct.EventTypeApp), renamed), IsNil)
+
+	jobEvents, err := s.c.ListEvents(ct.ListEventsOptions{
+		AppID:       app.ID,
+		ObjectTypes: []ct.EventType{ct.EventTypeJob},
+		ObjectID:    job.UUID,
+	})
+	c.Assert(err, IsNil)
+	c.Assert(jobEvents, HasLen, 1)
+	appEvents, err := s.c.ListEvents(ct.ListEventsOptions{
+		AppID:       app.ID,
+		ObjectTypes: []ct.EventType{ct.EventTypeApp},
+	})
+	c.Assert(err, IsNil)
+	c.Assert(appEvents, HasLen, 2)
+
+	// the current app has the new name
+	eventApp, err := s.c.GetEventApp(jobEvents[0].ID)
+	c.Assert(err, IsNil)
+	c.Assert(eventApp.Name, Equals, "event-app-renamed")
+
+	// but the job was created before the rename
+	eventApp, err = s.c.GetEventAppAtEventTime(jobEvents[0].ID)
+	c.Assert(err, IsNil)
+	c.Assert(eventApp.ID, Equals, app.ID)
+	c.Assert(eventApp.Name, Equals, "event-app-at-event-time")
+
+	// and the rename event has the new name (events are listed newest
+	// first)
+	eventApp, err = s.c.GetEventAppAtEventTime(appEvents[0].ID)
+	c.Assert(err, IsNil)
+	c.Assert(eventApp.Name, Equals, "event-app-renamed")
+	eventApp, err = s.c.GetEventAppAtEventTime(appEvents[1].ID)
+	c.Assert(err, IsNil)
+	c.Assert(eventApp.Name, Equals, "event-app-at-event-time")
+
+	// apps without a recorded app event fall back to the current app
+	appID := random.UUID()
+	c.Assert(s.hc.db.Exec("INSERT INTO apps (app_id, name) VALUES ($1, $2)", appID, "event-app-unrecorded"), IsNil)
+	c.Assert(s.hc.db.Exec("event_insert", appID, appID, string(ct.EventTypeAppGarbageCollection), ct.AppGarbageCollectionEvent{}), IsNil)
+	events, err := s.c.ListEvents(ct.ListEventsOptions{AppID: appID})
+	c.Assert(err, IsNil)
+	c.Assert(events, HasLen, 1)
+	eventApp, err = s.c.GetEventAppAtEventTime(events[0].ID)
+	c.Assert(err, IsNil)
+	c.Assert(eventApp.ID, Equals, appID)
+	c.Assert(eventApp.Name, Equals, "event-app-unrecorded")
+}
+
+func (s *S) TestListEventsPage(c *C) {
+	app := s.createTestApp(c, &ct.App{Name: "list-events-page"})
+	release := s.createTestRelease(c, &ct.Release{})
+	for i := 0; i < 5; i++ {
+		s.createTestJob(c, &ct.Job{UUID: random.UUID(), AppID: app.ID, ReleaseID: release.ID, Type: "web", State: ct.JobStateUp})
+	}
+	all, err := s.c.ListEvents(ct.ListEventsOptions{AppID: app.ID, ObjectTypes: []ct.EventType{ct.EventTypeJob}})
+	c.Assert(err, IsNil)
+	c.Assert(all, HasLen, 5)
+
+	// page through the events two at a time using the event ID as a cursor
+	var paged []*ct.Event
+	opts := ct.ListEventsOptions{AppID: app.ID, ObjectTypes: []ct.EventType{ct.EventTypeJob}, Count: 2}
+	for {
+		page, err := s.c.ListEvents(opts)
+		c.Assert(err, IsNil)
+		paged = append(paged, page...)
+		if len(page) < opts.Count {
+			break
+		}
+		opts.BeforeID = &page[len(page)-1].ID
+	}
+	c.Assert(paged, HasLen, len(all))
+	for i, event := range paged {
+		c.Assert(event.ID, Equals, all[i].ID)
+	}
+}
+
+func (s *S) TestGetEventData(c *C) {
+	app := s.createTestApp(c, &ct.App{Name: "event-data"})
+	release := s.createTestRelease(c, &ct.Release{})
+	s.createTestJob(c, &ct.Job{UUID: random.UUID(), AppID: app.ID, ReleaseID: release.ID, Type: "web", State: ct.JobStateUp})
+	c.Assert(s.c.SetAppRelease(app.ID, release.ID), IsNil)
+
+	events, err := s.c.ListEvents(ct.ListEventsOptions{
+		AppID:       app.ID,
+		ObjectTypes: []ct.EventType{ct.EventTypeApp, ct.EventTypeJob, ct.EventTypeAppRelease},
+	})
+	c.Assert(err, IsNil)
+	c.Assert(events, HasLen, 3)
+
+	for _, event := range events {
+		var stored string
+		c.Assert(s.hc.db.QueryRow("SELECT data::text FROM events WHERE event_id = $1", event.ID).Scan(&stored), IsNil)
+		data, err := s.c.GetEventData(event.ID)
+		c.Assert(err, IsNil)
+		c.Assert(string(data), Equals, stored, Commentf("event type %s", event.ObjectType))
+	}
+}
+
+func (s *S) TestListAppEvents(c *C) {
+	app1 := s.createTestApp(c, &ct.App{Name: "list-app-events-1"})
+	app2 := s.createTestApp(c, &ct.App{Name: "list-app-events-2"})
+	app3 := s.createTestApp(c, &ct.App{Name: "list-app-events-3"})
+	release := s.createTestRelease(c, &ct.Release{})
+
+	// create three job events for app1 and one for app2
+	jobID1 := random.UUID()
+	jobID2 := random.UUID()
+	for _, job := range []*ct.Job{
+		{UUID: jobID1, AppID: app1.ID, ReleaseID: release.ID, Type: "web", State: ct.JobStateStarting},
+		{UUID: jobID1, AppID: app1.ID, ReleaseID: release.ID, Type: "web", State: ct.JobStateUp},
+		{UUID: jobID1, AppID: app1.ID, ReleaseID: release.ID, Type: "web", State: ct.JobStateDown},
+		{UUID: jobID2, AppID: app2.ID, ReleaseID: release.ID, Type: "web", State: ct.JobStateStarting},
+	} {
+		s.createTestJob(c, job)
+	}
+
+	list, err := s.c.ListAppEvents([]string{app1.ID, app2.Name, app3.ID}, 0)
+	c.Assert(err, IsNil)
+	c.Assert(list, HasLen, 3)
+	c.Assert(list[0].App.ID, Equals, app1.ID)
+	c.Assert(list[1].App.ID, Equals, app2.ID)
+	c.Assert(list[2].App.ID, Equals, app3.ID)
+	for _, appEvents := range list {
+		for _, e := range appEvents.Events {
+			c.Assert(e.AppID, Equals, appEvents.App.ID)
+		}
+	}
+	// app1 has an app creation event plus three job events
+	c.Assert(list[0].Events, HasLen, 4)
+	c.Assert(list[0].Events[0].ObjectType, Equals, ct.EventTypeJob)
+	c.Assert(list[1].Events, HasLen, 2)
+	c.Assert(list[2].Events, HasLen, 1)
+
+	// check the count caps the events for each app
+	list, err = s.c.ListAppEvents([]string{app1.ID, app2.ID}, 2)
+	c.Assert(err, IsNil)
+	c.Assert(list, HasLen, 2)
+	c.Assert(list[0].Events, HasLen, 2)
+	c.Assert(list[0].Events[0].ID > list[0].Events[1].ID, Equals, true)
+	var jobEvent ct.Job
+	c.Assert(json.Unmarshal(list[0].Events[0].Data, &jobEvent), IsNil)
+	c.Assert(jobEvent.State, Equals, ct.JobStateDown)
+	c.Assert(list[1].Events, HasLen, 2)
+}
+
+func (s *S) TestListRecentAppEvents(c *C) {
+	app := s.createTestApp(c, &ct.App{Name: "recent-app-events"})
+	release := s.createTestRelease(c, &ct.Release{})
+	s.setAppRelease(c, app.ID, release.ID)
+	for i := 0; i < 12; i++ {
+		s.createTestJob(c, &ct.Job{UUID: random.UUID(), AppID: app.ID, ReleaseID: release.ID, Type: "web", State: ct.JobStateUp})
+	}
+	all, err := s.c.ListEvents(ct.ListEventsOptions{AppID: app.ID})
+	c.Assert(err, IsNil)
+	c.Assert(len(all) > 12, Equals, true)
+
+	// the newest 10 events should be listed by default, most recent first
+	events, err := s.c.ListRecentAppEvents(app.ID, 0)
+	c.Assert(err, IsNil)
+	c.Assert(events, HasLen, 10)
+	for i, e := range events {
+		c.Assert(e.ID, Equals, all[i].ID)
+		c.Assert(e.AppID, Equals, app.ID)
+	}
+
+	// the count should limit the events
+	events, err = s.c.ListRecentAppEvents(app.ID, 3)
+	c.Assert(err, IsNil)
+	c.Assert(events, HasLen, 3)
+	c.Assert(events[0].ID > events[1].ID, Equals, true)
+	c.Assert(events[1].ID > events[2].ID, Equals, true)
+	c.Assert(events[0].ID, Equals, all[0].ID)
+
+	// counts above the cap are reduced to it rather than rejected
+	events, err = s.c.ListRecentAppEvents(app.ID, 1000)
+	c.Assert(err, IsNil)
+	c.Assert(events, HasLen, len(all))
+
+	// an app with no events other than its creation lists just that
+	other := s.createTestApp(c, &ct.App{Name: "recent-app-events-other"})
+	events, err = s.c.ListRecentAppEvents(other.ID, 0)
+	c.Assert(err, IsNil)
+	c.Assert(events, HasLen, 1)
+	c.Assert(events[0].ObjectType, Equals, ct.EventTypeApp)
+}
+
+func (s *S) TestStreamAppLifecycle(c *C) {
+	events := make(chan *ct.AppLifecycleEvent)
+	stream, err := s.c.StreamAppLifecycle(events)
+	c.Assert(err, IsNil)
+	defer stream.Close()
+
+	app := s.createTestApp(c, &ct.App{Name: "stream-app-lifecycle"})
+
+	assertEvent := func(typ string, deleted bool) {
+		timeout := time.After(5 * time.Second)
+		for {
+			select {
+			case e, ok := <-events:
+				if !ok {
+					c.Fatalf("unexpected close of app lifecycle stream: %s", stream.Err())
+				}
+				if e.App.ID != app.ID {
+					continue
+				}
+				c.Assert(e.EventType, Equals, typ)
+				c.Assert(e.App.Name, Equals, app.Name)
+				c.Assert(e.App.Deleted, Equals, deleted)
+				return
+			case <-timeout:
+				c.Fatalf("timed out waiting for %s app lifecycle event", typ)
+			}
+		}
+	}
+	assertEvent(ct.AppLifecycleCreated, false)
+
+	// updating the app should not emit a lifecycle event
+	c.Assert(s.c.UpdateApp(&ct.App{ID: app.ID, Meta: map[string]string{"foo": "bar"}}), IsNil)
+
+	// simulate the app deletion worker deleting the app and recording an
+	// app deletion event
+	c.Assert(s.hc.db.Exec("app_delete", app.ID), IsNil)
+	c.Assert(s.hc.db.Exec("event_insert", app.ID, app.ID, string(ct.EventTypeAppDeletion), ct.AppDeletionEvent{
+		AppDeletion: &ct.AppDeletion{AppID: app.ID},
+	}), IsNil)
+	assertEvent(ct.AppLifecycleDeleted, true)
+}
+
+func (s *S) TestStreamAppActivity(c *C) {
+	app := s.createTestApp(c, &ct.App{Name: "stream-app-activity"})
+	other := s.createTestApp(c, &ct.App{Name: "stream-app-activity-other"})
+	release := s.createTestRelease(c, &ct.Release{
+		Processes: map[string]ct.ProcessType{"web": {}},
+	})
+
+	assertEvent := func(events chan *ct.Event, typ ct.EventType) *ct.Event {
+		select {
+		case e, ok := <-events:
+			if !ok {
+				c.Fatal("unexpected close of event stream")
+			}
+			c.Assert(e.AppID, Equals, app.ID)
+			c.Assert(e.ObjectType, Equals, typ)
+			return e
+		case <-time.After(10 * time.Second):
+			c.Fatalf("timed out waiting for %s event", typ)
+		}
+		return nil
+	}
+
+	events := make(chan *ct.Event)
+	stream, err := s.c.StreamAppActivity(app.Name, []ct.EventType{ct.EventTypeScale, ct.EventTypeJob}, events)
+	c.Assert(err, IsNil)
+	defer stream.Close()
+
+	// events of the requested types for the app should arrive, with
+	// other types and other apps' events filtered out
+	formation := s.createTestFormation(c, &ct.Formation{AppID: app.ID, ReleaseID: release.ID, Processes: map[string]int{"web": 1}})
+	defer s.deleteTestFormation(formation)
+	assertEvent(events, ct.EventTypeScale)
+	s.createTestJob(c, &ct.Job{UUID: random.UUID(), AppID: other.ID, ReleaseID: release.ID, Type: "web", State: ct.JobStateUp})
+	c.Assert(s.c.UpdateApp(&ct.App{ID: app.ID, Meta: map[string]string{"foo": "bar"}}), IsNil)
+	job := s.createTestJob(c, &ct.Job{UUID: random.UUID(), AppID: app.ID, ReleaseID: release.ID, Type: "web", State: ct.JobStateUp})
+	e := assertEvent(events, ct.EventTypeJob)
+	c.Assert(e.ObjectID, Equals, job.UUID)
+
+	// without requested types, job, deployment and scale events should
+	// arrive
+	defaultEvents := make(chan *ct.Event)
+	defaultStream, err := s.c.StreamAppActivity(app.ID, nil, defaultEvents)
+	c.Assert(err, IsNil)
+	defer defaultStream.Close()
+	c.Assert(s.c.UpdateApp(&ct.App{ID: app.ID, Meta: map[string]string{"foo": "baz"}}), IsNil)
+	deploymentID := random.UUID()
+	c.Assert(s.hc.db.Exec("event_insert", app.ID, deploymentID, string(ct.EventTypeDeployment), ct.DeploymentEvent{
+		AppID:        app.ID,
+		DeploymentID: deploymentID,
+		ReleaseID:    release.ID,
+		Status:       "running",
+	}), IsNil)
+	e = assertEvent(defaultEvents, ct.EventTypeDeployment)
+	c.Assert(e.ObjectID, Equals, deploymentID)
+
+	// the deployment event should have been filtered out of the first
+	// stream
+	job = s.createTestJob(c, &ct.Job{UUID: random.UUID(), AppID: app.ID, ReleaseID: release.ID, Type: "web", State: ct.JobStateUp})
+	e = assertEvent(events, ct.EventTypeJob)
+	c.Assert(e.ObjectID, Equals, job.UUID)
+}
+
+func (s *S) TestStreamEventsResumeFromLastID(c *C) {
+	app := s.createTestApp(c, &ct.App{Name: "stream-events-last-id"})
+	opts := ct.StreamEventsOptions{
+		AppID:       app.ID,
+		ObjectTypes: []ct.EventType{ct.EventTypeApp},
+	}
+	updateApp := func(i int) {
+		c.Assert(s.c.UpdateApp(&ct.App{ID: app.ID, Meta: map[string]string{"i": strconv.Itoa(i)}}), IsNil)
+	}
+	nextEvent := func(events chan *ct.Event) *ct.Event {
+		select {
+		case e, ok := <-events:
+			if !ok {
+				c.Fatal("unexpected close of event stream")
+			}
+			return e
+		case <-time.After(5 * time.Second):
+			c.Fatal("timed out waiting for event")
+		}
+		return nil
+	}
+
+	events := make(chan *ct.Event)
+	stream, err := s.c.StreamEvents(opts, events)
+	c.Assert(err, IsNil)
+	updateApp(0)
+	last := nextEvent(events)
+	stream.Close()
+
+	// generate events while disconnected
+	updateApp(1)
+	updateApp(2)
+
+	// reconnecting with the last seen ID should replay the missed events
+	// then stream new ones, each exactly once
+	opts.LastID = last.ID
+	events = make(chan *ct.Event)
+	stream, err = s.c.StreamEvents(opts, events)
+	c.Assert(err, IsNil)
+	defer stream.Close()
+	updateApp(3)
+
+	for i := 1; i <= 3; i++ {
+		e := nextEvent(events)
+		c.Assert(e.ID > last.ID, Equals, true)
+		var data ct.App
+		c.Assert(json.Unmarshal(e.Data, &data), IsNil)
+		c.Assert(data.Meta["i"], Equals, strconv.Itoa(i))
+		last = e
+	}
+	select {
+	case e := <-events:
+		c.Fatalf("unexpected event: %+v", e)
+	case <-time.After(100 * time.Millisecond):
+	}
+}
+
+func (s *S) TestListEventsErrorsOnly(c *C) {
+	app := s.createTestApp(c, &ct.App{Name: "list-events-errors-only"})
+	insert := func(typ ct.EventType, data interface{}) {
+		c.Assert(s.hc.db.Exec("event_insert", app.ID, random.UUID(), string(typ), data), IsNil)
+	}
+	insert(ct.EventTypeDeployment, ct.DeploymentEvent{AppID: app.ID, Status: "complete"})
+	insert(ct.EventTypeDeployment, ct.DeploymentEvent{AppID: app.ID, Status: "failed", Error: "deploy timed out"})
+	insert(ct.EventTypeAppDeletion, ct.AppDeletionEvent{AppDeletion: &ct.AppDeletion{AppID: app.ID}})
+	insert(ct.EventTypeReleaseDeletion, ct.ReleaseDeletionEvent{ReleaseDeletion: &ct.ReleaseDeletion{AppID: app.ID}, Error: "release is in use"})
+
+	events, err := s.c.ListEvents(ct.ListEventsOptions{AppID: app.ID})
+	c.Assert(err, IsNil)
+	c.Assert(events, HasLen, 4)
+
+	events, err = s.c.ListEvents(ct.ListEventsOptions{AppID: app.ID, ErrorsOnly: true})
+	c.Assert(err, IsNil)
+	c.Assert(events, HasLen, 2)
+	c.Assert(events[0].ObjectType, Equals, ct.EventTypeReleaseDeletion)
+	c.Assert(events[1].ObjectType, Equals, ct.EventTypeDeployment)
+	var data ct.DeploymentEvent
+	c.Assert(json.Unmarshal(events[1].Data, &data), IsNil)
+	c.Assert(data.Error, Equals, "deploy timed out")
+}
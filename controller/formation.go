@@ -1,8 +1,10 @@
 package main
 
 import (
+	"encoding/json"
 	"fmt"
 	"net/http"
+	"sort"
 	"strings"
 	"sync"
 	"time"
@@ -12,7 +14,6 @@ import (
 	"github.com/flynn/flynn/pkg/ctxhelper"
 	"github.com/flynn/flynn/pkg/httphelper"
 	"github.com/flynn/flynn/pkg/postgres"
-	"github.com/flynn/flynn/pkg/sse"
 	"github.com/jackc/pgx"
 	"golang.org/x/net/context"
 )
@@ -88,10 +89,15 @@ func (r *FormationRepo) validateFormProcs(f *ct.Formation) error {
 	if err != nil {
 		return err
 	}
-	rel := release.(*ct.Release)
+	return validateFormationProcesses(f, release.(*ct.Release))
+}
+
+// validateFormationProcesses checks that the formation only scales process
+// types which exist in the given release.
+func validateFormationProcesses(f *ct.Formation, release *ct.Release) error {
 	invalid := make([]string, 0, len(f.Processes))
 	for k := range f.Processes {
-		if _, ok := rel.Processes[k]; !ok {
+		if _, ok := release.Processes[k]; !ok {
 			invalid = append(invalid, k)
 		}
 	}
@@ -102,35 +108,90 @@ func (r *FormationRepo) validateFormProcs(f *ct.Formation) error {
 }
 
 func (r *FormationRepo) Add(f *ct.Formation) error {
-	if err := r.validateFormProcs(f); err != nil {
-		return err
-	}
-	scale := &ct.Scale{
-		Processes: f.Processes,
-		ReleaseID: f.ReleaseID,
-	}
-	prevFormation, _ := r.Get(f.AppID, f.ReleaseID)
-	if prevFormation != nil {
-		scale.PrevProcesses = prevFormation.Processes
+	return r.addAll([]*ct.Formation{f}, nil, nil)
+}
+
+// AddIfUnmodifiedSince puts the given formation, failing without making any
+// changes if the existing formation has been modified since the given
+// precondition.
+func (r *FormationRepo) AddIfUnmodifiedSince(f *ct.Formation, since *unmodifiedSince) error {
+	return r.addAll([]*ct.Formation{f}, since, nil)
+}
+
+// AddAll validates and then puts the given formations in a single
+// transaction, so either all of them are updated or none of them are.
+func (r *FormationRepo) AddAll(formations []*ct.Formation) error {
+	return r.addAll(formations, nil, nil)
+}
+
+// AddAllWithAppRelease is like AddAll but also sets the app's current release
+// in the same transaction.
+func (r *FormationRepo) AddAllWithAppRelease(formations []*ct.Formation, app *ct.App, releaseID string) error {
+	return r.addAll(formations, nil, func(tx *postgres.DBTx) error {
+		return setAppRelease(tx, app, releaseID)
+	})
+}
+
+// addAll puts the given formations in a single transaction, calling then (if
+// set) as part of the transaction once they have been put.
+func (r *FormationRepo) addAll(formations []*ct.Formation, since *unmodifiedSince, then func(*postgres.DBTx) error) error {
+	scales := make([]*ct.Scale, len(formations))
+	for i, f := range formations {
+		if err := r.validateFormProcs(f); err != nil {
+			return err
+		}
+		scale := &ct.Scale{
+			Processes: f.Processes,
+			ReleaseID: f.ReleaseID,
+		}
+		prevFormation, _ := r.Get(f.AppID, f.ReleaseID)
+		if prevFormation != nil {
+			scale.PrevProcesses = prevFormation.Processes
+		}
+		scales[i] = scale
 	}
 	tx, err := r.db.Begin()
 	if err != nil {
 		return err
 	}
-	err = tx.QueryRow("formation_insert", f.AppID, f.ReleaseID, f.Processes, f.Tags).Scan(&f.CreatedAt, &f.UpdatedAt)
-	if err != nil {
-		tx.Rollback()
+	for i, f := range formations {
+		if since != nil {
+			existing, err := scanFormation(tx.QueryRow("formation_select_for_update", f.AppID, f.ReleaseID))
+			if err == nil {
+				err = since.Check(existing.UpdatedAt)
+			} else if err == ErrNotFound {
+				err = nil
+			}
+			if err != nil {
+				tx.Rollback()
+				return err
+			}
+		}
+		if err := insertFormation(tx, f, scales[i]); err != nil {
+			tx.Rollback()
+			return err
+		}
+	}
+	if then != nil {
+		if err := then(tx); err != nil {
+			tx.Rollback()
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// insertFormation puts the formation along with a scale event as part of the
+// given transaction, which the caller must roll back on error.
+func insertFormation(tx *postgres.DBTx, f *ct.Formation, scale *ct.Scale) error {
+	if err := tx.QueryRow("formation_insert", f.AppID, f.ReleaseID, f.Processes, f.Tags).Scan(&f.CreatedAt, &f.UpdatedAt); err != nil {
 		return err
 	}
-	if err := createEvent(tx.Exec, &ct.Event{
+	return createEvent(tx.Exec, &ct.Event{
 		AppID:      f.AppID,
 		ObjectID:   f.AppID + ":" + f.ReleaseID,
 		ObjectType: ct.EventTypeScale,
-	}, scale); err != nil {
-		tx.Rollback()
-		return err
-	}
-	return tx.Commit()
+	}, scale)
 }
 
 func scanFormations(rows *pgx.Rows) ([]*ct.Formation, error) {
@@ -168,6 +229,7 @@ func scanExpandedFormation(s postgres.Scanner) (*ct.ExpandedFormation, error) {
 		&f.App.ID,
 		&f.App.Name,
 		&f.App.Meta,
+		&f.App.Paused,
 		&f.Release.ID,
 		&artifactIDs,
 		&f.Release.Meta,
@@ -186,6 +248,7 @@ func scanExpandedFormation(s postgres.Scanner) (*ct.ExpandedFormation, error) {
 	if artifactIDs != "" {
 		f.Release.ArtifactIDs = split(artifactIDs[1:len(artifactIDs)-1], ",")
 	}
+	f.App.SchedulingState = appSchedulingState(f.App.Paused)
 	return f, nil
 }
 
@@ -463,6 +526,70 @@ func (r *FormationRepo) unsubscribeLocked(sub *FormationSubscription) {
 	}
 }
 
+// decodeFormation decodes a formation from the given JSON, first checking its
+// tags so that clients sending a flat map or the wrong nesting get a
+// validation error naming the offending field rather than a generic decode
+// error. prefix is prepended to the field, and is set when decoding a list of
+// formations.
+func decodeFormation(data json.RawMessage, prefix string) (*ct.Formation, error) {
+	var raw struct {
+		Tags json.RawMessage `json:"tags"`
+	}
+	if err := json.Unmarshal(data, &raw); err == nil {
+		if err := checkFormationTags(raw.Tags, prefix+"tags"); err != nil {
+			return nil, err
+		}
+	}
+	var formation *ct.Formation
+	if err := json.Unmarshal(data, &formation); err != nil {
+		return nil, err
+	}
+	return formation, nil
+}
+
+// checkFormationTags checks that the given JSON tags are an object mapping
+// process types to objects of string tags, returning a validation error for
+// the first offending field (in sorted order) if not.
+func checkFormationTags(data json.RawMessage, field string) error {
+	if len(data) == 0 || string(data) == "null" {
+		return nil
+	}
+	tagsErr := func(field string) error {
+		return ct.ValidationError{
+			Field:   field,
+			Message: "must be an object mapping process types to objects of string tags",
+		}
+	}
+	var tags map[string]json.RawMessage
+	if err := json.Unmarshal(data, &tags); err != nil {
+		return tagsErr(field)
+	}
+	types := make([]string, 0, len(tags))
+	for typ := range tags {
+		types = append(types, typ)
+	}
+	sort.Strings(types)
+	for _, typ := range types {
+		var procTags map[string]interface{}
+		if err := json.Unmarshal(tags[typ], &procTags); err != nil {
+			return tagsErr(field + "." + typ)
+		}
+		keys := make([]string, 0, len(procTags))
+		for key := range procTags {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		for _, key := range keys {
+			switch procTags[key].(type) {
+			case string, nil:
+			default:
+				return tagsErr(field + "." + typ + "." + key)
+			}
+		}
+	}
+	return nil
+}
+
 func (c *controllerAPI) PutFormation(ctx context.Context, w http.ResponseWriter, req *http.Request) {
 	app := c.getApp(ctx)
 	release, err := c.getRelease(ctx)
@@ -471,11 +598,20 @@ func (c *controllerAPI) PutFormation(ctx context.Context, w http.ResponseWriter,
 		return
 	}
 
-	var formation ct.Formation
-	if err = httphelper.DecodeJSON(req, &formation); err != nil {
+	var data json.RawMessage
+	if err = httphelper.DecodeJSON(req, &data); err != nil {
 		respondWithError(w, err)
 		return
 	}
+	decoded, err := decodeFormation(data, "")
+	if err != nil {
+		respondWithError(w, err)
+		return
+	}
+	var formation ct.Formation
+	if decoded != nil {
+		formation = *decoded
+	}
 
 	if release.ImageArtifactID() == "" {
 		respondWithError(w, ct.ValidationError{Message: "release is not deployable"})
@@ -490,13 +626,148 @@ func (c *controllerAPI) PutFormation(ctx context.Context, w http.ResponseWriter,
 		return
 	}
 
-	if err = c.formationRepo.Add(&formation); err != nil {
+	since, err := parseUnmodifiedSince(req)
+	if err != nil {
+		respondWithError(w, err)
+		return
+	}
+
+	if err = c.formationRepo.AddIfUnmodifiedSince(&formation, since); err != nil {
 		respondWithError(w, err)
 		return
 	}
 	httphelper.JSON(w, 200, &formation)
 }
 
+// PutFormations updates the formations of potentially many apps at once,
+// validating all of them before applying any so that a single invalid
+// formation leaves every app untouched.
+func (c *controllerAPI) PutFormations(ctx context.Context, w http.ResponseWriter, req *http.Request) {
+	var data []json.RawMessage
+	if err := httphelper.DecodeJSON(req, &data); err != nil {
+		respondWithError(w, err)
+		return
+	}
+	formations := make([]*ct.Formation, len(data))
+	for i, d := range data {
+		formation, err := decodeFormation(d, fmt.Sprintf("formations[%d].", i))
+		if err != nil {
+			respondWithError(w, err)
+			return
+		}
+		formations[i] = formation
+	}
+
+	seen := make(map[string]struct{}, len(formations))
+	for i, formation := range formations {
+		field := fmt.Sprintf("formations[%d]", i)
+		if formation == nil {
+			respondWithError(w, ct.ValidationError{Field: field, Message: "must not be null"})
+			return
+		}
+
+		app, err := c.resolveApp(formation.AppID)
+		if err != nil {
+			respondWithError(w, err)
+			return
+		}
+		formation.AppID = app.ID
+
+		data, err := c.releaseRepo.Get(formation.ReleaseID)
+		if err == ErrNotFound {
+			respondWithError(w, ct.ValidationError{Field: field + ".release", Message: "does not exist"})
+			return
+		} else if err != nil {
+			respondWithError(w, err)
+			return
+		}
+		release := data.(*ct.Release)
+		if release.ImageArtifactID() == "" {
+			respondWithError(w, ct.ValidationError{Field: field + ".release", Message: "is not deployable"})
+			return
+		}
+
+		key := formation.AppID + ":" + formation.ReleaseID
+		if _, ok := seen[key]; ok {
+			respondWithError(w, ct.ValidationError{Field: field, Message: "is a duplicate of an earlier formation"})
+			return
+		}
+		seen[key] = struct{}{}
+
+		if err := schema.Validate(formation); err != nil {
+			respondWithError(w, err)
+			return
+		}
+	}
+
+	if err := c.formationRepo.AddAll(formations); err != nil {
+		respondWithError(w, err)
+		return
+	}
+	httphelper.JSON(w, 200, formations)
+}
+
+// CordonApp sets the reserved cordon tag on every process type of all of the
+// app's formations, keeping its jobs off hosts which have the tag (see
+// ct.CordonTagKey), without changing any process counts.
+func (c *controllerAPI) CordonApp(ctx context.Context, w http.ResponseWriter, req *http.Request) {
+	c.setCordonTag(ctx, w, true)
+}
+
+// UncordonApp removes the reserved cordon tag from all of the app's
+// formations.
+func (c *controllerAPI) UncordonApp(ctx context.Context, w http.ResponseWriter, req *http.Request) {
+	c.setCordonTag(ctx, w, false)
+}
+
+func (c *controllerAPI) setCordonTag(ctx context.Context, w http.ResponseWriter, cordon bool) {
+	app := c.getApp(ctx)
+	formations, err := c.formationRepo.List(app.ID)
+	if err != nil {
+		respondWithError(w, err)
+		return
+	}
+	if len(formations) == 0 {
+		httphelper.JSON(w, 200, []*ct.Formation{})
+		return
+	}
+	for _, f := range formations {
+		tags := make(map[string]map[string]string, len(f.Tags))
+		for typ, t := range f.Tags {
+			tags[typ] = make(map[string]string, len(t))
+			for k, v := range t {
+				tags[typ][k] = v
+			}
+		}
+		if cordon {
+			data, err := c.releaseRepo.Get(f.ReleaseID)
+			if err != nil {
+				respondWithError(w, err)
+				return
+			}
+			for typ := range data.(*ct.Release).Processes {
+				if tags[typ] == nil {
+					tags[typ] = make(map[string]string, 1)
+				}
+				tags[typ][ct.CordonTagKey] = ct.CordonTagValue
+			}
+		} else {
+			for typ, t := range tags {
+				delete(t, ct.CordonTagKey)
+				if len(t) == 0 {
+					delete(tags, typ)
+				}
+			}
+		}
+		f.Tags = tags
+	}
+	if err := c.formationRepo.AddAll(formations); err != nil {
+		respondWithError(w, err)
+		return
+	}
+	httphelper.JSON(w, 200, formations)
+}
+
 func (c *controllerAPI) GetFormation(ctx context.Context, w http.ResponseWriter, req *http.Request) {
 	params, _ := ctxhelper.ParamsFromContext(ctx)
 
@@ -520,6 +791,68 @@ func (c *controllerAPI) GetFormation(ctx context.Context, w http.ResponseWriter,
 	httphelper.JSON(w, 200, formation)
 }
 
+// GetCurrentFormation responds with the expanded formation of the app's
+// current release, returning a 404 if the app has no current release or the
+// release has no formation.
+func (c *controllerAPI) GetCurrentFormation(ctx context.Context, w http.ResponseWriter, req *http.Request) {
+	app := c.getApp(ctx)
+	if app.ReleaseID == "" {
+		respondWithError(w, ErrNotFound)
+		return
+	}
+	formation, err := c.formationRepo.GetExpanded(app.ID, app.ReleaseID)
+	if err != nil {
+		respondWithError(w, err)
+		return
+	}
+	// include the full app rather than the subset of fields loaded with
+	// the formation
+	formation.App = app
+	httphelper.JSON(w, 200, formation)
+}
+
+// GetDegradedProcesses responds with the process types of the app's current
+// release which have fewer up jobs than the formation wants, ordered by type.
+// Omni process types are not included since the number of jobs they want
+// depends on the number of hosts.
+func (c *controllerAPI) GetDegradedProcesses(ctx context.Context, w http.ResponseWriter, req *http.Request) {
+	app := c.getApp(ctx)
+	degraded := []*ct.DegradedProcess{}
+	if app.ReleaseID == "" {
+		httphelper.JSON(w, 200, degraded)
+		return
+	}
+	formation, err := c.formationRepo.GetExpanded(app.ID, app.ReleaseID)
+	if err == ErrNotFound {
+		httphelper.JSON(w, 200, degraded)
+		return
+	} else if err != nil {
+		respondWithError(w, err)
+		return
+	}
+	counts, err := c.jobRepo.UpCounts(app.ID, app.ReleaseID)
+	if err != nil {
+		respondWithError(w, err)
+		return
+	}
+	for typ, desired := range formation.Processes {
+		if formation.Release.Processes[typ].Omni {
+			continue
+		}
+		if actual := counts[typ]; actual < desired {
+			degraded = append(degraded, &ct.DegradedProcess{Type: typ, Desired: desired, Actual: actual})
+		}
+	}
+	sort.Sort(degradedProcessesByType(degraded))
+	httphelper.JSON(w, 200, degraded)
+}
+
+type degradedProcessesByType []*ct.DegradedProcess
+
+func (d degradedProcessesByType) Len() int           { return len(d) }
+func (d degradedProcessesByType) Less(i, j int) bool { return d[i].Type < d[j].Type }
+func (d degradedProcessesByType) Swap(i, j int)      { d[i], d[j] = d[j], d[i] }
+
 func (c *controllerAPI) DeleteFormation(ctx context.Context, w http.ResponseWriter, req *http.Request) {
 	params, _ := ctxhelper.ParamsFromContext(ctx)
 
@@ -538,6 +871,11 @@ func (c *controllerAPI) DeleteFormation(ctx context.Context, w http.ResponseWrit
 }
 
 func (c *controllerAPI) ListFormations(ctx context.Context, w http.ResponseWriter, req *http.Request) {
+	if strings.Contains(req.Header.Get("Accept"), "text/event-stream") {
+		c.streamAppFormations(ctx, w, req)
+		return
+	}
+
 	app := c.getApp(ctx)
 	list, err := c.formationRepo.List(app.ID)
 	if err != nil {
@@ -582,10 +920,58 @@ func (c *controllerAPI) streamFormations(ctx context.Context, w http.ResponseWri
 	}
 	defer c.formationRepo.Unsubscribe(sub)
 	l, _ := ctxhelper.LoggerFromContext(ctx)
-	stream := sse.NewStream(w, ch, l)
+	stream := c.newSSEStream(w, ch, l)
 	stream.Serve()
 	stream.Wait()
 	if err := sub.Err(); err != nil {
 		stream.Error(err)
 	}
 }
+
+// streamAppFormations streams formation changes for a single app, including
+// formations being scaled to zero or deleted, until either the client
+// disconnects or the request context is cancelled.
+func (c *controllerAPI) streamAppFormations(ctx context.Context, w http.ResponseWriter, req *http.Request) {
+	app := c.getApp(ctx)
+	ch := make(chan *ct.ExpandedFormation)
+	sub, err := c.formationRepo.Subscribe(ctx, ch, time.Now(), nil)
+	if err != nil {
+		respondWithError(w, err)
+		return
+	}
+	defer c.formationRepo.Unsubscribe(sub)
+
+	l, _ := ctxhelper.LoggerFromContext(ctx)
+	output := make(chan *ct.ExpandedFormation)
+	stream := c.newSSEStream(w, output, l)
+	stream.Serve()
+	defer stream.Close()
+
+	for {
+		select {
+		case f, ok := <-ch:
+			if !ok {
+				if err := sub.Err(); err != nil {
+					stream.Error(err)
+				}
+				return
+			}
+			// skip formations for other apps and the empty formation
+			// which marks the end of the initial updates
+			if f.App == nil || f.App.ID != app.ID {
+				continue
+			}
+			select {
+			case output <- f:
+			case <-stream.Done:
+				return
+			case <-ctx.Done():
+				return
+			}
+		case <-stream.Done:
+			return
+		case <-ctx.Done():
+			return
+		}
+	}
+}
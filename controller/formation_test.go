@@ -1,12 +1,17 @@
 package main
 
 import (
+	"encoding/json"
 	"fmt"
+	"net/http"
 	"os"
+	"strings"
 	"time"
 
+	"github.com/flynn/flynn/controller/client"
 	ct "github.com/flynn/flynn/controller/types"
 	"github.com/flynn/flynn/host/types"
+	hh "github.com/flynn/flynn/pkg/httphelper"
 	"github.com/flynn/flynn/pkg/random"
 	. "github.com/flynn/go-check"
 )
@@ -71,6 +76,175 @@ func (s *S) TestFormationStreaming(c *C) {
 	c.Assert(out.Processes, IsNil)
 }
 
+func (s *S) TestAppFormationStreaming(c *C) {
+	release := s.createTestRelease(c, &ct.Release{
+		Processes: map[string]ct.ProcessType{"web": {}},
+	})
+	app := s.createTestApp(c, &ct.App{Name: "app-formation-stream"})
+	other := s.createTestApp(c, &ct.App{Name: "app-formation-stream-other"})
+
+	updates := make(chan *ct.ExpandedFormation)
+	stream, err := s.c.StreamAppFormations(app.ID, updates)
+	c.Assert(err, IsNil)
+	defer stream.Close()
+
+	// a formation change for a different app should not be streamed
+	s.createTestFormation(c, &ct.Formation{
+		ReleaseID: release.ID,
+		AppID:     other.ID,
+		Processes: map[string]int{"web": 1},
+	})
+
+	assertUpdate := func(processes map[string]int) {
+		select {
+		case f, ok := <-updates:
+			if !ok {
+				c.Fatalf("stream closed unexpectedly: %s", stream.Err())
+			}
+			c.Assert(f.App.ID, Equals, app.ID)
+			c.Assert(f.Release.ID, Equals, release.ID)
+			c.Assert(f.Processes, DeepEquals, processes)
+		case <-time.After(10 * time.Second):
+			c.Fatal("timed out waiting for formation update")
+		}
+	}
+
+	// scale up
+	formation := s.createTestFormation(c, &ct.Formation{
+		ReleaseID: release.ID,
+		AppID:     app.ID,
+		Processes: map[string]int{"web": 2},
+	})
+	assertUpdate(map[string]int{"web": 2})
+
+	// scale to zero
+	formation.Processes = map[string]int{"web": 0}
+	s.createTestFormation(c, formation)
+	assertUpdate(map[string]int{"web": 0})
+}
+
+func (s *S) TestPutFormations(c *C) {
+	release := s.createTestRelease(c, &ct.Release{
+		Processes: map[string]ct.ProcessType{"web": {}, "worker": {}},
+	})
+	app1 := s.createTestApp(c, &ct.App{Name: "put-formations-1"})
+	app2 := s.createTestApp(c, &ct.App{Name: "put-formations-2"})
+
+	formations := []*ct.Formation{
+		{AppID: app1.ID, ReleaseID: release.ID, Processes: map[string]int{"web": 2}},
+		{AppID: app2.Name, ReleaseID: release.ID, Processes: map[string]int{"web": 1, "worker": 3}},
+	}
+	c.Assert(s.c.PutFormations(formations), IsNil)
+	c.Assert(formations, HasLen, 2)
+	c.Assert(formations[1].AppID, Equals, app2.ID)
+	for _, f := range formations {
+		c.Assert(f.CreatedAt, NotNil)
+		got, err := s.c.GetFormation(f.AppID, f.ReleaseID)
+		c.Assert(err, IsNil)
+		c.Assert(got.Processes, DeepEquals, f.Processes)
+	}
+}
+
+func (s *S) TestPutFormationsRollback(c *C) {
+	artifact := s.createTestArtifact(c, &ct.Artifact{Type: host.ArtifactTypeDocker})
+	release := s.createTestRelease(c, &ct.Release{
+		ArtifactIDs: []string{artifact.ID},
+		Processes:   map[string]ct.ProcessType{"web": {}},
+	})
+	app1 := s.createTestApp(c, &ct.App{Name: "put-formations-rollback-1"})
+	app2 := s.createTestApp(c, &ct.App{Name: "put-formations-rollback-2"})
+
+	// both formations are valid, so make inserting the second one fail
+	// after the first has been inserted in the same transaction
+	c.Assert(s.hc.db.Exec(`CREATE FUNCTION fail_formation_insert() RETURNS TRIGGER AS $$
+    BEGIN
+        RAISE EXCEPTION 'formation insert failed';
+    END;
+$$ LANGUAGE plpgsql`), IsNil)
+	defer s.hc.db.Exec("DROP FUNCTION fail_formation_insert() CASCADE")
+	c.Assert(s.hc.db.Exec(fmt.Sprintf(`CREATE TRIGGER fail_formation_insert BEFORE INSERT ON formations
+    FOR EACH ROW WHEN (NEW.app_id = '%s') EXECUTE PROCEDURE fail_formation_insert()`, app2.ID)), IsNil)
+
+	err := s.c.PutFormations([]*ct.Formation{
+		{AppID: app1.ID, ReleaseID: release.ID, Processes: map[string]int{"web": 1}},
+		{AppID: app2.ID, ReleaseID: release.ID, Processes: map[string]int{"web": 1}},
+	})
+	c.Assert(err, NotNil)
+	c.Assert(hh.IsValidationError(err), Equals, false)
+
+	// the first formation should have been rolled back along with the
+	// scale event inserted with it
+	_, err = s.c.GetFormation(app1.ID, release.ID)
+	c.Assert(err, Equals, controller.ErrNotFound)
+	_, err = s.c.GetFormation(app2.ID, release.ID)
+	c.Assert(err, Equals, controller.ErrNotFound)
+	var events int
+	c.Assert(s.hc.db.QueryRow("SELECT COUNT(*) FROM events WHERE app_id = $1 AND object_type = 'scale'", app1.ID).Scan(&events), IsNil)
+	c.Assert(events, Equals, 0)
+}
+
+func (s *S) TestCordonApp(c *C) {
+	app := s.createTestApp(c, &ct.App{Name: "cordon-app"})
+	release1 := s.createTestRelease(c, &ct.Release{
+		Processes: map[string]ct.ProcessType{"web": {}, "worker": {}},
+	})
+	release2 := s.createTestRelease(c, &ct.Release{
+		Processes: map[string]ct.ProcessType{"web": {}},
+	})
+	s.createTestFormation(c, &ct.Formation{
+		AppID:     app.ID,
+		ReleaseID: release1.ID,
+		Processes: map[string]int{"web": 2, "worker": 1},
+		Tags:      map[string]map[string]string{"web": {"disk": "ssd"}},
+	})
+	s.createTestFormation(c, &ct.Formation{
+		AppID:     app.ID,
+		ReleaseID: release2.ID,
+		Processes: map[string]int{"web": 3},
+	})
+	counts := map[string]map[string]int{
+		release1.ID: {"web": 2, "worker": 1},
+		release2.ID: {"web": 3},
+	}
+
+	// cordoning should tag every process type of every formation
+	formations, err := s.c.CordonApp(app.ID)
+	c.Assert(err, IsNil)
+	c.Assert(formations, HasLen, 2)
+	for _, f := range formations {
+		got, err := s.c.GetFormation(app.ID, f.ReleaseID)
+		c.Assert(err, IsNil)
+		c.Assert(got.Processes, DeepEquals, counts[f.ReleaseID])
+		switch f.ReleaseID {
+		case release1.ID:
+			c.Assert(got.Tags, DeepEquals, map[string]map[string]string{
+				"web":    {"disk": "ssd", ct.CordonTagKey: ct.CordonTagValue},
+				"worker": {ct.CordonTagKey: ct.CordonTagValue},
+			})
+		case release2.ID:
+			c.Assert(got.Tags, DeepEquals, map[string]map[string]string{
+				"web": {ct.CordonTagKey: ct.CordonTagValue},
+			})
+		}
+	}
+
+	// uncordoning should remove only the cordon tag
+	formations, err = s.c.UncordonApp(app.ID)
+	c.Assert(err, IsNil)
+	c.Assert(formations, HasLen, 2)
+	for _, f := range formations {
+		got, err := s.c.GetFormation(app.ID, f.ReleaseID)
+		c.Assert(err, IsNil)
+		c.Assert(got.Processes, DeepEquals, counts[f.ReleaseID])
+		switch f.ReleaseID {
+		case release1.ID:
+			c.Assert(got.Tags, DeepEquals, map[string]map[string]string{"web": {"disk": "ssd"}})
+		case release2.ID:
+			c.Assert(len(got.Tags), Equals, 0)
+		}
+	}
+}
+
 func (s *S) TestFormationStreamDeleted(c *C) {
 	app := s.createTestApp(c, &ct.App{Name: "formation-stream-deleted"})
 
@@ -174,6 +348,116 @@ func (s *S) TestFormationListActive(c *C) {
 	}
 }
 
+func (s *S) TestCurrentExpandedFormation(c *C) {
+	app := s.createTestApp(c, &ct.App{Name: "current-expanded-formation"})
+
+	// an app without a current release has no current formation
+	_, err := s.c.GetCurrentExpandedFormation(app.ID)
+	c.Assert(err, Equals, controller.ErrNotFound)
+
+	imageArtifact := s.createTestArtifact(c, &ct.Artifact{Type: host.ArtifactTypeDocker})
+	fileArtifact := s.createTestArtifact(c, &ct.Artifact{Type: host.ArtifactTypeFile})
+	release := s.createTestRelease(c, &ct.Release{
+		ArtifactIDs: []string{imageArtifact.ID, fileArtifact.ID},
+		Processes:   map[string]ct.ProcessType{"web": {}, "worker": {}},
+	})
+	procs := map[string]int{"web": 2, "worker": 1}
+	tags := map[string]map[string]string{"web": {"disk": "ssd"}}
+	s.createTestFormation(c, &ct.Formation{AppID: app.ID, ReleaseID: release.ID, Processes: procs, Tags: tags})
+
+	// an old release's formation should not be returned
+	oldRelease := s.createTestRelease(c, &ct.Release{Processes: map[string]ct.ProcessType{"web": {}}})
+	s.createTestFormation(c, &ct.Formation{AppID: app.ID, ReleaseID: oldRelease.ID, Processes: map[string]int{"web": 1}})
+	s.setAppRelease(c, app.ID, release.ID)
+
+	f, err := s.c.GetCurrentExpandedFormation(app.Name)
+	c.Assert(err, IsNil)
+	c.Assert(f.App.ID, Equals, app.ID)
+	c.Assert(f.App.ReleaseID, Equals, release.ID)
+	c.Assert(f.Release.ID, Equals, release.ID)
+	c.Assert(f.ImageArtifact.ID, Equals, imageArtifact.ID)
+	c.Assert(f.FileArtifacts, HasLen, 1)
+	c.Assert(f.FileArtifacts[0].ID, Equals, fileArtifact.ID)
+	c.Assert(f.Processes, DeepEquals, procs)
+	c.Assert(f.Tags, DeepEquals, tags)
+}
+
+func (s *S) TestDegradedProcesses(c *C) {
+	createApp := func(name string) (*ct.App, *ct.Release) {
+		app := s.createTestApp(c, &ct.App{Name: name})
+		release := s.createTestRelease(c, &ct.Release{
+			Processes: map[string]ct.ProcessType{"web": {}, "worker": {}, "clock": {}, "agent": {Omni: true}},
+		})
+		s.createTestFormation(c, &ct.Formation{
+			AppID:     app.ID,
+			ReleaseID: release.ID,
+			Processes: map[string]int{"web": 2, "worker": 1, "clock": 0, "agent": 1},
+		})
+		s.setAppRelease(c, app.ID, release.ID)
+		return app, release
+	}
+	createJob := func(app *ct.App, release *ct.Release, typ string, state ct.JobState) {
+		s.createTestJob(c, &ct.Job{UUID: random.UUID(), AppID: app.ID, ReleaseID: release.ID, Type: typ, State: state})
+	}
+
+	// an app without a release has no degraded processes
+	app := s.createTestApp(c, &ct.App{Name: "degraded-processes-no-release"})
+	degraded, err := s.c.GetDegradedProcesses(app.ID)
+	c.Assert(err, IsNil)
+	c.Assert(degraded, HasLen, 0)
+
+	// an app with all of its jobs up has no degraded processes
+	app, release := createApp("degraded-processes-healthy")
+	createJob(app, release, "web", ct.JobStateUp)
+	createJob(app, release, "web", ct.JobStateUp)
+	createJob(app, release, "worker", ct.JobStateUp)
+	degraded, err = s.c.GetDegradedProcesses(app.ID)
+	c.Assert(err, IsNil)
+	c.Assert(degraded, HasLen, 0)
+
+	// an app with crashed jobs has degraded processes
+	app, release = createApp("degraded-processes-crashed")
+	createJob(app, release, "web", ct.JobStateUp)
+	createJob(app, release, "web", ct.JobStateDown)
+	createJob(app, release, "worker", ct.JobStateStarting)
+	createJob(app, release, "clock", ct.JobStateDown)
+	degraded, err = s.c.GetDegradedProcesses(app.ID)
+	c.Assert(err, IsNil)
+	c.Assert(degraded, DeepEquals, []*ct.DegradedProcess{
+		{Type: "web", Desired: 2, Actual: 1},
+		{Type: "worker", Desired: 1, Actual: 0},
+	})
+
+	// up jobs of other releases should not be counted
+	oldRelease := s.createTestRelease(c, &ct.Release{Processes: map[string]ct.ProcessType{"web": {}}})
+	createJob(app, oldRelease, "web", ct.JobStateUp)
+	degraded, err = s.c.GetDegradedProcesses(app.ID)
+	c.Assert(err, IsNil)
+	c.Assert(degraded, HasLen, 2)
+}
+
+func (s *S) TestPutFormationIfUnmodifiedSince(c *C) {
+	app := s.createTestApp(c, &ct.App{Name: "put-formation-if-unmodified-since"})
+	release := s.createTestRelease(c, &ct.Release{Processes: map[string]ct.ProcessType{"web": {}}})
+	formation := s.createTestFormation(c, &ct.Formation{AppID: app.ID, ReleaseID: release.ID, Processes: map[string]int{"web": 1}})
+	c.Assert(formation.UpdatedAt, NotNil)
+	updatedAt := *formation.UpdatedAt
+
+	// an update conditional on the current updated_at should succeed
+	update := &ct.Formation{AppID: app.ID, ReleaseID: release.ID, Processes: map[string]int{"web": 2}}
+	c.Assert(s.c.PutFormationIfUnmodifiedSince(update, updatedAt), IsNil)
+
+	// an update conditional on the stale updated_at should fail
+	stale := &ct.Formation{AppID: app.ID, ReleaseID: release.ID, Processes: map[string]int{"web": 3}}
+	err := s.c.PutFormationIfUnmodifiedSince(stale, updatedAt)
+	c.Assert(err, NotNil)
+	c.Assert(hh.IsPreconditionFailedError(err), Equals, true)
+
+	gotFormation, err := s.c.GetFormation(app.ID, release.ID)
+	c.Assert(err, IsNil)
+	c.Assert(gotFormation.Processes, DeepEquals, map[string]int{"web": 2})
+}
+
 func (s *S) TestFormationStreamingInterrupted(c *C) {
 	before := time.Now()
 	appRepo := NewAppRepo(s.hc.db, os.Getenv("DEFAULT_ROUTE_DOMAIN"), s.hc.rc)
@@ -210,3 +494,48 @@ func (s *S) TestFormationStreamingInterrupted(c *C) {
 		c.Fatal("timed out waiting for sendUpdatedSince to finish")
 	}
 }
+
+func (s *S) TestPutFormationMalformedTags(c *C) {
+	app := s.createTestApp(c, &ct.App{Name: "put-formation-malformed-tags"})
+	release := s.createTestRelease(c, &ct.Release{Processes: map[string]ct.ProcessType{"web": {}}})
+
+	put := func(path, body string) *hh.JSONError {
+		req, err := http.NewRequest("PUT", s.srv.URL+path, strings.NewReader(body))
+		c.Assert(err, IsNil)
+		req.SetBasicAuth("", authKey)
+		req.Header.Set("Content-Type", "application/json")
+		res, err := http.DefaultClient.Do(req)
+		c.Assert(err, IsNil)
+		defer res.Body.Close()
+		c.Assert(res.StatusCode, Equals, 400)
+		var jsonErr hh.JSONError
+		c.Assert(json.NewDecoder(res.Body).Decode(&jsonErr), IsNil)
+		return &jsonErr
+	}
+
+	formationPath := fmt.Sprintf("/apps/%s/formations/%s", app.ID, release.ID)
+	for _, t := range []struct {
+		tags  string
+		field string
+	}{
+		{tags: `{"web": "a"}`, field: "tags.web"},
+		{tags: `"web"`, field: "tags"},
+		{tags: `{"web": {"a": 1}}`, field: "tags.web.a"},
+	} {
+		jsonErr := put(formationPath, fmt.Sprintf(`{"processes": {"web": 1}, "tags": %s}`, t.tags))
+		c.Assert(jsonErr.Code, Equals, hh.ValidationErrorCode)
+		var detail map[string]string
+		c.Assert(json.Unmarshal(jsonErr.Detail, &detail), IsNil)
+		c.Assert(detail["field"], Equals, t.field)
+	}
+
+	jsonErr := put("/formations", fmt.Sprintf(`[{"app": %q, "release": %q, "tags": {"web": "a"}}]`, app.ID, release.ID))
+	c.Assert(jsonErr.Code, Equals, hh.ValidationErrorCode)
+	var detail map[string]string
+	c.Assert(json.Unmarshal(jsonErr.Detail, &detail), IsNil)
+	c.Assert(detail["field"], Equals, "formations[0].tags.web")
+
+	// the formation should not have been created
+	_, err := s.c.GetFormation(app.ID, release.ID)
+	c.Assert(err, Equals, controller.ErrNotFound)
+}
@@ -1,14 +1,18 @@
 package main
 
 import (
+	"encoding/json"
 	"errors"
 	"fmt"
 	"io"
 	"net/http"
+	"strconv"
 	"strings"
+	"time"
 
 	"github.com/flynn/flynn/controller/schema"
 	ct "github.com/flynn/flynn/controller/types"
+	"github.com/flynn/flynn/controller/utils"
 	"github.com/flynn/flynn/host/resource"
 	"github.com/flynn/flynn/host/types"
 	"github.com/flynn/flynn/pkg/cluster"
@@ -16,6 +20,7 @@ import (
 	"github.com/flynn/flynn/pkg/httphelper"
 	"github.com/flynn/flynn/pkg/postgres"
 	"github.com/flynn/flynn/pkg/random"
+	"github.com/flynn/que-go"
 	"github.com/jackc/pgx"
 	"golang.org/x/net/context"
 )
@@ -43,6 +48,7 @@ func (r *JobRepo) Get(id string) (*ct.Job, error) {
 
 func (r *JobRepo) Add(job *ct.Job) error {
 	// TODO: actually validate
+	job.Exited = job.IsDown()
 	err := r.db.QueryRow(
 		"job_insert",
 		job.ID,
@@ -97,11 +103,25 @@ func scanJob(s postgres.Scanner) (*ct.Job, error) {
 		return nil, err
 	}
 	job.State = ct.JobState(state)
+	job.Exited = job.IsDown()
 	return job, nil
 }
 
 func (r *JobRepo) List(appID string) ([]*ct.Job, error) {
-	rows, err := r.db.Query("job_list", appID)
+	return r.ListPage(appID, "", 0)
+}
+
+// ListPage lists at most count of the app's jobs which were created before
+// the job with the given beforeID, or from the most recent job if beforeID
+// is empty. A count of zero lists all of the jobs.
+func (r *JobRepo) ListPage(appID, beforeID string, count int) ([]*ct.Job, error) {
+	var rows *pgx.Rows
+	var err error
+	if beforeID == "" {
+		rows, err = r.db.Query("job_list", appID, count)
+	} else {
+		rows, err = r.db.Query("job_list_page", appID, beforeID, count)
+	}
 	if err != nil {
 		return nil, err
 	}
@@ -117,6 +137,28 @@ func (r *JobRepo) List(appID string) ([]*ct.Job, error) {
 	return jobs, nil
 }
 
+// ListByRelease lists the app's jobs grouped by release, with the groups
+// ordered by their most recently created job and the jobs in each group most
+// recent first. Only the ID of each group's release is set.
+func (r *JobRepo) ListByRelease(appID string) ([]*ct.ReleaseJobs, error) {
+	jobs, err := r.List(appID)
+	if err != nil {
+		return nil, err
+	}
+	groups := []*ct.ReleaseJobs{}
+	byRelease := make(map[string]*ct.ReleaseJobs)
+	for _, job := range jobs {
+		group, ok := byRelease[job.ReleaseID]
+		if !ok {
+			group = &ct.ReleaseJobs{Release: &ct.Release{ID: job.ReleaseID}}
+			byRelease[job.ReleaseID] = group
+			groups = append(groups, group)
+		}
+		group.Jobs = append(group.Jobs, job)
+	}
+	return groups, nil
+}
+
 func (r *JobRepo) ListActive() ([]*ct.Job, error) {
 	rows, err := r.db.Query("job_list_active")
 	if err != nil {
@@ -134,9 +176,72 @@ func (r *JobRepo) ListActive() ([]*ct.Job, error) {
 	return jobs, nil
 }
 
+// terminatedJobStates are the states of jobs which are no longer running and
+// so can have their records pruned.
+var terminatedJobStates = []ct.JobState{ct.JobStateDown, ct.JobStateCrashed, ct.JobStateFailed}
+
+// UpCounts returns the number of up jobs of each process type of the given
+// app and release.
+func (r *JobRepo) UpCounts(appID, releaseID string) (map[string]int, error) {
+	rows, err := r.db.Query("job_up_counts", appID, releaseID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	counts := make(map[string]int)
+	for rows.Next() {
+		var typ *string
+		var count int
+		if err := rows.Scan(&typ, &count); err != nil {
+			return nil, err
+		}
+		if typ != nil {
+			counts[*typ] = count
+		}
+	}
+	return counts, rows.Err()
+}
+
+// jobDeleteBatchSize is the maximum number of job records DeleteTerminated
+// deletes in a single call, so pruning a large number of jobs neither holds
+// locks on them all at once nor keeps the request open until they are all
+// deleted. It is a variable so tests can lower it.
+var jobDeleteBatchSize = 1000
+
+// DeleteTerminated deletes up to jobDeleteBatchSize records of jobs in one of
+// the given terminated states which were last updated before the given time,
+// optionally restricted to the given app, returning the number of records
+// deleted and whether any matching records remain to be deleted by a later
+// call.
+func (r *JobRepo) DeleteTerminated(before time.Time, appID string, states []ct.JobState) (int, bool, error) {
+	s := make([]string, len(states))
+	for i, state := range states {
+		s[i] = string(state)
+	}
+	stateArray := fmt.Sprintf("{%s}", strings.Join(s, ","))
+	var count int
+	var more bool
+	err := r.db.QueryRow("job_delete_terminated", before, stateArray, appID, jobDeleteBatchSize).Scan(&count, &more)
+	return count, more, err
+}
+
 func (c *controllerAPI) ListJobs(ctx context.Context, w http.ResponseWriter, req *http.Request) {
 	app := c.getApp(ctx)
-	list, err := c.jobRepo.List(app.ID)
+	beforeID := req.FormValue("before_id")
+	if beforeID != "" && !idPattern.MatchString(beforeID) {
+		respondWithError(w, ct.ValidationError{Field: "before_id", Message: "is invalid"})
+		return
+	}
+	var count int
+	if req.FormValue("count") != "" {
+		var err error
+		count, err = strconv.Atoi(req.FormValue("count"))
+		if err != nil || count < 0 {
+			respondWithError(w, ct.ValidationError{Field: "count", Message: "is invalid"})
+			return
+		}
+	}
+	list, err := c.jobRepo.ListPage(app.ID, beforeID, count)
 	if err != nil {
 		respondWithError(w, err)
 		return
@@ -144,6 +249,29 @@ func (c *controllerAPI) ListJobs(ctx context.Context, w http.ResponseWriter, req
 	httphelper.JSON(w, 200, list)
 }
 
+// ListJobsByRelease responds with the app's jobs grouped by release, which
+// shows jobs of old releases lingering after a deploy.
+func (c *controllerAPI) ListJobsByRelease(ctx context.Context, w http.ResponseWriter, req *http.Request) {
+	groups, err := c.jobRepo.ListByRelease(c.getApp(ctx).ID)
+	if err != nil {
+		respondWithError(w, err)
+		return
+	}
+	for _, group := range groups {
+		release, err := c.releaseRepo.Get(group.Release.ID)
+		if err == ErrNotFound {
+			// the release has since been deleted, so only its ID
+			// is known
+			continue
+		} else if err != nil {
+			respondWithError(w, err)
+			return
+		}
+		group.Release = release.(*ct.Release)
+	}
+	httphelper.JSON(w, 200, groups)
+}
+
 func (c *controllerAPI) ListActiveJobs(ctx context.Context, w http.ResponseWriter, req *http.Request) {
 	list, err := c.jobRepo.ListActive()
 	if err != nil {
@@ -163,6 +291,67 @@ func (c *controllerAPI) GetJob(ctx context.Context, w http.ResponseWriter, req *
 	httphelper.JSON(w, 200, job)
 }
 
+// GetJobNetwork responds with the IP and ports of the job as reported by its
+// host, or null if they are unavailable, for example because the job has not
+// been placed on a host or is no longer running there.
+func (c *controllerAPI) GetJobNetwork(ctx context.Context, w http.ResponseWriter, req *http.Request) {
+	params, _ := ctxhelper.ParamsFromContext(ctx)
+	job, err := c.jobRepo.Get(params.ByName("jobs_id"))
+	if err != nil {
+		respondWithError(w, err)
+		return
+	} else if job.AppID != c.getApp(ctx).ID {
+		respondWithError(w, ErrNotFound)
+		return
+	}
+	network, err := c.jobNetwork(job)
+	if err != nil {
+		respondWithError(w, err)
+		return
+	}
+	httphelper.JSON(w, 200, network)
+}
+
+// jobNetwork returns the network configuration of the job from its host, or
+// nil if the job has not been placed on a host, the host is not in the
+// cluster, or the host is not running the job. Other errors (for example if
+// the host cannot be reached) are returned.
+func (c *controllerAPI) jobNetwork(job *ct.Job) (*ct.JobNetwork, error) {
+	if job.HostID == "" || job.ID == "" {
+		return nil, nil
+	}
+	hosts, err := c.clusterClient.Hosts()
+	if err != nil {
+		return nil, err
+	}
+	var client utils.HostClient
+	for _, h := range hosts {
+		if h.ID() == job.HostID {
+			client = h
+			break
+		}
+	}
+	if client == nil {
+		return nil, nil
+	}
+	activeJob, err := client.GetJob(job.ID)
+	if _, ok := err.(ct.NotFoundError); ok || err == cluster.ErrNotFound {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	} else if activeJob.Job == nil {
+		return nil, nil
+	}
+	network := &ct.JobNetwork{
+		IP:    activeJob.InternalIP,
+		Ports: make([]int, len(activeJob.Job.Config.Ports)),
+	}
+	for i, port := range activeJob.Job.Config.Ports {
+		network.Ports[i] = port.Port
+	}
+	return network, nil
+}
+
 func (c *controllerAPI) PutJob(ctx context.Context, w http.ResponseWriter, req *http.Request) {
 	app := c.getApp(ctx)
 
@@ -186,6 +375,112 @@ func (c *controllerAPI) PutJob(ctx context.Context, w http.ResponseWriter, req *
 	httphelper.JSON(w, 200, &job)
 }
 
+func (c *controllerAPI) PruneJobs(ctx context.Context, w http.ResponseWriter, req *http.Request) {
+	var data ct.PruneJobs
+	if err := httphelper.DecodeJSON(req, &data); err != nil {
+		respondWithError(w, err)
+		return
+	}
+
+	if data.Before == nil {
+		respondWithError(w, ct.ValidationError{Field: "before", Message: "must be set"})
+		return
+	}
+
+	states := terminatedJobStates
+	if data.State != "" {
+		terminated := false
+		for _, state := range terminatedJobStates {
+			if data.State == state {
+				terminated = true
+				break
+			}
+		}
+		if !terminated {
+			respondWithError(w, ct.ValidationError{Field: "state", Message: "must be a terminated job state"})
+			return
+		}
+		states = []ct.JobState{data.State}
+	}
+
+	var appID string
+	if data.AppID != "" {
+		app, err := c.resolveApp(data.AppID)
+		if err != nil {
+			respondWithError(w, err)
+			return
+		}
+		appID = app.ID
+	}
+
+	deleted, more, err := c.jobRepo.DeleteTerminated(*data.Before, appID, states)
+	if err != nil {
+		respondWithError(w, err)
+		return
+	}
+	httphelper.JSON(w, 200, &ct.PruneJobsResult{Deleted: deleted, More: more})
+}
+
+// ScheduleJob enqueues a worker job which runs a one-off job for the app at
+// the requested time, responding with the scheduled job.
+//
+// The one-off job is only created when it runs, as the scheduler marks any
+// job it is not running as down.
+func (c *controllerAPI) ScheduleJob(ctx context.Context, w http.ResponseWriter, req *http.Request) {
+	app := c.getApp(ctx)
+
+	var scheduled ct.ScheduledJob
+	if err := httphelper.DecodeJSON(req, &scheduled); err != nil {
+		respondWithError(w, err)
+		return
+	}
+
+	if scheduled.RunAt == nil {
+		respondWithError(w, ct.ValidationError{Field: "run_at", Message: "must be set"})
+		return
+	} else if !scheduled.RunAt.After(time.Now()) {
+		respondWithError(w, ct.ValidationError{Field: "run_at", Message: "must be in the future"})
+		return
+	}
+
+	if scheduled.ReleaseID == "" {
+		respondWithError(w, ct.ValidationError{Field: "release", Message: "must be set"})
+		return
+	}
+	data, err := c.releaseRepo.Get(scheduled.ReleaseID)
+	if err == ErrNotFound {
+		respondWithError(w, ct.ValidationError{Field: "release", Message: "does not exist"})
+		return
+	} else if err != nil {
+		respondWithError(w, err)
+		return
+	}
+	release := data.(*ct.Release)
+	if ok, err := c.isAppRelease(app.ID, release.ID); err != nil {
+		respondWithError(w, err)
+		return
+	} else if !ok {
+		respondWithError(w, ct.ValidationError{Field: "release", Message: "does not belong to the app"})
+		return
+	}
+
+	scheduled.AppID = app.ID
+	args, err := json.Marshal(&scheduled)
+	if err != nil {
+		respondWithError(w, err)
+		return
+	}
+	if err := c.que.Enqueue(&que.Job{
+		Type:  "scheduled_job",
+		Args:  args,
+		RunAt: *scheduled.RunAt,
+	}); err != nil {
+		respondWithError(w, err)
+		return
+	}
+	httphelper.JSON(w, 200, &scheduled)
+}
+
 func (c *controllerAPI) KillJob(ctx context.Context, w http.ResponseWriter, req *http.Request) {
 	params, _ := ctxhelper.ParamsFromContext(ctx)
 	job, err := c.jobRepo.Get(params.ByName("jobs_id"))
@@ -212,6 +507,165 @@ func (c *controllerAPI) KillJob(ctx context.Context, w http.ResponseWriter, req
 	}
 }
 
+// jobRestartTimeout is how long RestartJob waits for the scheduler to
+// replace the stopped job.
+const jobRestartTimeout = 30 * time.Second
+
+// RestartJob stops an up job which the app's formation expects to be
+// running so that the scheduler replaces it with a new job of the same type
+// (incrementing its restart count), responding with the new job once the
+// scheduler has created it.
+//
+// If the scheduler does not replace the job within jobRestartTimeout, a
+// service unavailable error is returned which is not retryable, as the job
+// has already been stopped.
+func (c *controllerAPI) RestartJob(ctx context.Context, w http.ResponseWriter, req *http.Request) {
+	app := c.getApp(ctx)
+	params, _ := ctxhelper.ParamsFromContext(ctx)
+	job, err := c.jobRepo.Get(params.ByName("jobs_id"))
+	if err != nil {
+		respondWithError(w, err)
+		return
+	} else if job.AppID != app.ID {
+		respondWithError(w, ErrNotFound)
+		return
+	} else if job.State != ct.JobStateUp {
+		httphelper.ValidationError(w, "", "cannot restart a job which is not up")
+		return
+	} else if job.Type == "" {
+		httphelper.ValidationError(w, "", "cannot restart a one-off job")
+		return
+	} else if app.Paused {
+		httphelper.ValidationError(w, "", "cannot restart a job of a paused app")
+		return
+	}
+
+	// the scheduler only replaces stopped jobs which the formation still
+	// expects to be running
+	formation, err := c.formationRepo.Get(app.ID, job.ReleaseID)
+	if err == ErrNotFound || (err == nil && formation.Processes[job.Type] == 0) {
+		httphelper.ValidationError(w, "", "cannot restart a job whose process type is scaled to zero")
+		return
+	} else if err != nil {
+		respondWithError(w, err)
+		return
+	}
+
+	// subscribe before stopping the job so the replacement is not missed
+	if err := c.maybeStartEventListener(); err != nil {
+		respondWithError(w, err)
+		return
+	}
+	sub, err := c.eventListener.Subscribe(app.ID, []string{string(ct.EventTypeJob)}, "")
+	if err != nil {
+		respondWithError(w, err)
+		return
+	}
+	defer sub.Close()
+
+	client, err := c.clusterClient.Host(job.HostID)
+	if err != nil {
+		respondWithError(w, err)
+		return
+	}
+	if err := client.StopJob(job.ID); err != nil {
+		if _, ok := err.(ct.NotFoundError); ok {
+			err = ErrNotFound
+		}
+		respondWithError(w, err)
+		return
+	}
+
+	// the replacement is a new job of the same type and release which,
+	// unlike jobs started by scaling, has its restart count set
+	timeout := time.After(jobRestartTimeout)
+	for {
+		select {
+		case event, ok := <-sub.Events:
+			if !ok {
+				respondWithError(w, sub.Err)
+				return
+			}
+			var newJob ct.Job
+			if err := json.Unmarshal(event.Data, &newJob); err != nil {
+				continue
+			}
+			if newJob.UUID == job.UUID || newJob.Type != job.Type || newJob.ReleaseID != job.ReleaseID || newJob.Restarts == nil {
+				continue
+			}
+			httphelper.JSON(w, 200, &newJob)
+			return
+		case <-timeout:
+			respondWithError(w, httphelper.JSONError{
+				Code:    httphelper.ServiceUnavailableErrorCode,
+				Message: "timed out waiting for the scheduler to replace the stopped job",
+			})
+			return
+		}
+	}
+}
+
+// StopReleaseJobs stops all of the app's up jobs which are running the given
+// release, responding with the jobs which were stopped.
+//
+// The app's formation for the release must be scaled to zero, as otherwise
+// the scheduler would just replace the stopped jobs.
+func (c *controllerAPI) StopReleaseJobs(ctx context.Context, w http.ResponseWriter, req *http.Request) {
+	app := c.getApp(ctx)
+	release, err := c.getRelease(ctx)
+	if err != nil {
+		respondWithError(w, err)
+		return
+	}
+	if ok, err := c.isAppRelease(app.ID, release.ID); err != nil {
+		respondWithError(w, err)
+		return
+	} else if !ok {
+		respondWithError(w, ct.ValidationError{Field: "release", Message: "does not belong to the app"})
+		return
+	}
+	formation, err := c.formationRepo.Get(app.ID, release.ID)
+	if err != nil && err != ErrNotFound {
+		respondWithError(w, err)
+		return
+	}
+	if formation != nil {
+		for _, count := range formation.Processes {
+			if count > 0 {
+				respondWithError(w, ct.ValidationError{Field: "release", Message: "must be scaled to zero before its jobs are stopped"})
+				return
+			}
+		}
+	}
+
+	jobs, err := c.jobRepo.List(app.ID)
+	if err != nil {
+		respondWithError(w, err)
+		return
+	}
+	stopped := make([]*ct.Job, 0, len(jobs))
+	for _, job := range jobs {
+		if job.ReleaseID != release.ID || job.State != ct.JobStateUp || job.HostID == "" {
+			continue
+		}
+		client, err := c.clusterClient.Host(job.HostID)
+		if err != nil {
+			respondWithError(w, err)
+			return
+		}
+		if err := client.StopJob(job.ID); err != nil {
+			// the job has already stopped
+			if _, ok := err.(ct.NotFoundError); ok {
+				continue
+			}
+			respondWithError(w, err)
+			return
+		}
+		stopped = append(stopped, job)
+	}
+	httphelper.JSON(w, 200, stopped)
+}
+
 func (c *controllerAPI) RunJob(ctx context.Context, w http.ResponseWriter, req *http.Request) {
 	var newJob ct.NewJob
 	if err := httphelper.DecodeJSON(req, &newJob); err != nil {
@@ -1,13 +1,18 @@
 package main
 
 import (
+	"errors"
 	"io"
+	"time"
 
+	"github.com/flynn/flynn/controller/client"
 	tu "github.com/flynn/flynn/controller/testutils"
 	ct "github.com/flynn/flynn/controller/types"
 	host "github.com/flynn/flynn/host/types"
 	"github.com/flynn/flynn/pkg/cluster"
+	hh "github.com/flynn/flynn/pkg/httphelper"
 	"github.com/flynn/flynn/pkg/random"
+	"github.com/flynn/flynn/pkg/typeconv"
 	. "github.com/flynn/go-check"
 )
 
@@ -110,6 +115,71 @@ func fakeHostID() string {
 	return random.Hex(16)
 }
 
+func (s *S) TestJobListPage(c *C) {
+	app := s.createTestApp(c, &ct.App{Name: "job-list-page"})
+	release := s.createTestRelease(c, &ct.Release{})
+	jobs := make([]*ct.Job, 5)
+	for i := range jobs {
+		jobs[i] = s.createTestJob(c, &ct.Job{UUID: random.UUID(), AppID: app.ID, ReleaseID: release.ID, Type: "web", State: ct.JobStateUp})
+	}
+
+	// page through the jobs two at a time, most recent first
+	var paged []string
+	opts := ct.ListJobsOptions{Count: 2}
+	for {
+		page, err := s.c.JobListPage(app.ID, opts)
+		c.Assert(err, IsNil)
+		c.Assert(len(page) <= 2, Equals, true)
+		for _, job := range page {
+			paged = append(paged, job.UUID)
+		}
+		if len(page) < opts.Count {
+			break
+		}
+		opts.BeforeID = page[len(page)-1].UUID
+	}
+	c.Assert(paged, HasLen, len(jobs))
+	for i, id := range paged {
+		c.Assert(id, Equals, jobs[len(jobs)-1-i].UUID)
+	}
+
+	_, err := s.c.JobListPage(app.ID, ct.ListJobsOptions{BeforeID: "foo"})
+	c.Assert(hh.IsValidationError(err), Equals, true)
+}
+
+func (s *S) TestJobListByRelease(c *C) {
+	app := s.createTestApp(c, &ct.App{Name: "job-list-by-release"})
+	oldRelease := s.createTestRelease(c, &ct.Release{Env: map[string]string{"VERSION": "1"}})
+	newRelease := s.createTestRelease(c, &ct.Release{Env: map[string]string{"VERSION": "2"}})
+
+	// a lingering job of the old release along with jobs of the new
+	// release which were started after it
+	oldJob := s.createTestJob(c, &ct.Job{UUID: random.UUID(), AppID: app.ID, ReleaseID: oldRelease.ID, Type: "web", State: ct.JobStateUp})
+	newJobs := make([]*ct.Job, 2)
+	for i := range newJobs {
+		newJobs[i] = s.createTestJob(c, &ct.Job{UUID: random.UUID(), AppID: app.ID, ReleaseID: newRelease.ID, Type: "web", State: ct.JobStateUp})
+	}
+
+	groups, err := s.c.JobListByRelease(app.ID)
+	c.Assert(err, IsNil)
+	c.Assert(groups, HasLen, 2)
+	c.Assert(groups[0].Release.ID, Equals, newRelease.ID)
+	c.Assert(groups[0].Release.Env, DeepEquals, newRelease.Env)
+	c.Assert(groups[0].Jobs, HasLen, 2)
+	c.Assert(groups[0].Jobs[0].UUID, Equals, newJobs[1].UUID)
+	c.Assert(groups[0].Jobs[1].UUID, Equals, newJobs[0].UUID)
+	c.Assert(groups[1].Release.ID, Equals, oldRelease.ID)
+	c.Assert(groups[1].Release.Env, DeepEquals, oldRelease.Env)
+	c.Assert(groups[1].Jobs, HasLen, 1)
+	c.Assert(groups[1].Jobs[0].UUID, Equals, oldJob.UUID)
+
+	// an app without jobs has no groups
+	empty := s.createTestApp(c, &ct.App{Name: "job-list-by-release-empty"})
+	groups, err = s.c.JobListByRelease(empty.ID)
+	c.Assert(err, IsNil)
+	c.Assert(groups, HasLen, 0)
+}
+
 func (s *S) TestKillJob(c *C) {
 	app := s.createTestApp(c, &ct.App{Name: "killjob"})
 	release := s.createTestRelease(c, &ct.Release{})
@@ -135,6 +205,219 @@ func (s *S) TestKillJob(c *C) {
 	c.Assert(hc.IsStopped(jobID), Equals, true)
 }
 
+func (s *S) TestGetJobNetwork(c *C) {
+	app := s.createTestApp(c, &ct.App{Name: "job-network"})
+	release := s.createTestRelease(c, &ct.Release{})
+	hostID := fakeHostID()
+	uuid := random.UUID()
+	jobID := cluster.GenerateJobID(hostID, uuid)
+	s.createTestJob(c, &ct.Job{
+		ID:        jobID,
+		UUID:      uuid,
+		HostID:    hostID,
+		AppID:     app.ID,
+		ReleaseID: release.ID,
+		Type:      "web",
+		State:     ct.JobStateUp,
+	})
+
+	// the network is null while the job's host is unknown
+	network, err := s.c.GetJobNetwork(app.ID, jobID)
+	c.Assert(err, IsNil)
+	c.Assert(network, IsNil)
+
+	hc := tu.NewFakeHostClient(hostID, false)
+	s.cc.AddHost(hc)
+
+	// or the host isn't running the job
+	network, err = s.c.GetJobNetwork(app.ID, jobID)
+	c.Assert(err, IsNil)
+	c.Assert(network, IsNil)
+
+	hc.AddJob(&host.Job{ID: jobID, Config: host.ContainerConfig{
+		Ports: []host.Port{{Port: 8080, Proto: "tcp"}, {Port: 8081, Proto: "tcp"}},
+	}})
+	activeJob := hc.Jobs[jobID]
+	activeJob.InternalIP = "10.0.0.2"
+	hc.Jobs[jobID] = activeJob
+
+	network, err = s.c.GetJobNetwork(app.ID, jobID)
+	c.Assert(err, IsNil)
+	c.Assert(network, NotNil)
+	c.Assert(network.IP, Equals, "10.0.0.2")
+	c.Assert(network.Ports, DeepEquals, []int{8080, 8081})
+
+	// errors other than the job not being found are returned
+	hc.GetJobErr = errors.New("connection refused")
+	_, err = s.c.GetJobNetwork(app.ID, jobID)
+	c.Assert(err, NotNil)
+	hc.GetJobErr = nil
+
+	// jobs of other apps are not found
+	other := s.createTestApp(c, &ct.App{Name: "job-network-other"})
+	_, err = s.c.GetJobNetwork(other.ID, jobID)
+	c.Assert(err, Equals, controller.ErrNotFound)
+}
+
+func (s *S) TestStopReleaseJobs(c *C) {
+	app := s.createTestApp(c, &ct.App{Name: "stop-release-jobs"})
+	oldRelease := s.createTestRelease(c, &ct.Release{})
+	newRelease := s.createTestRelease(c, &ct.Release{})
+	s.createTestFormation(c, &ct.Formation{AppID: app.ID, ReleaseID: oldRelease.ID})
+	s.createTestFormation(c, &ct.Formation{AppID: app.ID, ReleaseID: newRelease.ID})
+	hostID := fakeHostID()
+	hc := tu.NewFakeHostClient(hostID, false)
+	s.cc.AddHost(hc)
+
+	createJob := func(release *ct.Release, state ct.JobState) *ct.Job {
+		uuid := random.UUID()
+		job := s.createTestJob(c, &ct.Job{
+			ID:        cluster.GenerateJobID(hostID, uuid),
+			UUID:      uuid,
+			HostID:    hostID,
+			AppID:     app.ID,
+			ReleaseID: release.ID,
+			Type:      "web",
+			State:     state,
+		})
+		hc.AddJob(&host.Job{ID: job.ID})
+		return job
+	}
+	oldJobs := []*ct.Job{createJob(oldRelease, ct.JobStateUp), createJob(oldRelease, ct.JobStateUp)}
+	downJob := createJob(oldRelease, ct.JobStateDown)
+	newJob := createJob(newRelease, ct.JobStateUp)
+
+	stopped, err := s.c.StopReleaseJobs(app.ID, oldRelease.ID)
+	c.Assert(err, IsNil)
+	c.Assert(stopped, HasLen, len(oldJobs))
+	stoppedIDs := make(map[string]struct{}, len(stopped))
+	for _, job := range stopped {
+		stoppedIDs[job.ID] = struct{}{}
+	}
+	for _, job := range oldJobs {
+		c.Assert(hc.IsStopped(job.ID), Equals, true)
+		_, ok := stoppedIDs[job.ID]
+		c.Assert(ok, Equals, true)
+	}
+	c.Assert(hc.IsStopped(downJob.ID), Equals, false)
+	c.Assert(hc.IsStopped(newJob.ID), Equals, false)
+
+	// a release which is not scaled to zero should be rejected
+	s.createTestFormation(c, &ct.Formation{AppID: app.ID, ReleaseID: newRelease.ID, Processes: map[string]int{"web": 1}})
+	_, err = s.c.StopReleaseJobs(app.ID, newRelease.ID)
+	c.Assert(hh.IsValidationError(err), Equals, true)
+	c.Assert(err.(hh.JSONError).Message, Equals, "release must be scaled to zero before its jobs are stopped")
+	c.Assert(hc.IsStopped(newJob.ID), Equals, false)
+
+	// a release which does not belong to the app should be rejected
+	other := s.createTestApp(c, &ct.App{Name: "stop-release-jobs-other"})
+	otherRelease := s.createTestRelease(c, &ct.Release{})
+	s.createTestFormation(c, &ct.Formation{AppID: other.ID, ReleaseID: otherRelease.ID})
+	_, err = s.c.StopReleaseJobs(app.ID, otherRelease.ID)
+	c.Assert(hh.IsValidationError(err), Equals, true)
+}
+
+func (s *S) TestJobExited(c *C) {
+	app := s.createTestApp(c, &ct.App{Name: "job-exited"})
+	release := s.createTestRelease(c, &ct.Release{})
+	job := s.createTestJob(c, &ct.Job{UUID: random.UUID(), AppID: app.ID, ReleaseID: release.ID, Type: "web", State: ct.JobStateUp})
+	c.Assert(job.Exited, Equals, false)
+
+	gotJob, err := s.c.GetJob(app.ID, job.UUID)
+	c.Assert(err, IsNil)
+	c.Assert(gotJob.Exited, Equals, false)
+	c.Assert(gotJob.ExitStatus, IsNil)
+
+	// a stopped job should be exited with its exit status, even if the
+	// exit status is zero
+	for _, status := range []int32{0, 1} {
+		job.State = ct.JobStateDown
+		job.ExitStatus = &status
+		s.createTestJob(c, job)
+		c.Assert(job.Exited, Equals, true)
+
+		gotJob, err = s.c.GetJob(app.ID, job.UUID)
+		c.Assert(err, IsNil)
+		c.Assert(gotJob.Exited, Equals, true)
+		c.Assert(gotJob.ExitStatus, NotNil)
+		c.Assert(*gotJob.ExitStatus, Equals, status)
+	}
+}
+
+func (s *S) TestRestartJob(c *C) {
+	app := s.createTestApp(c, &ct.App{Name: "restart-job"})
+	release := s.createTestRelease(c, &ct.Release{
+		Processes: map[string]ct.ProcessType{"web": {Args: []string{"start", "web"}}},
+	})
+	s.createTestFormation(c, &ct.Formation{AppID: app.ID, ReleaseID: release.ID, Processes: map[string]int{"web": 1}})
+	hostID := fakeHostID()
+	hc := tu.NewFakeHostClient(hostID, false)
+	s.cc.AddHost(hc)
+
+	createJob := func(state ct.JobState) *ct.Job {
+		uuid := random.UUID()
+		job := s.createTestJob(c, &ct.Job{
+			ID:        cluster.GenerateJobID(hostID, uuid),
+			UUID:      uuid,
+			HostID:    hostID,
+			AppID:     app.ID,
+			ReleaseID: release.ID,
+			Type:      "web",
+			State:     state,
+		})
+		hc.AddJob(&host.Job{ID: job.ID})
+		return job
+	}
+
+	// restarting an up job should stop it and respond with the job the
+	// scheduler replaces it with, which is simulated here by persisting a
+	// pending job with an incremented restart count once the job stops
+	job := createJob(ct.JobStateUp)
+	replacement := &ct.Job{
+		UUID:      random.UUID(),
+		AppID:     app.ID,
+		ReleaseID: release.ID,
+		Type:      "web",
+		State:     ct.JobStatePending,
+		Restarts:  typeconv.Int32Ptr(1),
+	}
+	go func() {
+		for i := 0; i < 100 && !hc.IsStopped(job.ID); i++ {
+			time.Sleep(50 * time.Millisecond)
+		}
+		s.c.PutJob(replacement)
+	}()
+	newJob, err := s.c.RestartJob(app.ID, job.ID)
+	c.Assert(err, IsNil)
+	c.Assert(hc.IsStopped(job.ID), Equals, true)
+	c.Assert(newJob.UUID, Equals, replacement.UUID)
+	c.Assert(newJob.UUID, Not(Equals), job.UUID)
+	c.Assert(newJob.Type, Equals, job.Type)
+	c.Assert(newJob.Restarts, NotNil)
+	c.Assert(*newJob.Restarts, Equals, int32(1))
+
+	// restarting a down job should fail
+	job = createJob(ct.JobStateDown)
+	_, err = s.c.RestartJob(app.ID, job.ID)
+	c.Assert(hh.IsValidationError(err), Equals, true)
+	c.Assert(err.(hh.JSONError).Message, Equals, "cannot restart a job which is not up")
+	c.Assert(hc.IsStopped(job.ID), Equals, false)
+
+	// restarting another app's job should fail
+	otherApp := s.createTestApp(c, &ct.App{Name: "restart-job-other"})
+	job = createJob(ct.JobStateUp)
+	_, err = s.c.RestartJob(otherApp.ID, job.ID)
+	c.Assert(err, Equals, controller.ErrNotFound)
+
+	// restarting a job whose process type is scaled to zero should fail
+	// as the scheduler would not replace it
+	s.createTestFormation(c, &ct.Formation{AppID: app.ID, ReleaseID: release.ID, Processes: map[string]int{"web": 0}})
+	_, err = s.c.RestartJob(app.ID, job.ID)
+	c.Assert(hh.IsValidationError(err), Equals, true)
+	c.Assert(err.(hh.JSONError).Message, Equals, "cannot restart a job whose process type is scaled to zero")
+	c.Assert(hc.IsStopped(job.ID), Equals, false)
+}
+
 func (s *S) TestRunJobDetached(c *C) {
 	app := s.createTestApp(c, &ct.App{Name: "run-detached"})
 	artifact := s.createTestArtifact(c, &ct.Artifact{Type: host.ArtifactTypeDocker, URI: "docker://foo/bar"})
@@ -187,6 +470,109 @@ func (s *S) TestRunJobDetached(c *C) {
 	}
 }
 
+func (s *S) TestScheduleJob(c *C) {
+	app := s.createTestApp(c, &ct.App{Name: "schedule-job"})
+	release := s.createTestRelease(c, &ct.Release{})
+	c.Assert(s.c.SetAppRelease(app.ID, release.ID), IsNil)
+
+	runAt := time.Now().Add(time.Hour).UTC().Truncate(time.Second)
+	args := []string{"foo", "bar"}
+	job, err := s.c.ScheduleJob(app.ID, &ct.ScheduledJob{
+		ReleaseID: release.ID,
+		Args:      args,
+		RunAt:     &runAt,
+	})
+	c.Assert(err, IsNil)
+	c.Assert(job.AppID, Equals, app.ID)
+	c.Assert(job.ReleaseID, Equals, release.ID)
+	c.Assert(job.Args, DeepEquals, args)
+	c.Assert(job.RunAt.Equal(runAt), Equals, true)
+
+	// the job is run by the worker, so check it was enqueued to run at
+	// the requested time rather than created straight away
+	var queRunAt time.Time
+	c.Assert(s.hc.db.QueryRow("SELECT run_at FROM que_jobs WHERE job_class = 'scheduled_job' AND args->>'app' = $1", app.ID).Scan(&queRunAt), IsNil)
+	c.Assert(queRunAt.Equal(runAt), Equals, true)
+
+	jobs, err := s.c.JobList(app.ID)
+	c.Assert(err, IsNil)
+	c.Assert(jobs, HasLen, 0)
+
+	// releases which do not belong to the app cannot be scheduled
+	other := s.createTestRelease(c, &ct.Release{})
+	_, err = s.c.ScheduleJob(app.ID, &ct.ScheduledJob{
+		ReleaseID: other.ID,
+		RunAt:     &runAt,
+	})
+	c.Assert(hh.IsValidationError(err), Equals, true)
+	c.Assert(err.(hh.JSONError).Message, Equals, "release does not belong to the app")
+}
+
+func (s *S) TestScheduleJobInPast(c *C) {
+	app := s.createTestApp(c, &ct.App{Name: "schedule-job-past"})
+	release := s.createTestRelease(c, &ct.Release{})
+
+	runAt := time.Now().Add(-time.Minute)
+	_, err := s.c.ScheduleJob(app.ID, &ct.ScheduledJob{
+		ReleaseID: release.ID,
+		RunAt:     &runAt,
+	})
+	c.Assert(hh.IsValidationError(err), Equals, true)
+	c.Assert(err.(hh.JSONError).Message, Equals, "run_at must be in the future")
+
+	jobs, err := s.c.JobList(app.ID)
+	c.Assert(err, IsNil)
+	c.Assert(jobs, HasLen, 0)
+}
+
+func (s *S) TestPruneJobs(c *C) {
+	app := s.createTestApp(c, &ct.App{Name: "prune-jobs"})
+	otherApp := s.createTestApp(c, &ct.App{Name: "prune-jobs-other"})
+	release := s.createTestRelease(c, &ct.Release{})
+
+	createJob := func(appID string, state ct.JobState, age time.Duration) *ct.Job {
+		job := s.createTestJob(c, &ct.Job{UUID: random.UUID(), AppID: appID, ReleaseID: release.ID, Type: "web", State: state})
+		c.Assert(s.hc.db.Exec("UPDATE job_cache SET updated_at = $2 WHERE job_id = $1", job.UUID, time.Now().Add(-age)), IsNil)
+		return job
+	}
+	oldDown := createJob(app.ID, ct.JobStateDown, time.Hour)
+	oldUp := createJob(app.ID, ct.JobStateUp, time.Hour)
+	oldPending := createJob(app.ID, ct.JobStatePending, time.Hour)
+	recentDown := createJob(app.ID, ct.JobStateDown, 0)
+	otherDown := createJob(otherApp.ID, ct.JobStateDown, time.Hour)
+
+	// active job states cannot be pruned
+	before := time.Now().Add(-time.Minute)
+	_, err := s.c.PruneJobs(&ct.PruneJobs{Before: &before, State: ct.JobStateUp})
+	c.Assert(hh.IsValidationError(err), Equals, true)
+	c.Assert(err.(hh.JSONError).Message, Equals, "state must be a terminated job state")
+
+	res, err := s.c.PruneJobs(&ct.PruneJobs{Before: &before, AppID: app.Name})
+	c.Assert(err, IsNil)
+	c.Assert(res.Deleted, Equals, 1)
+	c.Assert(res.More, Equals, false)
+
+	_, err = s.c.GetJob(app.ID, oldDown.UUID)
+	c.Assert(err, Equals, controller.ErrNotFound)
+	for _, job := range []*ct.Job{oldUp, oldPending, recentDown, otherDown} {
+		_, err = s.c.GetJob(job.AppID, job.UUID)
+		c.Assert(err, IsNil)
+	}
+
+	// each request deletes a single batch, reporting whether more remain
+	createJob(otherApp.ID, ct.JobStateCrashed, time.Hour)
+	defer func(size int) { jobDeleteBatchSize = size }(jobDeleteBatchSize)
+	jobDeleteBatchSize = 1
+	res, err = s.c.PruneJobs(&ct.PruneJobs{Before: &before, AppID: otherApp.ID})
+	c.Assert(err, IsNil)
+	c.Assert(res.Deleted, Equals, 1)
+	c.Assert(res.More, Equals, true)
+	res, err = s.c.PruneJobs(&ct.PruneJobs{Before: &before, AppID: otherApp.ID})
+	c.Assert(err, IsNil)
+	c.Assert(res.Deleted, Equals, 1)
+	c.Assert(res.More, Equals, false)
+}
+
 func (s *S) TestRunJobAttached(c *C) {
 	app := s.createTestApp(c, &ct.App{Name: "run-attached"})
 	hostID := fakeHostID()
@@ -0,0 +1,67 @@
+package main
+
+import (
+	"net/http"
+
+	"github.com/flynn/flynn/pkg/httphelper"
+	"golang.org/x/net/context"
+)
+
+// errServerBusy is returned for expensive requests made while the limit of
+// concurrent expensive requests or deploys is reached.
+var errServerBusy = httphelper.JSONError{
+	Code:    httphelper.ServiceUnavailableErrorCode,
+	Message: "server busy, too many deploys and provisions in progress",
+	Retry:   true,
+}
+
+// limitExpensive wraps a handler for a request which is expensive to serve
+// (for example one which provisions a resource) so that only a limited number
+// run concurrently, rejecting requests over the limit with a server busy
+// error rather than queueing them behind slow ones.
+//
+// Requests are not limited if the limit is zero.
+func (c *controllerAPI) limitExpensive(handler httphelper.HandlerFunc) httphelper.HandlerFunc {
+	return func(ctx context.Context, w http.ResponseWriter, req *http.Request) {
+		if c.expensiveRequests == nil {
+			handler(ctx, w, req)
+			return
+		}
+		select {
+		case c.expensiveRequests <- struct{}{}:
+			defer func() { <-c.expensiveRequests }()
+		default:
+			respondWithError(w, errServerBusy)
+			return
+		}
+		handler(ctx, w, req)
+	}
+}
+
+// limitDeploys wraps a handler for a request which starts a deploy (or
+// otherwise changes which jobs are run for an app), rejecting it with a
+// server busy error if the limit of unfinished deployments is reached.
+//
+// Deploys are performed by the deployer after the request has been served,
+// so rather than limiting concurrent requests the deployments which the
+// deployer has not yet finished are counted, across all controllers. The
+// count is checked before the handler runs, so concurrent requests may
+// exceed the limit slightly.
+//
+// Requests are not limited if the limit is zero.
+func (c *controllerAPI) limitDeploys(handler httphelper.HandlerFunc) httphelper.HandlerFunc {
+	return func(ctx context.Context, w http.ResponseWriter, req *http.Request) {
+		if c.config.deployLimit > 0 {
+			count, err := c.deploymentRepo.CountInProgress()
+			if err != nil {
+				respondWithError(w, err)
+				return
+			}
+			if count >= c.config.deployLimit {
+				respondWithError(w, errServerBusy)
+				return
+			}
+		}
+		handler(ctx, w, req)
+	}
+}
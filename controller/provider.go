@@ -2,10 +2,15 @@ package main
 
 import (
 	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
 
 	ct "github.com/flynn/flynn/controller/types"
+	"github.com/flynn/flynn/pkg/httphelper"
 	"github.com/flynn/flynn/pkg/postgres"
 	"github.com/jackc/pgx"
+	"golang.org/x/net/context"
 )
 
 type ProviderRepo struct {
@@ -24,14 +29,82 @@ func (r *ProviderRepo) Add(data interface{}) error {
 	if p.URL == "" {
 		return errors.New("controller: url must not be blank")
 	}
-	// TODO: validate url
+	if err := validateProviderURL(p.URL); err != nil {
+		return err
+	}
 	tx, err := r.db.Begin()
 	if err != nil {
 		return err
 	}
-	err = tx.QueryRow("provider_insert", p.Name, p.URL).Scan(&p.ID, &p.CreatedAt, &p.UpdatedAt)
+	if err := insertProvider(tx, p); err != nil {
+		tx.Rollback()
+		return err
+	}
+	return tx.Commit()
+}
+
+// AddWithResource registers the provider and adds a resource it has
+// provisioned in a single transaction, so the provider is only registered if
+// the resource is successfully added.
+func (r *ProviderRepo) AddWithResource(p *ct.Provider, res *ct.Resource) error {
+	if p.Name == "" {
+		return ct.ValidationError{Field: "name", Message: "must not be blank"}
+	}
+	if err := validateProviderURL(p.URL); err != nil {
+		return err
+	}
+	tx, err := r.db.Begin()
 	if err != nil {
+		return err
+	}
+	if err := insertProvider(tx, p); err != nil {
 		tx.Rollback()
+		if postgres.IsUniquenessError(err, "") {
+			return httphelper.ObjectExistsErr(fmt.Sprintf("provider with name %q or url %q already exists", p.Name, p.URL))
+		}
+		return err
+	}
+	res.ProviderID = p.ID
+	if err := insertResource(tx, res); err != nil {
+		tx.Rollback()
+		return err
+	}
+	return tx.Commit()
+}
+
+// insertProvider registers the provider as part of the given transaction,
+// which the caller must roll back on error.
+func insertProvider(tx *postgres.DBTx, p *ct.Provider) error {
+	if err := tx.QueryRow("provider_insert", p.Name, p.URL).Scan(&p.ID, &p.CreatedAt, &p.UpdatedAt); err != nil {
+		return err
+	}
+	return createEvent(tx.Exec, &ct.Event{
+		ObjectID:   p.ID,
+		ObjectType: ct.EventTypeProvider,
+	}, p)
+}
+
+// Update changes the name and URL of an existing provider. Resources refer
+// to providers by ID, so they continue to use the provider at its new URL.
+func (r *ProviderRepo) Update(p *ct.Provider) error {
+	if p.Name == "" {
+		return ct.ValidationError{Field: "name", Message: "must not be blank"}
+	}
+	if err := validateProviderURL(p.URL); err != nil {
+		return err
+	}
+	tx, err := r.db.Begin()
+	if err != nil {
+		return err
+	}
+	err = tx.QueryRow("provider_update", p.ID, p.Name, p.URL).Scan(&p.CreatedAt, &p.UpdatedAt)
+	if err != nil {
+		tx.Rollback()
+		if err == pgx.ErrNoRows {
+			return ErrNotFound
+		} else if postgres.IsUniquenessError(err, "") {
+			return httphelper.ObjectExistsErr(fmt.Sprintf("provider with name %q or url %q already exists", p.Name, p.URL))
+		}
 		return err
 	}
 	if err := createEvent(tx.Exec, &ct.Event{
@@ -44,6 +117,15 @@ func (r *ProviderRepo) Add(data interface{}) error {
 	return tx.Commit()
 }
 
+// validateProviderURL checks that u is an absolute HTTP(S) URL.
+func validateProviderURL(u string) error {
+	parsed, err := url.Parse(u)
+	if err != nil || (parsed.Scheme != "http" && parsed.Scheme != "https") || parsed.Host == "" {
+		return ct.ValidationError{Field: "url", Message: "must be a valid http or https URL"}
+	}
+	return nil
+}
+
 func scanProvider(s postgres.Scanner) (*ct.Provider, error) {
 	p := &ct.Provider{}
 	err := s.Scan(&p.ID, &p.Name, &p.URL, &p.CreatedAt, &p.UpdatedAt)
@@ -63,6 +145,11 @@ func (r *ProviderRepo) Get(id string) (interface{}, error) {
 	return scanProvider(row)
 }
 
+// GetByURL returns the provider with the given URL.
+func (r *ProviderRepo) GetByURL(url string) (*ct.Provider, error) {
+	return scanProvider(r.db.QueryRow("provider_select_by_url", url))
+}
+
 func (r *ProviderRepo) List() (interface{}, error) {
 	rows, err := r.db.Query("provider_list")
 	if err != nil {
@@ -79,3 +166,29 @@ func (r *ProviderRepo) List() (interface{}, error) {
 	}
 	return providers, rows.Err()
 }
+
+// UpdateProvider updates the name and URL of a provider, keeping its ID so
+// that existing resources are not orphaned.
+func (c *controllerAPI) UpdateProvider(ctx context.Context, w http.ResponseWriter, req *http.Request) {
+	p, err := c.getProvider(ctx)
+	if err != nil {
+		respondWithError(w, err)
+		return
+	}
+
+	var data ct.Provider
+	if err := httphelper.DecodeJSON(req, &data); err != nil {
+		respondWithError(w, err)
+		return
+	}
+	if data.Name != "" {
+		p.Name = data.Name
+	}
+	p.URL = data.URL
+
+	if err := c.providerRepo.Update(p); err != nil {
+		respondWithError(w, err)
+		return
+	}
+	httphelper.JSON(w, 200, p)
+}
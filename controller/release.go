@@ -4,10 +4,13 @@ import (
 	"encoding/json"
 	"fmt"
 	"net/http"
+	"sort"
+	"time"
 
 	"github.com/flynn/flynn/controller/schema"
 	ct "github.com/flynn/flynn/controller/types"
 	"github.com/flynn/flynn/host/resource"
+	"github.com/flynn/flynn/pkg/ctxhelper"
 	"github.com/flynn/flynn/pkg/httphelper"
 	"github.com/flynn/flynn/pkg/postgres"
 	"github.com/flynn/flynn/pkg/random"
@@ -21,6 +24,10 @@ type ReleaseRepo struct {
 	artifacts  *ArtifactRepo
 	formations *FormationRepo
 	que        *que.Client
+
+	// cache is an optional cache of releases which is nil unless
+	// enabled in the handler config
+	cache *objectCache
 }
 
 func NewReleaseRepo(db *postgres.DB, artifacts *ArtifactRepo, que *que.Client) *ReleaseRepo {
@@ -31,10 +38,11 @@ func NewReleaseRepo(db *postgres.DB, artifacts *ArtifactRepo, que *que.Client) *
 	}
 }
 
-func scanRelease(s postgres.Scanner) (*ct.Release, error) {
+func scanRelease(s postgres.Scanner, extra ...interface{}) (*ct.Release, error) {
 	var artifactIDs string
 	release := &ct.Release{}
-	err := s.Scan(&release.ID, &artifactIDs, &release.Env, &release.Processes, &release.Meta, &release.CreatedAt)
+	dest := []interface{}{&release.ID, &artifactIDs, &release.Env, &release.Processes, &release.Meta, &release.CreatedAt}
+	err := s.Scan(append(dest, extra...)...)
 	if err != nil {
 		if err == pgx.ErrNoRows {
 			err = ErrNotFound
@@ -51,6 +59,108 @@ func scanRelease(s postgres.Scanner) (*ct.Release, error) {
 func (r *ReleaseRepo) Add(data interface{}) error {
 	release := data.(*ct.Release)
 
+	if err := r.Validate(release); err != nil {
+		return err
+	}
+	prepareRelease(release)
+	if release.ID == "" {
+		release.ID = random.UUID()
+	}
+	return r.insert(release, "release_insert", release.ID, release.Env, release.Processes, release.Meta)
+}
+
+// Import inserts a release which was exported from another cluster along
+// with the given artifacts in a single transaction, preserving the release's
+// ID and creation time. The release's artifacts must either already exist or
+// be included, and included artifacts keep their IDs unless an artifact with
+// the same ID, or with the same type and URI, already exists.
+func (r *ReleaseRepo) Import(release *ct.Release, artifacts []*ct.Artifact) error {
+	if release.ID == "" {
+		return ct.ValidationError{Field: "id", Message: "must not be empty"}
+	}
+	if !idPattern.MatchString(release.ID) {
+		return ct.ValidationError{Field: "id", Message: "is not a valid ID"}
+	}
+	if err := r.validateImport(release, artifacts); err != nil {
+		return err
+	}
+	prepareRelease(release)
+	createdAt := time.Now()
+	if release.CreatedAt != nil {
+		createdAt = *release.CreatedAt
+	}
+
+	tx, err := r.db.Begin()
+	if err != nil {
+		return err
+	}
+	for _, artifact := range artifacts {
+		id := artifact.ID
+		if id != "" {
+			if _, err := scanArtifact(tx.QueryRow("artifact_select", id)); err == nil {
+				continue
+			} else if err != ErrNotFound {
+				tx.Rollback()
+				return err
+			}
+		}
+		if err := insertArtifact(tx, artifact); err != nil {
+			tx.Rollback()
+			return err
+		}
+		// an existing artifact with the same type and URI is reused
+		// rather than inserted, so reference it instead
+		if id != artifact.ID {
+			for i, artifactID := range release.ArtifactIDs {
+				if artifactID == id {
+					release.ArtifactIDs[i] = artifact.ID
+				}
+			}
+		}
+	}
+	if err := insertRelease(tx, release, "release_import", release.ID, release.Env, release.Processes, release.Meta, createdAt); err != nil {
+		tx.Rollback()
+		return err
+	}
+	return tx.Commit()
+}
+
+// validateImport is like Validate but also accepts references to the
+// artifacts being imported along with the release.
+func (r *ReleaseRepo) validateImport(release *ct.Release, artifacts []*ct.Artifact) error {
+	included := make(map[string]struct{}, len(artifacts))
+	for _, artifact := range artifacts {
+		included[artifact.ID] = struct{}{}
+	}
+	artifactIDs := release.ArtifactIDs
+	if len(artifactIDs) == 0 && release.LegacyArtifactID != "" {
+		artifactIDs = []string{release.LegacyArtifactID}
+	}
+	validIDs := make([]string, 0, len(artifactIDs))
+	for _, id := range artifactIDs {
+		if idPattern.MatchString(id) {
+			validIDs = append(validIDs, id)
+		}
+	}
+	existing, err := r.artifacts.ListIDs(validIDs...)
+	if err != nil {
+		return err
+	}
+	var errs ct.ValidationErrors
+	for i, id := range artifactIDs {
+		if _, ok := existing[id]; ok {
+			continue
+		}
+		if _, ok := included[id]; !ok || id == "" {
+			errs = append(errs, ct.ValidationError{Field: fmt.Sprintf("artifacts[%d]", i), Message: "does not exist"})
+		}
+	}
+	return append(errs, validateReleaseConfig(release)...).Err()
+}
+
+// prepareRelease handles deprecated process type fields and sets default
+// resources before a release is inserted.
+func prepareRelease(release *ct.Release) {
 	for typ, proc := range release.Processes {
 		// handle deprecated Entrypoint and Cmd
 		if len(proc.DeprecatedEntrypoint) > 0 {
@@ -62,35 +172,37 @@ func (r *ReleaseRepo) Add(data interface{}) error {
 		resource.SetDefaults(&proc.Resources)
 		release.Processes[typ] = proc
 	}
-
-	if release.ID == "" {
-		release.ID = random.UUID()
-	}
 	if release.LegacyArtifactID != "" && len(release.ArtifactIDs) == 0 {
 		release.ArtifactIDs = []string{release.LegacyArtifactID}
 	}
+}
 
-	if value, ok := release.Env[""]; ok {
-		return ct.ValidationError{
-			Field:   "env",
-			Message: fmt.Sprintf("you can't create an env var with an empty key (tried to set \"\"=%q)", value),
-		}
-	}
-
+// insert inserts the release using the given query, which must return the
+// release's creation time, along with its artifacts and a release event.
+func (r *ReleaseRepo) insert(release *ct.Release, query string, args ...interface{}) error {
 	tx, err := r.db.Begin()
 	if err != nil {
 		return err
 	}
-
-	err = tx.QueryRow("release_insert", release.ID, release.Env, release.Processes, release.Meta).Scan(&release.CreatedAt)
-	if err != nil {
+	if err := insertRelease(tx, release, query, args...); err != nil {
 		tx.Rollback()
 		return err
 	}
+	return tx.Commit()
+}
+
+// insertRelease inserts the release like ReleaseRepo.insert but as part of
+// the given transaction, which the caller must roll back on error.
+func insertRelease(tx *postgres.DBTx, release *ct.Release, query string, args ...interface{}) error {
+	if err := tx.QueryRow(query, args...).Scan(&release.CreatedAt); err != nil {
+		if postgres.IsUniquenessError(err, "") {
+			return httphelper.ObjectExistsErr(fmt.Sprintf("release %q already exists", release.ID))
+		}
+		return err
+	}
 
 	for i, artifactID := range release.ArtifactIDs {
 		if err := tx.Exec("release_artifacts_insert", release.ID, artifactID, i); err != nil {
-			tx.Rollback()
 			if e, ok := err.(pgx.PgError); ok && e.Code == postgres.CheckViolation {
 				return ct.ValidationError{
 					Field:   "artifacts",
@@ -101,20 +213,113 @@ func (r *ReleaseRepo) Add(data interface{}) error {
 		}
 	}
 
-	if err := createEvent(tx.Exec, &ct.Event{
+	return createEvent(tx.Exec, &ct.Event{
 		ObjectID:   release.ID,
 		ObjectType: ct.EventTypeRelease,
-	}, release); err != nil {
-		tx.Rollback()
+	}, release)
+}
+
+// Validate checks the release's artifacts, env and process types, returning
+// all of the problems found rather than just the first.
+func (r *ReleaseRepo) Validate(data interface{}) error {
+	release := data.(*ct.Release)
+	var errs ct.ValidationErrors
+
+	artifactIDs := release.ArtifactIDs
+	if len(artifactIDs) == 0 && release.LegacyArtifactID != "" {
+		artifactIDs = []string{release.LegacyArtifactID}
+	}
+	validIDs := make([]string, 0, len(artifactIDs))
+	for _, id := range artifactIDs {
+		if idPattern.MatchString(id) {
+			validIDs = append(validIDs, id)
+		}
+	}
+	artifacts, err := r.artifacts.ListIDs(validIDs...)
+	if err != nil {
 		return err
 	}
+	for i, id := range artifactIDs {
+		if _, ok := artifacts[id]; !ok {
+			errs = append(errs, ct.ValidationError{Field: fmt.Sprintf("artifacts[%d]", i), Message: "does not exist"})
+		}
+	}
 
-	return tx.Commit()
+	return append(errs, validateReleaseConfig(release)...).Err()
+}
+
+// validateReleaseConfig checks the release's env and process types.
+func validateReleaseConfig(release *ct.Release) (errs ct.ValidationErrors) {
+	if value, ok := release.Env[""]; ok {
+		errs = append(errs, ct.ValidationError{
+			Field:   "env",
+			Message: fmt.Sprintf("you can't create an env var with an empty key (tried to set \"\"=%q)", value),
+		})
+	}
+
+	types := make([]string, 0, len(release.Processes))
+	for typ := range release.Processes {
+		types = append(types, typ)
+	}
+	sort.Strings(types)
+	for _, typ := range types {
+		errs = append(errs, validateProcessType(typ, release.Processes[typ])...)
+	}
+	return errs
+}
+
+// validateProcessType checks that the ports and resource limits of the given
+// process type are valid.
+func validateProcessType(typ string, proc ct.ProcessType) (errs ct.ValidationErrors) {
+	if typ == "" {
+		errs = append(errs, ct.ValidationError{Field: "processes", Message: "must not contain an empty process type"})
+	}
+	for i, port := range proc.Ports {
+		field := fmt.Sprintf("processes.%s.ports[%d]", typ, i)
+		if port.Port < 0 || port.Port > 65535 {
+			errs = append(errs, ct.ValidationError{Field: field + ".port", Message: "must be between 0 and 65535"})
+		}
+		if port.Proto != "tcp" && port.Proto != "udp" {
+			errs = append(errs, ct.ValidationError{Field: field + ".proto", Message: `must be either "tcp" or "udp"`})
+		}
+	}
+	names := make([]string, 0, len(proc.Resources))
+	for name := range proc.Resources {
+		names = append(names, string(name))
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		spec := proc.Resources[resource.Type(name)]
+		field := fmt.Sprintf("processes.%s.resources.%s", typ, name)
+		switch resource.Type(name) {
+		case resource.TypeMemory, resource.TypeCPU, resource.TypeMaxFD, resource.TypeMaxProcs:
+		default:
+			errs = append(errs, ct.ValidationError{Field: field, Message: "is not a known resource type"})
+			continue
+		}
+		if spec.Request != nil && *spec.Request < 0 {
+			errs = append(errs, ct.ValidationError{Field: field + ".request", Message: "must not be negative"})
+		}
+		if spec.Limit != nil && *spec.Limit < 0 {
+			errs = append(errs, ct.ValidationError{Field: field + ".limit", Message: "must not be negative"})
+		}
+		if spec.Request != nil && spec.Limit != nil && *spec.Request > *spec.Limit {
+			errs = append(errs, ct.ValidationError{Field: field + ".request", Message: "must not be greater than the limit"})
+		}
+	}
+	return errs
 }
 
 func (r *ReleaseRepo) Get(id string) (interface{}, error) {
+	if v, ok := r.cache.Get(id); ok {
+		return copyRelease(v.(*ct.Release)), nil
+	}
 	row := r.db.QueryRow("release_select", id)
-	return scanRelease(row)
+	release, err := scanRelease(row)
+	if err == nil && r.cache != nil {
+		r.cache.Add(release.ID, copyRelease(release))
+	}
+	return release, err
 }
 
 func releaseList(rows *pgx.Rows) ([]*ct.Release, error) {
@@ -146,11 +351,97 @@ func (r *ReleaseRepo) AppList(appID string) ([]*ct.Release, error) {
 	return releaseList(rows)
 }
 
+// InUse returns whether the release is the current release of any app.
+func (r *ReleaseRepo) InUse(releaseID string) (bool, error) {
+	var inUse bool
+	err := r.db.QueryRow("release_in_use", releaseID).Scan(&inUse)
+	return inUse, err
+}
+
+// ListDeleted returns releases which have been deleted, most recently
+// deleted first, optionally limited to those deleted from the given app.
+func (r *ReleaseRepo) ListDeleted(appID string) ([]*ct.Release, error) {
+	var rows *pgx.Rows
+	var err error
+	if appID != "" {
+		rows, err = r.db.Query("release_app_list_deleted", appID)
+	} else {
+		rows, err = r.db.Query("release_list_deleted")
+	}
+	if err != nil {
+		return nil, err
+	}
+	var releases []*ct.Release
+	for rows.Next() {
+		var deletedAt time.Time
+		release, err := scanRelease(rows, &deletedAt)
+		if err != nil {
+			rows.Close()
+			return nil, err
+		}
+		release.DeletedAt = &deletedAt
+		releases = append(releases, release)
+	}
+	return releases, rows.Err()
+}
+
+// AppIDs returns the IDs of the apps which either have a formation for the
+// given release or are currently using it.
+func (r *ReleaseRepo) AppIDs(releaseID string) ([]string, error) {
+	rows, err := r.db.Query("release_app_ids", releaseID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var ids []string
+	for rows.Next() {
+		var id string
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+	return ids, rows.Err()
+}
+
+// GetPrevious returns the release which was the app's current release before
+// the given release most recently became current, which is nil if there was
+// none, or ErrNotFound if the release has never been current for the app.
+// Previous releases which have since been deleted only have their ID set.
+func (r *ReleaseRepo) GetPrevious(appID, releaseID string) (*ct.Release, error) {
+	var prevID *string
+	if err := r.db.QueryRow("release_select_previous_id", appID, releaseID).Scan(&prevID); err != nil {
+		if err == pgx.ErrNoRows {
+			err = ErrNotFound
+		}
+		return nil, err
+	}
+	if prevID == nil {
+		return nil, nil
+	}
+	release, err := r.Get(*prevID)
+	if err == ErrNotFound {
+		return &ct.Release{ID: *prevID}, nil
+	} else if err != nil {
+		return nil, err
+	}
+	return release.(*ct.Release), nil
+}
+
 // Delete deletes any formations for the given app and release, then deletes
 // the release and any associated file artifacts if there are no remaining
 // formations for the release, enqueueing a worker job to delete any files
 // stored in the blobstore
 func (r *ReleaseRepo) Delete(app *ct.App, release *ct.Release) error {
+	if err := r.delete(app, release); err != nil {
+		return err
+	}
+	r.cache.Remove(release.ID)
+	r.artifacts.cache.Remove(release.FileArtifactIDs()...)
+	return nil
+}
+
+func (r *ReleaseRepo) delete(app *ct.App, release *ct.Release) error {
 	tx, err := r.db.Begin()
 	if err != nil {
 		return err
@@ -289,6 +580,22 @@ func (c *controllerAPI) GetAppReleases(ctx context.Context, w http.ResponseWrite
 	httphelper.JSON(w, 200, list)
 }
 
+// GetDeletedReleases responds with releases which have been deleted, or only
+// those deleted from the app given by the app_id query parameter.
+func (c *controllerAPI) GetDeletedReleases(ctx context.Context, w http.ResponseWriter, req *http.Request) {
+	appID := req.FormValue("app_id")
+	if appID != "" && !idPattern.MatchString(appID) {
+		respondWithError(w, ct.ValidationError{Field: "app_id", Message: "is not a valid ID"})
+		return
+	}
+	list, err := c.releaseRepo.ListDeleted(appID)
+	if err != nil {
+		respondWithError(w, err)
+		return
+	}
+	httphelper.JSON(w, 200, list)
+}
+
 func (c *controllerAPI) SetAppRelease(ctx context.Context, w http.ResponseWriter, req *http.Request) {
 	var rid releaseID
 	if err := httphelper.DecodeJSON(req, &rid); err != nil {
@@ -318,12 +625,469 @@ func (c *controllerAPI) SetAppRelease(ctx context.Context, w http.ResponseWriter
 	httphelper.JSON(w, 200, release)
 }
 
+// SetCurrentRelease immediately switches the app to the given release
+// without a deployment, moving the process counts of the app's current
+// formation to the new release.
+func (c *controllerAPI) SetCurrentRelease(ctx context.Context, w http.ResponseWriter, req *http.Request) {
+	app := c.getApp(ctx)
+
+	var rid releaseID
+	if err := httphelper.DecodeJSON(req, &rid); err != nil {
+		respondWithError(w, err)
+		return
+	}
+	data, err := c.releaseRepo.Get(rid.ID)
+	if err != nil {
+		if err == ErrNotFound {
+			err = ct.ValidationError{
+				Field:   "release",
+				Message: fmt.Sprintf("could not find release with ID %s", rid.ID),
+			}
+		}
+		respondWithError(w, err)
+		return
+	}
+	release := data.(*ct.Release)
+
+	// releases are not directly owned by apps, so consider the release
+	// to belong to the app unless it is only used by other apps
+	appIDs, err := c.releaseRepo.AppIDs(release.ID)
+	if err != nil {
+		respondWithError(w, err)
+		return
+	}
+	if len(appIDs) > 0 {
+		var found bool
+		for _, id := range appIDs {
+			if id == app.ID {
+				found = true
+				break
+			}
+		}
+		if !found {
+			respondWithError(w, ct.ValidationError{Field: "release", Message: "release does not belong to this app"})
+			return
+		}
+	}
+
+	// move the process counts of the current formation to the new release
+	// and set the app's release in a single transaction, so a failure
+	// cannot leave the new release scaled up while the app still uses the
+	// old one
+	var formations []*ct.Formation
+	if app.ReleaseID != "" && app.ReleaseID != release.ID {
+		oldFormation, err := c.formationRepo.Get(app.ID, app.ReleaseID)
+		if err != nil && err != ErrNotFound {
+			respondWithError(w, err)
+			return
+		}
+		if oldFormation != nil && len(oldFormation.Processes) > 0 {
+			stopped := make(map[string]int, len(oldFormation.Processes))
+			for typ := range oldFormation.Processes {
+				stopped[typ] = 0
+			}
+			formations = []*ct.Formation{
+				{
+					AppID:     app.ID,
+					ReleaseID: release.ID,
+					Processes: oldFormation.Processes,
+					Tags:      oldFormation.Tags,
+				},
+				{
+					AppID:     app.ID,
+					ReleaseID: oldFormation.ReleaseID,
+					Processes: stopped,
+					Tags:      oldFormation.Tags,
+				},
+			}
+		}
+	}
+
+	if err := c.formationRepo.AddAllWithAppRelease(formations, app, release.ID); err != nil {
+		respondWithError(w, err)
+		return
+	}
+	c.forgetCurrentRelease(ctx, app.ID)
+	httphelper.JSON(w, 200, app)
+}
+
+// SetPendingRelease stages a release as the next release to deploy to the
+// app without deploying it, responding with the updated app.
+//
+// The release must belong to the app (see isAppRelease), so a new release
+// needs a formation for the app before it can be staged.
+func (c *controllerAPI) SetPendingRelease(ctx context.Context, w http.ResponseWriter, req *http.Request) {
+	app := c.getApp(ctx)
+
+	var rid releaseID
+	if err := httphelper.DecodeJSON(req, &rid); err != nil {
+		respondWithError(w, err)
+		return
+	}
+	if _, err := c.releaseRepo.Get(rid.ID); err != nil {
+		if err == ErrNotFound {
+			err = ct.ValidationError{
+				Field:   "release",
+				Message: fmt.Sprintf("could not find release with ID %s", rid.ID),
+			}
+		}
+		respondWithError(w, err)
+		return
+	}
+	if ok, err := c.isAppRelease(app.ID, rid.ID); err != nil {
+		respondWithError(w, err)
+		return
+	} else if !ok {
+		respondWithError(w, ct.ValidationError{Field: "release", Message: "does not belong to the app"})
+		return
+	}
+	if err := c.appRepo.SetPendingRelease(app, rid.ID); err != nil {
+		respondWithError(w, err)
+		return
+	}
+	httphelper.JSON(w, 200, app)
+}
+
+// GetPendingRelease responds with the release staged as the next release to
+// deploy to the app, or null if there is none.
+func (c *controllerAPI) GetPendingRelease(ctx context.Context, w http.ResponseWriter, req *http.Request) {
+	app := c.getApp(ctx)
+	if app.PendingReleaseID == "" {
+		httphelper.JSON(w, 200, nil)
+		return
+	}
+	release, err := c.releaseRepo.Get(app.PendingReleaseID)
+	if err != nil {
+		respondWithError(w, err)
+		return
+	}
+	httphelper.JSON(w, 200, release)
+}
+
+// ClearPendingRelease clears the app's staged release, responding with the
+// updated app.
+func (c *controllerAPI) ClearPendingRelease(ctx context.Context, w http.ResponseWriter, req *http.Request) {
+	app := c.getApp(ctx)
+	if err := c.appRepo.SetPendingRelease(app, ""); err != nil {
+		respondWithError(w, err)
+		return
+	}
+	httphelper.JSON(w, 200, app)
+}
+
 func (c *controllerAPI) GetAppRelease(ctx context.Context, w http.ResponseWriter, req *http.Request) {
-	release, err := c.appRepo.GetRelease(c.getApp(ctx).ID)
+	release, err := c.getCurrentRelease(ctx, c.getApp(ctx).ID)
+	if err != nil {
+		respondWithError(w, err)
+		return
+	}
+	httphelper.JSON(w, 200, release)
+}
+
+// GetPreviousRelease responds with the release which was the app's current
+// release before the release in the request became current, or null if it
+// was the app's first release.
+func (c *controllerAPI) GetPreviousRelease(ctx context.Context, w http.ResponseWriter, req *http.Request) {
+	params, _ := ctxhelper.ParamsFromContext(ctx)
+	release, err := c.releaseRepo.GetPrevious(c.getApp(ctx).ID, params.ByName("releases_id"))
+	if err != nil {
+		respondWithError(w, err)
+		return
+	}
+	httphelper.JSON(w, 200, release)
+}
+
+func (c *controllerAPI) GetReleaseMetaValue(ctx context.Context, w http.ResponseWriter, req *http.Request) {
+	release, err := c.getRelease(ctx)
 	if err != nil {
 		respondWithError(w, err)
 		return
 	}
+	params, _ := ctxhelper.ParamsFromContext(ctx)
+	httphelper.JSON(w, 200, ct.NewMetaValue(release.Meta, params.ByName("key")))
+}
+
+// PromoteRelease creates a release for the app with the artifacts and
+// process types of another app's release and the app's own env with the
+// given overrides, optionally deploying it.
+//
+// If deploying the release fails, the release is deleted again rather than
+// being left unused.
+func (c *controllerAPI) PromoteRelease(ctx context.Context, w http.ResponseWriter, req *http.Request) {
+	app := c.getApp(ctx)
+
+	var data ct.PromoteRelease
+	if err := httphelper.DecodeJSON(req, &data); err != nil {
+		respondWithError(w, err)
+		return
+	}
+	if data.ReleaseID == "" {
+		respondWithError(w, ct.ValidationError{Field: "release", Message: "must be set"})
+		return
+	}
+	source, err := c.releaseRepo.Get(data.ReleaseID)
+	if err == ErrNotFound {
+		respondWithError(w, ct.ValidationError{Field: "release", Message: "does not exist"})
+		return
+	} else if err != nil {
+		respondWithError(w, err)
+		return
+	}
+	current, err := c.getCurrentRelease(ctx, app.ID)
+	if err == ErrNotFound {
+		current = &ct.Release{}
+	} else if err != nil {
+		respondWithError(w, err)
+		return
+	}
+
+	// releases returned by the repo are copies, so the source release can
+	// be modified to build the new release
+	release := source.(*ct.Release)
+	release.ID = ""
+	release.CreatedAt = nil
+	release.Env = make(map[string]string, len(current.Env)+len(data.Env))
+	for k, v := range current.Env {
+		release.Env[k] = v
+	}
+	for k, v := range data.Env {
+		release.Env[k] = v
+	}
+	if err := c.releaseRepo.Add(release); err != nil {
+		respondWithError(w, err)
+		return
+	}
+
+	res := &ct.PromotedRelease{Release: release}
+	if data.Deploy {
+		res.Deployment, err = c.createDeployment(ctx, app, release, "")
+		if err != nil {
+			c.deleteUnusedRelease(app, release)
+			respondWithError(w, err)
+			return
+		}
+	}
+	httphelper.JSON(w, 200, res)
+}
+
+// deleteUnusedRelease deletes a release which was created for the app as
+// part of a request which then failed, unless an app has since started
+// using it (e.g. if creating a deployment set it as the app's release before
+// failing).
+func (c *controllerAPI) deleteUnusedRelease(app *ct.App, release *ct.Release) {
+	appIDs, err := c.releaseRepo.AppIDs(release.ID)
+	if err != nil || len(appIDs) > 0 {
+		return
+	}
+	c.releaseRepo.Delete(app, release)
+}
+
+// SetAppEnv creates a release for the app which is a copy of its current
+// release with the given env vars set and unset, optionally deploying it.
+//
+// If the env is unchanged no release is created, and the response has the
+// current release and no deployment.
+func (c *controllerAPI) SetAppEnv(ctx context.Context, w http.ResponseWriter, req *http.Request) {
+	app := c.getApp(ctx)
+
+	var data ct.AppEnvUpdate
+	if err := httphelper.DecodeJSON(req, &data); err != nil {
+		respondWithError(w, err)
+		return
+	}
+	if len(data.Set) == 0 && len(data.Unset) == 0 {
+		respondWithError(w, ct.ValidationError{Message: "must set or unset at least one env var"})
+		return
+	}
+	for k := range data.Set {
+		if k == "" {
+			respondWithError(w, ct.ValidationError{Field: "set", Message: "must not contain empty keys"})
+			return
+		}
+	}
+	for _, k := range data.Unset {
+		if _, ok := data.Set[k]; ok {
+			respondWithError(w, ct.ValidationError{Field: "unset", Message: fmt.Sprintf("%s cannot be both set and unset", k)})
+			return
+		}
+	}
+
+	current, err := c.getCurrentRelease(ctx, app.ID)
+	if err == ErrNotFound {
+		current = &ct.Release{}
+	} else if err != nil {
+		respondWithError(w, err)
+		return
+	}
+
+	release := *current
+	release.ID = ""
+	release.CreatedAt = nil
+	release.Env = make(map[string]string, len(current.Env)+len(data.Set))
+	for k, v := range current.Env {
+		release.Env[k] = v
+	}
+	var changed bool
+	for k, v := range data.Set {
+		if cur, ok := release.Env[k]; !ok || cur != v {
+			changed = true
+		}
+		release.Env[k] = v
+	}
+	for _, k := range data.Unset {
+		if _, ok := release.Env[k]; ok {
+			changed = true
+		}
+		delete(release.Env, k)
+	}
+	if !changed && current.ID != "" {
+		httphelper.JSON(w, 200, &ct.PromotedRelease{Release: current})
+		return
+	}
+	if err := c.releaseRepo.Add(&release); err != nil {
+		respondWithError(w, err)
+		return
+	}
+
+	res := &ct.PromotedRelease{Release: &release}
+	if data.Deploy {
+		res.Deployment, err = c.createDeployment(ctx, app, &release, "")
+		if err != nil {
+			c.deleteUnusedRelease(app, &release)
+			respondWithError(w, err)
+			return
+		}
+	}
+	httphelper.JSON(w, 200, res)
+}
+
+// ReviseRelease creates a new release with the env, processes and meta of the
+// given release but with the given artifacts, which is how the
+// artifacts of an immutable release are changed.
+func (c *controllerAPI) ReviseRelease(ctx context.Context, w http.ResponseWriter, req *http.Request) {
+	var data ct.ReleaseRevision
+	if err := httphelper.DecodeJSON(req, &data); err != nil {
+		respondWithError(w, err)
+		return
+	}
+	if data.ReleaseID == "" {
+		respondWithError(w, ct.ValidationError{Field: "release", Message: "must be set"})
+		return
+	}
+	if len(data.ArtifactIDs) == 0 {
+		respondWithError(w, ct.ValidationError{Field: "artifacts", Message: "must be set"})
+		return
+	}
+	base, err := c.releaseRepo.Get(data.ReleaseID)
+	if err == ErrNotFound {
+		respondWithError(w, ct.ValidationError{Field: "release", Message: "does not exist"})
+		return
+	} else if err != nil {
+		respondWithError(w, err)
+		return
+	}
+
+	release := *base.(*ct.Release)
+	release.ID = ""
+	release.CreatedAt = nil
+	release.ArtifactIDs = data.ArtifactIDs
+	release.LegacyArtifactID = release.ImageArtifactID()
+	if err := c.releaseRepo.Add(&release); err != nil {
+		respondWithError(w, err)
+		return
+	}
+	httphelper.JSON(w, 200, &release)
+}
+
+func (c *controllerAPI) GetReleaseDefaultProcesses(ctx context.Context, w http.ResponseWriter, req *http.Request) {
+	release, err := c.getRelease(ctx)
+	if err != nil {
+		respondWithError(w, err)
+		return
+	}
+	httphelper.JSON(w, 200, release.DefaultProcesses())
+}
+
+// GetReleaseSize responds with the total size of the release's artifacts,
+// which is null if the size of any artifact is not known.
+func (c *controllerAPI) GetReleaseSize(ctx context.Context, w http.ResponseWriter, req *http.Request) {
+	release, err := c.getRelease(ctx)
+	if err != nil {
+		respondWithError(w, err)
+		return
+	}
+	artifacts, err := c.artifactRepo.ListIDs(release.ArtifactIDs...)
+	if err != nil {
+		respondWithError(w, err)
+		return
+	}
+	var total int64
+	res := &ct.ReleaseSize{TotalSize: &total}
+	for _, id := range release.ArtifactIDs {
+		artifact, ok := artifacts[id]
+		if !ok || artifact.Size == nil {
+			res.TotalSize = nil
+			break
+		}
+		total += *artifact.Size
+	}
+	if res.TotalSize != nil {
+		res.TotalSizeHuman = ct.ByteSize(total).String()
+	}
+	httphelper.JSON(w, 200, res)
+}
+
+// GetReleaseInUse responds with whether the release is the current release of
+// any app, in which case it cannot be deleted.
+func (c *controllerAPI) GetReleaseInUse(ctx context.Context, w http.ResponseWriter, req *http.Request) {
+	release, err := c.getRelease(ctx)
+	if err != nil {
+		respondWithError(w, err)
+		return
+	}
+	inUse, err := c.releaseRepo.InUse(release.ID)
+	if err != nil {
+		respondWithError(w, err)
+		return
+	}
+	httphelper.JSON(w, 200, &ct.ReleaseInUse{InUse: inUse})
+}
+
+// ImportRelease inserts a release exported from another cluster, preserving
+// its ID, along with any artifacts included in the request (see
+// ReleaseRepo.Import).
+func (c *controllerAPI) ImportRelease(ctx context.Context, w http.ResponseWriter, req *http.Request) {
+	var data ct.ReleaseImport
+	if err := httphelper.DecodeJSON(req, &data); err != nil {
+		respondWithError(w, err)
+		return
+	}
+	release := data.Release
+	if release == nil {
+		respondWithError(w, ct.ValidationError{Field: "release", Message: "must be set"})
+		return
+	}
+
+	if err := schema.Validate(release); err != nil {
+		respondWithError(w, prefixValidationError(err, "release"))
+		return
+	}
+	for i, artifact := range data.Artifacts {
+		field := fmt.Sprintf("artifacts[%d]", i)
+		if artifact == nil {
+			respondWithError(w, ct.ValidationError{Field: field, Message: "must not be null"})
+			return
+		}
+		if err := schema.Validate(artifact); err != nil {
+			respondWithError(w, prefixValidationError(err, field))
+			return
+		}
+	}
+
+	if err := c.releaseRepo.Import(release, data.Artifacts); err != nil {
+		respondWithError(w, err)
+		return
+	}
 	httphelper.JSON(w, 200, release)
 }
 
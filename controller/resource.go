@@ -1,6 +1,8 @@
 package main
 
 import (
+	"encoding/json"
+	"fmt"
 	"net/http"
 	"strings"
 
@@ -24,18 +26,36 @@ func NewResourceRepo(db *postgres.DB) *ResourceRepo {
 }
 
 func (rr *ResourceRepo) Add(r *ct.Resource) error {
-	if r.ID == "" {
-		r.ID = random.UUID()
-	}
 	tx, err := rr.db.Begin()
 	if err != nil {
 		return err
 	}
-	err = tx.QueryRow("resource_insert", r.ID, r.ProviderID, r.ExternalID, r.Env).Scan(&r.CreatedAt)
-	if err != nil {
+	if err := insertResource(tx, r); err != nil {
 		tx.Rollback()
 		return err
 	}
+	return tx.Commit()
+}
+
+// AddProvisionFailure records that provisioning a resource with a
+// client-supplied ID failed.
+func (rr *ResourceRepo) AddProvisionFailure(failure *ct.ResourceProvisionFailure) error {
+	return createEvent(rr.db.Exec, &ct.Event{
+		ObjectID:   failure.ResourceID,
+		ObjectType: ct.EventTypeResourceProvisionFailure,
+	}, failure)
+}
+
+// insertResource adds the resource as part of the given transaction, which
+// the caller must roll back on error.
+func insertResource(tx *postgres.DBTx, r *ct.Resource) error {
+	if r.ID == "" {
+		r.ID = random.UUID()
+	}
+	err := tx.QueryRow("resource_insert", r.ID, r.ProviderID, r.ExternalID, r.Env).Scan(&r.CreatedAt, &r.UpdatedAt)
+	if err != nil {
+		return err
+	}
 	for i, appID := range r.Apps {
 		var row postgres.Scanner
 		if idPattern.MatchString(appID) {
@@ -44,7 +64,6 @@ func (rr *ResourceRepo) Add(r *ct.Resource) error {
 			row = tx.QueryRow("app_resource_insert_app_by_name", appID, r.ID)
 		}
 		if err := row.Scan(&r.Apps[i]); err != nil {
-			tx.Rollback()
 			return err
 		}
 	}
@@ -54,24 +73,26 @@ func (rr *ResourceRepo) Add(r *ct.Resource) error {
 			ObjectID:   r.ID,
 			ObjectType: ct.EventTypeResource,
 		}, r); err != nil {
-			tx.Rollback()
 			return err
 		}
 	}
 	if len(r.Apps) == 0 {
 		// Ensure an event is created if there are no associated apps
-		if err := createEvent(tx.Exec, &ct.Event{
+		return createEvent(tx.Exec, &ct.Event{
 			ObjectID:   r.ID,
 			ObjectType: ct.EventTypeResource,
-		}, r); err != nil {
-			tx.Rollback()
-			return err
-		}
+		}, r)
 	}
-	return tx.Commit()
+	return nil
 }
 
 func (rr *ResourceRepo) AddApp(resourceID, appID string) (*ct.Resource, error) {
+	return rr.AddApps(resourceID, []string{appID})
+}
+
+// AddApps attaches the resource to all of the given apps in a single
+// transaction, so either all of the apps are attached or none of them are.
+func (rr *ResourceRepo) AddApps(resourceID string, appIDs []string) (*ct.Resource, error) {
 	tx, err := rr.db.Begin()
 	if err != nil {
 		return nil, err
@@ -84,9 +105,9 @@ func (rr *ResourceRepo) AddApp(resourceID, appID string) (*ct.Resource, error) {
 		return nil, err
 	}
 
-	r.Apps = append(r.Apps, appID)
+	for _, appID := range appIDs {
+		r.Apps = append(r.Apps, appID)
 
-	{
 		var row postgres.Scanner
 		if idPattern.MatchString(appID) {
 			row = tx.QueryRow("app_resource_insert_app_by_name_or_id", appID, appID, r.ID)
@@ -98,14 +119,20 @@ func (rr *ResourceRepo) AddApp(resourceID, appID string) (*ct.Resource, error) {
 			return nil, err
 		}
 	}
-	if err := createEvent(tx.Exec, &ct.Event{
-		AppID:      appID,
-		ObjectID:   r.ID,
-		ObjectType: ct.EventTypeResource,
-	}, r); err != nil {
+	if err := tx.QueryRow("resource_touch", r.ID).Scan(&r.UpdatedAt); err != nil {
 		tx.Rollback()
 		return nil, err
 	}
+	for _, appID := range appIDs {
+		if err := createEvent(tx.Exec, &ct.Event{
+			AppID:      appID,
+			ObjectID:   r.ID,
+			ObjectType: ct.EventTypeResource,
+		}, r); err != nil {
+			tx.Rollback()
+			return nil, err
+		}
+	}
 	return r, tx.Commit()
 }
 
@@ -134,6 +161,10 @@ func (rr *ResourceRepo) RemoveApp(resourceID, appID string) (*ct.Resource, error
 		tx.Rollback()
 		return nil, err
 	}
+	if err := tx.QueryRow("resource_touch", r.ID).Scan(&r.UpdatedAt); err != nil {
+		tx.Rollback()
+		return nil, err
+	}
 	if err := createEvent(tx.Exec, &ct.Event{
 		AppID:      appID,
 		ObjectID:   r.ID,
@@ -155,7 +186,7 @@ func split(s string, sep string) []string {
 func scanResource(s postgres.Scanner) (*ct.Resource, error) {
 	r := &ct.Resource{}
 	var appIDs string
-	err := s.Scan(&r.ID, &r.ProviderID, &r.ExternalID, &r.Env, &appIDs, &r.CreatedAt)
+	err := s.Scan(&r.ID, &r.ProviderID, &r.ExternalID, &r.Env, &appIDs, &r.CreatedAt, &r.UpdatedAt)
 	if err == pgx.ErrNoRows {
 		return nil, ErrNotFound
 	} else if err != nil {
@@ -259,6 +290,21 @@ func (c *controllerAPI) ProvisionResource(ctx context.Context, w http.ResponseWr
 		respondWithError(w, err)
 		return
 	}
+	if rr.ID != "" {
+		if !idPattern.MatchString(rr.ID) {
+			respondWithError(w, ct.ValidationError{Field: "id", Message: "is not a valid ID"})
+			return
+		}
+		// check the ID is free before provisioning so a resource is
+		// not left provisioned without a record
+		if _, err := c.resourceRepo.Get(rr.ID); err == nil {
+			respondWithError(w, httphelper.ObjectExistsErr(fmt.Sprintf("resource %s already exists", rr.ID)))
+			return
+		} else if err != ErrNotFound {
+			respondWithError(w, err)
+			return
+		}
+	}
 
 	var config []byte
 	if rr.Config != nil {
@@ -266,13 +312,19 @@ func (c *controllerAPI) ProvisionResource(ctx context.Context, w http.ResponseWr
 	} else {
 		config = []byte(`{}`)
 	}
-	data, err := resource.Provision(p.URL, config)
+	var data *resource.Resource
+	err = c.callDependencyOnce(ctx, dependencyProvider, func(ctx context.Context) (err error) {
+		data, err = resource.ProvisionContext(ctx, p.URL, config)
+		return
+	})
 	if err != nil {
+		c.resourceProvisionFailed(rr.ID, p.ID, err)
 		respondWithError(w, err)
 		return
 	}
 
 	res := &ct.Resource{
+		ID:         rr.ID,
 		ProviderID: p.ID,
 		ExternalID: data.ID,
 		Env:        data.Env,
@@ -280,18 +332,241 @@ func (c *controllerAPI) ProvisionResource(ctx context.Context, w http.ResponseWr
 	}
 
 	if err := schema.Validate(res); err != nil {
+		c.resourceProvisionFailed(rr.ID, p.ID, err)
 		respondWithError(w, err)
 		return
 	}
 
 	if err := c.resourceRepo.Add(res); err != nil {
 		// TODO: attempt to "rollback" provisioning
+		if isRejected(err) {
+			c.resourceProvisionFailed(rr.ID, p.ID, err)
+		}
 		respondWithError(w, err)
 		return
 	}
 	httphelper.JSON(w, 200, res)
 }
 
+// resourceProvisionFailed records that provisioning the resource with the
+// given client-supplied ID failed (doing nothing if the ID was not supplied)
+// so that clients watching its status stop waiting for it to be ready.
+func (c *controllerAPI) resourceProvisionFailed(id, providerID string, err error) {
+	if id == "" {
+		return
+	}
+	failure := &ct.ResourceProvisionFailure{ResourceID: id, ProviderID: providerID, Error: err.Error()}
+	if err := c.resourceRepo.AddProvisionFailure(failure); err != nil {
+		logger.Error("error creating resource provision failure event", "resource.id", id, "err", err)
+	}
+}
+
+// CreateProviderResource provisions a resource from the provider with the
+// given URL, registering the provider with the given name first if there is
+// no provider with that URL, and responds with both.
+//
+// A new provider is only registered once the resource has been provisioned,
+// in the same transaction as the resource is added, so it is not left
+// registered if provisioning fails. The resource is deprovisioned if it is
+// known not to have been added, but not if the outcome is unknown (for
+// example if the connection to the database fails), as deprovisioning a
+// resource which was added would leave it without its backing resource.
+func (c *controllerAPI) CreateProviderResource(ctx context.Context, w http.ResponseWriter, req *http.Request) {
+	var data ct.ProviderResourceReq
+	if err := httphelper.DecodeJSON(req, &data); err != nil {
+		respondWithError(w, err)
+		return
+	}
+	if err := validateProviderURL(data.URL); err != nil {
+		respondWithError(w, err)
+		return
+	}
+
+	p, err := c.providerRepo.GetByURL(data.URL)
+	var created bool
+	if err == ErrNotFound {
+		if data.Name == "" {
+			respondWithError(w, ct.ValidationError{Field: "name", Message: "must be set to register a new provider"})
+			return
+		}
+		// check the name is free before provisioning so a resource is
+		// not provisioned for a provider which cannot be registered
+		if _, err := c.providerRepo.Get(data.Name); err == nil {
+			respondWithError(w, httphelper.ObjectExistsErr(fmt.Sprintf("provider with name %q already exists", data.Name)))
+			return
+		} else if err != ErrNotFound {
+			respondWithError(w, err)
+			return
+		}
+		p = &ct.Provider{Name: data.Name, URL: data.URL}
+		created = true
+	} else if err != nil {
+		respondWithError(w, err)
+		return
+	}
+
+	config := []byte(`{}`)
+	if data.Config != nil {
+		config = *data.Config
+	}
+	var provisioned *resource.Resource
+	err = c.callDependencyOnce(ctx, dependencyProvider, func(ctx context.Context) (err error) {
+		provisioned, err = resource.ProvisionContext(ctx, p.URL, config)
+		return
+	})
+	if err != nil {
+		respondWithError(w, err)
+		return
+	}
+
+	res := &ct.Resource{
+		ProviderID: p.ID,
+		ExternalID: provisioned.ID,
+		Env:        provisioned.Env,
+		Apps:       data.Apps,
+	}
+	err = schema.Validate(res)
+	if err == nil {
+		if created {
+			err = c.providerRepo.AddWithResource(p, res)
+		} else {
+			err = c.resourceRepo.Add(res)
+		}
+	}
+	if err != nil {
+		if !isRejected(err) {
+			logger.Error("error adding resource, leaving it provisioned", "provider.url", p.URL, "external.id", res.ExternalID, "err", err)
+		} else if err := resource.Deprovision(p.URL, res.ExternalID); err != nil {
+			logger.Error("error deprovisioning resource", "provider.url", p.URL, "external.id", res.ExternalID, "err", err)
+		}
+		respondWithError(w, err)
+		return
+	}
+	httphelper.JSON(w, 200, &ct.ProviderResource{Provider: p, Resource: res, ProviderCreated: created})
+}
+
+// isRejected returns whether err means a write was rejected and so definitely
+// did not take effect, as opposed to, for example, an error communicating
+// with the database which leaves the outcome unknown.
+func isRejected(err error) bool {
+	if err == ErrNotFound || err == pgx.ErrNoRows {
+		return true
+	}
+	switch err.(type) {
+	case pgx.PgError, ct.ValidationError, ct.ValidationErrors, httphelper.JSONError:
+		return true
+	}
+	return false
+}
+
+// CreateAppResource provisions a resource and attaches it to the app in one
+// step, deploying a new release of the app which includes the resource's env
+// if the app has a release. If any step after provisioning fails, the
+// resource is removed and deprovisioned so it is not left half attached.
+func (c *controllerAPI) CreateAppResource(ctx context.Context, w http.ResponseWriter, req *http.Request) {
+	app := c.getApp(ctx)
+
+	var rr ct.AppResourceReq
+	if err := httphelper.DecodeJSON(req, &rr); err != nil {
+		respondWithError(w, err)
+		return
+	}
+
+	data, err := c.providerRepo.Get(rr.ProviderID)
+	if err == ErrNotFound {
+		respondWithError(w, ct.ValidationError{Field: "provider", Message: "does not exist"})
+		return
+	} else if err != nil {
+		respondWithError(w, err)
+		return
+	}
+	p := data.(*ct.Provider)
+
+	config := []byte(`{}`)
+	if rr.Config != nil {
+		config = *rr.Config
+	}
+	var provisioned *resource.Resource
+	err = c.callDependencyOnce(ctx, dependencyProvider, func(ctx context.Context) (err error) {
+		provisioned, err = resource.ProvisionContext(ctx, p.URL, config)
+		return
+	})
+	if err != nil {
+		respondWithError(w, err)
+		return
+	}
+
+	res := &ct.Resource{
+		ProviderID: p.ID,
+		ExternalID: provisioned.ID,
+		Env:        provisioned.Env,
+		Apps:       []string{app.ID},
+	}
+	if err := c.addAppResource(ctx, app, res); err != nil {
+		if err := resource.Deprovision(p.URL, res.ExternalID); err != nil {
+			logger.Error("error deprovisioning resource", "provider", p.ID, "external.id", res.ExternalID, "err", err)
+		}
+		respondWithError(w, err)
+		return
+	}
+	httphelper.JSON(w, 200, res)
+}
+
+func (c *controllerAPI) addAppResource(ctx context.Context, app *ct.App, res *ct.Resource) error {
+	if err := schema.Validate(res); err != nil {
+		return err
+	}
+	if err := c.resourceRepo.Add(res); err != nil {
+		return err
+	}
+	if err := c.injectResourceEnv(ctx, app, res.Env); err != nil {
+		if err := c.resourceRepo.Remove(res); err != nil {
+			logger.Error("error removing resource", "resource.id", res.ID, "err", err)
+		}
+		return err
+	}
+	return nil
+}
+
+// injectResourceEnv deploys a copy of the app's current release with the
+// given env added, doing nothing if the app has no release.
+func (c *controllerAPI) injectResourceEnv(ctx context.Context, app *ct.App, env map[string]string) error {
+	release, err := c.resourceEnvRelease(ctx, app, env)
+	if err != nil || release == nil {
+		return err
+	}
+	if err := c.releaseRepo.Add(release); err != nil {
+		return err
+	}
+	if _, err := c.createDeployment(ctx, app, release, ""); err != nil {
+		c.deleteUnusedRelease(app, release)
+		return err
+	}
+	return nil
+}
+
+// resourceEnvRelease returns a copy of the app's current release with the
+// given env added, or nil if the app has no release or the env is empty.
+func (c *controllerAPI) resourceEnvRelease(ctx context.Context, app *ct.App, env map[string]string) (*ct.Release, error) {
+	current, err := c.getCurrentRelease(ctx, app.ID)
+	if err == ErrNotFound || len(env) == 0 {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	release := *current
+	release.ID = ""
+	release.CreatedAt = nil
+	release.Env = make(map[string]string, len(current.Env)+len(env))
+	for k, v := range current.Env {
+		release.Env[k] = v
+	}
+	for k, v := range env {
+		release.Env[k] = v
+	}
+	return &release, nil
+}
+
 func (c *controllerAPI) GetProviderResources(ctx context.Context, w http.ResponseWriter, req *http.Request) {
 	p, err := c.getProvider(ctx)
 	if err != nil {
@@ -307,8 +582,20 @@ func (c *controllerAPI) GetProviderResources(ctx context.Context, w http.Respons
 	httphelper.JSON(w, 200, res)
 }
 
+// GetResources responds with all resources, or only those attached to the
+// app given by the app_id query parameter.
 func (c *controllerAPI) GetResources(ctx context.Context, w http.ResponseWriter, req *http.Request) {
-	res, err := c.resourceRepo.List()
+	var res []*ct.Resource
+	var err error
+	if appID := req.FormValue("app_id"); appID != "" {
+		if !idPattern.MatchString(appID) {
+			respondWithError(w, ct.ValidationError{Field: "app_id", Message: "is not a valid ID"})
+			return
+		}
+		res, err = c.resourceRepo.AppList(appID)
+	} else {
+		res, err = c.resourceRepo.List()
+	}
 	if err != nil {
 		respondWithError(w, err)
 		return
@@ -411,7 +698,13 @@ func (c *controllerAPI) AddResourceApp(ctx context.Context, w http.ResponseWrite
 		return
 	}
 
-	resource, err := c.resourceRepo.AddApp(params.ByName("resources_id"), params.ByName("app_id"))
+	app, err := c.resolveApp(params.ByName("app_id"))
+	if err != nil {
+		respondWithError(w, err)
+		return
+	}
+
+	resource, err := c.resourceRepo.AddApp(params.ByName("resources_id"), app.ID)
 	if err != nil {
 		respondWithError(w, err)
 		return
@@ -420,6 +713,93 @@ func (c *controllerAPI) AddResourceApp(ctx context.Context, w http.ResponseWrite
 	httphelper.JSON(w, 200, resource)
 }
 
+// AddResourceApps attaches the resource to several apps at once, deploying a
+// new release of each app which includes the resource's env.
+//
+// Everything which would stop a release being created and deployed for one
+// of the apps (e.g. a deployment already being in progress) is checked
+// before any release is created, so a request which fails validation leaves
+// every app unchanged. If creating a release or deployment still fails
+// unexpectedly, the resource is detached from all of the apps again but any
+// releases already deployed to earlier apps are left in place, as a
+// deployment cannot be undone once it has started.
+func (c *controllerAPI) AddResourceApps(ctx context.Context, w http.ResponseWriter, req *http.Request) {
+	ctx = c.withCurrentReleaseCache(ctx)
+	params, _ := ctxhelper.ParamsFromContext(ctx)
+
+	if _, err := c.getProvider(ctx); err != nil {
+		respondWithError(w, err)
+		return
+	}
+
+	var data ct.ResourceApps
+	if err := httphelper.DecodeJSON(req, &data); err != nil {
+		respondWithError(w, err)
+		return
+	}
+	if len(data.Apps) == 0 {
+		respondWithError(w, ct.ValidationError{Field: "apps", Message: "must not be empty"})
+		return
+	}
+
+	apps := make([]*ct.App, len(data.Apps))
+	appIDs := make([]string, len(data.Apps))
+	for i, id := range data.Apps {
+		app, err := c.resolveApp(id)
+		if err != nil {
+			respondWithError(w, err)
+			return
+		}
+		apps[i] = app
+		appIDs[i] = app.ID
+	}
+
+	res, err := c.resourceRepo.Get(params.ByName("resources_id"))
+	if err != nil {
+		respondWithError(w, err)
+		return
+	}
+	for i, app := range apps {
+		field := fmt.Sprintf("apps[%d]", i)
+		release, err := c.resourceEnvRelease(ctx, app, res.Env)
+		if err != nil {
+			respondWithError(w, err)
+			return
+		} else if release == nil {
+			continue
+		}
+		if err := c.releaseRepo.Validate(release); err != nil {
+			respondWithError(w, prefixValidationError(err, field))
+			return
+		}
+		if inProgress, err := c.deploymentRepo.InProgress(app.ID); err != nil {
+			respondWithError(w, err)
+			return
+		} else if inProgress {
+			respondWithError(w, ct.ValidationError{Field: field, Message: fmt.Sprintf("app %s already has a deployment in progress", app.Name)})
+			return
+		}
+	}
+
+	res, err = c.resourceRepo.AddApps(res.ID, appIDs)
+	if err != nil {
+		respondWithError(w, err)
+		return
+	}
+	for _, app := range apps {
+		if err := c.injectResourceEnv(ctx, app, res.Env); err != nil {
+			for _, id := range appIDs {
+				if _, err := c.resourceRepo.RemoveApp(res.ID, id); err != nil {
+					logger.Error("error detaching resource", "resource.id", res.ID, "app.id", id, "err", err)
+				}
+			}
+			respondWithError(w, err)
+			return
+		}
+	}
+	httphelper.JSON(w, 200, res)
+}
+
 func (c *controllerAPI) DeleteResourceApp(ctx context.Context, w http.ResponseWriter, req *http.Request) {
 	params, _ := ctxhelper.ParamsFromContext(ctx)
 
@@ -429,7 +809,13 @@ func (c *controllerAPI) DeleteResourceApp(ctx context.Context, w http.ResponseWr
 		return
 	}
 
-	resource, err := c.resourceRepo.RemoveApp(params.ByName("resources_id"), params.ByName("app_id"))
+	app, err := c.resolveApp(params.ByName("app_id"))
+	if err != nil {
+		respondWithError(w, err)
+		return
+	}
+
+	resource, err := c.resourceRepo.RemoveApp(params.ByName("resources_id"), app.ID)
 	if err != nil {
 		respondWithError(w, err)
 		return
@@ -446,3 +832,96 @@ func (c *controllerAPI) GetAppResources(ctx context.Context, w http.ResponseWrit
 	}
 	httphelper.JSON(w, 200, res)
 }
+
+// StreamResourceStatus streams the provisioning status of a resource, which
+// is pending until the resource has been provisioned and then either ready or
+// failed, closing the stream once the resource is ready, has failed or has
+// been deleted. Clients watch a resource being provisioned by setting its ID
+// in the provisioning request, and should start watching before making the
+// request as a failure which has already happened is not streamed.
+func (c *controllerAPI) StreamResourceStatus(ctx context.Context, w http.ResponseWriter, req *http.Request) {
+	params, _ := ctxhelper.ParamsFromContext(ctx)
+	id := params.ByName("resources_id")
+	if !idPattern.MatchString(id) {
+		respondWithError(w, ct.ValidationError{Field: "id", Message: "is not a valid ID"})
+		return
+	}
+
+	l, _ := ctxhelper.LoggerFromContext(ctx)
+	log := l.New("fn", "StreamResourceStatus", "resource.id", id)
+
+	if err := c.maybeStartEventListener(); err != nil {
+		log.Error("error starting event listener", "err", err)
+		respondWithError(w, err)
+		return
+	}
+	// subscribe before loading the resource so the event marking it
+	// ready is not missed
+	sub, err := c.eventListener.Subscribe("", []string{
+		string(ct.EventTypeResource),
+		string(ct.EventTypeResourceDeletion),
+		string(ct.EventTypeResourceProvisionFailure),
+	}, id)
+	if err != nil {
+		respondWithError(w, err)
+		return
+	}
+	defer sub.Close()
+
+	status := &ct.ResourceStatus{Status: ct.ResourceStatePending}
+	if res, err := c.resourceRepo.Get(id); err == nil {
+		status = &ct.ResourceStatus{Status: ct.ResourceStateReady, Resource: res}
+	} else if err != ErrNotFound {
+		respondWithError(w, err)
+		return
+	}
+
+	ch := make(chan *ct.ResourceStatus)
+	stream := c.newSSEStream(w, ch, log)
+	stream.Serve()
+	defer stream.Close()
+
+	send := func(status *ct.ResourceStatus) bool {
+		select {
+		case ch <- status:
+		case <-stream.Done:
+			return false
+		}
+		return status.Status == ct.ResourceStatePending
+	}
+	if !send(status) {
+		return
+	}
+
+	for {
+		select {
+		case <-stream.Done:
+			return
+		case event, ok := <-sub.Events:
+			if !ok {
+				stream.Error(sub.Err)
+				return
+			}
+			if event.ObjectType == ct.EventTypeResourceProvisionFailure {
+				var failure ct.ResourceProvisionFailure
+				if err := json.Unmarshal(event.Data, &failure); err != nil {
+					log.Error("error decoding event", "event.id", event.ID, "err", err)
+					continue
+				}
+				send(&ct.ResourceStatus{Status: ct.ResourceStateFailed, Error: failure.Error})
+				return
+			}
+			var res ct.Resource
+			if err := json.Unmarshal(event.Data, &res); err != nil {
+				log.Error("error decoding event", "event.id", event.ID, "err", err)
+				continue
+			}
+			status := &ct.ResourceStatus{Status: ct.ResourceStateReady, Resource: &res}
+			if event.ObjectType == ct.EventTypeResourceDeletion {
+				status.Status = ct.ResourceStateDeleted
+			}
+			send(status)
+			return
+		}
+	}
+}
@@ -6,11 +6,16 @@ import (
 	"io/ioutil"
 	"net/http"
 	"net/http/httptest"
+	"time"
 
 	"github.com/flynn/flynn/controller/client"
+	"github.com/flynn/flynn/controller/schema"
 	ct "github.com/flynn/flynn/controller/types"
+	hh "github.com/flynn/flynn/pkg/httphelper"
+	"github.com/flynn/flynn/pkg/postgres"
 	"github.com/flynn/flynn/pkg/random"
 	. "github.com/flynn/go-check"
+	"github.com/jackc/pgx"
 )
 
 func (s *S) provisionTestResourceWithServer(c *C, name string, apps []string) (*ct.Resource, *ct.Provider, *httptest.Server) {
@@ -82,6 +87,41 @@ func (s *S) TestPutResource(c *C) {
 	c.Assert(gotResource, DeepEquals, resource)
 }
 
+func (s *S) TestAppEffectiveEnv(c *C) {
+	app := s.createTestApp(c, &ct.App{Name: "effective-env"})
+	provider := s.createTestProvider(c, &ct.Provider{URL: "https://example.ca", Name: "effective-env"})
+
+	// an app without a release or resources has an empty env
+	env, err := s.c.GetAppEffectiveEnv(app.ID)
+	c.Assert(err, IsNil)
+	c.Assert(env, DeepEquals, map[string]string{})
+
+	for _, resEnv := range []map[string]string{
+		{"A": "resource1", "B": "resource1"},
+		{"B": "resource2", "C": "resource2"},
+	} {
+		c.Assert(s.c.PutResource(&ct.Resource{
+			ID:         random.UUID(),
+			ProviderID: provider.ID,
+			ExternalID: "/effective-env/" + random.UUID(),
+			Env:        resEnv,
+			Apps:       []string{app.ID},
+		}), IsNil)
+	}
+
+	// newer resources take precedence over older ones
+	env, err = s.c.GetAppEffectiveEnv(app.ID)
+	c.Assert(err, IsNil)
+	c.Assert(env, DeepEquals, map[string]string{"A": "resource1", "B": "resource2", "C": "resource2"})
+
+	// the release env takes precedence over all resources
+	release := s.createTestRelease(c, &ct.Release{Env: map[string]string{"C": "release", "D": "release"}})
+	s.setAppRelease(c, app.ID, release.ID)
+	env, err = s.c.GetAppEffectiveEnv(app.ID)
+	c.Assert(err, IsNil)
+	c.Assert(env, DeepEquals, map[string]string{"A": "resource1", "B": "resource2", "C": "release", "D": "release"})
+}
+
 func (s *S) TestAddResourceApp(c *C) {
 	app1 := s.createTestApp(c, &ct.App{Name: "add-resource-app1"})
 	app2 := s.createTestApp(c, &ct.App{Name: "add-resource-app2"})
@@ -96,6 +136,99 @@ func (s *S) TestAddResourceApp(c *C) {
 	c.Assert(gotResource.Apps, DeepEquals, []string{app1.ID, app2.ID})
 }
 
+func (s *S) TestAddResourceApps(c *C) {
+	app1 := s.createTestApp(c, &ct.App{Name: "add-resource-apps1"})
+	app2 := s.createTestApp(c, &ct.App{Name: "add-resource-apps2"})
+	release := s.createTestRelease(c, &ct.Release{Env: map[string]string{"FOO": "bar"}})
+	s.setAppRelease(c, app1.ID, release.ID)
+	resource, provider := s.provisionTestResource(c, "add-resource-apps", []string{})
+
+	gotResource, err := s.c.AddResourceApps(provider.ID, resource.ID, []string{app1.ID, app2.Name})
+	c.Assert(err, IsNil)
+	c.Assert(gotResource.Apps, DeepEquals, []string{app1.ID, app2.ID})
+	for _, app := range []*ct.App{app1, app2} {
+		resources, err := s.c.AppResourceList(app.ID)
+		c.Assert(err, IsNil)
+		c.Assert(resources, HasLen, 1)
+		c.Assert(resources[0].ID, Equals, resource.ID)
+	}
+
+	// the resource env should have been injected into the app with a
+	// release
+	newRelease, err := s.c.GetAppRelease(app1.ID)
+	c.Assert(err, IsNil)
+	c.Assert(newRelease.ID, Not(Equals), release.ID)
+	c.Assert(newRelease.Env, DeepEquals, map[string]string{"FOO": "bar", "foo": "baz"})
+}
+
+func (s *S) TestAddResourceAppsInvalidApp(c *C) {
+	app := s.createTestApp(c, &ct.App{Name: "add-resource-apps-invalid"})
+	resource, provider := s.provisionTestResource(c, "add-resource-apps-invalid", []string{})
+
+	_, err := s.c.AddResourceApps(provider.ID, resource.ID, []string{app.ID, "add-resource-apps-nonexistent"})
+	c.Assert(err, NotNil)
+
+	// the valid app should not have been attached
+	gotResource, err := s.c.GetResource(provider.ID, resource.ID)
+	c.Assert(err, IsNil)
+	c.Assert(gotResource.Apps, HasLen, 0)
+	resources, err := s.c.AppResourceList(app.ID)
+	c.Assert(err, IsNil)
+	c.Assert(resources, HasLen, 0)
+}
+
+func (s *S) TestAddResourceAppsDeploymentInProgress(c *C) {
+	app1 := s.createTestApp(c, &ct.App{Name: "add-resource-apps-deploying1"})
+	app2 := s.createTestApp(c, &ct.App{Name: "add-resource-apps-deploying2"})
+	release := s.createTestRelease(c, &ct.Release{Env: map[string]string{"FOO": "bar"}})
+	for _, app := range []*ct.App{app1, app2} {
+		s.setAppRelease(c, app.ID, release.ID)
+	}
+	c.Assert(s.c.PutFormation(&ct.Formation{AppID: app2.ID, ReleaseID: release.ID, Processes: map[string]int{"web": 1}}), IsNil)
+	_, err := s.c.CreateDeployment(app2.ID, s.createTestRelease(c, &ct.Release{}).ID)
+	c.Assert(err, IsNil)
+	resource, provider := s.provisionTestResource(c, "add-resource-apps-deploying", []string{})
+
+	_, err = s.c.AddResourceApps(provider.ID, resource.ID, []string{app1.ID, app2.ID})
+	c.Assert(hh.IsValidationError(err), Equals, true)
+	c.Assert(err.(hh.JSONError).Message, Equals, "apps[1] app add-resource-apps-deploying2 already has a deployment in progress")
+
+	// no release should have been deployed to the first app
+	gotRelease, err := s.c.GetAppRelease(app1.ID)
+	c.Assert(err, IsNil)
+	c.Assert(gotRelease.ID, Equals, release.ID)
+	gotResource, err := s.c.GetResource(provider.ID, resource.ID)
+	c.Assert(err, IsNil)
+	c.Assert(gotResource.Apps, HasLen, 0)
+}
+
+func (s *S) TestResourceUpdatedAt(c *C) {
+	app := s.createTestApp(c, &ct.App{Name: "resource-updated-at"})
+	resource, provider := s.provisionTestResource(c, "resource-updated-at", []string{})
+	c.Assert(resource.CreatedAt, NotNil)
+	c.Assert(resource.UpdatedAt, NotNil)
+	c.Assert(*resource.UpdatedAt, Equals, *resource.CreatedAt)
+
+	// adding and removing apps should bump updated_at
+	added, err := s.c.AddResourceApp(provider.ID, resource.ID, app.ID)
+	c.Assert(err, IsNil)
+	c.Assert(added.UpdatedAt.After(*resource.UpdatedAt), Equals, true)
+	removed, err := s.c.DeleteResourceApp(provider.ID, resource.ID, app.ID)
+	c.Assert(err, IsNil)
+	c.Assert(removed.UpdatedAt.After(*added.UpdatedAt), Equals, true)
+
+	// the timestamps should be serialized from the stored values
+	gotResource, err := s.c.GetResource(provider.ID, resource.ID)
+	c.Assert(err, IsNil)
+	c.Assert(*gotResource.CreatedAt, Equals, *resource.CreatedAt)
+	c.Assert(*gotResource.UpdatedAt, Equals, *removed.UpdatedAt)
+	data, err := json.Marshal(gotResource)
+	c.Assert(err, IsNil)
+	var fields map[string]interface{}
+	c.Assert(json.Unmarshal(data, &fields), IsNil)
+	c.Assert(fields["updated_at"], Equals, gotResource.UpdatedAt.Format(time.RFC3339Nano))
+}
+
 func (s *S) TestDeleteResourceApp(c *C) {
 	app1 := s.createTestApp(c, &ct.App{Name: "delete-resource-app1"})
 	app2 := s.createTestApp(c, &ct.App{Name: "delete-resource-app2"})
@@ -150,6 +283,29 @@ func (s *S) TestResourceLists(c *C) {
 	check(s.c.ResourceListAll())
 }
 
+func (s *S) TestResourceListByApp(c *C) {
+	app1 := s.createTestApp(c, &ct.App{Name: "resource-list-by-app1"})
+	app2 := s.createTestApp(c, &ct.App{Name: "resource-list-by-app2"})
+	app3 := s.createTestApp(c, &ct.App{Name: "resource-list-by-app3"})
+
+	shared, _ := s.provisionTestResource(c, "resource-list-by-app-shared", []string{app1.ID, app2.ID})
+	own, _ := s.provisionTestResource(c, "resource-list-by-app-own", []string{app1.ID})
+	s.provisionTestResource(c, "resource-list-by-app-other", []string{app2.ID})
+
+	list, err := s.c.ResourceListByApp(app1.ID)
+	c.Assert(err, IsNil)
+	c.Assert(list, HasLen, 2)
+	c.Assert(list[0].ID, Equals, own.ID)
+	c.Assert(list[1].ID, Equals, shared.ID)
+
+	list, err = s.c.ResourceListByApp(app3.ID)
+	c.Assert(err, IsNil)
+	c.Assert(list, HasLen, 0)
+
+	_, err = s.c.ResourceListByApp("not-an-id")
+	c.Assert(hh.IsValidationError(err), Equals, true)
+}
+
 func (s *S) TestAppResourceListWithDeletedAppResource(c *C) {
 	app1 := s.createTestApp(c, &ct.App{Name: "resource-app-list1"})
 	app2 := s.createTestApp(c, &ct.App{Name: "resource-app-list2"})
@@ -169,3 +325,379 @@ func (s *S) TestAppResourceListWithDeletedAppResource(c *C) {
 	c.Assert(list[0].ID, Equals, resource.ID)
 	c.Assert(list[0].Apps, DeepEquals, []string{app2.ID})
 }
+
+func (s *S) TestCreateAppResource(c *C) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.Write([]byte(`{"id":"/things/create-app-resource","env":{"THING_URL":"thing://example.com"}}`))
+	}))
+	defer srv.Close()
+	provider := s.createTestProvider(c, &ct.Provider{URL: srv.URL + "/things", Name: "create-app-resource"})
+
+	app := s.createTestApp(c, &ct.App{Name: "create-app-resource"})
+	release := s.createTestRelease(c, &ct.Release{Env: map[string]string{"FOO": "bar"}})
+	s.setAppRelease(c, app.ID, release.ID)
+
+	res, err := s.c.CreateAppResource(app.Name, &ct.AppResourceReq{ProviderID: provider.Name})
+	c.Assert(err, IsNil)
+	c.Assert(res.ID, Not(Equals), "")
+	c.Assert(res.ProviderID, Equals, provider.ID)
+	c.Assert(res.Apps, DeepEquals, []string{app.ID})
+
+	resources, err := s.c.AppResourceList(app.ID)
+	c.Assert(err, IsNil)
+	c.Assert(resources, HasLen, 1)
+	c.Assert(resources[0].ID, Equals, res.ID)
+
+	// the resource env should have been injected into a new release
+	newRelease, err := s.c.GetAppRelease(app.ID)
+	c.Assert(err, IsNil)
+	c.Assert(newRelease.ID, Not(Equals), release.ID)
+	c.Assert(newRelease.Env, DeepEquals, map[string]string{
+		"FOO":       "bar",
+		"THING_URL": "thing://example.com",
+	})
+}
+
+func (s *S) TestCreateAppResourceProvisionFailure(c *C) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.WriteHeader(500)
+	}))
+	defer srv.Close()
+	provider := s.createTestProvider(c, &ct.Provider{URL: srv.URL + "/things", Name: "create-app-resource-failure"})
+
+	app := s.createTestApp(c, &ct.App{Name: "create-app-resource-failure"})
+	release := s.createTestRelease(c, &ct.Release{})
+	s.setAppRelease(c, app.ID, release.ID)
+
+	_, err := s.c.CreateAppResource(app.ID, &ct.AppResourceReq{ProviderID: provider.ID})
+	c.Assert(err, NotNil)
+
+	// neither the resource nor a new release should exist
+	resources, err := s.c.AppResourceList(app.ID)
+	c.Assert(err, IsNil)
+	c.Assert(resources, HasLen, 0)
+	gotRelease, err := s.c.GetAppRelease(app.ID)
+	c.Assert(err, IsNil)
+	c.Assert(gotRelease.ID, Equals, release.ID)
+}
+
+func (s *S) TestProvisionResourceProviderTimeout(c *C) {
+	done := make(chan struct{})
+	defer close(done)
+	provider := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if req.URL.Path == "/slow" {
+			select {
+			case <-done:
+			case <-time.After(10 * time.Second):
+			}
+		}
+		w.Write([]byte(`{"id":"/things/provider-timeout","env":{"FOO":"bar"}}`))
+	}))
+	defer provider.Close()
+
+	// use a handler which gives providers very little time, and database
+	// statements a timeout which is enforced by postgres but long enough
+	// for the handler's queries
+	pgxpool, err := pgx.NewConnPool(pgx.ConnPoolConfig{
+		ConnConfig: pgx.ConnConfig{
+			Host:     "/var/run/postgresql",
+			Database: "controllertest",
+		},
+		AfterConnect: limitStatements(time.Second, schema.PrepareStatements),
+	})
+	c.Assert(err, IsNil)
+	db := postgres.New(pgxpool, nil)
+	defer db.Close()
+	hc := s.hc
+	hc.db = db
+	hc.timeouts = map[dependency]time.Duration{
+		dependencyDB:       time.Second,
+		dependencyProvider: 100 * time.Millisecond,
+	}
+	srv := httptest.NewServer(appHandler(hc))
+	defer srv.Close()
+	client, err := controller.NewClient(srv.URL, authKey)
+	c.Assert(err, IsNil)
+
+	// statements which take longer than the database timeout are
+	// cancelled, which is reported as a retryable timeout
+	err = db.Exec("SELECT pg_sleep(2)")
+	c.Assert(postgres.IsPostgresCode(err, postgres.QueryCanceled), Equals, true)
+	w := httptest.NewRecorder()
+	respondWithError(w, err)
+	c.Assert(w.Code, Equals, 503)
+	var dbErr hh.JSONError
+	c.Assert(json.Unmarshal(w.Body.Bytes(), &dbErr), IsNil)
+	c.Assert(dbErr.Message, Equals, "timed out waiting for database")
+	c.Assert(dbErr.Retry, Equals, true)
+
+	slow := &ct.Provider{URL: provider.URL + "/slow", Name: "provider-timeout-slow"}
+	c.Assert(client.CreateProvider(slow), IsNil)
+	fast := &ct.Provider{URL: provider.URL + "/fast", Name: "provider-timeout-fast"}
+	c.Assert(client.CreateProvider(fast), IsNil)
+
+	// the provider lookup succeeds but the slow provider call times out
+	start := time.Now()
+	_, err = client.ProvisionResource(&ct.ResourceReq{ProviderID: slow.ID})
+	c.Assert(err, NotNil)
+	c.Assert(time.Since(start) < 5*time.Second, Equals, true)
+	e, ok := err.(hh.JSONError)
+	c.Assert(ok, Equals, true)
+	c.Assert(e.Code, Equals, hh.ServiceUnavailableErrorCode)
+	c.Assert(e.Message, Equals, "timed out waiting for provider")
+	// the provider may still provision the resource, so the request is
+	// not safe to retry
+	c.Assert(e.Retry, Equals, false)
+
+	// calls within their timeouts succeed, including the database calls
+	// made to look up the provider and save the resource
+	res, err := client.ProvisionResource(&ct.ResourceReq{ProviderID: fast.ID})
+	c.Assert(err, IsNil)
+	c.Assert(res.ExternalID, Equals, "/things/provider-timeout")
+	_, err = client.GetResource(fast.ID, res.ID)
+	c.Assert(err, IsNil)
+}
+
+func (s *S) TestStreamResourceStatus(c *C) {
+	provider := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.Write([]byte(`{"id":"/things/resource-status","env":{"FOO":"bar"}}`))
+	}))
+	defer provider.Close()
+	p := s.createTestProvider(c, &ct.Provider{URL: provider.URL + "/things", Name: "resource-status"})
+	id := random.UUID()
+
+	statuses := make(chan *ct.ResourceStatus)
+	stream, err := s.c.StreamResourceStatus(id, statuses)
+	c.Assert(err, IsNil)
+	defer stream.Close()
+
+	assertStatus := func(state ct.ResourceState) *ct.ResourceStatus {
+		select {
+		case status, ok := <-statuses:
+			if !ok {
+				c.Fatalf("unexpected close of resource status stream: %s", stream.Err())
+			}
+			c.Assert(status.Status, Equals, state)
+			return status
+		case <-time.After(5 * time.Second):
+			c.Fatalf("timed out waiting for %s resource status", state)
+		}
+		return nil
+	}
+	assertClosed := func() {
+		select {
+		case _, ok := <-statuses:
+			c.Assert(ok, Equals, false)
+		case <-time.After(5 * time.Second):
+			c.Fatal("timed out waiting for resource status stream to close")
+		}
+	}
+
+	// the resource is pending until it has been provisioned
+	status := assertStatus(ct.ResourceStatePending)
+	c.Assert(status.Resource, IsNil)
+
+	res, err := s.c.ProvisionResource(&ct.ResourceReq{ID: id, ProviderID: p.ID})
+	c.Assert(err, IsNil)
+	c.Assert(res.ID, Equals, id)
+
+	// the stream ends once the resource is ready
+	status = assertStatus(ct.ResourceStateReady)
+	c.Assert(status.Resource.ID, Equals, id)
+	c.Assert(status.Resource.Env, DeepEquals, map[string]string{"FOO": "bar"})
+	assertClosed()
+
+	// streaming the status of a provisioned resource ends immediately
+	statuses = make(chan *ct.ResourceStatus)
+	stream, err = s.c.StreamResourceStatus(id, statuses)
+	c.Assert(err, IsNil)
+	defer stream.Close()
+	status = assertStatus(ct.ResourceStateReady)
+	c.Assert(status.Resource.ID, Equals, id)
+	assertClosed()
+
+	// provisioning a resource with an ID which is in use fails
+	_, err = s.c.ProvisionResource(&ct.ResourceReq{ID: id, ProviderID: p.ID})
+	c.Assert(hh.IsObjectExistsError(err), Equals, true)
+}
+
+func (s *S) TestStreamResourceStatusFailure(c *C) {
+	provider := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.WriteHeader(500)
+	}))
+	defer provider.Close()
+	p := s.createTestProvider(c, &ct.Provider{URL: provider.URL + "/things", Name: "resource-status-failure"})
+	id := random.UUID()
+
+	statuses := make(chan *ct.ResourceStatus)
+	stream, err := s.c.StreamResourceStatus(id, statuses)
+	c.Assert(err, IsNil)
+	defer stream.Close()
+
+	nextStatus := func() *ct.ResourceStatus {
+		select {
+		case status, ok := <-statuses:
+			if !ok {
+				c.Fatalf("unexpected close of resource status stream: %s", stream.Err())
+			}
+			return status
+		case <-time.After(5 * time.Second):
+			c.Fatal("timed out waiting for resource status")
+		}
+		return nil
+	}
+	c.Assert(nextStatus().Status, Equals, ct.ResourceStatePending)
+
+	// provisioning fails in the provider
+	_, err = s.c.ProvisionResource(&ct.ResourceReq{ID: id, ProviderID: p.ID})
+	c.Assert(err, NotNil)
+
+	// so the stream ends with a failed status
+	status := nextStatus()
+	c.Assert(status.Status, Equals, ct.ResourceStateFailed)
+	c.Assert(status.Resource, IsNil)
+	c.Assert(status.Error, Not(Equals), "")
+	select {
+	case _, ok := <-statuses:
+		c.Assert(ok, Equals, false)
+	case <-time.After(5 * time.Second):
+		c.Fatal("timed out waiting for resource status stream to close")
+	}
+
+	// and the resource was not created
+	_, err = s.c.GetResource(p.ID, id)
+	c.Assert(err, Equals, controller.ErrNotFound)
+}
+
+func (s *S) TestCreateProviderResource(c *C) {
+	provider := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if req.URL.Path == "/fail" {
+			w.WriteHeader(500)
+			return
+		}
+		w.Write([]byte(fmt.Sprintf(`{"id":"/things/%s","env":{"FOO":"bar"}}`, random.UUID())))
+	}))
+	defer provider.Close()
+	app := s.createTestApp(c, &ct.App{Name: "create-provider-resource"})
+
+	// a provider with a new URL should be registered
+	res, err := s.c.CreateProviderResource(&ct.ProviderResourceReq{
+		Name: "create-provider-resource",
+		URL:  provider.URL + "/things",
+		Apps: []string{app.ID},
+	})
+	c.Assert(err, IsNil)
+	c.Assert(res.ProviderCreated, Equals, true)
+	c.Assert(res.Provider.ID, Not(Equals), "")
+	c.Assert(res.Provider.Name, Equals, "create-provider-resource")
+	c.Assert(res.Resource.ProviderID, Equals, res.Provider.ID)
+	c.Assert(res.Resource.Env, DeepEquals, map[string]string{"FOO": "bar"})
+	c.Assert(res.Resource.Apps, DeepEquals, []string{app.ID})
+	gotProvider, err := s.c.GetProvider(res.Provider.ID)
+	c.Assert(err, IsNil)
+	c.Assert(gotProvider.URL, Equals, provider.URL+"/things")
+	gotResource, err := s.c.GetResource(res.Provider.ID, res.Resource.ID)
+	c.Assert(err, IsNil)
+	c.Assert(gotResource.ExternalID, Equals, res.Resource.ExternalID)
+
+	// an existing provider should be reused by URL, ignoring the name
+	existing, err := s.c.CreateProviderResource(&ct.ProviderResourceReq{
+		Name: "create-provider-resource-other",
+		URL:  provider.URL + "/things",
+	})
+	c.Assert(err, IsNil)
+	c.Assert(existing.ProviderCreated, Equals, false)
+	c.Assert(existing.Provider.ID, Equals, res.Provider.ID)
+	c.Assert(existing.Provider.Name, Equals, "create-provider-resource")
+	c.Assert(existing.Resource.ID, Not(Equals), res.Resource.ID)
+	c.Assert(existing.Resource.ProviderID, Equals, res.Provider.ID)
+	resources, err := s.c.ResourceList(res.Provider.ID)
+	c.Assert(err, IsNil)
+	c.Assert(resources, HasLen, 2)
+
+	// a new provider should not be registered if provisioning fails
+	_, err = s.c.CreateProviderResource(&ct.ProviderResourceReq{
+		Name: "create-provider-resource-fail",
+		URL:  provider.URL + "/fail",
+	})
+	c.Assert(err, NotNil)
+	_, err = s.c.GetProvider("create-provider-resource-fail")
+	c.Assert(err, Equals, controller.ErrNotFound)
+
+	// a new provider needs a name which is not taken
+	_, err = s.c.CreateProviderResource(&ct.ProviderResourceReq{URL: provider.URL + "/other"})
+	c.Assert(hh.IsValidationError(err), Equals, true)
+	_, err = s.c.CreateProviderResource(&ct.ProviderResourceReq{
+		Name: "create-provider-resource",
+		URL:  provider.URL + "/other",
+	})
+	c.Assert(hh.IsObjectExistsError(err), Equals, true)
+
+	// and the URL must be valid
+	_, err = s.c.CreateProviderResource(&ct.ProviderResourceReq{Name: "create-provider-resource-invalid", URL: "foo"})
+	c.Assert(hh.IsValidationError(err), Equals, true)
+}
+
+func (s *S) TestProvisionResourceLimit(c *C) {
+	started := make(chan struct{})
+	done := make(chan struct{})
+	provider := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if req.URL.Path == "/slow" {
+			started <- struct{}{}
+			<-done
+		}
+		w.Write([]byte(`{"id":"/things/provision-limit","env":{"FOO":"bar"}}`))
+	}))
+	defer provider.Close()
+
+	// use a handler which only serves one expensive request at a time
+	hc := s.hc
+	hc.expensiveRequestLimit = 1
+	srv := httptest.NewServer(appHandler(hc))
+	defer srv.Close()
+	client, err := controller.NewClient(srv.URL, authKey)
+	c.Assert(err, IsNil)
+
+	slow := &ct.Provider{URL: provider.URL + "/slow", Name: "provision-limit-slow"}
+	c.Assert(client.CreateProvider(slow), IsNil)
+	fast := &ct.Provider{URL: provider.URL + "/fast", Name: "provision-limit-fast"}
+	c.Assert(client.CreateProvider(fast), IsNil)
+
+	// start a provision which blocks in the provider
+	errs := make(chan error)
+	go func() {
+		_, err := client.ProvisionResource(&ct.ResourceReq{ProviderID: slow.ID})
+		errs <- err
+	}()
+	select {
+	case <-started:
+	case <-time.After(5 * time.Second):
+		c.Fatal("timed out waiting for provision to start")
+	}
+
+	// further provisions should be rejected while it is in progress
+	_, err = client.ProvisionResource(&ct.ResourceReq{ProviderID: fast.ID})
+	c.Assert(err, NotNil)
+	e, ok := err.(hh.JSONError)
+	c.Assert(ok, Equals, true)
+	c.Assert(e.Code, Equals, hh.ServiceUnavailableErrorCode)
+	c.Assert(e.Message, Equals, errServerBusy.Message)
+
+	// but other requests should still be served
+	_, err = client.GetProvider(fast.ID)
+	c.Assert(err, IsNil)
+	_, err = client.ProviderList()
+	c.Assert(err, IsNil)
+
+	// once the provision finishes, provisions should succeed again
+	close(done)
+	select {
+	case err := <-errs:
+		c.Assert(err, IsNil)
+	case <-time.After(5 * time.Second):
+		c.Fatal("timed out waiting for provision to finish")
+	}
+	res, err := client.ProvisionResource(&ct.ResourceReq{ProviderID: fast.ID})
+	c.Assert(err, IsNil)
+	c.Assert(res.ExternalID, Equals, "/things/provision-limit")
+}
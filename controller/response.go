@@ -0,0 +1,145 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// formatResponse wraps w so that JSON responses are pretty printed if the
+// request has a truthy "pretty" query parameter, and gzip compressed if the
+// client sends "Accept-Encoding: gzip".
+//
+// Only JSON responses are modified, so event streams, backups and hijacked
+// attach connections pass through untouched. The returned writer must be
+// closed once the handler returns.
+func formatResponse(w http.ResponseWriter, req *http.Request) *formatResponseWriter {
+	pretty, _ := strconv.ParseBool(req.URL.Query().Get("pretty"))
+	return &formatResponseWriter{
+		ResponseWriter: w,
+		gzip:           req.Method != "HEAD" && acceptsGzip(req),
+		pretty:         pretty,
+	}
+}
+
+func acceptsGzip(req *http.Request) bool {
+	for _, enc := range strings.Split(req.Header.Get("Accept-Encoding"), ",") {
+		if strings.TrimSpace(strings.SplitN(enc, ";", 2)[0]) == "gzip" {
+			return true
+		}
+	}
+	return false
+}
+
+type formatResponseWriter struct {
+	http.ResponseWriter
+
+	gzip   bool
+	pretty bool
+
+	started bool
+	status  int
+	gz      *gzip.Writer
+	buf     *bytes.Buffer
+}
+
+// start determines how the response should be encoded based on the status
+// and headers set by the handler and writes the response header (unless the
+// body is being buffered for pretty printing).
+func (w *formatResponseWriter) start(status int) {
+	w.started = true
+	w.status = status
+
+	header := w.Header()
+	isJSON := strings.HasPrefix(header.Get("Content-Type"), "application/json")
+	hasBody := status >= 200 && status != http.StatusNoContent && status != http.StatusNotModified
+	if !isJSON || !hasBody || header.Get("Content-Encoding") != "" {
+		w.ResponseWriter.WriteHeader(status)
+		return
+	}
+
+	if w.gzip {
+		header.Del("Content-Length")
+		header.Set("Content-Encoding", "gzip")
+		header.Add("Vary", "Accept-Encoding")
+		w.gz = gzip.NewWriter(w.ResponseWriter)
+	}
+	if w.pretty {
+		header.Del("Content-Length")
+		w.buf = &bytes.Buffer{}
+		return
+	}
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *formatResponseWriter) WriteHeader(status int) {
+	if w.started {
+		return
+	}
+	w.start(status)
+}
+
+func (w *formatResponseWriter) Write(p []byte) (int, error) {
+	if !w.started {
+		w.start(http.StatusOK)
+	}
+	switch {
+	case w.buf != nil:
+		return w.buf.Write(p)
+	case w.gz != nil:
+		return w.gz.Write(p)
+	default:
+		return w.ResponseWriter.Write(p)
+	}
+}
+
+// Close writes any buffered pretty printed output and flushes the gzip
+// stream.
+func (w *formatResponseWriter) Close() error {
+	if w.buf != nil {
+		data := w.buf.Bytes()
+		var out bytes.Buffer
+		if err := json.Indent(&out, data, "", "  "); err == nil {
+			data = out.Bytes()
+		}
+		w.buf = nil
+		w.ResponseWriter.WriteHeader(w.status)
+		if _, err := w.Write(data); err != nil {
+			return err
+		}
+	}
+	if w.gz != nil {
+		return w.gz.Close()
+	}
+	return nil
+}
+
+func (w *formatResponseWriter) Flush() {
+	if w.buf != nil {
+		return
+	}
+	if w.gz != nil {
+		w.gz.Flush()
+	}
+	if f, ok := w.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+func (w *formatResponseWriter) CloseNotify() <-chan bool {
+	return w.ResponseWriter.(http.CloseNotifier).CloseNotify()
+}
+
+func (w *formatResponseWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	hijacker, ok := w.ResponseWriter.(http.Hijacker)
+	if !ok {
+		return nil, nil, fmt.Errorf("the ResponseWriter doesn't support the Hijacker interface")
+	}
+	return hijacker.Hijack()
+}
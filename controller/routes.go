@@ -1,16 +1,92 @@
 package main
 
 import (
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"net"
 	"net/http"
+	"regexp"
+	"strings"
+	"time"
 
 	"github.com/flynn/flynn/controller/schema"
+	ct "github.com/flynn/flynn/controller/types"
+	"github.com/flynn/flynn/pkg/ctxhelper"
 	"github.com/flynn/flynn/pkg/httphelper"
 	"github.com/flynn/flynn/pkg/postgres"
 	routerc "github.com/flynn/flynn/router/client"
 	"github.com/flynn/flynn/router/types"
+	"github.com/jackc/pgx"
 	"golang.org/x/net/context"
 )
 
+// RouteMetaRepo stores metadata for routes, which the router itself has no
+// support for.
+type RouteMetaRepo struct {
+	db *postgres.DB
+}
+
+func NewRouteMetaRepo(db *postgres.DB) *RouteMetaRepo {
+	return &RouteMetaRepo{db}
+}
+
+// Get returns the metadata for the given route, which is empty if none has
+// been set.
+func (r *RouteMetaRepo) Get(routeID string) (map[string]string, error) {
+	var meta map[string]string
+	err := r.db.QueryRow("route_meta_select", routeID).Scan(&meta)
+	if err == pgx.ErrNoRows {
+		err = nil
+	}
+	if meta == nil {
+		// ensure `{}` rather than `null` when serializing to JSON
+		meta = map[string]string{}
+	}
+	return meta, err
+}
+
+func (r *RouteMetaRepo) Set(appID, routeID string, meta map[string]string) error {
+	if meta == nil {
+		meta = map[string]string{}
+	}
+	return r.db.Exec("route_meta_upsert", routeID, appID, meta)
+}
+
+func (r *RouteMetaRepo) Delete(routeID string) error {
+	return r.db.Exec("route_meta_delete", routeID)
+}
+
+// AddToRoutes sets the metadata of the given routes of an app, which is left
+// unset for routes which have none.
+func (r *RouteMetaRepo) AddToRoutes(appID string, routes []*router.Route) error {
+	rows, err := r.db.Query("route_meta_list_by_app", appID)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	metas := make(map[string]map[string]string)
+	for rows.Next() {
+		var id string
+		var meta map[string]string
+		if err := rows.Scan(&id, &meta); err != nil {
+			return err
+		}
+		metas[id] = meta
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+	for _, route := range routes {
+		route.Meta = nil
+		if meta := metas[route.ID]; len(meta) > 0 {
+			route.Meta = meta
+		}
+	}
+	return nil
+}
+
 func createRoute(db *postgres.DB, rc routerc.Client, appID string, route *router.Route) error {
 	route.ParentRef = routeParentRef(appID)
 	if err := schema.Validate(route); err != nil {
@@ -26,14 +102,136 @@ func (c *controllerAPI) CreateRoute(ctx context.Context, w http.ResponseWriter,
 		return
 	}
 
-	if err := createRoute(c.appRepo.db, c.routerc, c.getApp(ctx).ID, &route); err != nil {
-		respondWithError(w, err)
+	// TCP routes without a port are allocated a free port by the
+	// router, which responds with a conflict error if none are left
+	appID := c.getApp(ctx).ID
+	defer c.routeCache.Remove(appID)
+	if err := createRoute(c.appRepo.db, c.routerc, appID, &route); err != nil {
+		respondWithError(w, routerError(err))
 		return
 	}
 
 	httphelper.JSON(w, 200, &route)
 }
 
+// CreateRoutes creates several routes for an app at once, validating all of
+// them and checking they don't conflict with each other or existing routes
+// before creating any, and deleting those already created if creating a
+// later one fails.
+func (c *controllerAPI) CreateRoutes(ctx context.Context, w http.ResponseWriter, req *http.Request) {
+	var routes []*router.Route
+	if err := httphelper.DecodeJSON(req, &routes); err != nil {
+		respondWithError(w, err)
+		return
+	}
+	if len(routes) == 0 {
+		respondWithError(w, ct.ValidationError{Field: "routes", Message: "must not be empty"})
+		return
+	}
+
+	app := c.getApp(ctx)
+	for i, route := range routes {
+		field := fmt.Sprintf("routes[%d]", i)
+		if route == nil {
+			respondWithError(w, ct.ValidationError{Field: field, Message: "must not be null"})
+			return
+		}
+		route.ParentRef = routeParentRef(app.ID)
+		if err := schema.Validate(route); err != nil {
+			respondWithError(w, prefixValidationError(err, field))
+			return
+		}
+		if route.Type == "http" && route.Domain == "" {
+			respondWithError(w, ct.ValidationError{Field: field + ".domain", Message: "must be set for HTTP routes"})
+			return
+		}
+		if route.Type == "tcp" && (route.Domain != "" || route.Path != "") {
+			respondWithError(w, ct.ValidationError{Field: field, Message: "TCP routes must not have a domain or path"})
+			return
+		}
+	}
+
+	existing, err := c.routerc.ListRoutes("")
+	if err != nil {
+		respondWithError(w, routerError(err))
+		return
+	}
+	for i, route := range routes {
+		for _, r := range append(existing, routes[:i]...) {
+			if routesConflict(route, r) {
+				respondWithError(w, httphelper.ObjectExistsErr(fmt.Sprintf("routes[%d] conflicts with an existing route", i)))
+				return
+			}
+		}
+	}
+
+	defer c.routeCache.Remove(app.ID)
+	for i, route := range routes {
+		err := c.routerc.CreateRoute(route)
+		if err != nil {
+			// roll back the routes already created, which the router
+			// has no transactions for
+			for _, created := range routes[:i] {
+				c.routerc.DeleteRoute(created.Type, created.ID)
+			}
+			respondWithError(w, routerError(err))
+			return
+		}
+	}
+	httphelper.JSON(w, 200, routes)
+}
+
+// forgetRoutes removes the cached routes of each app which the given routes
+// belong to, for changes which are not limited to the routes of a single app
+// (for example replacing a certificate used by several apps).
+func (c *controllerAPI) forgetRoutes(routes []*router.Route) {
+	for _, route := range routes {
+		if strings.HasPrefix(route.ParentRef, ct.RouteParentRefPrefix) {
+			c.routeCache.Remove(strings.TrimPrefix(route.ParentRef, ct.RouteParentRefPrefix))
+		}
+	}
+}
+
+// routesConflict reports whether the router would refuse to have both routes,
+// which is the case for HTTP routes with the same domain and path, or TCP
+// routes with the same port.
+func routesConflict(a, b *router.Route) bool {
+	if a.Type != b.Type {
+		return false
+	}
+	switch a.Type {
+	case "http":
+		return strings.EqualFold(a.Domain, b.Domain) && routePath(a) == routePath(b)
+	case "tcp":
+		return a.Port != 0 && a.Port == b.Port
+	}
+	return false
+}
+
+// prefixValidationError prefixes the fields of the given validation error(s)
+// with prefix, leaving other errors untouched.
+func prefixValidationError(err error, prefix string) error {
+	prefixField := func(e ct.ValidationError) ct.ValidationError {
+		if e.Field == "" {
+			e.Field = prefix
+		} else {
+			e.Field = prefix + "." + e.Field
+		}
+		return e
+	}
+	switch e := err.(type) {
+	case ct.ValidationError:
+		return prefixField(e)
+	case ct.ValidationErrors:
+		errs := make(ct.ValidationErrors, len(e))
+		for i, v := range e {
+			errs[i] = prefixField(v)
+		}
+		return errs
+	}
+	return err
+}
+
 func (c *controllerAPI) GetRoute(ctx context.Context, w http.ResponseWriter, req *http.Request) {
 	route, err := c.getRoute(ctx)
 	if err != nil {
@@ -45,14 +243,287 @@ func (c *controllerAPI) GetRoute(ctx context.Context, w http.ResponseWriter, req
 }
 
 func (c *controllerAPI) GetRouteList(ctx context.Context, w http.ResponseWriter, req *http.Request) {
-	routes, err := c.routerc.ListRoutes(routeParentRef(c.getApp(ctx).ID))
+	appID := c.getApp(ctx).ID
+	routes, err := c.routerc.ListRoutes(routeParentRef(appID))
 	if err != nil {
+		// if the router is unavailable, serve the last known routes
+		// for the app (if any) marked as stale rather than failing
+		if cached, ok := c.routeCache.Get(appID); ok {
+			w.Header().Set("Warning", `110 - "Response is Stale"`)
+			httphelper.JSON(w, 200, cached)
+			return
+		}
+		respondWithError(w, routerError(err))
+		return
+	}
+	if err := c.routeMetaRepo.AddToRoutes(appID, routes); err != nil {
 		respondWithError(w, err)
 		return
 	}
+	c.routeCache.Add(appID, routes)
 	httphelper.JSON(w, 200, routes)
 }
 
+// GetAppRouteCount responds with the number of routes the app has. If the
+// router is unavailable, the count of the last known routes for the app is
+// returned marked as stale, or a null count if there are none.
+func (c *controllerAPI) GetAppRouteCount(ctx context.Context, w http.ResponseWriter, req *http.Request) {
+	appID := c.getApp(ctx).ID
+	res := &ct.RouteCount{}
+	if routes, err := c.routerc.ListRoutes(routeParentRef(appID)); err == nil {
+		c.routeCache.Add(appID, routes)
+		count := len(routes)
+		res.Count = &count
+	} else if cached, ok := c.routeCache.Get(appID); ok {
+		w.Header().Set("Warning", `110 - "Response is Stale"`)
+		count := len(cached.([]*router.Route))
+		res.Count = &count
+	}
+	httphelper.JSON(w, 200, res)
+}
+
+// GetAppLeaderJob responds with the job which is currently the leader of the
+// service an app's leader routes send traffic to, or null if the app has no
+// leader routes or the leader is not known.
+func (c *controllerAPI) GetAppLeaderJob(ctx context.Context, w http.ResponseWriter, req *http.Request) {
+	app := c.getApp(ctx)
+	routes, err := c.routerc.ListRoutes(routeParentRef(app.ID))
+	if err != nil {
+		respondWithError(w, routerError(err))
+		return
+	}
+	for _, route := range routes {
+		if !route.Leader {
+			continue
+		}
+		leader, err := c.serviceLeaders.Leader(route.Service)
+		if err != nil || leader == nil {
+			continue
+		}
+		jobID, ok := leader.Meta["FLYNN_JOB_ID"]
+		if !ok {
+			continue
+		}
+		job, err := c.jobRepo.Get(jobID)
+		if err == ErrNotFound || err == nil && job.AppID != app.ID {
+			continue
+		} else if err != nil {
+			respondWithError(w, err)
+			return
+		}
+		httphelper.JSON(w, 200, job)
+		return
+	}
+	httphelper.JSON(w, 200, nil)
+}
+
+// GetDomainApp responds with the app which has a HTTP route for the given
+// domain, or null if the domain is not routed to an app.
+func (c *controllerAPI) GetDomainApp(ctx context.Context, w http.ResponseWriter, req *http.Request) {
+	params, _ := ctxhelper.ParamsFromContext(ctx)
+	domain := params.ByName("domain")
+
+	routes, err := c.routerc.ListRoutes("")
+	if err != nil {
+		respondWithError(w, routerError(err))
+		return
+	}
+	for _, route := range routes {
+		if route.Type != "http" || !strings.EqualFold(route.Domain, domain) || !strings.HasPrefix(route.ParentRef, ct.RouteParentRefPrefix) {
+			continue
+		}
+		app, err := c.appRepo.Get(strings.TrimPrefix(route.ParentRef, ct.RouteParentRefPrefix))
+		if err == ErrNotFound {
+			break
+		} else if err != nil {
+			respondWithError(w, err)
+			return
+		}
+		httphelper.JSON(w, 200, app)
+		return
+	}
+	httphelper.JSON(w, 200, nil)
+}
+
+// GetAppCertificateStatus responds with the soonest expiry of the
+// certificates used by the app's HTTP routes, or null if the app has no TLS
+// routes.
+func (c *controllerAPI) GetAppCertificateStatus(ctx context.Context, w http.ResponseWriter, req *http.Request) {
+	routes, err := c.routerc.ListRoutes(routeParentRef(c.getApp(ctx).ID))
+	if err != nil {
+		respondWithError(w, routerError(err))
+		return
+	}
+	var status *ct.CertificateStatus
+	for _, route := range routes {
+		if route.Type != "http" {
+			continue
+		}
+		cert := route.LegacyTLSCert
+		if route.Certificate != nil {
+			cert = route.Certificate.Cert
+		}
+		if cert == "" {
+			continue
+		}
+		expiry, err := certificateExpiry(cert)
+		if err != nil {
+			respondWithError(w, err)
+			return
+		}
+		if status == nil || expiry.Before(status.SoonestExpiry) {
+			status = &ct.CertificateStatus{SoonestExpiry: expiry}
+		}
+	}
+	if status != nil {
+		status.ExpiringWithin30Days = status.SoonestExpiry.Before(time.Now().Add(30 * 24 * time.Hour))
+	}
+	httphelper.JSON(w, 200, status)
+}
+
+// certificateExpiry returns the expiry of the leaf certificate in the given
+// PEM encoded certificate chain.
+func certificateExpiry(chain string) (time.Time, error) {
+	cert, err := parseLeafCertificate(chain)
+	if err != nil {
+		return time.Time{}, err
+	}
+	return cert.NotAfter, nil
+}
+
+// parseLeafCertificate parses the leaf certificate in the given PEM encoded
+// certificate chain.
+func parseLeafCertificate(chain string) (*x509.Certificate, error) {
+	block, _ := pem.Decode([]byte(chain))
+	if block == nil || block.Type != "CERTIFICATE" {
+		return nil, errors.New("controller: invalid PEM encoded certificate")
+	}
+	return x509.ParseCertificate(block.Bytes)
+}
+
+// domainPattern matches a lowercase DNS name, optionally with a leading
+// wildcard label.
+var domainPattern = regexp.MustCompile(`^(\*\.)?([a-z\d]([a-z\d-]*[a-z\d])?\.)*[a-z\d]([a-z\d-]*[a-z\d])?$`)
+
+// ChangeRouteDomain moves an HTTP route to a new domain, checking that no
+// other route uses the domain and path and that the route's certificate (if
+// any) is valid for the new domain.
+func (c *controllerAPI) ChangeRouteDomain(ctx context.Context, w http.ResponseWriter, req *http.Request) {
+	var data ct.RouteDomain
+	if err := httphelper.DecodeJSON(req, &data); err != nil {
+		respondWithError(w, err)
+		return
+	}
+	domain := strings.ToLower(strings.TrimSuffix(data.Domain, "."))
+	if len(domain) > 253 || !domainPattern.MatchString(domain) {
+		respondWithError(w, ct.ValidationError{Field: "domain", Message: "is not a valid domain name"})
+		return
+	}
+
+	route, err := c.getRoute(ctx)
+	if err != nil {
+		respondWithError(w, err)
+		return
+	}
+	if route.Type != "http" {
+		respondWithError(w, ct.ValidationError{Message: "only HTTP routes have a domain"})
+		return
+	}
+	if route.Domain == domain {
+		httphelper.JSON(w, 200, route)
+		return
+	}
+
+	routes, err := c.routerc.ListRoutes("")
+	if err != nil {
+		respondWithError(w, routerError(err))
+		return
+	}
+	for _, r := range routes {
+		if r.Type == "http" && r.ID != route.ID && strings.EqualFold(r.Domain, domain) && routePath(r) == routePath(route) {
+			respondWithError(w, httphelper.ObjectExistsErr(fmt.Sprintf("a route for %s%s already exists", domain, routePath(route))))
+			return
+		}
+	}
+
+	chain := route.LegacyTLSCert
+	if route.Certificate != nil {
+		chain = route.Certificate.Cert
+	}
+	if chain != "" {
+		cert, err := parseLeafCertificate(chain)
+		if err != nil {
+			respondWithError(w, err)
+			return
+		}
+		if err := cert.VerifyHostname(domain); err != nil {
+			respondWithError(w, ct.ValidationError{Field: "domain", Message: "is not covered by the route's certificate"})
+			return
+		}
+	}
+
+	route.Domain = domain
+	defer c.routeCache.Remove(c.getApp(ctx).ID)
+	if err := c.routerc.UpdateRoute(route); err != nil {
+		respondWithError(w, routerError(err))
+		return
+	}
+	httphelper.JSON(w, 200, route)
+}
+
+// UpdateAppRoutes sets the given flags on all of the app's routes to which
+// they apply, responding with the routes which were changed.
+func (c *controllerAPI) UpdateAppRoutes(ctx context.Context, w http.ResponseWriter, req *http.Request) {
+	var flags ct.AppRouteFlags
+	if err := httphelper.DecodeJSON(req, &flags); err != nil {
+		respondWithError(w, err)
+		return
+	}
+	if flags.Sticky == nil && flags.Leader == nil {
+		respondWithError(w, ct.ValidationError{Message: "at least one of sticky or leader must be set"})
+		return
+	}
+
+	appID := c.getApp(ctx).ID
+	routes, err := c.routerc.ListRoutes(routeParentRef(appID))
+	if err != nil {
+		respondWithError(w, routerError(err))
+		return
+	}
+
+	defer c.routeCache.Remove(appID)
+	updated := make([]*router.Route, 0, len(routes))
+	for _, route := range routes {
+		changed := false
+		if flags.Sticky != nil && route.Type == "http" && route.Sticky != *flags.Sticky {
+			route.Sticky = *flags.Sticky
+			changed = true
+		}
+		if flags.Leader != nil && route.Leader != *flags.Leader {
+			route.Leader = *flags.Leader
+			changed = true
+		}
+		if !changed {
+			continue
+		}
+		if err := c.routerc.UpdateRoute(route); err != nil {
+			respondWithError(w, routerError(err))
+			return
+		}
+		updated = append(updated, route)
+	}
+	httphelper.JSON(w, 200, updated)
+}
+
+// routePath returns the path of an HTTP route, which the router treats as "/"
+// if not set.
+func routePath(r *router.Route) string {
+	if r.Path == "" {
+		return "/"
+	}
+	return r.Path
+}
+
 func (c *controllerAPI) UpdateRoute(ctx context.Context, w http.ResponseWriter, req *http.Request) {
 	var route *router.Route
 	if err := httphelper.DecodeJSON(req, &route); err != nil {
@@ -60,17 +531,18 @@ func (c *controllerAPI) UpdateRoute(ctx context.Context, w http.ResponseWriter,
 		return
 	}
 
+	defer c.routeCache.Remove(c.getApp(ctx).ID)
 	err := c.routerc.UpdateRoute(route)
-	if err == routerc.ErrNotFound {
-		err = ErrNotFound
-	}
 	if err != nil {
-		respondWithError(w, err)
+		respondWithError(w, routerError(err))
 		return
 	}
 	httphelper.JSON(w, 200, route)
 }
 
+// DeleteRoute deletes the route along with its metadata, which is deleted in
+// a transaction committed only once the router has deleted the route, so
+// the metadata is kept if deleting the route fails.
 func (c *controllerAPI) DeleteRoute(ctx context.Context, w http.ResponseWriter, req *http.Request) {
 	route, err := c.getRoute(ctx)
 	if err != nil {
@@ -78,13 +550,126 @@ func (c *controllerAPI) DeleteRoute(ctx context.Context, w http.ResponseWriter,
 		return
 	}
 
-	err = c.routerc.DeleteRoute(route.Type, route.ID)
-	if err == routerc.ErrNotFound {
-		err = ErrNotFound
-	}
+	tx, err := c.routeMetaRepo.db.Begin()
 	if err != nil {
 		respondWithError(w, err)
 		return
 	}
+	if err := tx.Exec("route_meta_delete", route.ID); err != nil {
+		tx.Rollback()
+		respondWithError(w, err)
+		return
+	}
+	defer c.routeCache.Remove(c.getApp(ctx).ID)
+	if err := c.routerc.DeleteRoute(route.Type, route.ID); err != nil {
+		tx.Rollback()
+		respondWithError(w, routerError(err))
+		return
+	}
+	if err := tx.Commit(); err != nil {
+		respondWithError(w, err)
+		return
+	}
 	w.WriteHeader(200)
 }
+
+// GetRouteURL responds with the external URL of the route in the request.
+func (c *controllerAPI) GetRouteURL(ctx context.Context, w http.ResponseWriter, req *http.Request) {
+	route, err := c.getRoute(ctx)
+	if err != nil {
+		respondWithError(w, err)
+		return
+	}
+	res := &ct.RouteURL{}
+	if u := routeURL(route, c.appRepo.defaultDomain); u != "" {
+		res.URL = &u
+	}
+	httphelper.JSON(w, 200, res)
+}
+
+// routeURL returns the external URL of the route, or an empty string if the
+// route is missing fields needed to build it.
+//
+// HTTP routes are served over HTTPS if they have a certificate or are under
+// the cluster's default domain (which the cluster certificate covers), and
+// TCP routes are served on their port of the cluster's default domain.
+func routeURL(route *router.Route, defaultDomain string) string {
+	switch route.Type {
+	case "http":
+		if route.Domain == "" {
+			return ""
+		}
+		scheme := "http"
+		if route.Certificate != nil || route.LegacyTLSCert != "" ||
+			defaultDomain != "" && (route.Domain == defaultDomain || strings.HasSuffix(route.Domain, "."+defaultDomain)) {
+			scheme = "https"
+		}
+		return scheme + "://" + route.Domain + route.Path
+	case "tcp":
+		if defaultDomain == "" || route.Port == 0 {
+			return ""
+		}
+		return fmt.Sprintf("%s:%d", defaultDomain, route.Port)
+	}
+	return ""
+}
+
+func (c *controllerAPI) GetRouteMeta(ctx context.Context, w http.ResponseWriter, req *http.Request) {
+	route, err := c.getRoute(ctx)
+	if err != nil {
+		respondWithError(w, err)
+		return
+	}
+	meta, err := c.routeMetaRepo.Get(route.ID)
+	if err != nil {
+		respondWithError(w, err)
+		return
+	}
+	httphelper.JSON(w, 200, meta)
+}
+
+func (c *controllerAPI) UpdateRouteMeta(ctx context.Context, w http.ResponseWriter, req *http.Request) {
+	route, err := c.getRoute(ctx)
+	if err != nil {
+		respondWithError(w, err)
+		return
+	}
+	var meta map[string]string
+	if err := httphelper.DecodeJSON(req, &meta); err != nil {
+		respondWithError(w, err)
+		return
+	}
+	if meta == nil {
+		meta = map[string]string{}
+	}
+	appID := c.getApp(ctx).ID
+	defer c.routeCache.Remove(appID)
+	if err := c.routeMetaRepo.Set(appID, route.ID, meta); err != nil {
+		respondWithError(w, err)
+		return
+	}
+	httphelper.JSON(w, 200, meta)
+}
+
+// routerError converts an error returned by the router client into one
+// suitable for responding with, returning a 503 for errors other than not
+// found so a router outage is distinguishable from a controller failure.
+func routerError(err error) error {
+	switch err.(type) {
+	case ct.ValidationError, ct.ValidationErrors, httphelper.JSONError:
+		return err
+	}
+	if err == routerc.ErrNotFound {
+		return ErrNotFound
+	}
+	if e, ok := err.(net.Error); ok && e.Timeout() {
+		// the router may still make a change which timed out, so the
+		// request is not safe to blindly retry
+		return dependencyTimeoutError(dependencyRouter, false)
+	}
+	return httphelper.JSONError{
+		Code:    httphelper.ServiceUnavailableErrorCode,
+		Message: fmt.Sprintf("router unavailable: %s", err),
+		Retry:   true,
+	}
+}
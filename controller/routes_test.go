@@ -1,12 +1,24 @@
 package main
 
 import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"errors"
+	"math/big"
 	"sort"
+	"strings"
 	"sync"
 	"time"
 
 	"github.com/flynn/flynn/controller/client"
 	ct "github.com/flynn/flynn/controller/types"
+	"github.com/flynn/flynn/discoverd/client"
+	"github.com/flynn/flynn/pkg/cluster"
+	hh "github.com/flynn/flynn/pkg/httphelper"
 	"github.com/flynn/flynn/pkg/random"
 	"github.com/flynn/flynn/pkg/stream"
 	routerc "github.com/flynn/flynn/router/client"
@@ -15,7 +27,41 @@ import (
 )
 
 func newFakeRouter() routerc.Client {
-	return &fakeRouter{routes: make(map[string]*router.Route)}
+	return &fakeRouter{
+		routes: make(map[string]*router.Route),
+		certs:  make(map[string]*router.Certificate),
+	}
+}
+
+// fakeServiceLeaders reports the leaders of services set with setLeader
+// rather than looking them up in discoverd.
+type fakeServiceLeaders struct {
+	mtx     sync.RWMutex
+	leaders map[string]*discoverd.Instance
+}
+
+func newFakeServiceLeaders() *fakeServiceLeaders {
+	return &fakeServiceLeaders{leaders: make(map[string]*discoverd.Instance)}
+}
+
+func (f *fakeServiceLeaders) Leader(service string) (*discoverd.Instance, error) {
+	f.mtx.RLock()
+	defer f.mtx.RUnlock()
+	leader, ok := f.leaders[service]
+	if !ok {
+		return nil, errors.New("no leader")
+	}
+	return leader, nil
+}
+
+func (f *fakeServiceLeaders) setLeader(service string, leader *discoverd.Instance) {
+	f.mtx.Lock()
+	defer f.mtx.Unlock()
+	if leader == nil {
+		delete(f.leaders, service)
+		return
+	}
+	f.leaders[service] = leader
 }
 
 type fakeStream struct{}
@@ -26,11 +72,60 @@ func (s *fakeStream) Err() error   { return nil }
 type fakeRouter struct {
 	mtx    sync.RWMutex
 	routes map[string]*router.Route
+	certs  map[string]*router.Certificate
+
+	// tcpPorts, if set, is the range of ports TCP routes without a port
+	// are allocated from, otherwise the router's default range is used
+	tcpPorts [2]int32
+
+	// err, if set, is returned when getting or listing routes to
+	// simulate the router being unavailable
+	err error
+}
+
+func (r *fakeRouter) setErr(err error) {
+	r.mtx.Lock()
+	defer r.mtx.Unlock()
+	r.err = err
+}
+
+func (r *fakeRouter) setTCPPorts(start, end int32) {
+	r.mtx.Lock()
+	defer r.mtx.Unlock()
+	r.tcpPorts = [2]int32{start, end}
+}
+
+// allocatePort returns the first port in the TCP port range which is not
+// used by an existing route, mirroring the router.
+func (r *fakeRouter) allocatePort() (int32, error) {
+	start, end := r.tcpPorts[0], r.tcpPorts[1]
+	if start == 0 && end == 0 {
+		start, end = 3000, 3500
+	}
+	used := make(map[int32]bool, len(r.routes))
+	for _, route := range r.routes {
+		if route.Type == "tcp" {
+			used[route.Port] = true
+		}
+	}
+	for port := start; port <= end; port++ {
+		if !used[port] {
+			return port, nil
+		}
+	}
+	return 0, hh.JSONError{Code: hh.ConflictErrorCode, Message: "No TCP ports available"}
 }
 
 func (r *fakeRouter) CreateRoute(route *router.Route) error {
 	r.mtx.Lock()
 	defer r.mtx.Unlock()
+	if route.Type == "tcp" && route.Port == 0 {
+		port, err := r.allocatePort()
+		if err != nil {
+			return err
+		}
+		route.Port = port
+	}
 	route.ID = route.Type + "/" + random.UUID()
 	now := time.Now()
 	route.CreatedAt = now
@@ -52,6 +147,9 @@ func (r *fakeRouter) DeleteRoute(routeType, id string) error {
 func (r *fakeRouter) GetRoute(routeType, id string) (*router.Route, error) {
 	r.mtx.RLock()
 	defer r.mtx.RUnlock()
+	if r.err != nil {
+		return nil, r.err
+	}
 
 	route, ok := r.routes[routeType+"/"+id]
 	if !ok {
@@ -73,24 +171,89 @@ func (r *fakeRouter) StreamEvents(output chan *router.StreamEvent) (stream.Strea
 	return &fakeStream{}, nil
 }
 
+// CreateCert stores the certificate, reusing the ID of an existing one with
+// the same cert and attaching it to the given routes, mirroring the router.
 func (r *fakeRouter) CreateCert(cert *router.Certificate) error {
+	r.mtx.Lock()
+	defer r.mtx.Unlock()
+	if _, err := tls.X509KeyPair([]byte(cert.Cert), []byte(cert.Key)); err != nil {
+		return hh.JSONError{Code: hh.ValidationErrorCode, Message: "Certificate invalid: " + err.Error()}
+	}
+	var existing *router.Certificate
+	for _, c := range r.certs {
+		if c.Cert == cert.Cert {
+			existing = c
+			break
+		}
+	}
+	if existing != nil {
+		cert.ID = existing.ID
+		cert.CreatedAt = existing.CreatedAt
+		cert.UpdatedAt = existing.UpdatedAt
+	} else {
+		cert.ID = random.UUID()
+		cert.CreatedAt = time.Now()
+		cert.UpdatedAt = cert.CreatedAt
+	}
+	stored := *cert
+	stored.Routes = nil
+	r.certs[cert.ID] = &stored
+	for _, id := range cert.Routes {
+		if route, ok := r.routes[id]; ok {
+			c := stored
+			route.Certificate = &c
+		}
+	}
 	return nil
 }
 
 func (r *fakeRouter) GetCert(id string) (*router.Certificate, error) {
-	return nil, nil
+	r.mtx.RLock()
+	defer r.mtx.RUnlock()
+	cert, ok := r.certs[id]
+	if !ok {
+		return nil, routerc.ErrNotFound
+	}
+	c := *cert
+	return &c, nil
 }
 
 func (r *fakeRouter) DeleteCert(id string) error {
+	r.mtx.Lock()
+	defer r.mtx.Unlock()
+	if _, ok := r.certs[id]; !ok {
+		return routerc.ErrNotFound
+	}
+	delete(r.certs, id)
+	for _, route := range r.routes {
+		if route.Certificate != nil && route.Certificate.ID == id {
+			route.Certificate = nil
+		}
+	}
 	return nil
 }
 
 func (r *fakeRouter) ListCerts() ([]*router.Certificate, error) {
-	return nil, nil
+	r.mtx.RLock()
+	defer r.mtx.RUnlock()
+	certs := make([]*router.Certificate, 0, len(r.certs))
+	for _, cert := range r.certs {
+		c := *cert
+		certs = append(certs, &c)
+	}
+	return certs, nil
 }
 
 func (r *fakeRouter) ListCertRoutes(id string) ([]*router.Route, error) {
-	return nil, nil
+	r.mtx.RLock()
+	defer r.mtx.RUnlock()
+	var routes []*router.Route
+	for _, route := range r.routes {
+		if route.Certificate != nil && route.Certificate.ID == id {
+			routes = append(routes, route)
+		}
+	}
+	return routes, nil
 }
 
 type sortedRoutes []*router.Route
@@ -102,6 +265,9 @@ func (p sortedRoutes) Swap(i, j int)      { p[i], p[j] = p[j], p[i] }
 func (r *fakeRouter) ListRoutes(parentRef string) ([]*router.Route, error) {
 	r.mtx.RLock()
 	defer r.mtx.RUnlock()
+	if r.err != nil {
+		return nil, r.err
+	}
 
 	routes := make([]*router.Route, 0, len(r.routes))
 	for _, route := range r.routes {
@@ -131,6 +297,177 @@ func (s *S) TestCreateRoute(c *C) {
 	c.Assert(gotRoute, DeepEquals, route)
 }
 
+func (s *S) TestCreateTCPRouteAllocatesPort(c *C) {
+	app := s.createTestApp(c, &ct.App{Name: "create-tcp-route-port"})
+	fr := s.hc.rc.(*fakeRouter)
+	fr.setTCPPorts(45000, 45001)
+	defer fr.setTCPPorts(0, 0)
+
+	// routes without a port should be allocated one from the range
+	route1 := s.createTestRoute(c, app.ID, (&router.TCPRoute{Service: "foo"}).ToRoute())
+	c.Assert(route1.Port, Equals, int32(45000))
+	gotRoute, err := s.c.GetRoute(app.ID, route1.ID)
+	c.Assert(err, IsNil)
+	c.Assert(gotRoute.Port, Equals, int32(45000))
+	route2 := s.createTestRoute(c, app.ID, (&router.TCPRoute{Service: "foo"}).ToRoute())
+	c.Assert(route2.Port, Equals, int32(45001))
+
+	// once the range is exhausted, creating a route should fail
+	err = s.c.CreateRoute(app.ID, (&router.TCPRoute{Service: "foo"}).ToRoute())
+	c.Assert(err, NotNil)
+	c.Assert(err.(hh.JSONError).Code, Equals, hh.ConflictErrorCode)
+	c.Assert(err.(hh.JSONError).Message, Equals, "No TCP ports available")
+
+	// deleting a route should free up its port
+	c.Assert(s.c.DeleteRoute(app.ID, route1.ID), IsNil)
+	route3 := s.createTestRoute(c, app.ID, (&router.TCPRoute{Service: "foo"}).ToRoute())
+	c.Assert(route3.Port, Equals, int32(45000))
+}
+
+func (s *S) TestCreateRoutes(c *C) {
+	app := s.createTestApp(c, &ct.App{Name: "create-routes"})
+
+	// a mixed batch of HTTP and TCP routes should all be created
+	routes, err := s.c.CreateRoutes(app.ID, []*router.Route{
+		(&router.HTTPRoute{Service: "create-routes-web", Domain: "create-routes.example.com"}).ToRoute(),
+		(&router.HTTPRoute{Service: "create-routes-web", Domain: "www.create-routes.example.com"}).ToRoute(),
+		(&router.TCPRoute{Service: "create-routes-tcp"}).ToRoute(),
+	})
+	c.Assert(err, IsNil)
+	c.Assert(routes, HasLen, 3)
+	for _, route := range routes {
+		c.Assert(route.ID, Not(Equals), "")
+		gotRoute, err := s.c.GetRoute(app.ID, route.ID)
+		c.Assert(err, IsNil)
+		c.Assert(gotRoute, DeepEquals, route)
+	}
+	c.Assert(routes[2].Type, Equals, "tcp")
+	c.Assert(routes[2].Port, Not(Equals), int32(0))
+	list, err := s.c.RouteList(app.ID)
+	c.Assert(err, IsNil)
+	c.Assert(list, HasLen, 3)
+
+	// invalid routes should be rejected
+	_, err = s.c.CreateRoutes(app.ID, []*router.Route{
+		(&router.HTTPRoute{Service: "create-routes-web", Domain: "invalid.create-routes.example.com"}).ToRoute(),
+		{Type: "http", Service: "create-routes-web"},
+	})
+	c.Assert(hh.IsValidationError(err), Equals, true)
+	c.Assert(err.(hh.JSONError).Message, Equals, "routes[1].domain must be set for HTTP routes")
+	_, err = s.c.CreateRoutes(app.ID, []*router.Route{{Type: "udp", Service: "create-routes-web"}})
+	c.Assert(hh.IsValidationError(err), Equals, true)
+	list, err = s.c.RouteList(app.ID)
+	c.Assert(err, IsNil)
+	c.Assert(list, HasLen, 3)
+
+	// a batch conflicting with an existing domain should create nothing
+	other := s.createTestApp(c, &ct.App{Name: "create-routes-conflict"})
+	_, err = s.c.CreateRoutes(other.ID, []*router.Route{
+		(&router.TCPRoute{Service: "create-routes-conflict-tcp"}).ToRoute(),
+		(&router.HTTPRoute{Service: "create-routes-conflict-web", Domain: "www.create-routes.example.com"}).ToRoute(),
+	})
+	c.Assert(hh.IsObjectExistsError(err), Equals, true)
+	list, err = s.c.RouteList(other.ID)
+	c.Assert(err, IsNil)
+	c.Assert(list, HasLen, 0)
+
+	// as should a batch conflicting with itself
+	_, err = s.c.CreateRoutes(other.ID, []*router.Route{
+		(&router.HTTPRoute{Service: "create-routes-conflict-web", Domain: "create-routes-conflict.example.com"}).ToRoute(),
+		(&router.HTTPRoute{Service: "create-routes-conflict-web", Domain: "Create-Routes-Conflict.example.com"}).ToRoute(),
+	})
+	c.Assert(hh.IsObjectExistsError(err), Equals, true)
+	list, err = s.c.RouteList(other.ID)
+	c.Assert(err, IsNil)
+	c.Assert(list, HasLen, 0)
+
+	// routes created before the router rejects one should be rolled back
+	fr := s.hc.rc.(*fakeRouter)
+	fr.setTCPPorts(46000, 46000)
+	defer fr.setTCPPorts(0, 0)
+	_, err = s.c.CreateRoutes(other.ID, []*router.Route{
+		(&router.HTTPRoute{Service: "create-routes-conflict-web", Domain: "create-routes-conflict.example.com"}).ToRoute(),
+		(&router.TCPRoute{Service: "create-routes-conflict-tcp"}).ToRoute(),
+		(&router.TCPRoute{Service: "create-routes-conflict-tcp"}).ToRoute(),
+	})
+	c.Assert(err, NotNil)
+	c.Assert(err.(hh.JSONError).Code, Equals, hh.ConflictErrorCode)
+	list, err = s.c.RouteList(other.ID)
+	c.Assert(err, IsNil)
+	c.Assert(list, HasLen, 0)
+}
+
+func (s *S) TestUpdateAppRoutes(c *C) {
+	app := s.createTestApp(c, &ct.App{Name: "update-app-routes"})
+	http1 := s.createTestRoute(c, app.ID, (&router.HTTPRoute{Service: "foo", Domain: "update-app-routes.example.com"}).ToRoute())
+	http2 := s.createTestRoute(c, app.ID, (&router.HTTPRoute{Service: "foo", Domain: "www.update-app-routes.example.com", Sticky: true}).ToRoute())
+	tcp := s.createTestRoute(c, app.ID, (&router.TCPRoute{Service: "foo"}).ToRoute())
+	other := s.createTestApp(c, &ct.App{Name: "update-app-routes-other"})
+	otherRoute := s.createTestRoute(c, other.ID, (&router.HTTPRoute{Service: "bar", Domain: "update-app-routes-other.example.com"}).ToRoute())
+
+	getRoute := func(appID string, route *router.Route) *router.Route {
+		r, err := s.c.GetRoute(appID, route.ID)
+		c.Assert(err, IsNil)
+		return r
+	}
+	routeIDs := func(routes []*router.Route) []string {
+		ids := make([]string, len(routes))
+		for i, r := range routes {
+			ids[i] = r.ID
+		}
+		sort.Strings(ids)
+		return ids
+	}
+	sortedIDs := func(ids ...string) []string {
+		sort.Strings(ids)
+		return ids
+	}
+	t, f := true, false
+
+	// sticky should only be set on HTTP routes which aren't already sticky
+	updated, err := s.c.UpdateAppRoutes(app.ID, &ct.AppRouteFlags{Sticky: &t})
+	c.Assert(err, IsNil)
+	c.Assert(routeIDs(updated), DeepEquals, []string{http1.ID})
+	c.Assert(getRoute(app.ID, http1).Sticky, Equals, true)
+	c.Assert(getRoute(app.ID, http2).Sticky, Equals, true)
+	c.Assert(getRoute(app.ID, tcp).Sticky, Equals, false)
+	c.Assert(getRoute(other.ID, otherRoute).Sticky, Equals, false)
+
+	// leader should be set on all routes
+	updated, err = s.c.UpdateAppRoutes(app.ID, &ct.AppRouteFlags{Sticky: &f, Leader: &t})
+	c.Assert(err, IsNil)
+	c.Assert(routeIDs(updated), DeepEquals, sortedIDs(http1.ID, http2.ID, tcp.ID))
+	for _, route := range []*router.Route{http1, http2, tcp} {
+		r := getRoute(app.ID, route)
+		c.Assert(r.Leader, Equals, true)
+		c.Assert(r.Sticky, Equals, false)
+	}
+	c.Assert(getRoute(other.ID, otherRoute).Leader, Equals, false)
+
+	// unchanged routes should not be returned
+	updated, err = s.c.UpdateAppRoutes(app.ID, &ct.AppRouteFlags{Leader: &t})
+	c.Assert(err, IsNil)
+	c.Assert(updated, HasLen, 0)
+
+	// at least one flag must be set
+	_, err = s.c.UpdateAppRoutes(app.ID, &ct.AppRouteFlags{})
+	c.Assert(hh.IsValidationError(err), Equals, true)
+}
+
+func (s *S) TestGetDomainApp(c *C) {
+	app := s.createTestApp(c, &ct.App{Name: "domain-app"})
+	s.createTestRoute(c, app.ID, (&router.HTTPRoute{Domain: "domain-app.example.com", Service: "foo"}).ToRoute())
+
+	gotApp, err := s.c.GetDomainApp("domain-app.example.com")
+	c.Assert(err, IsNil)
+	c.Assert(gotApp, NotNil)
+	c.Assert(gotApp.ID, Equals, app.ID)
+
+	gotApp, err = s.c.GetDomainApp("unrouted.example.com")
+	c.Assert(err, IsNil)
+	c.Assert(gotApp, IsNil)
+}
+
 func (s *S) TestDeleteRoute(c *C) {
 	app := s.createTestApp(c, &ct.App{Name: "delete-route"})
 	route := s.createTestRoute(c, app.ID, (&router.TCPRoute{Service: "foo"}).ToRoute())
@@ -164,6 +501,138 @@ func (s *S) TestUpdateRoute(c *C) {
 	c.Assert(routes[0].Sticky, Equals, route1.Sticky)
 }
 
+func (s *S) TestRouteMeta(c *C) {
+	app := s.createTestApp(c, &ct.App{Name: "route-meta"})
+	route := s.createTestRoute(c, app.ID, (&router.HTTPRoute{Service: "foo", Domain: "meta.example.com"}).ToRoute())
+
+	// routes have empty metadata by default
+	meta, err := s.c.GetRouteMeta(app.ID, route.ID)
+	c.Assert(err, IsNil)
+	c.Assert(meta, DeepEquals, map[string]string{})
+
+	c.Assert(s.c.UpdateRouteMeta(app.ID, route.ID, map[string]string{"owner": "web-team", "env": "staging"}), IsNil)
+	meta, err = s.c.GetRouteMeta(app.ID, route.ID)
+	c.Assert(err, IsNil)
+	c.Assert(meta, DeepEquals, map[string]string{"owner": "web-team", "env": "staging"})
+
+	// updating replaces the existing metadata
+	c.Assert(s.c.UpdateRouteMeta(app.ID, route.ID, map[string]string{"env": "production"}), IsNil)
+	meta, err = s.c.GetRouteMeta(app.ID, route.ID)
+	c.Assert(err, IsNil)
+	c.Assert(meta, DeepEquals, map[string]string{"env": "production"})
+
+	// the metadata is included when getting and listing routes
+	got, err := s.c.GetRoute(app.ID, route.ID)
+	c.Assert(err, IsNil)
+	c.Assert(got.Meta, DeepEquals, map[string]string{"env": "production"})
+	routes, err := s.c.RouteList(app.ID)
+	c.Assert(err, IsNil)
+	c.Assert(routes, HasLen, 1)
+	c.Assert(routes[0].Meta, DeepEquals, map[string]string{"env": "production"})
+
+	// routes belonging to other apps are not found
+	other := s.createTestApp(c, &ct.App{Name: "route-meta-other"})
+	_, err = s.c.GetRouteMeta(other.ID, route.ID)
+	c.Assert(err, Equals, controller.ErrNotFound)
+
+	// deleting the route deletes its metadata
+	c.Assert(s.c.DeleteRoute(app.ID, route.ID), IsNil)
+	var count int
+	c.Assert(s.hc.db.QueryRow("SELECT COUNT(*) FROM route_meta WHERE route_id = $1", route.ID).Scan(&count), IsNil)
+	c.Assert(count, Equals, 0)
+}
+
+func (s *S) TestRouteURL(c *C) {
+	app := s.createTestApp(c, &ct.App{Name: "route-url"})
+	route := s.createTestRoute(c, app.ID, (&router.HTTPRoute{Service: "foo", Domain: "url.example.com"}).ToRoute())
+	u, err := s.c.GetRouteURL(app.ID, route.ID)
+	c.Assert(err, IsNil)
+	c.Assert(u, Equals, "http://url.example.com")
+
+	route = s.createTestRoute(c, app.ID, (&router.HTTPRoute{Service: "foo", Domain: "url.example.com", Path: "/api/"}).ToRoute())
+	u, err = s.c.GetRouteURL(app.ID, route.ID)
+	c.Assert(err, IsNil)
+	c.Assert(u, Equals, "http://url.example.com/api/")
+
+	// the test controller has no default domain to serve TCP routes on
+	route = s.createTestRoute(c, app.ID, (&router.TCPRoute{Service: "foo"}).ToRoute())
+	u, err = s.c.GetRouteURL(app.ID, route.ID)
+	c.Assert(err, IsNil)
+	c.Assert(u, Equals, "")
+
+	for _, t := range []struct {
+		route *router.Route
+		url   string
+	}{
+		{(&router.HTTPRoute{Domain: "app.example.com"}).ToRoute(), "http://app.example.com"},
+		{(&router.HTTPRoute{Domain: "app.example.com", Path: "/api/"}).ToRoute(), "http://app.example.com/api/"},
+		{(&router.HTTPRoute{Domain: "app.example.com", Certificate: &router.Certificate{}}).ToRoute(), "https://app.example.com"},
+		{(&router.HTTPRoute{Domain: "app.cluster.example.com"}).ToRoute(), "https://app.cluster.example.com"},
+		{(&router.HTTPRoute{Domain: "app.notcluster.example.com"}).ToRoute(), "http://app.notcluster.example.com"},
+		{(&router.HTTPRoute{}).ToRoute(), ""},
+		{(&router.TCPRoute{Port: 2222}).ToRoute(), "cluster.example.com:2222"},
+		{(&router.TCPRoute{}).ToRoute(), ""},
+	} {
+		c.Assert(routeURL(t.route, "cluster.example.com"), Equals, t.url)
+	}
+}
+
+func (s *S) TestRouterUnavailable(c *C) {
+	app := s.createTestApp(c, &ct.App{Name: "router-unavailable"})
+	route := s.createTestRoute(c, app.ID, (&router.TCPRoute{Service: "foo"}).ToRoute())
+	uncachedApp := s.createTestApp(c, &ct.App{Name: "router-unavailable-uncached"})
+
+	// list the routes so they are cached
+	routes, err := s.c.RouteList(app.ID)
+	c.Assert(err, IsNil)
+	c.Assert(routes, HasLen, 1)
+
+	fr := s.hc.rc.(*fakeRouter)
+	fr.setErr(errors.New("connection refused"))
+	defer fr.setErr(nil)
+
+	// app fields should still be available
+	gotApp, err := s.c.GetApp(app.ID)
+	c.Assert(err, IsNil)
+	c.Assert(gotApp.Name, Equals, app.Name)
+
+	// the last known routes should be returned
+	routes, err = s.c.RouteList(app.ID)
+	c.Assert(err, IsNil)
+	c.Assert(routes, HasLen, 1)
+	c.Assert(routes[0].ID, Equals, route.ID)
+
+	// routes which aren't cached should return a service unavailable error
+	_, err = s.c.RouteList(uncachedApp.ID)
+	c.Assert(err, NotNil)
+	e, ok := err.(hh.JSONError)
+	if !ok {
+		c.Fatalf("expected error to have type httphelper.JSONError, got %T", err)
+	}
+	c.Assert(e.Code, Equals, hh.ServiceUnavailableErrorCode)
+
+	_, err = s.c.GetRoute(app.ID, route.ID)
+	c.Assert(err, NotNil)
+	e, ok = err.(hh.JSONError)
+	if !ok {
+		c.Fatalf("expected error to have type httphelper.JSONError, got %T", err)
+	}
+	c.Assert(e.Code, Equals, hh.ServiceUnavailableErrorCode)
+
+	// changing the app's routes invalidates the cached routes so that
+	// stale routes are not served if the router becomes unavailable
+	fr.setErr(nil)
+	s.createTestRoute(c, app.ID, (&router.TCPRoute{Service: "bar"}).ToRoute())
+	fr.setErr(errors.New("connection refused"))
+	_, err = s.c.RouteList(app.ID)
+	c.Assert(err, NotNil)
+	e, ok = err.(hh.JSONError)
+	if !ok {
+		c.Fatalf("expected error to have type httphelper.JSONError, got %T", err)
+	}
+	c.Assert(e.Code, Equals, hh.ServiceUnavailableErrorCode)
+}
+
 func (s *S) TestListRoutes(c *C) {
 	app0 := s.createTestApp(c, &ct.App{Name: "delete-route1"})
 	app1 := s.createTestApp(c, &ct.App{Name: "delete-route2"})
@@ -180,3 +649,288 @@ func (s *S) TestListRoutes(c *C) {
 	c.Assert(routes[1].ID, Equals, route0.ID)
 	c.Assert(routes[0].ID, Equals, route1.ID)
 }
+
+// generateTestCert returns a PEM encoded self-signed certificate for the given
+// domain which expires at notAfter.
+func generateTestCert(c *C, domain string, notAfter time.Time) string {
+	cert, _ := generateTestCertAndKey(c, domain, notAfter)
+	return cert
+}
+
+// generateTestCertAndKey is like generateTestCert but also returns the PEM
+// encoded private key of the certificate.
+func generateTestCertAndKey(c *C, domain string, notAfter time.Time) (string, string) {
+	key, err := rsa.GenerateKey(rand.Reader, 1024)
+	c.Assert(err, IsNil)
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: domain},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     notAfter,
+		DNSNames:     []string{domain},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	c.Assert(err, IsNil)
+	cert := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+	keyPEM := pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(key)})
+	return string(cert), string(keyPEM)
+}
+
+func (s *S) TestAppCertificateStatus(c *C) {
+	app := s.createTestApp(c, &ct.App{Name: "certificate-status"})
+
+	// an app without TLS routes has no certificate status
+	s.createTestRoute(c, app.ID, (&router.HTTPRoute{Service: "foo", Domain: "plain.example.com"}).ToRoute())
+	status, err := s.c.GetAppCertificateStatus(app.ID)
+	c.Assert(err, IsNil)
+	c.Assert(status, IsNil)
+
+	soon := time.Now().Add(10 * 24 * time.Hour).Truncate(time.Second).UTC()
+	later := time.Now().Add(90 * 24 * time.Hour).Truncate(time.Second).UTC()
+	for domain, expiry := range map[string]time.Time{
+		"soon.example.com":  soon,
+		"later.example.com": later,
+	} {
+		route := (&router.HTTPRoute{Service: "foo", Domain: domain}).ToRoute()
+		route.Certificate = &router.Certificate{Cert: generateTestCert(c, domain, expiry)}
+		s.createTestRoute(c, app.ID, route)
+	}
+	status, err = s.c.GetAppCertificateStatus(app.ID)
+	c.Assert(err, IsNil)
+	c.Assert(status, NotNil)
+	c.Assert(status.SoonestExpiry.Equal(soon), Equals, true)
+	c.Assert(status.ExpiringWithin30Days, Equals, true)
+
+	// an app whose certificates all expire after 30 days is not expiring
+	app = s.createTestApp(c, &ct.App{Name: "certificate-status-later"})
+	route := (&router.HTTPRoute{Service: "foo", Domain: "later2.example.com"}).ToRoute()
+	route.Certificate = &router.Certificate{Cert: generateTestCert(c, "later2.example.com", later)}
+	s.createTestRoute(c, app.ID, route)
+	status, err = s.c.GetAppCertificateStatus(app.ID)
+	c.Assert(err, IsNil)
+	c.Assert(status, NotNil)
+	c.Assert(status.SoonestExpiry.Equal(later), Equals, true)
+	c.Assert(status.ExpiringWithin30Days, Equals, false)
+}
+
+func (s *S) TestChangeRouteDomain(c *C) {
+	app := s.createTestApp(c, &ct.App{Name: "change-route-domain"})
+	route := s.createTestRoute(c, app.ID, (&router.HTTPRoute{Service: "foo", Domain: "change-route-domain.example.com"}).ToRoute())
+	other := s.createTestApp(c, &ct.App{Name: "change-route-domain-other"})
+	s.createTestRoute(c, other.ID, (&router.HTTPRoute{Service: "bar", Domain: "change-route-domain-taken.example.com"}).ToRoute())
+
+	// changing to a free domain updates the route
+	updated, err := s.c.ChangeRouteDomain(app.ID, route.ID, "Change-Route-Domain-New.example.com")
+	c.Assert(err, IsNil)
+	c.Assert(updated.ID, Equals, route.ID)
+	c.Assert(updated.Domain, Equals, "change-route-domain-new.example.com")
+	gotRoute, err := s.c.GetRoute(app.ID, route.ID)
+	c.Assert(err, IsNil)
+	c.Assert(gotRoute.Domain, Equals, "change-route-domain-new.example.com")
+
+	// changing to a domain used by another route is rejected
+	_, err = s.c.ChangeRouteDomain(app.ID, route.ID, "change-route-domain-taken.example.com")
+	c.Assert(hh.IsObjectExistsError(err), Equals, true)
+	gotRoute, err = s.c.GetRoute(app.ID, route.ID)
+	c.Assert(err, IsNil)
+	c.Assert(gotRoute.Domain, Equals, "change-route-domain-new.example.com")
+
+	// a different path on the same domain is not a conflict
+	pathRoute := (&router.HTTPRoute{Service: "foo", Domain: "change-route-domain-path.example.com", Path: "/api/"}).ToRoute()
+	s.createTestRoute(c, app.ID, pathRoute)
+	_, err = s.c.ChangeRouteDomain(app.ID, pathRoute.ID, "change-route-domain-taken.example.com")
+	c.Assert(err, IsNil)
+
+	// invalid domains are rejected
+	_, err = s.c.ChangeRouteDomain(app.ID, route.ID, "http://example.com")
+	c.Assert(hh.IsValidationError(err), Equals, true)
+
+	// the domain must be covered by the route's certificate
+	tlsRoute := (&router.HTTPRoute{Service: "foo", Domain: "change-route-domain-tls.example.com"}).ToRoute()
+	tlsRoute.Certificate = &router.Certificate{Cert: generateTestCert(c, "*.change-route-domain.example.com", time.Now().Add(time.Hour))}
+	s.createTestRoute(c, app.ID, tlsRoute)
+	_, err = s.c.ChangeRouteDomain(app.ID, tlsRoute.ID, "change-route-domain-other.example.com")
+	c.Assert(hh.IsValidationError(err), Equals, true)
+	updated, err = s.c.ChangeRouteDomain(app.ID, tlsRoute.ID, "tls.change-route-domain.example.com")
+	c.Assert(err, IsNil)
+	c.Assert(updated.Domain, Equals, "tls.change-route-domain.example.com")
+
+	// TCP routes have no domain
+	tcpRoute := s.createTestRoute(c, app.ID, (&router.TCPRoute{Service: "foo"}).ToRoute())
+	_, err = s.c.ChangeRouteDomain(app.ID, tcpRoute.ID, "change-route-domain-tcp.example.com")
+	c.Assert(hh.IsValidationError(err), Equals, true)
+}
+
+func (s *S) TestAppRouteCount(c *C) {
+	app := s.createTestApp(c, &ct.App{Name: "route-count"})
+	other := s.createTestApp(c, &ct.App{Name: "route-count-other"})
+
+	count, err := s.c.GetAppRouteCount(app.ID)
+	c.Assert(err, IsNil)
+	c.Assert(count, NotNil)
+	c.Assert(*count, Equals, 0)
+
+	s.createTestRoute(c, app.ID, (&router.TCPRoute{Service: "foo"}).ToRoute())
+	s.createTestRoute(c, app.ID, (&router.HTTPRoute{Service: "foo", Domain: "route-count.example.com"}).ToRoute())
+	s.createTestRoute(c, other.ID, (&router.HTTPRoute{Service: "bar", Domain: "route-count-other.example.com"}).ToRoute())
+	count, err = s.c.GetAppRouteCount(app.ID)
+	c.Assert(err, IsNil)
+	c.Assert(count, NotNil)
+	c.Assert(*count, Equals, 2)
+
+	// the last known count should be returned if the router is
+	// unavailable, or null if the routes aren't known
+	fr := s.hc.rc.(*fakeRouter)
+	fr.setErr(errors.New("connection refused"))
+	defer fr.setErr(nil)
+	count, err = s.c.GetAppRouteCount(app.ID)
+	c.Assert(err, IsNil)
+	c.Assert(count, NotNil)
+	c.Assert(*count, Equals, 2)
+	count, err = s.c.GetAppRouteCount(other.ID)
+	c.Assert(err, IsNil)
+	c.Assert(count, IsNil)
+}
+
+func (s *S) TestAppLeaderJob(c *C) {
+	app := s.createTestApp(c, &ct.App{Name: "app-leader-job"})
+	release := s.createTestRelease(c, &ct.Release{})
+	s.createTestFormation(c, &ct.Formation{AppID: app.ID, ReleaseID: release.ID})
+	leader := s.createTestJob(c, &ct.Job{UUID: random.UUID(), HostID: "host0", AppID: app.ID, ReleaseID: release.ID, Type: "web", State: ct.JobStateUp})
+	s.createTestJob(c, &ct.Job{UUID: random.UUID(), HostID: "host1", AppID: app.ID, ReleaseID: release.ID, Type: "web", State: ct.JobStateUp})
+
+	// an app without leader routes has no leader job
+	s.createTestRoute(c, app.ID, (&router.HTTPRoute{Service: "app-leader-job-web", Domain: "app-leader-job.example.com"}).ToRoute())
+	job, err := s.c.GetAppLeaderJob(app.ID)
+	c.Assert(err, IsNil)
+	c.Assert(job, IsNil)
+
+	// an app whose leader route's service has no known leader has no
+	// leader job
+	s.createTestRoute(c, app.ID, (&router.TCPRoute{Service: "app-leader-job-tcp", Leader: true}).ToRoute())
+	job, err = s.c.GetAppLeaderJob(app.ID)
+	c.Assert(err, IsNil)
+	c.Assert(job, IsNil)
+
+	// the job which discoverd reports as leader should be returned
+	sl := s.hc.sl.(*fakeServiceLeaders)
+	sl.setLeader("app-leader-job-tcp", &discoverd.Instance{
+		Addr: "10.0.0.1:5000",
+		Meta: map[string]string{"FLYNN_JOB_ID": cluster.GenerateJobID("host0", leader.UUID)},
+	})
+	defer sl.setLeader("app-leader-job-tcp", nil)
+	job, err = s.c.GetAppLeaderJob(app.ID)
+	c.Assert(err, IsNil)
+	c.Assert(job, NotNil)
+	c.Assert(job.UUID, Equals, leader.UUID)
+	c.Assert(job.HostID, Equals, "host0")
+}
+
+func (s *S) TestUpsertCertificate(c *C) {
+	expiry := time.Now().Add(90 * 24 * time.Hour)
+	certPEM, keyPEM := generateTestCertAndKey(c, "upsert-certificate.example.com", expiry)
+
+	// creating a certificate should store it in the router
+	cert := &router.Certificate{Cert: certPEM, Key: keyPEM}
+	c.Assert(s.c.UpsertCertificate(cert), IsNil)
+	c.Assert(cert.ID, Not(Equals), "")
+	fr := s.hc.rc.(*fakeRouter)
+	stored, err := fr.GetCert(cert.ID)
+	c.Assert(err, IsNil)
+	c.Assert(stored.Cert, Equals, strings.TrimSpace(certPEM))
+
+	// attach it to a route
+	app := s.createTestApp(c, &ct.App{Name: "upsert-certificate"})
+	route := s.createTestRoute(c, app.ID, (&router.HTTPRoute{Service: "foo", Domain: "upsert-certificate.example.com"}).ToRoute())
+	c.Assert(fr.CreateCert(&router.Certificate{Cert: stored.Cert, Key: stored.Key, Routes: []string{route.ID}}), IsNil)
+
+	// updating the certificate should replace it and move its routes
+	newCertPEM, newKeyPEM := generateTestCertAndKey(c, "upsert-certificate.example.com", expiry.Add(time.Hour))
+	updated := &router.Certificate{ID: cert.ID, Cert: newCertPEM, Key: newKeyPEM}
+	c.Assert(s.c.UpsertCertificate(updated), IsNil)
+	c.Assert(updated.ID, Not(Equals), "")
+	c.Assert(updated.ID, Not(Equals), cert.ID)
+	_, err = fr.GetCert(cert.ID)
+	c.Assert(err, Equals, routerc.ErrNotFound)
+	gotRoute, err := s.c.GetRoute(app.ID, route.ID)
+	c.Assert(err, IsNil)
+	c.Assert(gotRoute.Certificate, NotNil)
+	c.Assert(gotRoute.Certificate.ID, Equals, updated.ID)
+	c.Assert(gotRoute.Certificate.Cert, Equals, strings.TrimSpace(newCertPEM))
+
+	// updating an unknown certificate should fail
+	err = s.c.UpsertCertificate(&router.Certificate{ID: random.UUID(), Cert: newCertPEM, Key: newKeyPEM})
+	c.Assert(err, Equals, controller.ErrNotFound)
+
+	// a key which doesn't match the certificate should be rejected
+	err = s.c.UpsertCertificate(&router.Certificate{Cert: newCertPEM, Key: keyPEM})
+	c.Assert(hh.IsValidationError(err), Equals, true)
+	c.Assert(err.(hh.JSONError).Message, Equals, "key does not match the certificate")
+	err = s.c.UpsertCertificate(&router.Certificate{ID: updated.ID, Cert: newCertPEM, Key: keyPEM})
+	c.Assert(hh.IsValidationError(err), Equals, true)
+	stored, err = fr.GetCert(updated.ID)
+	c.Assert(err, IsNil)
+	c.Assert(stored.Key, Equals, strings.TrimSpace(newKeyPEM))
+
+	// as should an invalid certificate
+	err = s.c.UpsertCertificate(&router.Certificate{Cert: "not a certificate", Key: keyPEM})
+	c.Assert(hh.IsValidationError(err), Equals, true)
+}
+
+func (s *S) TestRotateRouteCertificate(c *C) {
+	expiry := time.Now().Add(90 * 24 * time.Hour)
+	certPEM, keyPEM := generateTestCertAndKey(c, "rotate-certificate.example.com", expiry)
+	app := s.createTestApp(c, &ct.App{Name: "rotate-certificate"})
+	route := s.createTestRoute(c, app.ID, (&router.HTTPRoute{
+		Service:     "foo",
+		Domain:      "rotate-certificate.example.com",
+		Certificate: &router.Certificate{Cert: certPEM, Key: keyPEM},
+	}).ToRoute())
+	fr := s.hc.rc.(*fakeRouter)
+	old := &router.Certificate{Cert: certPEM, Key: keyPEM, Routes: []string{route.ID}}
+	c.Assert(fr.CreateCert(old), IsNil)
+
+	// rotating the certificate should attach the new one to the route and
+	// delete the old one
+	newCertPEM, newKeyPEM := generateTestCertAndKey(c, "rotate-certificate.example.com", expiry.Add(time.Hour))
+	cert, err := s.c.RotateRouteCertificate(app.ID, route.ID, &router.Certificate{Cert: newCertPEM, Key: newKeyPEM})
+	c.Assert(err, IsNil)
+	c.Assert(cert.ID, Not(Equals), "")
+	c.Assert(cert.ID, Not(Equals), old.ID)
+	c.Assert(cert.Cert, Equals, strings.TrimSpace(newCertPEM))
+	gotRoute, err := s.c.GetRoute(app.ID, route.ID)
+	c.Assert(err, IsNil)
+	c.Assert(gotRoute.Certificate, NotNil)
+	c.Assert(gotRoute.Certificate.ID, Equals, cert.ID)
+	routes, err := fr.ListCertRoutes(old.ID)
+	c.Assert(err, IsNil)
+	c.Assert(routes, HasLen, 0)
+	_, err = fr.GetCert(old.ID)
+	c.Assert(err, Equals, routerc.ErrNotFound)
+
+	// a certificate still used by another route should only be detached
+	other := s.createTestRoute(c, app.ID, (&router.HTTPRoute{Service: "foo", Domain: "rotate-certificate-other.example.com"}).ToRoute())
+	c.Assert(fr.CreateCert(&router.Certificate{Cert: cert.Cert, Key: cert.Key, Routes: []string{other.ID}}), IsNil)
+	rotatedCertPEM, rotatedKeyPEM := generateTestCertAndKey(c, "rotate-certificate.example.com", expiry.Add(2*time.Hour))
+	rotated, err := s.c.RotateRouteCertificate(app.ID, route.ID, &router.Certificate{Cert: rotatedCertPEM, Key: rotatedKeyPEM})
+	c.Assert(err, IsNil)
+	c.Assert(rotated.ID, Not(Equals), cert.ID)
+	routes, err = fr.ListCertRoutes(cert.ID)
+	c.Assert(err, IsNil)
+	c.Assert(routes, HasLen, 1)
+	c.Assert(routes[0].ID, Equals, other.ID)
+
+	// a key which doesn't match the certificate should be rejected and leave
+	// the route unchanged
+	_, err = s.c.RotateRouteCertificate(app.ID, route.ID, &router.Certificate{Cert: newCertPEM, Key: keyPEM})
+	c.Assert(hh.IsValidationError(err), Equals, true)
+	c.Assert(err.(hh.JSONError).Message, Equals, "key does not match the certificate")
+	gotRoute, err = s.c.GetRoute(app.ID, route.ID)
+	c.Assert(err, IsNil)
+	c.Assert(gotRoute.Certificate.ID, Equals, rotated.ID)
+
+	// TCP routes cannot have certificates
+	tcpRoute := s.createTestRoute(c, app.ID, (&router.TCPRoute{Service: "foo"}).ToRoute())
+	_, err = s.c.RotateRouteCertificate(app.ID, tcpRoute.ID, &router.Certificate{Cert: newCertPEM, Key: newKeyPEM})
+	c.Assert(hh.IsValidationError(err), Equals, true)
+}
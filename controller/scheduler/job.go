@@ -105,9 +105,16 @@ func (j *Job) Tags() map[string]string {
 }
 
 // TagsMatchHost checks whether all of the job's tags match the corresponding
-// host's tags
+// host's tags, apart from the reserved cordon tag which instead excludes
+// hosts which have it, keeping a cordoned app's jobs off cordoned hosts
 func (j *Job) TagsMatchHost(host *Host) bool {
 	for k, v := range j.Tags() {
+		if k == ct.CordonTagKey {
+			if host.Tags[k] == v {
+				return false
+			}
+			continue
+		}
 		if w, ok := host.Tags[k]; !ok || v != w {
 			return false
 		}
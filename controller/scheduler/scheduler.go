@@ -933,6 +933,10 @@ func (s *Scheduler) handleFormationDiff(f *Formation, diff Processes) {
 	log := s.logger.New("fn", "handleFormationDiff", "app.id", f.App.ID, "release.id", f.Release.ID)
 	log.Info("formation in incorrect state", "diff", diff)
 	for typ, n := range diff {
+		if n > 0 && f.App.Paused {
+			log.Info(fmt.Sprintf("app is paused, not starting %d new %s jobs", n, typ))
+			continue
+		}
 		if n > 0 {
 			log.Info(fmt.Sprintf("starting %d new %s jobs", n, typ))
 			for i := 0; i < n; i++ {
@@ -1353,7 +1357,7 @@ func (s *Scheduler) handleJobStatus(job *Job, status host.JobStatus) {
 	// if the job has just transitioned to the stopped state, check if we
 	// expect it to be running, and if we do, restart it
 	if previousState != JobStateStopped && job.State == JobStateStopped {
-		if diff := s.formationDiff(job.Formation); diff[job.Type] > 0 {
+		if diff := s.formationDiff(job.Formation); diff[job.Type] > 0 && !job.Formation.App.Paused {
 			s.restartJob(job)
 		}
 	}
@@ -1401,6 +1405,14 @@ func (s *Scheduler) handleFormation(ef *ct.ExpandedFormation) (formation *Format
 		log.Info("adding new formation", "processes", ef.Processes)
 		formation = s.formations.Add(NewFormation(ef))
 	} else {
+		// pausing or resuming the app changes which jobs are started
+		// rather than the processes, so handle it separately
+		if formation.App.Paused != ef.App.Paused {
+			log.Info("updating paused state of existing formation", "paused", ef.App.Paused)
+			formation.App.Paused = ef.App.Paused
+			s.triggerRectify(formation.key())
+		}
+
 		diff := Processes(ef.Processes).Diff(formation.OriginalProcesses)
 		if diff.IsEmpty() && utils.FormationTagsEqual(formation.Tags, ef.Tags) {
 			return
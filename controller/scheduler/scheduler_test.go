@@ -744,6 +744,29 @@ func (TestSuite) TestJobPlacementTags(c *C) {
 	}
 }
 
+func (TestSuite) TestJobTagsMatchCordonedHost(c *C) {
+	cordoned := &Host{ID: "host1", Tags: map[string]string{"disk": "ssd", ct.CordonTagKey: ct.CordonTagValue}}
+	uncordoned := &Host{ID: "host2", Tags: map[string]string{"disk": "ssd"}}
+	mag := &Host{ID: "host3", Tags: map[string]string{"disk": "mag"}}
+	newJob := func(tags map[string]string) *Job {
+		return &Job{Type: "web", Formation: &Formation{ExpandedFormation: &ct.ExpandedFormation{
+			Tags: map[string]map[string]string{"web": tags},
+		}}}
+	}
+
+	// jobs of cordoned apps are kept off cordoned hosts, but other tags
+	// must still match
+	job := newJob(map[string]string{"disk": "ssd", ct.CordonTagKey: ct.CordonTagValue})
+	c.Assert(job.TagsMatchHost(cordoned), Equals, false)
+	c.Assert(job.TagsMatchHost(uncordoned), Equals, true)
+	c.Assert(job.TagsMatchHost(mag), Equals, false)
+
+	// jobs of other apps can be placed on cordoned hosts
+	job = newJob(map[string]string{"disk": "ssd"})
+	c.Assert(job.TagsMatchHost(cordoned), Equals, true)
+	c.Assert(job.TagsMatchHost(uncordoned), Equals, true)
+}
+
 func (TestSuite) TestScaleCriticalApp(c *C) {
 	s := runTestScheduler(c, nil, true)
 	defer s.Stop()
@@ -776,3 +799,28 @@ func (TestSuite) TestScaleCriticalApp(c *C) {
 	s.PutFormation(&ct.Formation{AppID: app.ID, ReleaseID: release.ID, Processes: nil})
 	s.waitJobStop()
 }
+
+func (TestSuite) TestPausedApp(c *C) {
+	s := runTestScheduler(c, nil, true)
+	defer s.Stop()
+	s.waitJobStart()
+
+	release, err := s.GetRelease(testReleaseID)
+	c.Assert(err, IsNil)
+	processes := map[string]int{testJobType: 3}
+
+	// scaling up a paused app should not start any jobs
+	s.CreateApp(&ct.App{ID: testAppID, Name: testAppID, Paused: true})
+	s.PutFormation(&ct.Formation{AppID: testAppID, ReleaseID: release.ID, Processes: processes})
+	_, err = s.waitForEvent("app is paused, not starting 2 new web jobs")
+	c.Assert(err, IsNil)
+	c.Assert(s.RunningJobs(), HasLen, 1)
+
+	// resuming the app should start the jobs
+	s.CreateApp(&ct.App{ID: testAppID, Name: testAppID})
+	s.PutFormation(&ct.Formation{AppID: testAppID, ReleaseID: release.ID, Processes: processes})
+	for i := 0; i < 2; i++ {
+		s.waitJobStart()
+	}
+	c.Assert(s.RunningJobs(), HasLen, 3)
+}
@@ -402,6 +402,39 @@ $$ LANGUAGE plpgsql`,
 		) r
 		WHERE release_id = r.id`,
 	)
+	migrations.Add(21,
+		`CREATE TABLE route_meta (
+			route_id text PRIMARY KEY,
+			app_id uuid NOT NULL REFERENCES apps (app_id),
+			meta jsonb NOT NULL,
+			created_at timestamptz NOT NULL DEFAULT now(),
+			updated_at timestamptz NOT NULL DEFAULT now()
+		)`,
+	)
+	migrations.Add(22,
+		`ALTER TABLE resources ADD COLUMN updated_at timestamptz`,
+		`UPDATE resources SET updated_at = created_at`,
+		`ALTER TABLE resources ALTER COLUMN updated_at SET NOT NULL`,
+		`ALTER TABLE resources ALTER COLUMN updated_at SET DEFAULT now()`,
+	)
+	migrations.Add(23,
+		`ALTER TABLE artifacts ADD COLUMN size bigint`,
+	)
+	migrations.Add(24,
+		`CREATE INDEX ON deployments (new_release_id)`,
+	)
+	migrations.Add(25,
+		`ALTER TABLE apps ADD COLUMN pending_release_id uuid REFERENCES releases (release_id)`,
+	)
+	migrations.Add(26,
+		`ALTER TABLE apps ADD COLUMN paused boolean NOT NULL DEFAULT false`,
+	)
+	migrations.Add(27,
+		`INSERT INTO event_types (name) VALUES ('resource_provision_failure')`,
+	)
+	migrations.Add(28,
+		`INSERT INTO event_types (name) VALUES ('cluster_backup_deletion')`,
+	)
 }
 
 func migrateDB(db *postgres.DB) error {
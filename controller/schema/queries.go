@@ -8,14 +8,18 @@ import (
 var preparedStatements = map[string]string{
 	"ping":                                  pingQuery,
 	"app_list":                              appListQuery,
+	"app_list_ids":                          appListIDsQuery,
 	"app_select_by_name":                    appSelectByNameQuery,
 	"app_select_by_name_for_update":         appSelectByNameForUpdateQuery,
 	"app_select_by_name_or_id":              appSelectByNameOrIDQuery,
 	"app_select_by_name_or_id_for_update":   appSelectByNameOrIDForUpdateQuery,
+	"app_select_including_deleted":          appSelectIncludingDeletedQuery,
 	"app_insert":                            appInsertQuery,
 	"app_update_strategy":                   appUpdateStrategyQuery,
 	"app_update_meta":                       appUpdateMetaQuery,
+	"app_update_pending_release":            appUpdatePendingReleaseQuery,
 	"app_update_release":                    appUpdateReleaseQuery,
+	"app_update_paused":                     appUpdatePausedQuery,
 	"app_update_deploy_timeout":             appUpdateDeployTimeoutQuery,
 	"app_delete":                            appDeleteQuery,
 	"app_next_name_id":                      appNextNameIDQuery,
@@ -23,57 +27,96 @@ var preparedStatements = map[string]string{
 	"release_list":                          releaseListQuery,
 	"release_select":                        releaseSelectQuery,
 	"release_insert":                        releaseInsertQuery,
+	"release_import":                        releaseImportQuery,
 	"release_app_list":                      releaseAppListQuery,
+	"release_list_deleted":                  releaseListDeletedQuery,
+	"release_app_list_deleted":              releaseAppListDeletedQuery,
 	"release_artifacts_insert":              releaseArtifactsInsertQuery,
 	"release_artifacts_delete":              releaseArtifactsDeleteQuery,
 	"release_delete":                        releaseDeleteQuery,
+	"release_in_use":                        releaseInUseQuery,
+	"release_app_ids":                       releaseAppIDsQuery,
+	"release_select_previous_id":            releaseSelectPreviousIDQuery,
 	"artifact_list":                         artifactListQuery,
+	"artifact_list_in_use":                  artifactListInUseQuery,
+	"artifact_list_unused":                  artifactListUnusedQuery,
 	"artifact_list_ids":                     artifactListIDsQuery,
 	"artifact_select":                       artifactSelectQuery,
 	"artifact_select_by_type_and_uri":       artifactSelectByTypeAndURIQuery,
 	"artifact_insert":                       artifactInsertQuery,
+	"artifact_select_for_update":            artifactSelectForUpdateQuery,
 	"artifact_delete":                       artifactDeleteQuery,
+	"artifact_release_ids":                  artifactReleaseIDsQuery,
 	"artifact_release_count":                artifactReleaseCountQuery,
 	"deployment_list":                       deploymentListQuery,
+	"deployment_list_by_release":            deploymentListByReleaseQuery,
 	"deployment_select":                     deploymentSelectQuery,
+	"deployment_select_in_progress":         deploymentSelectInProgressQuery,
+	"deployment_count_in_progress":          deploymentCountInProgressQuery,
 	"deployment_insert":                     deploymentInsertQuery,
 	"deployment_update_finished_at":         deploymentUpdateFinishedAtQuery,
 	"deployment_update_finished_at_now":     deploymentUpdateFinishedAtNowQuery,
 	"deployment_delete":                     deploymentDeleteQuery,
+	"deployment_times_by_app":               deploymentTimesByAppQuery,
 	"event_select":                          eventSelectQuery,
+	"event_app_updated":                     eventAppUpdatedQuery,
+	"event_select_app_as_of":                eventSelectAppAsOfQuery,
+	"event_select_latest_id":                eventSelectLatestIDQuery,
+	"event_list_by_apps":                    eventListByAppsQuery,
+	"event_list_by_deployment":              eventListByDeploymentQuery,
+	"event_list_job_by_release":             eventListJobByReleaseQuery,
 	"event_insert":                          eventInsertQuery,
 	"event_insert_unique":                   eventInsertUniqueQuery,
 	"formation_list_by_app":                 formationListByAppQuery,
 	"formation_list_by_release":             formationListByReleaseQuery,
 	"formation_list_active":                 formationListActiveQuery,
 	"formation_list_since":                  formationListSinceQuery,
+	"formation_touch_by_app":                formationTouchByAppQuery,
 	"formation_select":                      formationSelectQuery,
+	"formation_select_for_update":           formationSelectForUpdateQuery,
 	"formation_select_expanded":             formationSelectExpandedQuery,
 	"formation_insert":                      formationInsertQuery,
 	"formation_delete":                      formationDeleteQuery,
 	"formation_delete_by_app":               formationDeleteByAppQuery,
 	"job_list":                              jobListQuery,
+	"job_list_page":                         jobListPageQuery,
 	"job_list_active":                       jobListActiveQuery,
 	"job_select":                            jobSelectQuery,
 	"job_insert":                            jobInsertQuery,
+	"job_delete_terminated":                 jobDeleteTerminatedQuery,
+	"job_up_counts":                         jobUpCountsQuery,
+	"route_meta_select":                     routeMetaSelectQuery,
+	"route_meta_upsert":                     routeMetaUpsertQuery,
+	"route_meta_delete":                     routeMetaDeleteQuery,
+	"route_meta_list_by_app":                routeMetaListByAppQuery,
+	"route_meta_delete_by_app":              routeMetaDeleteByAppQuery,
 	"provider_list":                         providerListQuery,
 	"provider_select_by_name":               providerSelectByNameQuery,
 	"provider_select_by_name_or_id":         providerSelectByNameOrIDQuery,
+	"provider_select_by_url":                providerSelectByURLQuery,
 	"provider_insert":                       providerInsertQuery,
+	"provider_update":                       providerUpdateQuery,
 	"resource_list":                         resourceListQuery,
 	"resource_list_by_provider":             resourceListByProviderQuery,
 	"resource_list_by_app":                  resourceListByAppQuery,
 	"resource_select":                       resourceSelectQuery,
 	"resource_insert":                       resourceInsertQuery,
 	"resource_delete":                       resourceDeleteQuery,
+	"resource_touch":                        resourceTouchQuery,
 	"app_resource_insert_app_by_name":       appResourceInsertAppByNameQuery,
 	"app_resource_insert_app_by_name_or_id": appResourceInsertAppByNameOrIDQuery,
 	"app_resource_delete_by_app":            appResourceDeleteByAppQuery,
 	"app_resource_delete_by_resource":       appResourceDeleteByResourceQuery,
 	"domain_migration_insert":               domainMigrationInsert,
 	"backup_insert":                         backupInsert,
+	"stats_app_count":                       statsAppCountQuery,
+	"stats_running_job_count":               statsRunningJobCountQuery,
+	"stats_recent_deployment_count":         statsRecentDeploymentCountQuery,
+	"stats_provider_count":                  statsProviderCountQuery,
 	"backup_update":                         backupUpdate,
 	"backup_select_latest":                  backupSelectLatest,
+	"backup_select":                         backupSelect,
+	"backup_prune":                          backupPrune,
 }
 
 func PrepareStatements(conn *pgx.Conn) error {
@@ -93,20 +136,26 @@ const (
 	pingQuery = `SELECT 1`
 	// apps
 	appListQuery = `
-SELECT app_id, name, meta, strategy, release_id, deploy_timeout, created_at, updated_at
+SELECT app_id, name, meta, strategy, release_id, pending_release_id, deploy_timeout, paused, created_at, updated_at
 FROM apps WHERE deleted_at IS NULL ORDER BY created_at DESC`
+	appListIDsQuery = `
+SELECT app_id, name, meta, strategy, release_id, pending_release_id, deploy_timeout, paused, created_at, updated_at
+FROM apps WHERE deleted_at IS NULL AND app_id = ANY($1)`
 	appSelectByNameQuery = `
-SELECT app_id, name, meta, strategy, release_id, deploy_timeout, created_at, updated_at
+SELECT app_id, name, meta, strategy, release_id, pending_release_id, deploy_timeout, paused, created_at, updated_at
 FROM apps WHERE deleted_at IS NULL AND name = $1`
 	appSelectByNameForUpdateQuery = `
-SELECT app_id, name, meta, strategy, release_id, deploy_timeout, created_at, updated_at
+SELECT app_id, name, meta, strategy, release_id, pending_release_id, deploy_timeout, paused, created_at, updated_at
 FROM apps WHERE deleted_at IS NULL AND name = $1 FOR UPDATE`
 	appSelectByNameOrIDQuery = `
-SELECT app_id, name, meta, strategy, release_id, deploy_timeout, created_at, updated_at
+SELECT app_id, name, meta, strategy, release_id, pending_release_id, deploy_timeout, paused, created_at, updated_at
 FROM apps WHERE deleted_at IS NULL AND (app_id = $1 OR name = $2) LIMIT 1`
 	appSelectByNameOrIDForUpdateQuery = `
-SELECT app_id, name, meta, strategy, release_id, deploy_timeout, created_at, updated_at
+SELECT app_id, name, meta, strategy, release_id, pending_release_id, deploy_timeout, paused, created_at, updated_at
 FROM apps WHERE deleted_at IS NULL AND (app_id = $1 OR name = $2) LIMIT 1 FOR UPDATE`
+	appSelectIncludingDeletedQuery = `
+SELECT app_id, name, meta, strategy, release_id, pending_release_id, deploy_timeout, paused, created_at, updated_at, deleted_at
+FROM apps WHERE app_id = $1`
 	appInsertQuery = `
 INSERT INTO apps (app_id, name, meta, strategy, deploy_timeout) VALUES ($1, $2, $3, $4, $5) RETURNING created_at, updated_at`
 	appUpdateStrategyQuery = `
@@ -114,7 +163,14 @@ UPDATE apps SET strategy = $2, updated_at = now() WHERE app_id = $1`
 	appUpdateMetaQuery = `
 UPDATE apps SET meta = $2, updated_at = now() WHERE app_id = $1`
 	appUpdateReleaseQuery = `
-UPDATE apps SET release_id = $2, updated_at = now() WHERE app_id = $1`
+UPDATE apps SET release_id = $2,
+  pending_release_id = CASE WHEN pending_release_id = $2 THEN NULL ELSE pending_release_id END,
+  updated_at = now()
+WHERE app_id = $1`
+	appUpdatePendingReleaseQuery = `
+UPDATE apps SET pending_release_id = $2, updated_at = now() WHERE app_id = $1`
+	appUpdatePausedQuery = `
+UPDATE apps SET paused = $2, updated_at = now() WHERE app_id = $1`
 	appUpdateDeployTimeoutQuery = `
 UPDATE apps SET deploy_timeout = $2, updated_at = now() WHERE app_id = $1`
 	appDeleteQuery = `
@@ -152,6 +208,9 @@ FROM releases r WHERE r.release_id = $1 AND r.deleted_at IS NULL`
 	releaseInsertQuery = `
 INSERT INTO releases (release_id, env, processes, meta)
 VALUES ($1, $2, $3, $4) RETURNING created_at`
+	releaseImportQuery = `
+INSERT INTO releases (release_id, env, processes, meta, created_at)
+VALUES ($1, $2, $3, $4, $5) RETURNING created_at`
 	releaseAppListQuery = `
 SELECT DISTINCT(r.release_id),
   ARRAY(
@@ -162,27 +221,86 @@ SELECT DISTINCT(r.release_id),
   ), r.env, r.processes, r.meta, r.created_at
 FROM releases r JOIN formations f USING (release_id)
 WHERE f.app_id = $1 AND r.deleted_at IS NULL ORDER BY r.created_at DESC`
+	releaseSelectPreviousIDQuery = `
+SELECT data->'prev_release'->>'id' FROM events
+WHERE object_type = 'app_release' AND app_id = $1 AND object_id = $2
+ORDER BY event_id DESC LIMIT 1`
+	releaseListDeletedQuery = `
+SELECT r.release_id,
+  ARRAY(
+	SELECT a.artifact_id
+	FROM release_artifacts a
+	WHERE a.release_id = r.release_id
+	ORDER BY a.index
+  ), r.env, r.processes, r.meta, r.created_at, r.deleted_at
+FROM releases r WHERE r.deleted_at IS NOT NULL ORDER BY r.deleted_at DESC`
+	releaseAppListDeletedQuery = `
+SELECT r.release_id,
+  ARRAY(
+	SELECT a.artifact_id
+	FROM release_artifacts a
+	WHERE a.release_id = r.release_id
+	ORDER BY a.index
+  ), r.env, r.processes, r.meta, r.created_at, r.deleted_at
+FROM releases r
+WHERE r.deleted_at IS NOT NULL AND EXISTS (
+  SELECT 1 FROM events e
+  WHERE e.app_id = $1 AND e.object_type = 'release_deletion' AND e.object_id = r.release_id::text
+)
+ORDER BY r.deleted_at DESC`
 	releaseArtifactsInsertQuery = `
 INSERT INTO release_artifacts (release_id, artifact_id, index) VALUES ($1, $2, $3)`
 	releaseArtifactsDeleteQuery = `
 UPDATE release_artifacts SET deleted_at = now() WHERE release_id = $1 AND artifact_id = $2 AND deleted_at IS NULL`
+	releaseAppIDsQuery = `
+SELECT app_id FROM formations WHERE release_id = $1 AND deleted_at IS NULL
+UNION
+SELECT app_id FROM apps WHERE release_id = $1 AND deleted_at IS NULL`
+	releaseInUseQuery = `
+SELECT EXISTS (SELECT 1 FROM apps WHERE release_id = $1 AND deleted_at IS NULL)`
 	releaseDeleteQuery = `
 UPDATE releases SET deleted_at = now() WHERE release_id = $1 AND deleted_at IS NULL`
 	artifactListQuery = `
-SELECT artifact_id, type, uri, meta, created_at FROM artifacts
+SELECT artifact_id, type, uri, meta, size, created_at FROM artifacts
 WHERE deleted_at IS NULL ORDER BY created_at DESC`
+	artifactListInUseQuery = `
+SELECT a.artifact_id, a.type, a.uri, a.meta, a.size, a.created_at FROM artifacts a
+WHERE a.deleted_at IS NULL AND EXISTS (
+  SELECT 1 FROM release_artifacts ra
+  INNER JOIN releases r USING (release_id)
+  WHERE ra.artifact_id = a.artifact_id AND ra.deleted_at IS NULL AND r.deleted_at IS NULL
+) ORDER BY a.created_at DESC`
+	artifactListUnusedQuery = `
+SELECT a.artifact_id, a.type, a.uri, a.meta, a.size, a.created_at FROM artifacts a
+WHERE a.deleted_at IS NULL AND NOT EXISTS (
+  SELECT 1 FROM release_artifacts ra
+  INNER JOIN releases r USING (release_id)
+  WHERE ra.artifact_id = a.artifact_id AND ra.deleted_at IS NULL AND r.deleted_at IS NULL
+) ORDER BY a.created_at DESC`
 	artifactListIDsQuery = `
-SELECT artifact_id, type, uri, meta, created_at FROM artifacts
+SELECT artifact_id, type, uri, meta, size, created_at FROM artifacts
 WHERE deleted_at IS NULL AND artifact_id = ANY($1)`
 	artifactSelectQuery = `
-SELECT artifact_id, type, uri, meta, created_at FROM artifacts
+SELECT artifact_id, type, uri, meta, size, created_at FROM artifacts
 WHERE artifact_id = $1 AND deleted_at IS NULL`
 	artifactSelectByTypeAndURIQuery = `
-SELECT artifact_id, meta, created_at FROM artifacts WHERE type = $1 AND uri = $2 AND deleted_at IS NULL`
+SELECT artifact_id, meta, size, created_at FROM artifacts WHERE type = $1 AND uri = $2 AND deleted_at IS NULL`
 	artifactInsertQuery = `
-INSERT INTO artifacts (artifact_id, type, uri, meta) VALUES ($1, $2, $3, $4) RETURNING created_at`
+INSERT INTO artifacts (artifact_id, type, uri, meta, size) VALUES ($1, $2, $3, $4, $5) RETURNING created_at`
+	artifactSelectForUpdateQuery = `
+SELECT artifact_id, type, uri, meta, size, created_at FROM artifacts
+WHERE artifact_id = $1 AND deleted_at IS NULL FOR UPDATE`
 	artifactDeleteQuery = `
 UPDATE artifacts SET deleted_at = now() WHERE artifact_id = $1 AND deleted_at IS NULL`
+	artifactReleaseIDsQuery = `
+SELECT ARRAY(
+  SELECT r.release_id FROM releases r
+  WHERE r.deleted_at IS NULL AND EXISTS (
+    SELECT 1 FROM release_artifacts ra
+    WHERE ra.release_id = r.release_id AND ra.artifact_id = $1 AND ra.deleted_at IS NULL
+  )
+  ORDER BY r.created_at DESC
+)`
 	artifactReleaseCountQuery = `
 SELECT COUNT(*) FROM release_artifacts WHERE artifact_id = $1 AND deleted_at IS NULL`
 	deploymentInsertQuery = `
@@ -194,6 +312,12 @@ UPDATE deployments SET finished_at = $2 WHERE deployment_id = $1`
 UPDATE deployments SET finished_at = now() WHERE deployment_id = $1`
 	deploymentDeleteQuery = `
 DELETE FROM deployments WHERE deployment_id = $1`
+	deploymentTimesByAppQuery = `
+SELECT MIN(created_at), MAX(created_at) FROM deployments WHERE app_id = $1`
+	deploymentSelectInProgressQuery = `
+SELECT EXISTS (SELECT 1 FROM deployments WHERE app_id = $1 AND finished_at IS NULL)`
+	deploymentCountInProgressQuery = `
+SELECT COUNT(*) FROM deployments WHERE finished_at IS NULL`
 	deploymentSelectQuery = `
 WITH deployment_events AS (SELECT * FROM events WHERE object_type = 'deployment')
 SELECT d.deployment_id, d.app_id, d.old_release_id, d.new_release_id,
@@ -216,9 +340,48 @@ LEFT JOIN deployment_events e1
 LEFT OUTER JOIN deployment_events e2
   ON (d.deployment_id = e2.object_id::uuid AND e1.created_at < e2.created_at)
 WHERE e2.created_at IS NULL AND d.app_id = $1 ORDER BY d.created_at DESC`
+	deploymentListByReleaseQuery = `
+WITH deployment_events AS (SELECT * FROM events WHERE object_type = 'deployment')
+SELECT d.deployment_id, d.app_id, d.old_release_id, d.new_release_id,
+  strategy, e1.data->>'status' AS status,
+  processes, deploy_timeout, d.created_at, d.finished_at
+FROM deployments d
+LEFT JOIN deployment_events e1
+  ON d.deployment_id = e1.object_id::uuid
+LEFT OUTER JOIN deployment_events e2
+  ON (d.deployment_id = e2.object_id::uuid AND e1.created_at < e2.created_at)
+WHERE e2.created_at IS NULL AND d.new_release_id = $1 ORDER BY d.created_at DESC`
 	eventSelectQuery = `
 SELECT event_id, app_id, object_id, object_type, data, created_at
 FROM events WHERE event_id = $1`
+	eventAppUpdatedQuery = `
+SELECT EXISTS (
+  SELECT 1 FROM events WHERE object_type = 'app' AND object_id = $1 AND event_id < $2
+)`
+	eventSelectAppAsOfQuery = `
+SELECT event_id, app_id, object_id, object_type, data, created_at
+FROM events WHERE object_type = 'app' AND object_id = $1 AND event_id <= $2
+ORDER BY event_id DESC LIMIT 1`
+	eventSelectLatestIDQuery = `
+SELECT COALESCE(MAX(event_id), 0) FROM events`
+	eventListByAppsQuery = `
+SELECT event_id, app_id, object_id, object_type, data, created_at
+FROM (
+  SELECT *, row_number() OVER (PARTITION BY app_id ORDER BY event_id DESC) AS n
+  FROM events WHERE app_id = ANY($1)
+) e
+WHERE $2 = 0 OR n <= $2
+ORDER BY event_id DESC`
+	eventListByDeploymentQuery = `
+SELECT event_id, app_id, object_id, object_type, data, created_at
+FROM events WHERE object_type = 'deployment' AND object_id = $1
+ORDER BY event_id`
+	eventListJobByReleaseQuery = `
+SELECT event_id, app_id, object_id, object_type, data, created_at
+FROM events
+WHERE app_id = $1 AND object_type = 'job' AND data->>'release' = $2
+AND created_at >= $3 AND ($4::timestamptz IS NULL OR created_at <= $4)
+ORDER BY event_id`
 	eventInsertQuery = `
 INSERT INTO events (app_id, object_id, object_type, data)
 VALUES ($1, $2, $3, $4)`
@@ -233,7 +396,7 @@ SELECT app_id, release_id, processes, tags, created_at, updated_at
 FROM formations WHERE release_id = $1 AND deleted_at IS NULL ORDER BY created_at DESC`
 	formationListActiveQuery = `
 SELECT
-  apps.app_id, apps.name, apps.meta,
+  apps.app_id, apps.name, apps.meta, apps.paused,
   releases.release_id,
   ARRAY(
 	SELECT r.artifact_id
@@ -258,12 +421,17 @@ ORDER BY updated_at DESC`
 	formationListSinceQuery = `
 SELECT app_id, release_id, processes, tags, created_at, updated_at
 FROM formations WHERE updated_at >= $1 AND deleted_at IS NULL ORDER BY updated_at DESC`
+	formationTouchByAppQuery = `
+UPDATE formations SET updated_at = now() WHERE app_id = $1 AND deleted_at IS NULL`
 	formationSelectQuery = `
 SELECT app_id, release_id, processes, tags, created_at, updated_at
 FROM formations WHERE app_id = $1 AND release_id = $2 AND deleted_at IS NULL`
+	formationSelectForUpdateQuery = `
+SELECT app_id, release_id, processes, tags, created_at, updated_at
+FROM formations WHERE app_id = $1 AND release_id = $2 AND deleted_at IS NULL FOR UPDATE`
 	formationSelectExpandedQuery = `
 SELECT
-  apps.app_id, apps.name, apps.meta,
+  apps.app_id, apps.name, apps.meta, apps.paused,
   releases.release_id,
   ARRAY(
 	SELECT a.artifact_id
@@ -291,7 +459,12 @@ UPDATE formations SET deleted_at = now(), processes = NULL, updated_at = now()
 WHERE app_id = $1 AND deleted_at IS NULL`
 	jobListQuery = `
 SELECT cluster_id, job_id, host_id, app_id, release_id, process_type, state, meta, exit_status, host_error, run_at, restarts, created_at, updated_at
-FROM job_cache WHERE app_id = $1 ORDER BY created_at DESC`
+FROM job_cache WHERE app_id = $1 ORDER BY created_at DESC, job_id DESC LIMIT NULLIF($2, 0)`
+	jobListPageQuery = `
+SELECT cluster_id, job_id, host_id, app_id, release_id, process_type, state, meta, exit_status, host_error, run_at, restarts, created_at, updated_at
+FROM job_cache
+WHERE app_id = $1 AND (created_at, job_id) < (SELECT created_at, job_id FROM job_cache WHERE job_id = $2)
+ORDER BY created_at DESC, job_id DESC LIMIT NULLIF($3, 0)`
 	jobListActiveQuery = `
 SELECT cluster_id, job_id, host_id, app_id, release_id, process_type, state, meta, exit_status, host_error, run_at, restarts, created_at, updated_at
 FROM job_cache WHERE state = 'pending' OR state = 'starting' OR state = 'up' ORDER BY updated_at DESC`
@@ -303,6 +476,35 @@ INSERT INTO job_cache (cluster_id, job_id, host_id, app_id, release_id, process_
 VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12) ON CONFLICT (job_id) DO UPDATE
 SET cluster_id = $1, host_id = $3, state = $7, exit_status = $9, host_error = $10, run_at = $11, restarts = $12, updated_at = now()
 RETURNING created_at, updated_at`
+	jobUpCountsQuery = `
+SELECT process_type, count(*) FROM job_cache
+WHERE app_id = $1 AND release_id = $2 AND state = 'up'
+GROUP BY process_type`
+	jobDeleteTerminatedQuery = `
+WITH deleted AS (
+  DELETE FROM job_cache WHERE job_id IN (
+    SELECT job_id FROM job_cache
+    WHERE updated_at < $1 AND state = ANY($2) AND ($3 = '' OR app_id::text = $3)
+    LIMIT $4
+  )
+  RETURNING job_id
+)
+SELECT (SELECT count(*) FROM deleted), EXISTS (
+  SELECT 1 FROM job_cache
+  WHERE updated_at < $1 AND state = ANY($2) AND ($3 = '' OR app_id::text = $3)
+  AND job_id NOT IN (SELECT job_id FROM deleted)
+)`
+	routeMetaSelectQuery = `
+SELECT meta FROM route_meta WHERE route_id = $1`
+	routeMetaUpsertQuery = `
+INSERT INTO route_meta (route_id, app_id, meta) VALUES ($1, $2, $3)
+ON CONFLICT (route_id) DO UPDATE SET meta = $3, updated_at = now()`
+	routeMetaDeleteQuery = `
+DELETE FROM route_meta WHERE route_id = $1`
+	routeMetaListByAppQuery = `
+SELECT route_id, meta FROM route_meta WHERE app_id = $1`
+	routeMetaDeleteByAppQuery = `
+DELETE FROM route_meta WHERE app_id = $1`
 	providerListQuery = `
 SELECT provider_id, name, url, created_at, updated_at
 FROM providers WHERE deleted_at IS NULL ORDER BY created_at DESC`
@@ -312,9 +514,16 @@ FROM providers WHERE deleted_at IS NULL AND name = $1`
 	providerSelectByNameOrIDQuery = `
 SELECT provider_id, name, url, created_at, updated_at
 FROM providers WHERE deleted_at IS NULL AND (provider_id = $1 OR name = $2) LIMIT 1`
+	providerSelectByURLQuery = `
+SELECT provider_id, name, url, created_at, updated_at
+FROM providers WHERE deleted_at IS NULL AND url = $1`
 	providerInsertQuery = `
 INSERT INTO providers (name, url) VALUES ($1, $2)
 RETURNING provider_id, created_at, updated_at`
+	providerUpdateQuery = `
+UPDATE providers SET name = $2, url = $3, updated_at = now()
+WHERE provider_id = $1 AND deleted_at IS NULL
+RETURNING created_at, updated_at`
 	resourceListQuery = `
 SELECT resource_id, provider_id, external_id, env,
   ARRAY(
@@ -322,7 +531,7 @@ SELECT resource_id, provider_id, external_id, env,
     FROM app_resources a
 	WHERE a.resource_id = r.resource_id AND a.deleted_at IS NULL
 	ORDER BY a.created_at DESC
-  ), created_at
+  ), created_at, updated_at
 FROM resources r
 WHERE deleted_at IS NULL
 ORDER BY created_at DESC`
@@ -333,7 +542,7 @@ SELECT resource_id, provider_id, external_id, env,
     FROM app_resources a
 	WHERE a.resource_id = r.resource_id AND a.deleted_at IS NULL
 	ORDER BY a.created_at DESC
-  ), created_at
+  ), created_at, updated_at
 FROM resources r
 WHERE provider_id = $1 AND deleted_at IS NULL
 ORDER BY created_at DESC`
@@ -344,7 +553,7 @@ SELECT DISTINCT(r.resource_id), r.provider_id, r.external_id, r.env,
 	FROM app_resources a
 	WHERE a.resource_id = r.resource_id AND a.deleted_at IS NULL
 	ORDER BY a.created_at DESC
-  ), r.created_at
+  ), r.created_at, r.updated_at
 FROM resources r
 JOIN app_resources a USING (resource_id)
 WHERE a.app_id = $1 AND r.deleted_at IS NULL AND a.deleted_at IS NULL
@@ -356,12 +565,14 @@ SELECT resource_id, provider_id, external_id, env,
 	FROM app_resources a
 	WHERE a.resource_id = r.resource_id AND a.deleted_at IS NULL
 	ORDER BY a.created_at DESC
-  ), created_at
+  ), created_at, updated_at
 FROM resources r
 WHERE resource_id = $1 AND deleted_at IS NULL`
 	resourceInsertQuery = `
 INSERT INTO resources (resource_id, provider_id, external_id, env)
-VALUES ($1, $2, $3, $4) RETURNING created_at`
+VALUES ($1, $2, $3, $4) RETURNING created_at, updated_at`
+	resourceTouchQuery = `
+UPDATE resources SET updated_at = now() WHERE resource_id = $1 RETURNING updated_at`
 	resourceDeleteQuery = `
 UPDATE resources SET deleted_at = now() WHERE resource_id = $1 AND deleted_at IS NULL`
 	appResourceInsertAppByNameQuery = `
@@ -378,10 +589,24 @@ DELETE FROM app_resources WHERE app_id = $1`
 DELETE FROM app_resources WHERE resource_id = $1`
 	domainMigrationInsert = `
 INSERT INTO domain_migrations (old_domain, domain, old_tls_cert, tls_cert) VALUES ($1, $2, $3, $4) RETURNING migration_id, created_at`
+	statsAppCountQuery = `
+SELECT COUNT(*) FROM apps WHERE deleted_at IS NULL`
+	statsRunningJobCountQuery = `
+SELECT COUNT(*) FROM job_cache WHERE state = 'up'`
+	statsRecentDeploymentCountQuery = `
+SELECT COUNT(*) FROM deployments WHERE created_at > now() - interval '24 hours'`
+	statsProviderCountQuery = `
+SELECT COUNT(*) FROM providers WHERE deleted_at IS NULL`
 	backupInsert = `
 INSERT INTO backups (status, sha512, size, error, completed_at) VALUES ($1, $2, $3, $4, $5) RETURNING backup_id, created_at, updated_at`
 	backupUpdate = `
 UPDATE backups SET status = $2, sha512 = $3, size = $4, error = $5, completed_at = $6, updated_at = now() WHERE backup_id = $1 RETURNING updated_at`
 	backupSelectLatest = `
 SELECT backup_id, status, sha512, size, error, created_at, updated_at, completed_at FROM backups WHERE deleted_at IS NULL ORDER BY updated_at DESC LIMIT 1`
+	backupSelect = `
+SELECT backup_id, status, sha512, size, error, created_at, updated_at, completed_at FROM backups WHERE backup_id = $1 AND deleted_at IS NULL`
+	backupPrune = `
+UPDATE backups SET deleted_at = now()
+WHERE status = 'complete' AND completed_at < $1 AND deleted_at IS NULL
+RETURNING backup_id, status, sha512, size, error, created_at, updated_at, completed_at`
 )
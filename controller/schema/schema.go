@@ -86,13 +86,12 @@ func Validate(thing interface{}) error {
 	}
 
 	schemaErrs := schema.Validate(nil, validateData)
-	if len(schemaErrs) > 0 {
-		err := schemaErrs[0]
-		return ct.ValidationError{
+	errs := make(ct.ValidationErrors, len(schemaErrs))
+	for i, err := range schemaErrs {
+		errs[i] = ct.ValidationError{
 			Message: err.Description,
 			Field:   err.DotNotation(),
 		}
 	}
-
-	return nil
+	return errs.Err()
 }
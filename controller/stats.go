@@ -0,0 +1,57 @@
+package main
+
+import (
+	"net/http"
+	"sync"
+
+	ct "github.com/flynn/flynn/controller/types"
+	"github.com/flynn/flynn/pkg/httphelper"
+	"github.com/flynn/flynn/pkg/postgres"
+	"golang.org/x/net/context"
+)
+
+type StatsRepo struct {
+	db *postgres.DB
+}
+
+func NewStatsRepo(db *postgres.DB) *StatsRepo {
+	return &StatsRepo{db: db}
+}
+
+// Get returns cluster wide counts, running each of the count queries
+// concurrently.
+func (r *StatsRepo) Get() (*ct.ClusterStats, error) {
+	stats := &ct.ClusterStats{}
+	counts := map[string]*int64{
+		"stats_app_count":               &stats.AppCount,
+		"stats_running_job_count":       &stats.RunningJobCount,
+		"stats_recent_deployment_count": &stats.DeploymentCount24h,
+		"stats_provider_count":          &stats.ProviderCount,
+	}
+	var wg sync.WaitGroup
+	errs := make(chan error, len(counts))
+	for query, dest := range counts {
+		wg.Add(1)
+		go func(query string, dest *int64) {
+			defer wg.Done()
+			errs <- r.db.QueryRow(query).Scan(dest)
+		}(query, dest)
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		if err != nil {
+			return nil, err
+		}
+	}
+	return stats, nil
+}
+
+func (c *controllerAPI) GetClusterStats(ctx context.Context, w http.ResponseWriter, req *http.Request) {
+	stats, err := c.statsRepo.Get()
+	if err != nil {
+		respondWithError(w, err)
+		return
+	}
+	httphelper.JSON(w, 200, stats)
+}
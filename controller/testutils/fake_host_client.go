@@ -2,7 +2,6 @@ package testutils
 
 import (
 	"errors"
-	"fmt"
 	"sync"
 	"time"
 
@@ -42,6 +41,10 @@ type FakeHostClient struct {
 	jobsMtx          sync.RWMutex
 	Healthy          bool
 	TestEventHook    chan struct{}
+
+	// GetJobErr is returned by GetJob if set, simulating a host which
+	// cannot be reached.
+	GetJobErr error
 }
 
 func (c *FakeHostClient) ID() string { return c.hostID }
@@ -95,9 +98,12 @@ func (c *FakeHostClient) AddJob(job *host.Job) error {
 func (c *FakeHostClient) GetJob(id string) (*host.ActiveJob, error) {
 	c.jobsMtx.RLock()
 	defer c.jobsMtx.RUnlock()
+	if c.GetJobErr != nil {
+		return nil, c.GetJobErr
+	}
 	job, ok := c.Jobs[id]
 	if !ok {
-		return nil, fmt.Errorf("unable to find job with ID %q", id)
+		return nil, ct.NotFoundError{Resource: id}
 	}
 	return &job, nil
 }
@@ -0,0 +1,96 @@
+package main
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/flynn/flynn/pkg/httphelper"
+	"github.com/jackc/pgx"
+	"golang.org/x/net/context"
+)
+
+// dependency is a kind of external backend the controller calls out to when
+// serving requests, each of which has its own latency profile and so its own
+// timeout.
+type dependency string
+
+const (
+	dependencyDB       dependency = "database"
+	dependencyRouter   dependency = "router"
+	dependencyHost     dependency = "host"
+	dependencyProvider dependency = "provider"
+	dependencyRegistry dependency = "registry"
+)
+
+// defaultDependencyTimeouts are the timeouts used for calls to each kind of
+// dependency unless overridden by the *_TIMEOUT environment variables.
+//
+// Database, router and host calls cannot be cancelled using a context, so
+// rather than being wrapped with callDependency they are bounded by the
+// statement_timeout of database connections (see limitStatements) and the
+// timeout of the router and cluster HTTP clients.
+var defaultDependencyTimeouts = map[dependency]time.Duration{
+	dependencyDB:       10 * time.Second,
+	dependencyRouter:   10 * time.Second,
+	dependencyHost:     10 * time.Second,
+	dependencyProvider: 60 * time.Second,
+	dependencyRegistry: 30 * time.Second,
+}
+
+// callDependency calls fn with a context which expires after the configured
+// timeout for the given dependency (or sooner if ctx has an earlier
+// deadline), returning a retryable service unavailable error if fn fails
+// because the context expired.
+//
+// fn is called synchronously and must stop once the context is done, so only
+// calls which honour the context should be wrapped, and only idempotent ones
+// (see callDependencyOnce).
+func (c *controllerAPI) callDependency(ctx context.Context, dep dependency, fn func(context.Context) error) error {
+	return c.withDependencyTimeout(ctx, dep, true, fn)
+}
+
+// callDependencyOnce is like callDependency but for calls which are not
+// idempotent, such as provisioning a resource. The call may have taken
+// effect even though it timed out, so the error is not marked as retryable.
+func (c *controllerAPI) callDependencyOnce(ctx context.Context, dep dependency, fn func(context.Context) error) error {
+	return c.withDependencyTimeout(ctx, dep, false, fn)
+}
+
+func (c *controllerAPI) withDependencyTimeout(ctx context.Context, dep dependency, retry bool, fn func(context.Context) error) error {
+	if timeout := c.config.timeouts[dep]; timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+	if err := fn(ctx); err != nil {
+		if ctx.Err() == context.DeadlineExceeded {
+			return dependencyTimeoutError(dep, retry)
+		}
+		return err
+	}
+	return nil
+}
+
+func dependencyTimeoutError(dep dependency, retry bool) error {
+	return httphelper.JSONError{
+		Code:    httphelper.ServiceUnavailableErrorCode,
+		Message: fmt.Sprintf("timed out waiting for %s", dep),
+		Retry:   retry,
+	}
+}
+
+// limitStatements wraps the given AfterConnect func so that statements run
+// on each connection are cancelled by postgres after the given timeout.
+//
+// A cancelled statement has no effect (and aborts any transaction it is part
+// of), so respondWithError reports it as a retryable timeout.
+func limitStatements(timeout time.Duration, afterConn func(*pgx.Conn) error) func(*pgx.Conn) error {
+	return func(conn *pgx.Conn) error {
+		if timeout > 0 {
+			if _, err := conn.Exec(fmt.Sprintf("SET statement_timeout = %d", timeout/time.Millisecond)); err != nil {
+				return err
+			}
+		}
+		return afterConn(conn)
+	}
+}
@@ -25,6 +25,20 @@ type ExpandedFormation struct {
 	UpdatedAt     time.Time                    `json:"updated_at,omitempty"`
 }
 
+// ReleaseJobs are an app's jobs of one release.
+type ReleaseJobs struct {
+	Release *Release `json:"release"`
+	Jobs    []*Job   `json:"jobs"`
+}
+
+// DegradedProcess is a process type which has fewer up jobs than its
+// formation wants.
+type DegradedProcess struct {
+	Type    string `json:"type"`
+	Desired int    `json:"desired"`
+	Actual  int    `json:"actual"`
+}
+
 type App struct {
 	ID            string            `json:"id,omitempty"`
 	Name          string            `json:"name,omitempty"`
@@ -34,8 +48,32 @@ type App struct {
 	DeployTimeout int32             `json:"deploy_timeout,omitempty"`
 	CreatedAt     *time.Time        `json:"created_at,omitempty"`
 	UpdatedAt     *time.Time        `json:"updated_at,omitempty"`
+
+	// PendingReleaseID is the release which has been staged as the next
+	// release to deploy, and is cleared once it becomes the app's release
+	PendingReleaseID string `json:"pending_release,omitempty"`
+
+	// Paused is whether the scheduler has been stopped from starting new
+	// jobs for the app, which is set and cleared by pausing and resuming
+	// the app rather than by updating it
+	Paused bool `json:"paused,omitempty"`
+
+	// SchedulingState is AppSchedulingStatePaused for paused apps and
+	// AppSchedulingStateActive otherwise
+	SchedulingState string `json:"scheduling_state,omitempty"`
+
+	// Deleted and DeletedAt are only set when the app was looked up
+	// including deleted apps (e.g. when resolving the app an event
+	// refers to)
+	Deleted   bool       `json:"deleted,omitempty"`
+	DeletedAt *time.Time `json:"deleted_at,omitempty"`
 }
 
+const (
+	AppSchedulingStateActive = "active"
+	AppSchedulingStatePaused = "paused"
+)
+
 func (a *App) System() bool {
 	v, ok := a.Meta["flynn-system-app"]
 	return ok && v == "true"
@@ -51,6 +89,13 @@ func (a *App) Critical() bool {
 	return ok && v == "true"
 }
 
+// AppDeployTimes contains the times of an app's first and most recent
+// deployments, both of which are nil if the app has never been deployed.
+type AppDeployTimes struct {
+	FirstDeployedAt *time.Time `json:"first_deployed_at"`
+	LastDeployedAt  *time.Time `json:"last_deployed_at"`
+}
+
 type Release struct {
 	ID          string                 `json:"id,omitempty"`
 	ArtifactIDs []string               `json:"artifacts,omitempty"`
@@ -62,6 +107,9 @@ type Release struct {
 	// LegacyArtifactID is to support old clients which expect releases
 	// to have a single ArtifactID
 	LegacyArtifactID string `json:"artifact,omitempty"`
+
+	// DeletedAt is only set when listing deleted releases
+	DeletedAt *time.Time `json:"deleted_at,omitempty"`
 }
 
 func (r *Release) ImageArtifactID() string {
@@ -85,6 +133,20 @@ func (r *Release) FileArtifactIDs() []string {
 	return r.ArtifactIDs[1:len(r.ArtifactIDs)]
 }
 
+// DefaultProcesses returns the recommended initial process counts for the
+// release, which are 1 for the web process type and 0 for all other types.
+func (r *Release) DefaultProcesses() map[string]int {
+	procs := make(map[string]int, len(r.Processes))
+	for typ := range r.Processes {
+		if typ == "web" {
+			procs[typ] = 1
+		} else {
+			procs[typ] = 0
+		}
+	}
+	return procs
+}
+
 func (r *Release) IsGitDeploy() bool {
 	return r.Meta["git"] == "true"
 }
@@ -121,6 +183,13 @@ type Artifact struct {
 	URI       string            `json:"uri,omitempty"`
 	Meta      map[string]string `json:"meta,omitempty"`
 	CreatedAt *time.Time        `json:"created_at,omitempty"`
+
+	// Size is the size of the artifact in bytes, or nil if it is not
+	// known
+	Size *int64 `json:"size,omitempty"`
+
+	// SizeHuman is Size formatted for display (e.g. "1.2 GiB")
+	SizeHuman string `json:"size_human,omitempty"`
 }
 
 func (a *Artifact) HostArtifact() *host.Artifact {
@@ -143,6 +212,16 @@ type Formation struct {
 	UpdatedAt *time.Time                   `json:"updated_at,omitempty"`
 }
 
+// CordonTagKey and CordonTagValue form the reserved formation tag which is
+// set on every process type of a cordoned app. Unlike other tags, which jobs
+// must match, the scheduler keeps jobs with the cordon tag off hosts which
+// have been given the same tag, so operators can steer cordoned apps off
+// those hosts.
+const (
+	CordonTagKey   = "flynn-cordon"
+	CordonTagValue = "true"
+)
+
 type Key struct {
 	ID        string     `json:"fingerprint,omitempty"`
 	Key       string     `json:"key,omitempty"`
@@ -175,6 +254,11 @@ type Job struct {
 	Restarts   *int32            `json:"restarts,omitempty"`
 	CreatedAt  *time.Time        `json:"created_at,omitempty"`
 	UpdatedAt  *time.Time        `json:"updated_at,omitempty"`
+
+	// Exited is whether the job is in a terminal state, and so whether
+	// ExitStatus is meaningful. It is derived from State by the
+	// controller.
+	Exited bool `json:"exited"`
 }
 
 type JobState string
@@ -193,6 +277,13 @@ const (
 	JobStateFailed  JobState = "failed"
 )
 
+// JobNetwork is the network configuration of a running job as reported by
+// its host.
+type JobNetwork struct {
+	IP    string `json:"ip,omitempty"`
+	Ports []int  `json:"ports"`
+}
+
 type DomainMigration struct {
 	ID         string        `json:"id"`
 	OldTLSCert *tlscert.Cert `json:"old_tls_cert,omitempty"`
@@ -259,6 +350,31 @@ type NewJob struct {
 	DeprecatedEntrypoint []string `json:"entrypoint,omitempty"`
 }
 
+// ScheduledJob is a request to run a one-off job for a release at some
+// point in the future.
+type ScheduledJob struct {
+	AppID     string     `json:"app,omitempty"`
+	ReleaseID string     `json:"release,omitempty"`
+	Args      []string   `json:"args,omitempty"`
+	RunAt     *time.Time `json:"run_at,omitempty"`
+}
+
+// PruneJobs is a request to delete the records of jobs which terminated
+// before a given time, optionally restricted to an app and a terminal state.
+type PruneJobs struct {
+	Before *time.Time `json:"before,omitempty"`
+	AppID  string     `json:"app,omitempty"`
+	State  JobState   `json:"state,omitempty"`
+}
+
+// PruneJobsResult is the result of pruning job records, which are deleted
+// in bounded batches, with More set if matching records remain and the
+// request should be repeated to delete them.
+type PruneJobsResult struct {
+	Deleted int  `json:"deleted"`
+	More    bool `json:"more"`
+}
+
 const DefaultDeployTimeout = 120 // seconds
 
 type Deployment struct {
@@ -274,10 +390,28 @@ type Deployment struct {
 	FinishedAt    *time.Time     `json:"finished_at,omitempty"`
 }
 
+// CreateDeployment is a request to deploy a release, optionally with a
+// strategy overriding the app's strategy for just that deployment.
+type CreateDeployment struct {
+	ReleaseID string `json:"id"`
+	Strategy  string `json:"strategy,omitempty"`
+}
+
 type DeployID struct {
 	ID string
 }
 
+// DeploymentProgress is a snapshot of the number of jobs of the old and new
+// releases which are up during a deployment, along with how complete the
+// deployment is as a percentage.
+type DeploymentProgress struct {
+	NewUp   int    `json:"new_up"`
+	OldUp   int    `json:"old_up"`
+	Target  int    `json:"target"`
+	Status  string `json:"status"`
+	Percent int    `json:"percent"`
+}
+
 type DeploymentEvent struct {
 	AppID        string   `json:"app,omitempty"`
 	DeploymentID string   `json:"deployment,omitempty"`
@@ -310,14 +444,111 @@ type Resource struct {
 	Env        map[string]string `json:"env,omitempty"`
 	Apps       []string          `json:"apps,omitempty"`
 	CreatedAt  *time.Time        `json:"created_at,omitempty"`
+	UpdatedAt  *time.Time        `json:"updated_at,omitempty"`
 }
 
 type ResourceReq struct {
+	// ID is the ID to give the provisioned resource, which clients can set
+	// in order to watch the resource's status while it is provisioned
+	ID         string           `json:"id,omitempty"`
 	ProviderID string           `json:"-"`
 	Apps       []string         `json:"apps,omitempty"`
 	Config     *json.RawMessage `json:"config"`
 }
 
+// ProviderResourceReq is a request to provision a resource from the provider
+// with the given URL, registering the provider with the given name if there
+// is no provider with that URL.
+type ProviderResourceReq struct {
+	Name   string           `json:"name,omitempty"`
+	URL    string           `json:"url"`
+	Apps   []string         `json:"apps,omitempty"`
+	Config *json.RawMessage `json:"config,omitempty"`
+}
+
+// ProviderResource is a resource along with the provider which provisioned
+// it, and whether that provider was registered to provision it.
+type ProviderResource struct {
+	Provider        *Provider `json:"provider"`
+	Resource        *Resource `json:"resource"`
+	ProviderCreated bool      `json:"provider_created"`
+}
+
+// ResourceStatus is the provisioning status of a resource, with Error set if
+// provisioning it failed.
+type ResourceStatus struct {
+	Status   ResourceState `json:"status"`
+	Resource *Resource     `json:"resource,omitempty"`
+	Error    string        `json:"error,omitempty"`
+}
+
+type ResourceState string
+
+const (
+	ResourceStatePending ResourceState = "pending"
+	ResourceStateReady   ResourceState = "ready"
+	ResourceStateFailed  ResourceState = "failed"
+	ResourceStateDeleted ResourceState = "deleted"
+)
+
+// ResourceProvisionFailure is the data of the event recording that
+// provisioning a resource with a client-supplied ID failed.
+type ResourceProvisionFailure struct {
+	ResourceID string `json:"resource"`
+	ProviderID string `json:"provider"`
+	Error      string `json:"error"`
+}
+
+// AppClone is a request to create a new app from an existing one.
+type AppClone struct {
+	// Name is the name of the new app, with a name generated if it is
+	// not set
+	Name string `json:"name,omitempty"`
+}
+
+// AppResourceReq is a request to provision a resource and attach it to a
+// single app, injecting the resource's env into the app's release.
+type AppResourceReq struct {
+	ProviderID string           `json:"provider"`
+	Config     *json.RawMessage `json:"config"`
+}
+
+// PromoteRelease is a request to create a release for an app using the
+// artifacts, processes and meta of a release from another app.
+type PromoteRelease struct {
+	ReleaseID string `json:"release"`
+
+	// Env is merged into the env of the source release
+	Env map[string]string `json:"env,omitempty"`
+
+	// Deploy is whether to deploy the new release
+	Deploy bool `json:"deploy,omitempty"`
+}
+
+// AppEnvUpdate is a request to create a release for an app which is a copy of
+// its current release with some env vars set and others unset.
+type AppEnvUpdate struct {
+	Set   map[string]string `json:"set,omitempty"`
+	Unset []string          `json:"unset,omitempty"`
+
+	// Deploy is whether to deploy the new release
+	Deploy bool `json:"deploy,omitempty"`
+}
+
+// ReleaseRevision is a request to create a release which is a copy of an
+// existing release with a different list of artifacts.
+type ReleaseRevision struct {
+	ReleaseID   string   `json:"release"`
+	ArtifactIDs []string `json:"artifacts"`
+}
+
+// PromotedRelease is a release created from an existing one, along with its
+// deployment if it was deployed.
+type PromotedRelease struct {
+	Release    *Release    `json:"release"`
+	Deployment *Deployment `json:"deployment,omitempty"`
+}
+
 type ValidationError struct {
 	Field   string `json:"field,omitempty"`
 	Message string `json:"message"`
@@ -327,6 +558,31 @@ func (v ValidationError) Error() string {
 	return fmt.Sprintf("validation error: %s %s", v.Field, v.Message)
 }
 
+// ValidationErrors is a list of validation errors, used to report all of the
+// problems with an object at once.
+type ValidationErrors []ValidationError
+
+func (v ValidationErrors) Error() string {
+	msgs := make([]string, len(v))
+	for i, err := range v {
+		msgs[i] = err.Error()
+	}
+	return strings.Join(msgs, "; ")
+}
+
+// Err returns nil if there are no errors, the error itself if there is only
+// one, or otherwise the list of errors.
+func (v ValidationErrors) Err() error {
+	switch len(v) {
+	case 0:
+		return nil
+	case 1:
+		return v[0]
+	default:
+		return v
+	}
+}
+
 type NotFoundError struct {
 	Resource string `json:"field"`
 }
@@ -351,26 +607,28 @@ type LogOpts struct {
 type EventType string
 
 const (
-	EventTypeApp                  EventType = "app"
-	EventTypeAppDeletion          EventType = "app_deletion"
-	EventTypeAppRelease           EventType = "app_release"
-	EventTypeDeployment           EventType = "deployment"
-	EventTypeJob                  EventType = "job"
-	EventTypeScale                EventType = "scale"
-	EventTypeRelease              EventType = "release"
-	EventTypeReleaseDeletion      EventType = "release_deletion"
-	EventTypeArtifact             EventType = "artifact"
-	EventTypeProvider             EventType = "provider"
-	EventTypeResource             EventType = "resource"
-	EventTypeResourceDeletion     EventType = "resource_deletion"
-	EventTypeResourceAppDeletion  EventType = "resource_app_deletion"
-	EventTypeKey                  EventType = "key"
-	EventTypeKeyDeletion          EventType = "key_deletion"
-	EventTypeRoute                EventType = "route"
-	EventTypeRouteDeletion        EventType = "route_deletion"
-	EventTypeDomainMigration      EventType = "domain_migration"
-	EventTypeClusterBackup        EventType = "cluster_backup"
-	EventTypeAppGarbageCollection EventType = "app_garbage_collection"
+	EventTypeApp                      EventType = "app"
+	EventTypeAppDeletion              EventType = "app_deletion"
+	EventTypeAppRelease               EventType = "app_release"
+	EventTypeDeployment               EventType = "deployment"
+	EventTypeJob                      EventType = "job"
+	EventTypeScale                    EventType = "scale"
+	EventTypeRelease                  EventType = "release"
+	EventTypeReleaseDeletion          EventType = "release_deletion"
+	EventTypeArtifact                 EventType = "artifact"
+	EventTypeProvider                 EventType = "provider"
+	EventTypeResource                 EventType = "resource"
+	EventTypeResourceDeletion         EventType = "resource_deletion"
+	EventTypeResourceAppDeletion      EventType = "resource_app_deletion"
+	EventTypeResourceProvisionFailure EventType = "resource_provision_failure"
+	EventTypeKey                      EventType = "key"
+	EventTypeKeyDeletion              EventType = "key_deletion"
+	EventTypeRoute                    EventType = "route"
+	EventTypeRouteDeletion            EventType = "route_deletion"
+	EventTypeDomainMigration          EventType = "domain_migration"
+	EventTypeClusterBackup            EventType = "cluster_backup"
+	EventTypeClusterBackupDeletion    EventType = "cluster_backup_deletion"
+	EventTypeAppGarbageCollection     EventType = "app_garbage_collection"
 )
 
 type Event struct {
@@ -383,12 +641,118 @@ type Event struct {
 	CreatedAt  *time.Time      `json:"created_at,omitempty"`
 }
 
+// AppEvents is a list of the most recent events for a single app.
+type AppEvents struct {
+	App    *App     `json:"app"`
+	Events []*Event `json:"events"`
+}
+
 type Scale struct {
 	PrevProcesses map[string]int `json:"prev_processes,omitempty"`
 	Processes     map[string]int `json:"processes"`
 	ReleaseID     string         `json:"release"`
 }
 
+// MetaValue is the value of a single app or release meta key, with a nil
+// Value indicating the key is not set.
+type MetaValue struct {
+	Key   string  `json:"key"`
+	Value *string `json:"value"`
+}
+
+func NewMetaValue(meta map[string]string, key string) *MetaValue {
+	v := &MetaValue{Key: key}
+	if value, ok := meta[key]; ok {
+		v.Value = &value
+	}
+	return v
+}
+
+// CertificateStatus summarises the expiry of the TLS certificates used by an
+// app's routes.
+type CertificateStatus struct {
+	SoonestExpiry        time.Time `json:"soonest_expiry"`
+	ExpiringWithin30Days bool      `json:"expiring_within_30_days"`
+}
+
+// AppLifecycleEvent is streamed to clients when an app is created or deleted.
+type AppLifecycleEvent struct {
+	EventType string `json:"event_type"`
+	App       *App   `json:"app"`
+}
+
+const (
+	AppLifecycleCreated = "created"
+	AppLifecycleDeleted = "deleted"
+)
+
+// ResourceApps is a request to attach a resource to several apps at once.
+type ResourceApps struct {
+	Apps []string `json:"apps"`
+}
+
+// ReleaseImport is a release exported from another cluster along with any
+// of its artifacts which should be imported with it.
+type ReleaseImport struct {
+	Release   *Release    `json:"release"`
+	Artifacts []*Artifact `json:"artifacts,omitempty"`
+}
+
+// AppExport is a snapshot of an app's config which can be imported into
+// another cluster, including its current release along with the release's
+// artifacts and formation.
+type AppExport struct {
+	App       *App            `json:"app"`
+	Release   *Release        `json:"release,omitempty"`
+	Artifacts []*Artifact     `json:"artifacts,omitempty"`
+	Formation *Formation      `json:"formation,omitempty"`
+	Routes    []*router.Route `json:"routes,omitempty"`
+}
+
+// ReleaseSize is the total size of a release's artifacts in bytes, with a nil
+// TotalSize if the size of any of the artifacts is not known.
+type ReleaseSize struct {
+	TotalSize      *int64 `json:"total_size"`
+	TotalSizeHuman string `json:"total_size_human,omitempty"`
+}
+
+// AppConfigChecksum is a checksum of an app's effective config (the env and
+// processes of its current release along with the current formation) which
+// changes whenever the config does.
+type AppConfigChecksum struct {
+	Checksum string `json:"checksum"`
+}
+
+// ReleaseInUse is whether a release is the current release of any app.
+type ReleaseInUse struct {
+	InUse bool `json:"in_use"`
+}
+
+// RouteCount is the number of routes an app has, with a nil Count if the
+// router is unavailable and the app's routes are not known.
+type RouteCount struct {
+	Count *int `json:"count"`
+}
+
+// RouteDomain is a request to change the domain of an HTTP route.
+type RouteDomain struct {
+	Domain string `json:"domain"`
+}
+
+// AppRouteFlags is a request to change flags across all of an app's routes,
+// leaving flags which are not set unchanged.
+type AppRouteFlags struct {
+	// Sticky sets session stickiness, and so only applies to HTTP routes
+	Sticky *bool `json:"sticky,omitempty"`
+	Leader *bool `json:"leader,omitempty"`
+}
+
+// RouteURL is the external URL of a route, which is nil if the route is
+// missing the fields needed to build it.
+type RouteURL struct {
+	URL *string `json:"url"`
+}
+
 type AppRelease struct {
 	PrevRelease *Release `json:"prev_release,omitempty"`
 	Release     *Release `json:"release"`
@@ -417,17 +781,72 @@ const (
 	ClusterBackupStatusError    string = "error"
 )
 
+// ClusterStats are cluster wide totals for an overview of the cluster.
+type ClusterStats struct {
+	AppCount           int64 `json:"app_count"`
+	RunningJobCount    int64 `json:"running_job_count"`
+	DeploymentCount24h int64 `json:"deployment_count_24h"`
+	ProviderCount      int64 `json:"provider_count"`
+}
+
+// ServerInfo describes the running controller so clients talking to
+// different versions can detect which optional features are enabled.
+type ServerInfo struct {
+	Version      string   `json:"version"`
+	Capabilities []string `json:"capabilities"`
+}
+
+const (
+	CapabilityObjectCache        = "object_cache"
+	CapabilityEventKeepAlive     = "event_keepalive"
+	CapabilityDependencyTimeouts = "dependency_timeouts"
+)
+
+// ByteSize is a number of bytes which is formatted for display using binary
+// units (e.g. "512 B", "1.5 KiB", "1.2 GiB").
+type ByteSize int64
+
+var byteSizeUnits = []string{"KiB", "MiB", "GiB", "TiB", "PiB", "EiB"}
+
+func (b ByteSize) String() string {
+	if b < 1024 {
+		return fmt.Sprintf("%d B", int64(b))
+	}
+	size := float64(b) / 1024
+	unit := 0
+	// move to the next unit if the size would otherwise be displayed as
+	// 1024.0 after rounding
+	for size >= 1023.95 && unit < len(byteSizeUnits)-1 {
+		size /= 1024
+		unit++
+	}
+	return fmt.Sprintf("%.1f %s", size, byteSizeUnits[unit])
+}
+
 type ClusterBackup struct {
 	ID          string     `json:"id,omitempty"`
 	Status      string     `json:"status"`
 	SHA512      string     `json:"sha512,omitempty"`
 	Size        int64      `json:"size,omitempty"`
+	SizeHuman   string     `json:"size_human,omitempty"`
 	Error       string     `json:"error,omitempty"`
 	CreatedAt   *time.Time `json:"created_at,omitempty"`
 	UpdatedAt   *time.Time `json:"updated_at,omitempty"`
 	CompletedAt *time.Time `json:"completed_at,omitempty"`
 }
 
+// PruneBackups is a request to delete the records of completed backups which
+// completed before a given time.
+type PruneBackups struct {
+	Before *time.Time `json:"before,omitempty"`
+}
+
+type PruneBackupsResult struct {
+	Deleted    int    `json:"deleted"`
+	FreedBytes int64  `json:"freed_bytes"`
+	FreedHuman string `json:"freed_human,omitempty"`
+}
+
 type ReleaseDeletion struct {
 	AppID         string   `json:"app"`
 	ReleaseID     string   `json:"release"`
@@ -445,6 +864,11 @@ type JobWatcher interface {
 	Close() error
 }
 
+// LatestEventID is the ID of the most recent event.
+type LatestEventID struct {
+	ID int64 `json:"id"`
+}
+
 type ListEventsOptions struct {
 	AppID       string
 	ObjectTypes []EventType
@@ -452,6 +876,15 @@ type ListEventsOptions struct {
 	BeforeID    *int64
 	SinceID     *int64
 	Count       int
+
+	// ErrorsOnly limits the list to events whose data has a non-empty
+	// error field
+	ErrorsOnly bool
+}
+
+type ListJobsOptions struct {
+	BeforeID string
+	Count    int
 }
 
 type StreamEventsOptions struct {
@@ -460,6 +893,10 @@ type StreamEventsOptions struct {
 	ObjectID    string
 	Past        bool
 	Count       int
+
+	// LastID, if set, resumes the stream after the event with the given
+	// ID, first sending any stored events which were missed
+	LastID int64
 }
 
 type AppGarbageCollection struct {
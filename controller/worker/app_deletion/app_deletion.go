@@ -145,6 +145,12 @@ func (c *context) HandleAppDeletion(job *que.Job) (err error) {
 		tx.Rollback()
 		return err
 	}
+	err = tx.Exec("route_meta_delete_by_app", app.ID)
+	if err != nil {
+		log.Error("error executing route meta deletion query", "err", err)
+		tx.Rollback()
+		return err
+	}
 	return tx.Commit()
 }
 
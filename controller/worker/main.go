@@ -12,6 +12,7 @@ import (
 	"github.com/flynn/flynn/controller/worker/deployment"
 	"github.com/flynn/flynn/controller/worker/domain_migration"
 	"github.com/flynn/flynn/controller/worker/release_cleanup"
+	"github.com/flynn/flynn/controller/worker/scheduled_job"
 	"github.com/flynn/flynn/discoverd/client"
 	"github.com/flynn/flynn/pkg/postgres"
 	"github.com/flynn/flynn/pkg/shutdown"
@@ -64,6 +65,7 @@ func main() {
 			"domain_migration":       domain_migration.JobHandler(db, client, logger),
 			"release_cleanup":        release_cleanup.JobHandler(db, client, logger),
 			"app_garbage_collection": app_garbage_collection.JobHandler(db, client, logger),
+			"scheduled_job":          scheduled_job.JobHandler(db, client, logger),
 		},
 		workerCount,
 	)
@@ -0,0 +1,52 @@
+package scheduled_job
+
+import (
+	"encoding/json"
+
+	"github.com/flynn/flynn/controller/client"
+	ct "github.com/flynn/flynn/controller/types"
+	"github.com/flynn/flynn/pkg/postgres"
+	"github.com/flynn/que-go"
+	"gopkg.in/inconshreveable/log15.v2"
+)
+
+type context struct {
+	db     *postgres.DB
+	client controller.Client
+	logger log15.Logger
+}
+
+func JobHandler(db *postgres.DB, client controller.Client, logger log15.Logger) func(*que.Job) error {
+	return (&context{db, client, logger}).HandleScheduledJob
+}
+
+// HandleScheduledJob runs a one-off job which was scheduled to run at the
+// time the que job became due, using the release's env.
+func (c *context) HandleScheduledJob(job *que.Job) error {
+	log := c.logger.New("fn", "HandleScheduledJob")
+	log.Info("handling scheduled job", "job_id", job.ID, "error_count", job.ErrorCount)
+
+	var scheduled ct.ScheduledJob
+	if err := json.Unmarshal(job.Args, &scheduled); err != nil {
+		log.Error("error unmarshaling job", "err", err)
+		return err
+	}
+	log = log.New("app_id", scheduled.AppID, "release_id", scheduled.ReleaseID)
+
+	newJob, err := c.client.RunJobDetached(scheduled.AppID, &ct.NewJob{
+		ReleaseID:  scheduled.ReleaseID,
+		ReleaseEnv: true,
+		Args:       scheduled.Args,
+	})
+	if err == controller.ErrNotFound {
+		// the app or release has been deleted since the job was
+		// scheduled, so there is nothing to run
+		log.Info("app or release no longer exists, skipping scheduled job")
+		return nil
+	} else if err != nil {
+		log.Error("error running scheduled job", "err", err)
+		return err
+	}
+	log.Info("started scheduled job", "job_id", newJob.ID)
+	return nil
+}
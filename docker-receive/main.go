@@ -96,6 +96,7 @@ func (r *repository) Manifests(ctx context.Context, options ...distribution.Mani
 	}
 	return &manifestService{
 		ManifestService: m,
+		ctx:             ctx,
 		repository:      r,
 		client:          r.client,
 		authKey:         r.authKey,
@@ -105,6 +106,7 @@ func (r *repository) Manifests(ctx context.Context, options ...distribution.Mani
 type manifestService struct {
 	distribution.ManifestService
 
+	ctx        context.Context
 	repository distribution.Repository
 	client     controller.Client
 	authKey    string
@@ -120,10 +122,10 @@ func (m *manifestService) Put(manifest *manifest.SignedManifest) error {
 		return err
 	}
 
-	return m.createArtifact(dgst)
+	return m.createArtifact(dgst, m.imageSize(manifest))
 }
 
-func (m *manifestService) createArtifact(dgst digest.Digest) error {
+func (m *manifestService) createArtifact(dgst digest.Digest, size *int64) error {
 	return m.client.CreateArtifact(&ct.Artifact{
 		Type: host.ArtifactTypeDocker,
 		URI:  fmt.Sprintf("http://flynn:%s@docker-receive.discoverd?name=%s&id=%s", m.authKey, m.repository.Name(), dgst),
@@ -131,9 +133,31 @@ func (m *manifestService) createArtifact(dgst digest.Digest) error {
 			"docker-receive.repository": m.repository.Name(),
 			"docker-receive.digest":     string(dgst),
 		},
+		Size: size,
 	})
 }
 
+// imageSize returns the total size of the distinct layers of the image, or
+// nil if the size of any of them cannot be determined.
+func (m *manifestService) imageSize(manifest *manifest.SignedManifest) *int64 {
+	blobs := m.repository.Blobs(m.ctx)
+	seen := make(map[digest.Digest]struct{}, len(manifest.FSLayers))
+	var size int64
+	for _, layer := range manifest.FSLayers {
+		if _, ok := seen[layer.BlobSum]; ok {
+			continue
+		}
+		seen[layer.BlobSum] = struct{}{}
+		desc, err := blobs.Stat(m.ctx, layer.BlobSum)
+		if err != nil {
+			context.GetLogger(m.ctx).Errorf("error getting size of layer %s: %s", layer.BlobSum, err)
+			return nil
+		}
+		size += desc.Size
+	}
+	return &size
+}
+
 // digestManifest is a modified version of:
 // https://github.com/docker/distribution/blob/6ba799b/registry/handlers/images.go#L228-L251
 func digestManifest(manifest *manifest.SignedManifest) (digest.Digest, error) {
@@ -10,6 +10,7 @@ import (
 	"os"
 	"path"
 	"regexp"
+	"strconv"
 	"strings"
 	"time"
 
@@ -31,6 +32,10 @@ func init() {
 
 var typesPattern = regexp.MustCompile("types.* -> (.+)\n")
 
+// sizePattern matches the exact size in bytes of the slug, which the
+// slugbuilder outputs after building it
+var sizePattern = regexp.MustCompile("Slug size in bytes -> (\\d+)\n")
+
 const blobstoreURL = "http://blobstore.discoverd"
 
 func parsePairs(args *docopt.Args, str string) (map[string]string, error) {
@@ -178,6 +183,11 @@ Options:
 		URI:  slugURL,
 		Meta: map[string]string{"blobstore": "true"},
 	}
+	if match := sizePattern.FindSubmatch(output.Bytes()); match != nil {
+		if size, err := strconv.ParseInt(string(match[1]), 10, 64); err == nil {
+			slugArtifact.Size = &size
+		}
+	}
 	if err := client.CreateArtifact(slugArtifact); err != nil {
 		return fmt.Errorf("Error creating slug artifact: %s", err)
 	}
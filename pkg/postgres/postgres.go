@@ -19,6 +19,7 @@ const (
 	UniqueViolation           = "23505"
 	RaiseException            = "P0001"
 	ForeignKeyViolation       = "23503"
+	QueryCanceled             = "57014"
 )
 
 type Conf struct {
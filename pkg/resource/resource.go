@@ -6,6 +6,8 @@ import (
 	"fmt"
 	"net/http"
 	"net/url"
+
+	"golang.org/x/net/context"
 )
 
 type Resource struct {
@@ -14,7 +16,18 @@ type Resource struct {
 }
 
 func Provision(uri string, config []byte) (*Resource, error) {
-	res, err := http.Post(uri, "application/json", bytes.NewBuffer(config))
+	return ProvisionContext(context.Background(), uri, config)
+}
+
+// ProvisionContext is like Provision but aborts the request to the provider
+// if ctx is done before it completes.
+func ProvisionContext(ctx context.Context, uri string, config []byte) (*Resource, error) {
+	req, err := http.NewRequest("POST", uri, bytes.NewBuffer(config))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	res, err := http.DefaultClient.Do(req.WithContext(ctx))
 	if err != nil {
 		return nil, err
 	}
@@ -39,6 +39,15 @@ func (w *writer) Write(p []byte) (int, error) {
 	return len(p), err
 }
 
+// WriteKeepAlive writes an empty comment line which clients ignore but
+// which stops idle connections being closed by proxies.
+func (w *writer) WriteKeepAlive() error {
+	w.mtx.Lock()
+	defer w.mtx.Unlock()
+	_, err := w.w.Write([]byte(":\n"))
+	return err
+}
+
 func (w *writer) Error(err error) (int, error) {
 	w.mtx.Lock()
 	_, e := w.w.Write([]byte("event: error\n"))
@@ -12,6 +12,10 @@ import (
 	log "gopkg.in/inconshreveable/log15.v2"
 )
 
+// DefaultKeepAliveInterval is how long a stream can be idle before a
+// keepalive is sent if Stream.KeepAliveInterval is not set.
+const DefaultKeepAliveInterval = 30 * time.Second
+
 type identifier interface {
 	EventID() string
 }
@@ -27,6 +31,10 @@ type Stream struct {
 	closed    bool
 	logger    log.Logger
 	Done      chan struct{}
+
+	// KeepAliveInterval is how long the stream can be idle before a
+	// keepalive is sent, and must be set before calling Serve
+	KeepAliveInterval time.Duration
 }
 
 func NewStream(w http.ResponseWriter, ch interface{}, l log.Logger) *Stream {
@@ -63,6 +71,10 @@ func (s *Stream) Serve() {
 		}()
 	}
 
+	keepAliveInterval := s.KeepAliveInterval
+	if keepAliveInterval <= 0 {
+		keepAliveInterval = DefaultKeepAliveInterval
+	}
 	closeChanValue := reflect.ValueOf(s.closeChan)
 	chValue := reflect.ValueOf(s.ch)
 	go func() {
@@ -75,7 +87,7 @@ func (s *Stream) Serve() {
 				},
 				{
 					Dir:  reflect.SelectRecv,
-					Chan: reflect.ValueOf(time.After(30 * time.Second)),
+					Chan: reflect.ValueOf(time.After(keepAliveInterval)),
 				},
 				{
 					Dir:  reflect.SelectRecv,
@@ -86,7 +98,10 @@ func (s *Stream) Serve() {
 			case 0:
 				return
 			case 1:
-				s.sendKeepAlive()
+				if err := s.sendKeepAlive(); err != nil {
+					s.logError(err)
+					return
+				}
 			default:
 				if !ok {
 					return
@@ -123,7 +138,7 @@ func (s *Stream) send(v interface{}) error {
 }
 
 func (s *Stream) sendKeepAlive() error {
-	if _, err := s.w.w.Write([]byte(":\n")); err != nil {
+	if err := s.w.WriteKeepAlive(); err != nil {
 		return err
 	}
 	s.w.Flush()
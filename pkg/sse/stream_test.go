@@ -0,0 +1,58 @@
+package sse
+
+import (
+	"bytes"
+	"net/http"
+	"sync"
+	"testing"
+	"time"
+)
+
+// syncResponseWriter is a http.ResponseWriter which can be safely read from
+// whilst the stream is writing to it.
+type syncResponseWriter struct {
+	mtx    sync.Mutex
+	header http.Header
+	buf    bytes.Buffer
+}
+
+func (w *syncResponseWriter) Header() http.Header { return w.header }
+
+func (w *syncResponseWriter) WriteHeader(int) {}
+
+func (w *syncResponseWriter) Write(p []byte) (int, error) {
+	w.mtx.Lock()
+	defer w.mtx.Unlock()
+	return w.buf.Write(p)
+}
+
+func (w *syncResponseWriter) keepAlives() int {
+	w.mtx.Lock()
+	defer w.mtx.Unlock()
+	return bytes.Count(w.buf.Bytes(), []byte(":\n"))
+}
+
+func TestKeepAlive(t *testing.T) {
+	w := &syncResponseWriter{header: make(http.Header)}
+	s := NewStream(w, make(chan struct{}), nil)
+	s.KeepAliveInterval = 10 * time.Millisecond
+	s.Serve()
+
+	// an idle stream should send keepalives
+	timeout := time.After(time.Second)
+	for w.keepAlives() < 2 {
+		select {
+		case <-timeout:
+			t.Fatal("timed out waiting for keepalives")
+		case <-time.After(10 * time.Millisecond):
+		}
+	}
+
+	// a closed stream should not
+	s.Close()
+	n := w.keepAlives()
+	time.Sleep(50 * time.Millisecond)
+	if m := w.keepAlives(); m != n {
+		t.Fatalf("expected no keepalives after close, got %d", m-n)
+	}
+}
@@ -74,6 +74,9 @@ func (api *API) CreateRoute(ctx context.Context, w http.ResponseWriter, req *htt
 		case ErrInvalid:
 			jsonError.Code = httphelper.ValidationErrorCode
 			jsonError.Message = "Invalid route"
+		case ErrNoPorts:
+			jsonError.Code = httphelper.ConflictErrorCode
+			jsonError.Message = "No TCP ports available"
 		default:
 			log.Error(err.Error())
 			httphelper.Error(w, err)
@@ -61,6 +61,10 @@ type Route struct {
 
 	// Port is the TCP port to listen on for TCP Routes.
 	Port int32 `json:"port,omitempty"`
+
+	// Meta is the route's metadata, which is stored and set by the
+	// controller rather than the router.
+	Meta map[string]string `json:"meta,omitempty"`
 }
 
 func (r Route) FormattedID() string {
@@ -235,6 +235,7 @@ tar \
 if [[ "${slug_file}" != "-" ]]; then
   slug_size=$(du -Sh "${slug_file}" | cut -f1)
   echo_title "Compiled slug size is ${slug_size}"
+  echo_normal "Slug size in bytes -> $(stat --format=%s "${slug_file}")"
 
   if [[ ${put_url} ]]; then
     curl -0 -o "$(mktemp)" -X PUT -T ${slug_file} "${put_url}"